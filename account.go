@@ -0,0 +1,37 @@
+//go:build windows
+
+package main
+
+import "golang.org/x/sys/windows"
+
+// -------------------- 运行账户检查 --------------------
+
+// runningAsSystem 判断当前进程是否以 LocalSystem 运行，可在调试时替换
+var runningAsSystem = func() (bool, error) {
+	token, err := windows.OpenCurrentProcessToken()
+	if err != nil {
+		return false, err
+	}
+	defer token.Close()
+
+	user, err := token.GetTokenUser()
+	if err != nil {
+		return false, err
+	}
+	// S-1-5-18，即 SECURITY_LOCAL_SYSTEM_RID
+	return user.User.Sid.IsWellKnown(windows.WinLocalSystemSid), nil
+}
+
+// checkServiceAccount 不是 SYSTEM 时写一条警告：关机脚本通常需要系统权限，
+// 用普通账户安装服务是最常见的配置错误之一
+func checkServiceAccount() {
+	isSystem, err := runningAsSystem()
+	if err != nil {
+		debugf("WinPSP: cannot check service account: %v", err)
+		return
+	}
+	if !isSystem {
+		writeEvent(eventWarning, eventIDNotSystem,
+			"WinPSP is not running as SYSTEM; command execution may fail due to insufficient privileges.")
+	}
+}
@@ -0,0 +1,91 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+// loggedEvent 是一条写入事件日志的事件
+type loggedEvent struct {
+	kind int
+	eid  uint32
+	msg  string
+}
+
+// eventRecorder 记录 writeEvent 收到的事件
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []loggedEvent
+}
+
+func (r *eventRecorder) all() []loggedEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]loggedEvent(nil), r.events...)
+}
+
+// mockEvents 让 writeEvent 把事件记录下来，而不是写入事件日志
+func mockEvents(t *testing.T) *eventRecorder {
+	t.Helper()
+	r := &eventRecorder{}
+	orig := writeEvent
+	writeEvent = func(kind int, eid uint32, msg string) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.events = append(r.events, loggedEvent{kind, eid, msg})
+	}
+	t.Cleanup(func() { writeEvent = orig })
+	return r
+}
+
+func TestCheckServiceAccount(t *testing.T) {
+	tests := []struct {
+		isSystem  bool
+		err       error
+		wantEvent bool
+	}{
+		{true, nil, false},
+		{false, nil, true},
+		{false, errors.New("OpenProcessToken: Access is denied."), false}, // 无法判断时不报警
+	}
+	for _, tt := range tests {
+		events := mockEvents(t)
+		orig := runningAsSystem
+		runningAsSystem = func() (bool, error) { return tt.isSystem, tt.err }
+		checkServiceAccount()
+		runningAsSystem = orig
+
+		got := events.all()
+		if !tt.wantEvent {
+			if len(got) != 0 {
+				t.Errorf("system %v, err %v: events %+v, want none", tt.isSystem, tt.err, got)
+			}
+			continue
+		}
+		want := loggedEvent{eventWarning, eventIDNotSystem,
+			"WinPSP is not running as SYSTEM; command execution may fail due to insufficient privileges."}
+		if len(got) != 1 || got[0] != want {
+			t.Errorf("system %v: events %+v, want %+v", tt.isSystem, got, want)
+		}
+	}
+}
+
+func TestRunningAsSystem(t *testing.T) {
+	token := windows.GetCurrentProcessToken()
+	user, err := token.GetTokenUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	isSystem, err := runningAsSystem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := user.User.Sid.String() == "S-1-5-18"; isSystem != want {
+		t.Errorf("runningAsSystem = %v for %s", isSystem, user.User.Sid)
+	}
+}
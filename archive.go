@@ -0,0 +1,88 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	defaultArchiveTimeoutSecs = 60
+	archiveAttempts           = 3
+	archiveRetryDelay         = 2 * time.Second
+)
+
+// -------------------- 日志归档到网络共享 --------------------
+
+// archive_unc_path 设置后，日志文件关闭后复制到 {archive_unc_path}\{hostname}\{logfilename}。
+// 服务以 LocalSystem 运行，访问共享时使用计算机账户（DOMAIN\HOST$），
+// 共享和 NTFS 权限要对计算机账户开放写入。
+
+// copyFile 把 src 复制为 dst，可在调试时替换
+var copyFile = func(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// archiveLog 把已关闭的日志复制到归档共享，失败时最多重试 archiveAttempts 次。
+// 所有尝试共用 archive_timeout_secs：网络共享不可达时 SMB 可能长时间不返回，
+// 超时后不再等待，不让关机被卡住。关机截止时间 deadline（零值表示不限）更早时以它为准。
+func (s *winpspService) archiveLog(logPath string, deadline time.Time) error {
+	cfg := s.config.Load()
+
+	timeout := time.Duration(defaultArchiveTimeoutSecs) * time.Second
+	if cfg.ArchiveTimeoutSecs != nil {
+		timeout = time.Duration(*cfg.ArchiveTimeoutSecs) * time.Second
+	}
+
+	dir := filepath.Join(cfg.ArchiveUNCPath, s.run.Hostname)
+	dst := filepath.Join(dir, filepath.Base(logPath))
+	if timeout = timeoutBefore(timeout, deadline); timeout <= 0 {
+		return fmt.Errorf("archive to %s: skipped, shutdown timeout reached", dst)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		for attempt := 1; attempt <= archiveAttempts; attempt++ {
+			if attempt > 1 {
+				debugf("WinPSP: log archive attempt %d failed: %v", attempt-1, err)
+				time.Sleep(archiveRetryDelay)
+			}
+			if err = os.MkdirAll(dir, 0o755); err == nil {
+				if err = copyFile(dst, logPath); err == nil {
+					break
+				}
+			}
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("archive to %s: %w", dst, err)
+		}
+		debugf("WinPSP: log archived to %s", dst)
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("archive to %s: timed out after %s", dst, timeout.Round(100*time.Millisecond))
+	}
+}
@@ -0,0 +1,114 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockCopyFile 替换 copyFile：前 failures 次返回错误，hang 时一直不返回。返回调用次数
+func mockCopyFile(t *testing.T, failures int, hang bool) func() int {
+	t.Helper()
+	var mu sync.Mutex
+	calls := 0
+	orig := copyFile
+	copyFile = func(dst, src string) error {
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+		if hang {
+			select {}
+		}
+		if n <= failures {
+			return errors.New("The network path was not found.")
+		}
+		return orig(dst, src)
+	}
+	t.Cleanup(func() { copyFile = orig })
+	return func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls
+	}
+}
+
+func TestArchiveLog(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int
+		hang      bool
+		timeout   int
+		deadline  time.Duration // 距关机截止时间，0 = 不限
+		wantCalls int
+		wantErr   string
+	}{
+		{"first attempt", 0, false, 60, 0, 1, ""},
+		{"succeeds on retry", 2, false, 60, 0, 3, ""},
+		{"all attempts fail", archiveAttempts, false, 60, 0, archiveAttempts, "The network path was not found."},
+		{"share not responding", 0, true, 1, 0, 1, "timed out after 1s"},
+		{"shutdown deadline before the timeout", 0, true, 60, time.Second, 1, "timed out after 1s"},
+		{"shutdown deadline passed", 0, false, 60, -time.Second, 0, "skipped, shutdown timeout reached"},
+	}
+	for _, tt := range tests {
+		calls := mockCopyFile(t, tt.failures, tt.hang)
+		share := t.TempDir()
+		s := loadedService(t, fmt.Sprintf(`{"command": "backup.exe", "archive_unc_path": %q, "archive_timeout_secs": %d}`, share, tt.timeout))
+		s.run = newRunContext(s.config.Load())
+		logPath := filepath.Join(t.TempDir(), "winpsp-20261014-083000.log")
+		if err := os.WriteFile(logPath, []byte("[2026-10-14 08:30:00] Exit code: 0\r\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		var deadline time.Time
+		if tt.deadline != 0 {
+			deadline = start.Add(tt.deadline)
+		}
+		err := s.archiveLog(logPath, deadline)
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: archiveLog error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+		if got := calls(); got != tt.wantCalls {
+			t.Errorf("%s: copyFile called %d times, want %d", tt.name, got, tt.wantCalls)
+		}
+		if tt.hang && time.Since(start) > 5*time.Second {
+			t.Errorf("%s: archiveLog returned after %s, want after 1s", tt.name, time.Since(start))
+		}
+
+		// 成功时复制到 {archive_unc_path}\{hostname}\{logfilename}，内容不变
+		dst := filepath.Join(share, s.run.Hostname, filepath.Base(logPath))
+		data, readErr := os.ReadFile(dst)
+		if tt.wantErr == "" && (readErr != nil || string(data) != "[2026-10-14 08:30:00] Exit code: 0\r\n") {
+			t.Errorf("%s: archived copy = %q, %v", tt.name, data, readErr)
+		}
+		if tt.wantErr != "" && readErr == nil {
+			t.Errorf("%s: archived copy exists although archiving failed", tt.name)
+		}
+	}
+}
+
+func TestReadConfigArchiveTimeout(t *testing.T) {
+	tests := []struct {
+		timeout string
+		wantErr bool
+	}{
+		{"", false},
+		{`, "archive_timeout_secs": 30`, false},
+		{`, "archive_timeout_secs": 0`, true},
+		{`, "archive_timeout_secs": -1`, true},
+	}
+	for _, tt := range tests {
+		s := writeConfig(t, `{"command": "backup.exe", "archive_unc_path": "\\\\fileserver\\winpsp-archive"`+tt.timeout+`}`)
+		if err := s.loadConfig(); (err != nil) != tt.wantErr {
+			t.Errorf("archive_timeout_secs %q: error = %v, wantErr %v", tt.timeout, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+//go:build windows
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// -------------------- 参数文件 --------------------
+
+// 参数文件每行一个参数，追加在 command 的参数之后；忽略空行和 # 开头的注释行。
+// 每个参数单独加引号传给进程，不需要再按命令行规则转义。
+
+// argsFilePath 返回 args_file 的绝对路径：相对路径基于 working_directory，
+// 未设置 working_directory 时基于配置文件所在目录
+func (s *winpspService) argsFilePath(e *CommandEntry) string {
+	if filepath.IsAbs(e.ArgsFile) {
+		return e.ArgsFile
+	}
+	base := e.WorkingDirectory
+	if base == "" {
+		base = filepath.Dir(s.configPath)
+	}
+	return filepath.Join(base, e.ArgsFile)
+}
+
+// loadArgsFile 每次执行前重新读取 args_file。
+// 文件不存在且未设置 args_file_required 时不追加参数。
+func (s *winpspService) loadArgsFile(e *CommandEntry, logf func(format string, args ...any)) ([]string, error) {
+	if e.ArgsFile == "" {
+		return nil, nil
+	}
+	path := s.argsFilePath(e)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !e.ArgsFileRequired {
+			logf("args_file %s not found, running without it", path)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("args_file: %w", err)
+	}
+	if data, err = decodeConfig(data); err != nil {
+		return nil, fmt.Errorf("args_file %s: %w", path, err)
+	}
+
+	var args []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, len(data)+1)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		args = append(args, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("args_file %s: %w", path, err)
+	}
+
+	logf("Loaded %d argument(s) from %s", len(args), path)
+	return args, nil
+}
@@ -0,0 +1,124 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	// 每行输出一个参数
+	testHelpers["echoargs"] = func(args []string) int {
+		for _, a := range args {
+			fmt.Println(a)
+		}
+		return 0
+	}
+}
+
+func TestArgsFilePath(t *testing.T) {
+	s := &winpspService{configPath: `C:\ProgramData\WinPSP\winpsp.json`}
+	tests := []struct {
+		file, dir, want string
+	}{
+		{"args.txt", "", `C:\ProgramData\WinPSP\args.txt`},
+		{"args.txt", `D:\Backup`, `D:\Backup\args.txt`},
+		{`lists\args.txt`, `D:\Backup`, `D:\Backup\lists\args.txt`},
+		{`E:\args.txt`, `D:\Backup`, `E:\args.txt`},
+	}
+	for _, tt := range tests {
+		e := &CommandEntry{ArgsFile: tt.file, WorkingDirectory: tt.dir}
+		if got := s.argsFilePath(e); got != tt.want {
+			t.Errorf("argsFilePath(%q, %q) = %q, want %q", tt.file, tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestLoadArgsFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte // nil 表示文件不存在
+		required bool
+		want     []string
+		wantErr  bool
+	}{
+		{"lines", []byte("/full\r\n/target D:\\Backup\r\n"), false, []string{"/full", `/target D:\Backup`}, false},
+		{"comments and blank lines", []byte("# options\n\n  /full  \n\t\n#/verify\n/quiet"), false, []string{"/full", "/quiet"}, false},
+		{"empty", []byte{}, true, nil, false},
+		{"UTF-16LE", utf16le(t, "/name 备份\r\n", true), false, []string{"/name 备份"}, false},
+		{"missing", nil, false, nil, false},
+		{"missing and required", nil, true, nil, true},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		if tt.data != nil {
+			if err := os.WriteFile(filepath.Join(dir, "args.txt"), tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s := &winpspService{configPath: filepath.Join(dir, "winpsp.json")}
+		e := &CommandEntry{ArgsFile: "args.txt", ArgsFileRequired: tt.required}
+		got, err := s.loadArgsFile(e, func(string, ...any) {})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: loadArgsFile error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: loadArgsFile = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestArgsFileManyArgs(t *testing.T) {
+	const n = 1000
+	dir := t.TempDir()
+	var file strings.Builder
+	var want []string
+	for i := 1; i <= n; i++ {
+		// 带空格、引号和反斜杠的参数也要原样传给命令
+		arg := fmt.Sprintf(`D:\Data\folder %d\`, i)
+		if i%100 == 0 {
+			arg = fmt.Sprintf(`say "hi %d"`, i)
+		}
+		fmt.Fprintf(&file, "%s\r\n", arg)
+		want = append(want, arg)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "args.txt"), []byte(file.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &winpspService{configPath: filepath.Join(dir, "winpsp.json")}
+	var logged string
+	args, err := s.loadArgsFile(&CommandEntry{ArgsFile: "args.txt"}, func(format string, a ...any) {
+		logged = fmt.Sprintf(format, a...)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(logged, fmt.Sprintf("Loaded %d argument(s)", n)) {
+		t.Errorf("logged %q", logged)
+	}
+
+	exe, env := helperCommandLine(t, "echoargs")
+	var out bytes.Buffer
+	code, _, err := runCommandWithTimeout(exe, 30*time.Second, execOptions{Output: &out, Env: env, Args: args})
+	if err != nil || code != 0 {
+		t.Fatalf("runCommandWithTimeout = %d, %v", code, err)
+	}
+	got := strings.Split(strings.TrimRight(strings.ReplaceAll(out.String(), "\r\n", "\n"), "\n"), "\n")
+	if len(got) != n {
+		t.Fatalf("command received %d arguments, want %d", len(got), n)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("argument %d = %q, want %q", i+1, got[i], want[i])
+		}
+	}
+}
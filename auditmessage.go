@@ -0,0 +1,83 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// -------------------- on_start_message / on_stop_message --------------------
+
+// 审计要求服务启动、停止时在事件日志中留下固定格式的记录。
+// on_start_message 在服务进入 Running 时写入（Event ID 10），
+// on_stop_message 在服务停止时写入（Event ID 11），都是 Information 级别。
+// 消息是 text/template 模板，可以使用 {{.Hostname}}、{{.Timestamp}}、{{.ConfigHash}}。
+
+// serviceMessageData 是消息模板可以使用的变量
+type serviceMessageData struct {
+	Hostname   string
+	Timestamp  string // RFC 3339 本地时间
+	ConfigHash string // 与运行标识中的 config_hash 相同
+}
+
+// parseServiceMessages 在加载配置时解析两个模板，并用空数据执行一次，
+// 让写错的变量名在加载时就报错，而不是停止时静默失败
+func parseServiceMessages(cfg *Config) error {
+	var err error
+	if cfg.onStartMessage, err = parseServiceMessage("on_start_message", cfg.OnStartMessage); err != nil {
+		return err
+	}
+	cfg.onStopMessage, err = parseServiceMessage("on_stop_message", cfg.OnStopMessage)
+	return err
+}
+
+func parseServiceMessage(field, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	t, err := template.New(field).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", field, err)
+	}
+	if err := t.Execute(io.Discard, serviceMessageData{}); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", field, err)
+	}
+	return t, nil
+}
+
+// writeServiceMessage 按当前配置展开模板并写入事件日志，未配置时什么都不做
+func writeServiceMessage(cfg *Config, eid uint32, t *template.Template) {
+	if t == nil {
+		return
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown-host"
+	}
+
+	var b strings.Builder
+	data := serviceMessageData{Hostname: host, Timestamp: time.Now().Format(time.RFC3339), ConfigHash: cfg.configHash}
+	if err := t.Execute(&b, data); err != nil {
+		debugf("WinPSP: %s: %v", t.Name(), err)
+		return
+	}
+	writeEvent(eventInfo, eid, b.String())
+}
+
+// writeStartMessage 和 writeStopMessage 在服务状态变化时由 Execute 调用
+func (s *winpspService) writeStartMessage() {
+	if cfg := s.config.Load(); cfg != nil {
+		writeServiceMessage(cfg, eventIDStartMessage, cfg.onStartMessage)
+	}
+}
+
+func (s *winpspService) writeStopMessage() {
+	if cfg := s.config.Load(); cfg != nil {
+		writeServiceMessage(cfg, eventIDStopMessage, cfg.onStopMessage)
+	}
+}
@@ -0,0 +1,116 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+func TestReadConfigServiceMessages(t *testing.T) {
+	tests := []struct {
+		start, stop string
+		wantErr     bool
+	}{
+		{"", "", false},
+		{"WinPSP started on {{.Hostname}}", "", false},
+		{"", "WinPSP stopped at {{.Timestamp}} (config {{.ConfigHash}})", false},
+		{"{{.Hostname}", "", true},     // 语法错误
+		{"", "{{.Host}}", true},        // 没有这个变量
+		{"{{if .Hostname}}", "", true}, // 没有 end
+	}
+	for _, tt := range tests {
+		data, _ := json.Marshal(map[string]string{"command": "backup.exe", "on_start_message": tt.start, "on_stop_message": tt.stop})
+		cfg, err := writeConfig(t, string(data)).readConfig()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("on_start_message %q, on_stop_message %q: readConfig error = %v, wantErr %v", tt.start, tt.stop, err, tt.wantErr)
+			continue
+		}
+		if err == nil && ((cfg.onStartMessage != nil) != (tt.start != "") || (cfg.onStopMessage != nil) != (tt.stop != "")) {
+			t.Errorf("on_start_message %q, on_stop_message %q: templates %v, %v", tt.start, tt.stop, cfg.onStartMessage, cfg.onStopMessage)
+		}
+	}
+}
+
+func TestWriteServiceMessages(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := mockEvents(t)
+	s := loadedService(t, `{
+  "command": "backup.exe",
+  "on_start_message": "WinPSP started on {{.Hostname}} at {{.Timestamp}}",
+  "on_stop_message": "WinPSP stopped on {{.Hostname}}, config {{.ConfigHash}}"
+}`)
+	cfg := s.config.Load()
+
+	before := time.Now().Truncate(time.Second)
+	s.writeStartMessage()
+	s.writeStopMessage()
+	after := time.Now()
+
+	got := events.all()
+	if len(got) != 2 {
+		t.Fatalf("events %+v, want start and stop messages", got)
+	}
+	start, stop := got[0], got[1]
+	if start.kind != eventInfo || start.eid != eventIDStartMessage || !strings.HasPrefix(start.msg, "WinPSP started on "+host+" at ") {
+		t.Errorf("start event %+v", start)
+	} else if ts, err := time.Parse(time.RFC3339, strings.TrimPrefix(start.msg, "WinPSP started on "+host+" at ")); err != nil || ts.Before(before) || ts.After(after) {
+		t.Errorf("start event timestamp %q: %v", start.msg, err)
+	}
+	if want := "WinPSP stopped on " + host + ", config " + cfg.configHash; stop.kind != eventInfo || stop.eid != eventIDStopMessage || stop.msg != want || cfg.configHash == "" {
+		t.Errorf("stop event %+v, want %q", stop, want)
+	}
+
+	// 未配置消息或没有配置时不写事件
+	events = mockEvents(t)
+	loadedService(t, `{"command": "backup.exe"}`).writeStartMessage()
+	(&winpspService{}).writeStopMessage()
+	if got := events.all(); len(got) != 0 {
+		t.Errorf("events without messages: %+v", got)
+	}
+}
+
+// Execute 进入 Running 时写 on_start_message，返回时写 on_stop_message
+func TestExecuteServiceMessages(t *testing.T) {
+	events := mockEvents(t)
+	s := loadedService(t, `{"command": "backup.exe", "on_start_message": "audit: start", "on_stop_message": "audit: stop"}`)
+	s.interactive = true
+
+	r := make(chan svc.ChangeRequest)
+	changes := make(chan svc.Status, 100)
+	done := make(chan struct{})
+	go func() {
+		s.Execute(nil, r, changes)
+		close(done)
+	}()
+	for st := range changes {
+		if st.State == svc.Running {
+			break
+		}
+	}
+	r <- svc.ChangeRequest{Cmd: svc.Stop}
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Execute did not return after Stop")
+	}
+
+	var audit []loggedEvent
+	for _, e := range events.all() {
+		if strings.HasPrefix(e.msg, "audit: ") {
+			audit = append(audit, e)
+		}
+	}
+	if len(audit) != 2 || audit[0].eid != eventIDStartMessage || audit[0].msg != "audit: start" ||
+		audit[1].eid != eventIDStopMessage || audit[1].msg != "audit: stop" {
+		t.Errorf("audit events %+v, want start then stop", audit)
+	}
+}
@@ -0,0 +1,94 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// -------------------- 性能测试 --------------------
+
+// runBenchmark 实现 --benchmark N：按配置把命令连续执行 n 次并统计耗时。
+// 不做重试，也不写日志和运行记录，只看命令本身要跑多久。
+// 配置了 commands 时，一次运行是按顺序执行全部命令。
+func runBenchmark(configPath string, n int, simulate bool) error {
+	if n <= 0 {
+		return errors.New("--benchmark needs a positive number of runs")
+	}
+
+	s := &winpspService{configPath: configPath, simulate: simulate, interactive: true}
+	if err := s.loadConfig(); err != nil {
+		return err
+	}
+	cfg := s.config.Load()
+	entries := cfg.commandEntries()
+	env := cfg.commandEnv()
+
+	fmt.Printf("Benchmarking %d run(s) of %d command(s)\n\n", n, len(entries))
+	fmt.Printf("%-6s %10s  %s\n", "RUN", "TIME (ms)", "RESULT")
+
+	times := make([]time.Duration, 0, n)
+	for i := 1; i <= n; i++ {
+		result := "ok"
+		start := time.Now()
+		for j := range entries {
+			e := &entries[j]
+			timeout := time.Duration(*cfg.Timeout) * time.Second
+			if e.Timeout != nil && *e.Timeout > 0 {
+				timeout = time.Duration(*e.Timeout) * time.Second
+			}
+			opts := s.execOptions(nil, env, nil)
+			opts.Dir = e.WorkingDirectory
+			args, err := s.prepareEntry(e, opts.logf)
+			if err != nil {
+				return err
+			}
+			opts.Args = args
+			exitCode, timedOut, err := s.runEntryCommand(e, timeout, opts)
+			if timedOut {
+				result = e.displayName() + ": timeout"
+				break
+			}
+			if !s.isSuccess(exitCode, err) {
+				if err != nil && !isExitError(err) {
+					result = fmt.Sprintf("%s: %v", e.displayName(), err)
+				} else {
+					result = fmt.Sprintf("%s: exit code %d", e.displayName(), exitCode)
+				}
+				break
+			}
+		}
+		d := time.Since(start)
+		times = append(times, d)
+		fmt.Printf("%-6d %10d  %s\n", i, d.Milliseconds(), result)
+	}
+
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	var total time.Duration
+	for _, d := range times {
+		total += d
+	}
+
+	fmt.Println()
+	fmt.Printf("%10s %10s %10s %10s %10s\n", "MIN (ms)", "MAX", "MEAN", "P95", "P99")
+	fmt.Printf("%10d %10d %10d %10d %10d\n",
+		times[0].Milliseconds(),
+		times[len(times)-1].Milliseconds(),
+		(total / time.Duration(len(times))).Milliseconds(),
+		percentile(times, 95).Milliseconds(),
+		percentile(times, 99).Milliseconds())
+	return nil
+}
+
+// percentile 按最近秩法取已排序 sorted 的第 p 百分位
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
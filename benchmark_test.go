@@ -0,0 +1,150 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	// 等待 args[0] 毫秒，然后以 args[1]（默认 0）退出
+	testHelpers["sleep"] = func(args []string) int {
+		ms, _ := strconv.Atoi(args[0])
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		if len(args) > 1 {
+			code, _ := strconv.Atoi(args[1])
+			return code
+		}
+		return 0
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	ms := func(v ...int) []time.Duration {
+		d := make([]time.Duration, len(v))
+		for i, n := range v {
+			d[i] = time.Duration(n) * time.Millisecond
+		}
+		return d
+	}
+	tests := []struct {
+		sorted []time.Duration
+		p      float64
+		want   time.Duration
+	}{
+		{ms(10), 95, 10 * time.Millisecond},
+		{ms(10, 20, 30, 40, 50), 50, 30 * time.Millisecond},
+		{ms(10, 20, 30, 40, 50), 95, 50 * time.Millisecond},
+		{ms(10, 20, 30, 40, 50), 0, 10 * time.Millisecond},
+		{ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20), 95, 19 * time.Millisecond},
+		{ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20), 99, 20 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := percentile(tt.sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(%v, %v) = %s, want %s", tt.sorted, tt.p, got, tt.want)
+		}
+	}
+}
+
+// benchmarkConfig 写一个运行 sleep 测试子进程的配置，返回路径
+func benchmarkConfig(t *testing.T, args string) string {
+	t.Helper()
+	exe, _ := helperCommandLine(t, "sleep")
+	t.Setenv(testHelperEnv, "sleep")
+	data, err := json.Marshal(map[string]any{"command": exe + " " + args, "timeout": 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "winpsp.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// benchmarkRows 把 runBenchmark 的输出拆成每次运行的行和最后的统计行
+func benchmarkRows(t *testing.T, out string) (runs [][]string, summary []int64) {
+	t.Helper()
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	for _, l := range lines[:len(lines)-1] {
+		f := strings.Fields(l)
+		if len(f) >= 3 && f[0] != "RUN" {
+			if _, err := strconv.Atoi(f[0]); err == nil {
+				runs = append(runs, f)
+			}
+		}
+	}
+	for _, f := range strings.Fields(lines[len(lines)-1]) {
+		v, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			t.Fatalf("summary line %q: %v", lines[len(lines)-1], err)
+		}
+		summary = append(summary, v)
+	}
+	return runs, summary
+}
+
+func TestRunBenchmark(t *testing.T) {
+	const sleepMs = 200
+	// 进程启动的开销，宽松一些以免在慢机器上误报
+	const slackMs = 2000
+	path := benchmarkConfig(t, strconv.Itoa(sleepMs))
+
+	var err error
+	out := captureStdout(t, func() { err = runBenchmark(path, 5, false) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, summary := benchmarkRows(t, out)
+	if len(runs) != 5 || len(summary) != 5 {
+		t.Fatalf("runBenchmark printed:\n%s", out)
+	}
+	for _, r := range runs {
+		ms, _ := strconv.ParseInt(r[1], 10, 64)
+		if ms < sleepMs || ms > sleepMs+slackMs || r[2] != "ok" {
+			t.Errorf("run %s: %s ms, %s, want about %d ms, ok", r[0], r[1], r[2], sleepMs)
+		}
+	}
+
+	lo, hi, mean, p95, p99 := summary[0], summary[1], summary[2], summary[3], summary[4]
+	if lo < sleepMs || lo > mean || mean > hi || hi > sleepMs+slackMs {
+		t.Errorf("min %d, mean %d, max %d ms, want about %d ms", lo, mean, hi, sleepMs)
+	}
+	// 5 次运行时 p95 和 p99 都是最慢的一次
+	if p95 != hi || p99 != hi {
+		t.Errorf("p95 %d, p99 %d, want max %d", p95, p99, hi)
+	}
+}
+
+func TestRunBenchmarkFailure(t *testing.T) {
+	path := benchmarkConfig(t, "0 3")
+	var err error
+	out := captureStdout(t, func() { err = runBenchmark(path, 2, false) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, _ := benchmarkRows(t, out)
+	if len(runs) != 2 {
+		t.Fatalf("runBenchmark printed:\n%s", out)
+	}
+	for _, r := range runs {
+		if result := strings.Join(r[2:], " "); !strings.HasSuffix(result, "exit code 3") {
+			t.Errorf("run %s result %q, want exit code 3", r[0], result)
+		}
+	}
+}
+
+func TestRunBenchmarkInvalid(t *testing.T) {
+	if err := runBenchmark(filepath.Join(t.TempDir(), "winpsp.json"), 0, false); err == nil {
+		t.Error("runBenchmark(0) succeeded")
+	}
+	if err := runBenchmark(filepath.Join(t.TempDir(), "missing.json"), 3, false); err == nil {
+		t.Error("runBenchmark succeeded without a config file")
+	}
+}
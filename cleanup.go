@@ -0,0 +1,61 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// -------------------- 临时文件清理 --------------------
+
+// resolveCleanupPatterns 在加载配置时把相对的 cleanup_patterns 换成以配置文件目录为基准的绝对路径，
+// 并拒绝无效的模式。服务的当前目录是 System32，不能按当前目录匹配。
+func resolveCleanupPatterns(cfg *Config, configPath string) error {
+	resolved := make([]string, len(cfg.CleanupPatterns))
+	for i, pattern := range cfg.CleanupPatterns {
+		resolved[i] = pattern
+		if pattern == "" {
+			return errors.New("invalid cleanup_patterns entry: empty pattern")
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid cleanup_patterns entry %q: %w", pattern, err)
+		}
+		// 只有 "C:\..." 和 "\\server\share\..." 算绝对路径；"\temp\*.tmp" 这种只省略盘符的也要拒绝
+		if !filepath.IsAbs(pattern) {
+			if filepath.VolumeName(pattern) != "" || os.IsPathSeparator(pattern[0]) {
+				return fmt.Errorf("invalid cleanup_patterns entry %q: use a full path or one relative to the config directory", pattern)
+			}
+			resolved[i] = filepath.Join(filepath.Dir(configPath), pattern)
+		}
+	}
+	cfg.CleanupPatterns = resolved
+	return nil
+}
+
+// cleanupFiles 删除匹配 cleanup_patterns 的文件，返回删除的数量。
+// 脚本超时被终止时来不及清理自己的临时文件，由这里兜底。
+func cleanupFiles(patterns []string, logf func(format string, args ...any)) int {
+	deleted := 0
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			logf("Cleanup: invalid pattern %q: %v", pattern, err)
+			continue
+		}
+		for _, path := range matches {
+			if info, err := os.Lstat(path); err != nil || info.IsDir() {
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				logf("Cleanup: cannot delete %s: %v", path, err)
+				continue
+			}
+			debugf("WinPSP: cleanup deleted %s", path)
+			deleted++
+		}
+	}
+	return deleted
+}
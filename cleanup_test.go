@@ -0,0 +1,80 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveCleanupPatterns(t *testing.T) {
+	const configPath = `C:\ProgramData\WinPSP\winpsp.json`
+	tests := []struct {
+		pattern string
+		want    string
+		wantErr bool
+	}{
+		{`C:\Temp\*.tmp`, `C:\Temp\*.tmp`, false},
+		{`\\server\share\winpsp\*.tmp`, `\\server\share\winpsp\*.tmp`, false},
+		{`*.tmp`, `C:\ProgramData\WinPSP\*.tmp`, false},
+		{`tmp\backup-??.log`, `C:\ProgramData\WinPSP\tmp\backup-??.log`, false},
+		{`..\Cache\*`, `C:\ProgramData\Cache\*`, false},
+		{`\Temp\*.tmp`, "", true},  // 省略了盘符
+		{`C:Temp\*.tmp`, "", true}, // 相对于 C: 的当前目录
+		{``, "", true},
+		{`C:\Temp\[`, "", true},
+	}
+	for _, tt := range tests {
+		orig := []string{tt.pattern}
+		cfg := &Config{CleanupPatterns: orig}
+		err := resolveCleanupPatterns(cfg, configPath)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("resolveCleanupPatterns(%q) error = %v, wantErr %v", tt.pattern, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if len(cfg.CleanupPatterns) != 1 || cfg.CleanupPatterns[0] != tt.want {
+			t.Errorf("resolveCleanupPatterns(%q) = %q, want %q", tt.pattern, cfg.CleanupPatterns, tt.want)
+		}
+		// 解析出的是新的切片，重新加载前的配置不受影响
+		if orig[0] != tt.pattern {
+			t.Errorf("resolveCleanupPatterns(%q) modified the original slice", tt.pattern)
+		}
+	}
+}
+
+func TestCleanupFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.tmp", "b.tmp", "keep.log"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 匹配的目录不删除
+	if err := os.Mkdir(filepath.Join(dir, "d.tmp"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{CleanupPatterns: []string{"*.tmp", `missing\*.tmp`}}
+	if err := resolveCleanupPatterns(cfg, filepath.Join(dir, "winpsp.json")); err != nil {
+		t.Fatal(err)
+	}
+	var logged []string
+	logf := func(format string, args ...any) { logged = append(logged, format) }
+
+	if n := cleanupFiles(cfg.CleanupPatterns, logf); n != 2 {
+		t.Errorf("cleanupFiles deleted %d files, want 2 (log: %q)", n, logged)
+	}
+	for name, want := range map[string]bool{"a.tmp": false, "b.tmp": false, "keep.log": true, "d.tmp": true} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists := err == nil; exists != want {
+			t.Errorf("%s exists = %v, want %v", name, exists, want)
+		}
+	}
+	if n := cleanupFiles(cfg.CleanupPatterns, logf); n != 0 {
+		t.Errorf("second cleanupFiles deleted %d files, want 0", n)
+	}
+}
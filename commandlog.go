@@ -0,0 +1,71 @@
+//go:build windows
+
+package main
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const logTimestampLen = len(logTimestampLayout)
+
+// -------------------- per_command_logs --------------------
+
+// per_command_logs 时每条命令的输出写入单独的 winpsp-<时间>-<N>-<name>.log，
+// 时间与本次关机的主日志相同；主日志中只保留 WinPSP 自己的记录。
+// 每个命令名的日志单独按 log_count 轮换，不计入主日志的数量；总大小限制按整个目录计算。
+// pipeline 时各命令的输出连在一起，仍然写入主日志。
+
+// commandLogSuffix 返回第 n 条命令（从 1 开始）的日志文件名中时间戳之后的部分
+func commandLogSuffix(n int, name string) string {
+	safe := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, name)
+	return "-" + strconv.Itoa(n) + "-" + safe + logFileExt
+}
+
+// isCommandLog 判断日志文件是否为某条命令的日志（时间戳之后还有 -<N>-<name>）
+func isCommandLog(name string) bool {
+	rest := strings.TrimSuffix(strings.TrimPrefix(name, logFilePrefix), logFileExt)
+	return len(rest) > logTimestampLen && rest[logTimestampLen] == '-'
+}
+
+// openCommandLog 为第 n 条命令创建日志。mainLog 是本次关机的主日志路径，
+// 命令日志与它放在同一目录、使用相同的时间戳。
+func (s *winpspService) openCommandLog(mainLog string, n int, name string) (*os.File, io.Writer, error) {
+	cfg := s.config.Load()
+	dir := filepath.Dir(mainLog)
+	ts := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(mainLog), logFilePrefix), logFileExt)
+	suffix := commandLogSuffix(n, name)
+
+	if logs, err := listCommandLogs(dir, suffix); err == nil {
+		pruneLogs(dir, logs, cfg.logRetention)
+	}
+	return s.createLogFile(filepath.Join(dir, logFilePrefix+ts+suffix))
+}
+
+// listCommandLogs 返回以 suffix 结尾的命令日志，按文件名（即时间）升序排列
+func listCommandLogs(dir, suffix string) ([]fs.DirEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// os.ReadDir 已按文件名排序
+	var logs []fs.DirEntry
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() && strings.HasPrefix(name, logFilePrefix) && isCommandLog(name) && strings.HasSuffix(name, suffix) {
+			logs = append(logs, e)
+		}
+	}
+	return logs, nil
+}
@@ -0,0 +1,148 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandLogSuffix(t *testing.T) {
+	tests := []struct {
+		n    int
+		name string
+		want string
+	}{
+		{1, "backup.exe", "-1-backup.exe.log"},
+		{2, "sync files", "-2-sync_files.log"},
+		{10, `C:\Tools\a:b*?.bat`, "-10-C__Tools_a_b__.bat.log"},
+		{3, "上传", "-3-__.log"},
+	}
+	for _, tt := range tests {
+		if got := commandLogSuffix(tt.n, tt.name); got != tt.want {
+			t.Errorf("commandLogSuffix(%d, %q) = %q, want %q", tt.n, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsCommandLog(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"winpsp-20261014-083000.log", false},
+		{"winpsp-20261014-083000-1-backup.exe.log", true},
+		{"winpsp-20261014-083000-12-sync_files.log", true},
+		{"winpsp-periodic-20261014-083000.log", false},
+		{"winpsp-backup.log", false},
+	}
+	for _, tt := range tests {
+		if got := isCommandLog(tt.name); got != tt.want {
+			t.Errorf("isCommandLog(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// N 条命令写 N 个命令日志，每个只有自己的输出；主日志中没有命令输出。
+// 命令行中的 ^ 由 cmd.exe 去掉，主日志里 "Running: ..." 行不会与输出相同
+func TestPerCommandLogs(t *testing.T) {
+	s := loadedService(t, `{
+  "commands": [
+    {"name": "first", "command": "cmd.exe /c echo out^put-of-first"},
+    {"name": "second", "command": "cmd.exe /c echo out^put-of-second"},
+    {"name": "third step", "command": "cmd.exe /c echo out^put-of-third"}
+  ],
+  "per_command_logs": true,
+  "log_count": 2
+}`)
+	s.interactive = true
+	dir := filepath.Dir(s.configPath)
+
+	// 同一命令名的旧日志按 log_count 轮换
+	for _, old := range []string{"winpsp-20261011-083000-1-first.log", "winpsp-20261012-083000-1-first.log", "winpsp-20261013-083000-1-first.log"} {
+		if err := os.WriteFile(filepath.Join(dir, old), []byte("old run\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.handleShutdownOnce(); err != nil {
+		t.Fatal(err)
+	}
+
+	mains, err := listLogFiles(dir)
+	if err != nil || len(mains) != 1 {
+		t.Fatalf("main logs %v, %v, want one", mains, err)
+	}
+	ts := strings.TrimSuffix(strings.TrimPrefix(mains[0].Name(), logFilePrefix), logFileExt)
+	main, err := os.ReadFile(filepath.Join(dir, mains[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputs := []string{"output-of-first", "output-of-second", "output-of-third"}
+	for i, name := range []string{"first", "second", "third step"} {
+		path := filepath.Join(dir, logFilePrefix+ts+commandLogSuffix(i+1, name))
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Errorf("command log %d: %v", i+1, err)
+			continue
+		}
+		for j, out := range outputs {
+			if has := strings.Contains(string(data), out); has != (i == j) {
+				t.Errorf("%s contains %s: %v", filepath.Base(path), out, has)
+			}
+		}
+		if strings.Contains(string(main), outputs[i]) {
+			t.Errorf("main log contains the output of command %d:\n%s", i+1, main)
+		}
+	}
+
+	// 最早的被删除；其它命令名的日志不受影响
+	if _, err := os.Stat(filepath.Join(dir, "winpsp-20261011-083000-1-first.log")); !os.IsNotExist(err) {
+		t.Errorf("oldest log of the first command not rotated: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "winpsp-20261013-083000-1-first.log")); err != nil {
+		t.Errorf("newer log of the first command removed: %v", err)
+	}
+}
+
+// 命令日志计入目录的总大小：较早的主日志和其它命令的日志都按修改时间先后被删除
+func TestCommandLogsDirSize(t *testing.T) {
+	s := loadedService(t, `{
+  "commands": [{"name": "first", "command": "cmd.exe /c echo out^put-of-first"}],
+  "per_command_logs": true,
+  "max_log_dir_size_mb": 1
+}`)
+	s.interactive = true
+	dir := filepath.Dir(s.configPath)
+
+	olds := []string{"winpsp-20261012-083000.log", "winpsp-20261013-083000-2-other.log"}
+	for i, old := range olds {
+		path := filepath.Join(dir, old)
+		if err := os.WriteFile(path, bytes.Repeat([]byte("x"), 1<<20), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(time.Duration(i-2) * time.Hour)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.handleShutdownOnce(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, old := range olds {
+		if _, err := os.Stat(filepath.Join(dir, old)); !os.IsNotExist(err) {
+			t.Errorf("%s not removed by the size limit: %v", old, err)
+		}
+	}
+	logs, err := listAllLogFiles(dir)
+	if err != nil || len(logs) != 2 {
+		t.Errorf("logs left %v, %v, want the new shutdown log and command log", logs, err)
+	}
+}
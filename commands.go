@@ -0,0 +1,229 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	sectionFormatPlain = "plain"
+	sectionFormatJSON  = "json"
+)
+
+// -------------------- 多条命令 --------------------
+
+// CommandEntry 是一条要执行的命令。单命令配置中，顶层的 command / timeout
+// 就是唯一的一条；多条命令写在 commands 数组中，按顺序执行。
+type CommandEntry struct {
+	Name    string `json:"name,omitempty"` // 日志和事件中显示的名字，默认为可执行文件名
+	Command string `json:"command"`
+	Timeout *int   `json:"timeout,omitempty"` // seconds；顶层的 timeout 是整个关机阻塞的总预算
+
+	WorkingDirectory string `json:"working_directory,omitempty"`  // 命令的工作目录，空 = 继承
+	WorkingDirSDDL   string `json:"working_dir_sddl,omitempty"`   // 执行前设置到工作目录上的安全描述符，见 workdiracl.go
+	ArgsFile         string `json:"args_file,omitempty"`          // 追加参数的文件，每行一个，见 argsfile.go
+	ArgsFileRequired bool   `json:"args_file_required,omitempty"` // args_file 不存在时视为失败
+
+	wmi bool // 调用 wmi_class 的方法，不执行命令，见 wmi.go
+}
+
+func (e *CommandEntry) displayName() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return commandName(e.Command)
+}
+
+// commandEntries 返回要执行的命令列表
+func (cfg *Config) commandEntries() []CommandEntry {
+	if len(cfg.Commands) > 0 {
+		return cfg.Commands
+	}
+	// 顶层 timeout 已经作为总预算使用，这里不再重复限制
+	e := cfg.CommandEntry
+	e.Timeout = nil
+	if cfg.WMIClass != "" {
+		e.wmi = true
+		e.Command = "wmi:" + wmiDisplayName(cfg)
+		if e.Name == "" {
+			e.Name = wmiDisplayName(cfg)
+		}
+	}
+	return []CommandEntry{e}
+}
+
+// prepareEntry 在执行前设置 working_dir_sddl 并读取 args_file，返回追加的参数
+func (s *winpspService) prepareEntry(e *CommandEntry, logf func(format string, args ...any)) ([]string, error) {
+	if err := applyWorkingDirSDDL(e, logf); err != nil {
+		return nil, err
+	}
+	return s.loadArgsFile(e, logf)
+}
+
+// runEntryCommand 执行一次命令或 WMI 方法调用
+func (s *winpspService) runEntryCommand(e *CommandEntry, timeout time.Duration, opts execOptions) (int, bool, error) {
+	if e.wmi {
+		return s.runWMI(timeout, opts)
+	}
+	return s.runCommand(e.Command, timeout, opts)
+}
+
+func (cfg *Config) fallbackEntries() []CommandEntry {
+	return []CommandEntry{{Name: "fallback_command", Command: cfg.FallbackCommand}}
+}
+
+// commandResult 是一条命令（含重试）的执行结果
+type commandResult struct {
+	Name     string
+	Command  string
+	ExitCode int
+	TimedOut bool
+	Err      error
+	Attempts int
+	Duration time.Duration
+	LogPath  string // per_command_logs 时这条命令的日志
+
+	tail *tailBuffer // 最后一次尝试的输出末尾，未启用时为 nil
+}
+
+func (s *winpspService) succeeded(res *commandResult) bool {
+	return !res.TimedOut && s.isSuccess(res.ExitCode, res.Err)
+}
+
+// runEntry 执行一条命令，失败时按 retry_* 重试。
+// deadline 是整个关机阻塞的截止时间，零值表示不限。
+func (s *winpspService) runEntry(e *CommandEntry, deadline time.Time, output io.Writer, env []string, logf func(format string, args ...any)) commandResult {
+	cfg := s.config.Load()
+	res := commandResult{Name: e.displayName(), Command: e.Command}
+	start := time.Now()
+
+	extraArgs, err := s.prepareEntry(e, logf)
+	if err != nil {
+		logf("Command error: %v", err)
+		res.ExitCode, res.Err, res.Attempts = 1, err, 1
+		res.Duration = time.Since(start)
+		return res
+	}
+
+	// 单条命令自己的超时和总预算，取先到者
+	end := deadline
+	if e.Timeout != nil && *e.Timeout > 0 {
+		if own := start.Add(time.Duration(*e.Timeout) * time.Second); end.IsZero() || own.Before(end) {
+			end = own
+		}
+	}
+
+	for res.Attempts = 1; ; res.Attempts++ {
+		var attemptTimeout time.Duration // 0 = 不限
+		if !end.IsZero() {
+			attemptTimeout = time.Until(end)
+			if attemptTimeout <= 0 {
+				res.TimedOut = true
+				logf("Timeout after %d seconds", int(time.Since(start).Round(time.Second).Seconds()))
+				break
+			}
+		}
+
+		// 每次尝试重新记录输出末尾，失败时只显示最后一次的输出
+		opts := s.execOptions(output, env, logf)
+		opts.Dir, opts.Args = e.WorkingDirectory, extraArgs
+		if n := *cfg.FailureOutputLines; n > 0 {
+			res.tail = newTailBuffer(n)
+			opts.Tee = res.tail
+		}
+		stopWarning := s.startDeadlineWarning(e.Command, attemptTimeout, logf)
+		res.ExitCode, res.TimedOut, res.Err = s.runEntryCommand(e, attemptTimeout, opts)
+		stopWarning()
+
+		if res.Err != nil && !res.TimedOut && !isExitError(res.Err) {
+			logf("Command error: %v", res.Err)
+		}
+		if res.TimedOut {
+			logf("Timeout after %d seconds", int(time.Since(start).Round(time.Second).Seconds()))
+			break
+		}
+		logf("Exit code: %d", res.ExitCode)
+
+		if s.isSuccess(res.ExitCode, res.Err) {
+			if res.ExitCode != 0 {
+				logf("Exit code %d is in success_exit_codes, treating as success", res.ExitCode)
+			}
+			break
+		}
+		if res.Attempts > cfg.RetryCount {
+			break
+		}
+
+		delay := retryDelay(cfg, res.Attempts)
+		if !end.IsZero() && delay >= time.Until(end) {
+			logf("No time left for retry within timeout, giving up")
+			break
+		}
+
+		logf("Attempt %d/%d failed, retrying in %s", res.Attempts, cfg.RetryCount+1, delay.Round(time.Millisecond))
+		time.Sleep(delay)
+	}
+
+	res.Duration = time.Since(start)
+	return res
+}
+
+// -------------------- 分节标记 --------------------
+
+// sectionStart / sectionEnd 在日志（和输出管道）中标出每条命令输出的起止，
+// 多条命令写入同一个日志时能分清哪段输出属于哪条命令
+func (s *winpspService) sectionStart(w io.Writer, name string, at time.Time) {
+	cfg := s.config.Load()
+	if w == nil || !*cfg.LogSectionMarkers {
+		return
+	}
+	if cfg.LogSectionFormat == sectionFormatJSON {
+		writeJSONLine(w, map[string]any{
+			"section":     "start",
+			"name":        name,
+			"time":        at.Format(time.RFC3339),
+			"hostname":    s.run.Hostname,
+			"run_id":      s.run.RunID,
+			"config_hash": s.run.ConfigHash,
+		})
+		return
+	}
+	fmt.Fprintf(w, "=== START: %s at %s ===\n", name, at.Format(cfg.LogTimestampFormat))
+}
+
+func (s *winpspService) sectionEnd(w io.Writer, res *commandResult) {
+	cfg := s.config.Load()
+	if w == nil || !*cfg.LogSectionMarkers {
+		return
+	}
+	if cfg.LogSectionFormat == sectionFormatJSON {
+		writeJSONLine(w, map[string]any{
+			"section":     "end",
+			"name":        res.Name,
+			"exit_code":   res.ExitCode,
+			"timed_out":   res.TimedOut,
+			"duration_ms": res.Duration.Milliseconds(),
+			"hostname":    s.run.Hostname,
+			"run_id":      s.run.RunID,
+			"config_hash": s.run.ConfigHash,
+		})
+		return
+	}
+	timeout := ""
+	if res.TimedOut {
+		timeout = " timed_out"
+	}
+	fmt.Fprintf(w, "=== END: %s exit_code=%d duration=%.1fs%s ===\n", res.Name, res.ExitCode, res.Duration.Seconds(), timeout)
+}
+
+func writeJSONLine(w io.Writer, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	w.Write(append(data, '\n'))
+}
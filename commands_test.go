@@ -0,0 +1,112 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSectionMarkers(t *testing.T) {
+	at := time.Date(2026, 10, 14, 8, 30, 0, 0, time.Local)
+	tests := []struct {
+		res  commandResult
+		want string
+	}{
+		{commandResult{Name: "backup.exe", ExitCode: 0, Duration: 5200 * time.Millisecond},
+			"=== START: backup.exe at 2026-10-14 08:30:00 ===\n=== END: backup.exe exit_code=0 duration=5.2s ===\n"},
+		{commandResult{Name: "flush", ExitCode: 1, TimedOut: true, Duration: 300 * time.Second},
+			"=== START: flush at 2026-10-14 08:30:00 ===\n=== END: flush exit_code=1 duration=300.0s timed_out ===\n"},
+	}
+	s := loadedService(t, `{"command": "backup.exe"}`)
+	for _, tt := range tests {
+		var out bytes.Buffer
+		s.sectionStart(&out, tt.res.Name, at)
+		s.sectionEnd(&out, &tt.res)
+		if out.String() != tt.want {
+			t.Errorf("markers = %q, want %q", out.String(), tt.want)
+		}
+	}
+
+	// 没有写入目标或关闭了标记时什么也不写
+	s.sectionStart(nil, "backup.exe", at)
+	off := loadedService(t, `{"command": "backup.exe", "log_section_markers": false}`)
+	var out bytes.Buffer
+	off.sectionStart(&out, "backup.exe", at)
+	off.sectionEnd(&out, &tests[0].res)
+	if out.Len() != 0 {
+		t.Errorf("markers written with log_section_markers false: %q", out.String())
+	}
+}
+
+func TestSectionMarkersJSON(t *testing.T) {
+	s := loadedService(t, `{"command": "backup.exe", "log_section_format": "json"}`)
+	s.run = newRunContext(s.config.Load())
+	at := time.Date(2026, 10, 14, 8, 30, 0, 0, time.UTC)
+
+	var out bytes.Buffer
+	s.sectionStart(&out, "backup.exe", at)
+	s.sectionEnd(&out, &commandResult{Name: "backup.exe", ExitCode: 2, Duration: 1500 * time.Millisecond})
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("markers = %q, want two JSON lines", out.String())
+	}
+	var start, end map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &start); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &end); err != nil {
+		t.Fatal(err)
+	}
+	if start["section"] != "start" || start["name"] != "backup.exe" || start["time"] != "2026-10-14T08:30:00Z" ||
+		start["run_id"] != s.run.RunID || start["hostname"] != s.run.Hostname {
+		t.Errorf("start marker = %v", start)
+	}
+	if end["section"] != "end" || end["exit_code"] != float64(2) || end["timed_out"] != false ||
+		end["duration_ms"] != float64(1500) || end["run_id"] != s.run.RunID {
+		t.Errorf("end marker = %v", end)
+	}
+}
+
+// 多条命令时每条命令的输出夹在自己的 START / END 之间
+func TestShutdownSectionMarkers(t *testing.T) {
+	s := loadedService(t, `{
+  "commands": [
+    {"name": "first", "command": "cmd.exe /c echo output of first"},
+    {"name": "second", "command": "cmd.exe /c echo output of second"}
+  ]
+}`)
+	s.interactive = true
+	log := shutdownLog(t, s)
+
+	var got []string
+	for _, l := range strings.Split(log, "\n") {
+		l = strings.TrimRight(l, "\r ")
+		// 标记直接写入日志，命令输出带时间戳
+		if strings.HasPrefix(l, "=== ") {
+			got = append(got, l)
+		} else if _, out, ok := strings.Cut(l, "] "); ok && strings.HasPrefix(out, "output of ") {
+			got = append(got, out)
+		}
+	}
+	want := []*regexp.Regexp{
+		regexp.MustCompile(`^=== START: first at \d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2} ===$`),
+		regexp.MustCompile(`^output of first$`),
+		regexp.MustCompile(`^=== END: first exit_code=0 duration=\d+\.\ds ===$`),
+		regexp.MustCompile(`^=== START: second at .* ===$`),
+		regexp.MustCompile(`^output of second$`),
+		regexp.MustCompile(`^=== END: second exit_code=0 duration=\d+\.\ds ===$`),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("markers and output in log: %q", got)
+	}
+	for i, re := range want {
+		if !re.MatchString(got[i]) {
+			t.Errorf("line %d = %q, want %s", i+1, got[i], re)
+		}
+	}
+}
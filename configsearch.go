@@ -0,0 +1,79 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// 沿目录树向上查找时识别的配置文件名（按优先级）
+var searchConfigNames = []string{"winpsp.json", "winpsp-config.json"}
+
+// -------------------- 配置文件查找 --------------------
+
+// findConfig 从 startDir 开始逐级向上查找配置文件，到文件系统根目录为止。
+// 找不到时返回 defaultConfigPath。
+func findConfig(startDir string) string {
+	dir, err := filepath.Abs(startDir)
+	if err != nil {
+		return defaultConfigPath
+	}
+
+	for {
+		for _, name := range searchConfigNames {
+			p := filepath.Join(dir, name)
+			if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+				return p
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return defaultConfigPath
+		}
+		dir = parent
+	}
+}
+
+// resolveConfigPath 决定本次运行使用的配置文件：
+// 显式 --config 优先；交互模式下再按目录树查找（--no-search 关闭）；
+// 服务模式的工作目录是 System32，不做查找。
+func resolveConfigPath(explicit string, noSearch, isInteractive bool) string {
+	if explicit != "" {
+		return explicit
+	}
+	if !isInteractive || noSearch {
+		return defaultConfigPath
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return defaultConfigPath
+	}
+	return findConfig(wd)
+}
+
+// -------------------- --config-from-stdin --------------------
+
+// 从标准输入读入的配置。stdinConfigPath 是代替配置文件的路径（实例的默认配置路径），
+// 日志目录、相对路径等仍按它推导；读这个路径时返回 stdinConfig，不读磁盘。
+var (
+	stdinConfig     []byte
+	stdinConfigPath string
+)
+
+// readStdinConfig 读完 r 作为配置，返回代替配置文件的路径
+func readStdinConfig(r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	if len(data) == 0 {
+		return "", errors.New("no config on standard input")
+	}
+	stdinConfig, stdinConfigPath = data, defaultConfigPath
+	return stdinConfigPath, nil
+}
@@ -0,0 +1,148 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindConfig(t *testing.T) {
+	root := t.TempDir()
+	deep := filepath.Join(root, "a", "b", "c")
+	if err := os.MkdirAll(deep, 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(rel string) string {
+		p := filepath.Join(root, rel)
+		if err := os.WriteFile(p, []byte(`{}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	// 没有配置文件：使用默认路径
+	if got := findConfig(deep); got != defaultConfigPath {
+		t.Fatalf("findConfig with no config = %q, want %q", got, defaultConfigPath)
+	}
+
+	levelA := write(filepath.Join("a", "winpsp-config.json"))
+	// 同名目录不算
+	if err := os.Mkdir(filepath.Join(root, "a", "b", "winpsp.json"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		start, want string
+	}{
+		{deep, levelA},
+		{filepath.Join(root, "a", "b"), levelA},
+		{filepath.Join(root, "a"), levelA},
+		{root, defaultConfigPath},
+	}
+	for _, tt := range tests {
+		if got := findConfig(tt.start); got != tt.want {
+			t.Errorf("findConfig(%q) = %q, want %q", tt.start, got, tt.want)
+		}
+	}
+
+	// 同一目录中 winpsp.json 优先；更近的目录优先
+	preferred := write(filepath.Join("a", "winpsp.json"))
+	if got := findConfig(deep); got != preferred {
+		t.Errorf("findConfig with both names = %q, want %q", got, preferred)
+	}
+	nearest := write(filepath.Join("a", "b", "c", "winpsp-config.json"))
+	if got := findConfig(deep); got != nearest {
+		t.Errorf("findConfig with a config in the start directory = %q, want %q", got, nearest)
+	}
+}
+
+func TestResolveConfigPath(t *testing.T) {
+	dir := t.TempDir()
+	found := filepath.Join(dir, "winpsp.json")
+	if err := os.WriteFile(found, []byte(`{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+
+	tests := []struct {
+		explicit              string
+		noSearch, interactive bool
+		want                  string
+	}{
+		{`D:\cfg\winpsp.json`, false, true, `D:\cfg\winpsp.json`},
+		{`D:\cfg\winpsp.json`, false, false, `D:\cfg\winpsp.json`},
+		{"", false, true, found},
+		{"", true, true, defaultConfigPath},   // --no-search
+		{"", false, false, defaultConfigPath}, // 服务：工作目录是 System32
+	}
+	for _, tt := range tests {
+		if got := resolveConfigPath(tt.explicit, tt.noSearch, tt.interactive); got != tt.want {
+			t.Errorf("resolveConfigPath(%q, noSearch=%v, interactive=%v) = %q, want %q",
+				tt.explicit, tt.noSearch, tt.interactive, got, tt.want)
+		}
+	}
+}
+
+// pipeStdin 让 os.Stdin 在测试期间从管道读出 data，写完后关闭写端
+func pipeStdin(t *testing.T, data []byte) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		w.Write(data)
+		w.Close()
+	}()
+	orig := os.Stdin
+	os.Stdin = r
+	origConfig, origPath := stdinConfig, stdinConfigPath
+	t.Cleanup(func() {
+		os.Stdin = orig
+		r.Close()
+		stdinConfig, stdinConfigPath = origConfig, origPath
+	})
+}
+
+func TestReadStdinConfig(t *testing.T) {
+	const config = `{"command": "cmd.exe /c echo from stdin", "timeout": 45}`
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"UTF-8", []byte(config), false},
+		{"UTF-8 with BOM", append([]byte{0xEF, 0xBB, 0xBF}, config...), false},
+		{"UTF-16LE with BOM", utf16le(t, config, true), false},
+		{"larger than a pipe buffer", []byte(`{"command": "cmd.exe /c echo from stdin", "timeout": 45, "env": {"PAD": "` +
+			strings.Repeat("x", 256<<10) + `"}}`), false},
+		{"empty", nil, true},
+	}
+	for _, tt := range tests {
+		pipeStdin(t, tt.data)
+		path, err := readStdinConfig(os.Stdin)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: readStdinConfig error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if path != defaultConfigPath {
+			t.Errorf("%s: config path %q, want %q (logs go to the default config directory)", tt.name, path, defaultConfigPath)
+		}
+
+		// 之后读取这个路径时使用标准输入的内容，不读磁盘
+		s := &winpspService{configPath: path}
+		if err := s.loadConfig(); err != nil {
+			t.Errorf("%s: loadConfig: %v", tt.name, err)
+			continue
+		}
+		if cfg := s.config.Load(); cfg.Command != "cmd.exe /c echo from stdin" || *cfg.Timeout != 45 {
+			t.Errorf("%s: config from stdin: command %q, timeout %d", tt.name, cfg.Command, *cfg.Timeout)
+		}
+	}
+}
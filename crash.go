@@ -0,0 +1,66 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+)
+
+// -------------------- 崩溃记录 --------------------
+
+const crashFilePrefix = "winpsp-crash-"
+
+// commandStarting 在每条命令（或整条 pipeline）开始前调用，测试中用来注入 panic。可在调试时替换
+var commandStarting = func(command string) {}
+
+// crashRecord 是 winpsp-crash-<时间>.json 的内容
+type crashRecord struct {
+	Time    time.Time `json:"time"`
+	Panic   string    `json:"panic"`
+	Command string    `json:"command,omitempty"`
+	Stack   string    `json:"stack"`
+}
+
+// recoverShutdown 由 handleShutdownOnce defer 调用。
+// 关机处理中途 panic 时留下崩溃文件、关闭日志并写入事件日志，然后照常放行关机；
+// command 指向当时正在执行的命令。
+func (s *winpspService) recoverShutdown(logFile *os.File, logf func(format string, args ...any), command *string) {
+	v := recover()
+	if v == nil {
+		return
+	}
+
+	rec := crashRecord{
+		Time:    time.Now(),
+		Panic:   fmt.Sprint(v),
+		Command: *command,
+		Stack:   string(debug.Stack()),
+	}
+	msg := fmt.Sprintf("WinPSP: panic during shutdown handling: %v", v)
+	if path, err := s.writeCrashRecord(&rec); err != nil {
+		msg += fmt.Sprintf(" (crash file not written: %v)", err)
+	} else {
+		msg += " (details: " + path + ")"
+	}
+
+	logf("Panic: %v", v)
+	logf("Shutdown released")
+	if logFile != nil {
+		logFile.Close()
+	}
+	writeEvent(eventError, eventIDPanic, msg)
+}
+
+func (s *winpspService) writeCrashRecord(rec *crashRecord) (string, error) {
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(filepath.Dir(s.configPath), crashFilePrefix+rec.Time.Format("20060102-150405")+".json")
+	return path, os.WriteFile(path, data, 0644)
+}
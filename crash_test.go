@@ -0,0 +1,96 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShutdownPanic(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		command string // 在这条命令开始时 panic，崩溃文件中应记录它
+	}{
+		{
+			"single command",
+			`{"command": "cmd.exe /c echo first"}`,
+			"cmd.exe /c echo first",
+		},
+		{
+			"second command",
+			`{"commands": [{"command": "cmd.exe /c echo first"}, {"command": "cmd.exe /c echo second"}]}`,
+			"cmd.exe /c echo second",
+		},
+		{
+			"pipeline",
+			`{"pipeline": true, "commands": [{"command": "cmd.exe /c echo first"}, {"command": "findstr first"}]}`,
+			"cmd.exe /c echo first | findstr first",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := mockEvents(t)
+			orig := commandStarting
+			commandStarting = func(command string) {
+				if command == tt.command {
+					panic("injected panic")
+				}
+			}
+			t.Cleanup(func() { commandStarting = orig })
+
+			s := loadedService(t, tt.config)
+			s.interactive = true
+			log := shutdownLog(t, s)
+			for _, want := range []string{"Panic: injected panic", "Shutdown released"} {
+				if !strings.Contains(log, want) {
+					t.Errorf("log does not contain %q:\n%s", want, log)
+				}
+			}
+
+			crashes, _ := filepath.Glob(filepath.Join(filepath.Dir(s.configPath), crashFilePrefix+"*.json"))
+			if len(crashes) != 1 {
+				t.Fatalf("crash files: %q, want one", crashes)
+			}
+			data, err := os.ReadFile(crashes[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rec crashRecord
+			if err := json.Unmarshal(data, &rec); err != nil {
+				t.Fatalf("crash file: %v\n%s", err, data)
+			}
+			if rec.Panic != "injected panic" || rec.Command != tt.command || rec.Time.IsZero() {
+				t.Errorf("crash record = %+v, want panic %q, command %q", rec, "injected panic", tt.command)
+			}
+			if !strings.Contains(rec.Stack, "TestShutdownPanic") {
+				t.Errorf("crash stack does not show where the panic happened:\n%s", rec.Stack)
+			}
+
+			var found bool
+			for _, e := range events.all() {
+				if e.eid == eventIDPanic {
+					found = e.kind == eventError && strings.Contains(e.msg, crashes[0])
+				}
+			}
+			if !found {
+				t.Errorf("no error event pointing at %s: %+v", crashes[0], events.all())
+			}
+		})
+	}
+}
+
+func TestShutdownNoPanic(t *testing.T) {
+	s := loadedService(t, `{"command": "cmd.exe /c echo ok"}`)
+	s.interactive = true
+	if log := shutdownLog(t, s); strings.Contains(log, "Panic:") {
+		t.Errorf("log reports a panic:\n%s", log)
+	}
+	if crashes, _ := filepath.Glob(filepath.Join(filepath.Dir(s.configPath), crashFilePrefix+"*")); len(crashes) != 0 {
+		t.Errorf("crash files written without a panic: %q", crashes)
+	}
+}
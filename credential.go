@@ -0,0 +1,181 @@
+//go:build windows
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// -------------------- 凭据管理器 --------------------
+
+// webhook_token_credential_name 设置后，webhook 的 Bearer token 从 Windows 凭据管理器
+// 读取（普通凭据，CRED_TYPE_GENERIC），不再需要写在配置文件里。
+// 凭据属于某个用户：服务以 LocalSystem 运行，令牌必须保存在 SYSTEM 的凭据中。
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+)
+
+var (
+	modadvapi32     = windows.NewLazySystemDLL("advapi32.dll")
+	procCredReadW   = modadvapi32.NewProc("CredReadW")
+	procCredWriteW  = modadvapi32.NewProc("CredWriteW")
+	procCredDeleteW = modadvapi32.NewProc("CredDeleteW")
+	procCredFree    = modadvapi32.NewProc("CredFree")
+)
+
+// credential 对应 Win32 CREDENTIALW
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        windows.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// 凭据读写，可在调试时替换
+var (
+	readCredential   = credReadGeneric
+	writeCredential  = credWriteGeneric
+	deleteCredential = credDeleteGeneric
+)
+
+// credReadGeneric 读取普通凭据的密码部分。
+// 与凭据管理器界面和 cmdkey 一致，密码按 UTF-16LE 保存。
+func credReadGeneric(target string) (string, error) {
+	name, err := windows.UTF16PtrFromString(target)
+	if err != nil {
+		return "", err
+	}
+
+	var c *credential
+	r, _, e := procCredReadW.Call(uintptr(unsafe.Pointer(name)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&c)))
+	if r == 0 {
+		if errors.Is(e, windows.ERROR_NOT_FOUND) {
+			return "", fmt.Errorf("credential %q not found", target)
+		}
+		return "", e
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(c)))
+
+	if c.CredentialBlobSize == 0 {
+		return "", nil
+	}
+	blob := unsafe.Slice((*uint16)(unsafe.Pointer(c.CredentialBlob)), c.CredentialBlobSize/2)
+	return windows.UTF16ToString(blob), nil
+}
+
+func credWriteGeneric(target, secret string) error {
+	name, err := windows.UTF16PtrFromString(target)
+	if err != nil {
+		return err
+	}
+	blob, err := windows.UTF16FromString(secret)
+	if err != nil {
+		return err
+	}
+	blob = blob[:len(blob)-1] // 不含结尾的 0
+
+	c := credential{
+		Type:               credTypeGeneric,
+		TargetName:         name,
+		CredentialBlobSize: uint32(len(blob) * 2),
+		Persist:            credPersistLocalMachine,
+	}
+	if len(blob) > 0 {
+		c.CredentialBlob = (*byte)(unsafe.Pointer(&blob[0]))
+	}
+	if r, _, e := procCredWriteW.Call(uintptr(unsafe.Pointer(&c)), 0); r == 0 {
+		return e
+	}
+	return nil
+}
+
+// credDeleteGeneric 删除普通凭据，凭据不存在时返回 os.ErrNotExist
+func credDeleteGeneric(target string) error {
+	name, err := windows.UTF16PtrFromString(target)
+	if err != nil {
+		return err
+	}
+	if r, _, e := procCredDeleteW.Call(uintptr(unsafe.Pointer(name)), credTypeGeneric, 0); r == 0 {
+		if errors.Is(e, windows.ERROR_NOT_FOUND) {
+			return os.ErrNotExist
+		}
+		return e
+	}
+	return nil
+}
+
+// webhookToken 返回 webhook 的 Bearer token：凭据名优先，否则用 webhook_token
+func webhookToken(cfg *Config) (string, error) {
+	if cfg.WebhookTokenCredentialName == "" {
+		return cfg.WebhookToken, nil
+	}
+	token, err := readCredential(cfg.WebhookTokenCredentialName)
+	if err != nil {
+		return "", fmt.Errorf("read webhook token: %w", err)
+	}
+	return token, nil
+}
+
+// storeWebhookToken 实现 --store-webhook-token：从控制台读入令牌（不回显），
+// 保存到当前用户的凭据管理器中，凭据名取自配置的 webhook_token_credential_name
+func storeWebhookToken(configPath string) error {
+	cfg, err := parseConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+	if cfg.WebhookTokenCredentialName == "" {
+		return errors.New("webhook_token_credential_name is not set in the config file")
+	}
+
+	fmt.Printf("Webhook token for %s: ", cfg.WebhookTokenCredentialName)
+	token, err := readSecret()
+	fmt.Println()
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		return errors.New("empty token, nothing stored")
+	}
+
+	if err := writeCredential(cfg.WebhookTokenCredentialName, token); err != nil {
+		return err
+	}
+	fmt.Printf("Token stored as credential %s.\n", cfg.WebhookTokenCredentialName)
+	if isSystem, err := runningAsSystem(); err == nil && !isSystem {
+		fmt.Println("Warning: stored for the current user; the service reads the credentials of LocalSystem.")
+	}
+	return nil
+}
+
+// readSecret 从标准输入读一行，控制台上关闭回显
+func readSecret() (string, error) {
+	h := windows.Handle(os.Stdin.Fd())
+	var mode uint32
+	if windows.GetConsoleMode(h, &mode) == nil {
+		windows.SetConsoleMode(h, mode&^windows.ENABLE_ECHO_INPUT)
+		defer windows.SetConsoleMode(h, mode)
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,198 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// credentialStore 是模拟的凭据管理器
+type credentialStore struct {
+	mu      sync.Mutex
+	secrets map[string]string
+	reads   []string
+	err     error // 非 nil 时读写都返回这个错误
+}
+
+// mockCredentials 替换 readCredential / writeCredential，返回模拟的存储
+func mockCredentials(t *testing.T, secrets map[string]string) *credentialStore {
+	t.Helper()
+	cs := &credentialStore{secrets: map[string]string{}}
+	for k, v := range secrets {
+		cs.secrets[k] = v
+	}
+	origRead, origWrite := readCredential, writeCredential
+	readCredential = func(target string) (string, error) {
+		cs.mu.Lock()
+		defer cs.mu.Unlock()
+		cs.reads = append(cs.reads, target)
+		if cs.err != nil {
+			return "", cs.err
+		}
+		secret, ok := cs.secrets[target]
+		if !ok {
+			return "", fmt.Errorf("credential %q not found", target)
+		}
+		return secret, nil
+	}
+	writeCredential = func(target, secret string) error {
+		cs.mu.Lock()
+		defer cs.mu.Unlock()
+		if cs.err != nil {
+			return cs.err
+		}
+		cs.secrets[target] = secret
+		return nil
+	}
+	t.Cleanup(func() { readCredential, writeCredential = origRead, origWrite })
+	return cs
+}
+
+func TestWebhookToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		storeErr error
+		want     string
+		wantErr  bool
+		wantRead bool
+	}{
+		{"token in config", Config{WebhookToken: "from-config"}, nil, "from-config", false, false},
+		{"no token", Config{}, nil, "", false, false},
+		{"credential", Config{WebhookTokenCredentialName: "WinPSP/webhook"}, nil, "from-store", false, true},
+		{"credential wins over config", Config{WebhookToken: "from-config", WebhookTokenCredentialName: "WinPSP/webhook"}, nil, "from-store", false, true},
+		{"credential missing", Config{WebhookTokenCredentialName: "WinPSP/other"}, nil, "", true, true},
+		{"credential manager error", Config{WebhookTokenCredentialName: "WinPSP/webhook"}, errors.New("Access is denied."), "", true, true},
+	}
+	for _, tt := range tests {
+		cs := mockCredentials(t, map[string]string{"WinPSP/webhook": "from-store"})
+		cs.err = tt.storeErr
+		got, err := webhookToken(&tt.cfg)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%s: webhookToken = %q, %v, want %q, error %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+		if read := len(cs.reads) > 0; read != tt.wantRead {
+			t.Errorf("%s: credential read %v, want %v", tt.name, read, tt.wantRead)
+		}
+	}
+}
+
+func TestSendWebhookCredentialToken(t *testing.T) {
+	const secret = "s3cr3t-from-credential-manager"
+	var mu sync.Mutex
+	var auth []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		auth = append(auth, r.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+
+	cs := mockCredentials(t, map[string]string{"WinPSP/webhook": secret})
+	s := &winpspService{}
+	s.config.Store(&Config{WebhookURL: srv.URL, WebhookToken: "ignored", WebhookTokenCredentialName: "WinPSP/webhook"})
+	rec := &runRecord{LastRun: time.Now(), Command: "backup.exe"}
+	if err := s.sendWebhook(rec); err != nil {
+		t.Fatal(err)
+	}
+
+	// 读不到凭据时不发送请求，错误信息中也没有 token
+	cs.err = errors.New("Element not found.")
+	err := s.sendWebhook(rec)
+	if err == nil {
+		t.Fatal("sendWebhook succeeded without the credential")
+	}
+	if strings.Contains(err.Error(), secret) {
+		t.Errorf("error contains the token: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(auth) != 1 || auth[0] != "Bearer "+secret {
+		t.Errorf("Authorization headers %q, want one with the stored token", auth)
+	}
+}
+
+// withStdin 让 os.Stdin 在测试期间读出 input
+func withStdin(t *testing.T, input string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "stdin.txt")
+	if err := os.WriteFile(path, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stdin
+	os.Stdin = f
+	t.Cleanup(func() {
+		os.Stdin = orig
+		f.Close()
+	})
+}
+
+func TestStoreWebhookToken(t *testing.T) {
+	const secret = "s3cr3t"
+	tests := []struct {
+		name    string
+		config  string
+		input   string
+		wantErr bool
+		want    string // 存入 WinPSP/webhook 的 token，空表示没有存入
+	}{
+		{"stored", `{"command": "x.exe", "webhook_token_credential_name": "WinPSP/webhook"}`, secret + "\r\n", false, secret},
+		{"no trailing newline", `{"command": "x.exe", "webhook_token_credential_name": "WinPSP/webhook"}`, secret, false, secret},
+		{"empty token", `{"command": "x.exe", "webhook_token_credential_name": "WinPSP/webhook"}`, "\r\n", true, ""},
+		{"no credential name", `{"command": "x.exe"}`, secret + "\r\n", true, ""},
+	}
+	for _, tt := range tests {
+		cs := mockCredentials(t, nil)
+		s := writeConfig(t, tt.config)
+		withStdin(t, tt.input)
+		var err error
+		out := captureStdout(t, func() { err = storeWebhookToken(s.configPath) })
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: storeWebhookToken error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if got := cs.secrets["WinPSP/webhook"]; got != tt.want {
+			t.Errorf("%s: stored token %q, want %q", tt.name, got, tt.want)
+		}
+		if strings.Contains(out, secret) {
+			t.Errorf("%s: token printed to the console:\n%s", tt.name, out)
+		}
+	}
+}
+
+// 真实的凭据管理器：写入、读出、删除当前用户的一个临时凭据
+func TestCredentialRoundTrip(t *testing.T) {
+	target := fmt.Sprintf("WinPSP-test/%d", time.Now().UnixNano())
+	const secret = "token with ünïcödé and spaces"
+	if err := credWriteGeneric(target, secret); err != nil {
+		t.Skipf("CredWriteW: %v", err)
+	}
+	defer credDeleteGeneric(target)
+
+	got, err := credReadGeneric(target)
+	if err != nil || got != secret {
+		t.Errorf("credReadGeneric = %q, %v, want %q", got, err, secret)
+	}
+	if err := credDeleteGeneric(target); err != nil {
+		t.Errorf("credDeleteGeneric: %v", err)
+	}
+	if _, err := credReadGeneric(target); err == nil {
+		t.Error("credential still readable after deleting it")
+	}
+	if err := credDeleteGeneric(target); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("deleting a missing credential = %v, want os.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,75 @@
+//go:build windows
+
+package main
+
+import (
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+const defaultGracePeriodSecs = 5
+
+var (
+	modkernel32               = windows.NewLazySystemDLL("kernel32.dll")
+	procAttachConsole         = modkernel32.NewProc("AttachConsole")
+	procFreeConsole           = modkernel32.NewProc("FreeConsole")
+	procSetConsoleCtrlHandler = modkernel32.NewProc("SetConsoleCtrlHandler")
+)
+
+// consoleMu 保护本进程的控制台状态（附加的控制台、控制台事件处理）。
+// 这些都是整个进程共用的，关机处理与 periodic_command 可能同时修改。
+var consoleMu sync.Mutex
+
+// -------------------- 超时两段式终止 --------------------
+
+// setGracefulCancel 让超时先发送 Ctrl+Break，等待 grace 后仍未退出才 TerminateProcess。
+// 子进程必须在新的进程组中创建，Ctrl+Break 才能只发给它而不波及 WinPSP 自己。
+func setGracefulCancel(cmd *exec.Cmd, grace time.Duration) {
+	if grace <= 0 {
+		// 无宽限期 → 保持默认行为：直接 TerminateProcess
+		return
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= windows.CREATE_NEW_PROCESS_GROUP
+
+	cmd.Cancel = func() error {
+		if err := sendCtrlBreak(cmd.Process.Pid); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+	// WaitDelay 到期后 os/exec 会强制结束进程
+	cmd.WaitDelay = grace
+}
+
+// sendCtrlBreak 向以 pid 为组长的进程组发送 CTRL_BREAK_EVENT。
+// 服务进程没有控制台，此时临时附加到子进程的控制台再发送。
+// 一个进程同时只能附加一个控制台，两个命令同时超时时必须依次进行，
+// 否则一个的 FreeConsole 会把另一个刚附加的控制台释放掉。
+func sendCtrlBreak(pid int) error {
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+
+	err := windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(pid))
+	if err == nil {
+		return nil
+	}
+
+	if r, _, e := procAttachConsole.Call(uintptr(pid)); r == 0 {
+		return e
+	}
+	defer procFreeConsole.Call()
+
+	// 附加期间忽略控制台事件，避免自己被一并终止
+	procSetConsoleCtrlHandler.Call(0, 1)
+	defer procSetConsoleCtrlHandler.Call(0, 0)
+
+	return windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(pid))
+}
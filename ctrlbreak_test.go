@@ -0,0 +1,76 @@
+//go:build windows
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	// 等待 Ctrl+Break（Go 中收到的是 os.Interrupt），收到后输出 caught 并以 3 退出
+	testHelpers["ctrlbreak"] = func(args []string) int {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt)
+		fmt.Println("ready")
+		select {
+		case <-c:
+			fmt.Println("caught")
+			return 3
+		case <-time.After(30 * time.Second):
+			return 1
+		}
+	}
+}
+
+func TestGracefulCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cmd := helperCommand(ctx, t, "ctrlbreak")
+	setGracefulCancel(cmd, 10*time.Second)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	sc := bufio.NewScanner(stdout)
+	if !sc.Scan() || sc.Text() != "ready" {
+		cmd.Process.Kill()
+		cmd.Wait()
+		t.Fatalf("helper did not start: %q", sc.Text())
+	}
+
+	// 超时：先发 Ctrl+Break，子进程自己退出，不需要等到宽限期结束
+	start := time.Now()
+	cancel()
+	var out strings.Builder
+	for sc.Scan() {
+		out.WriteString(sc.Text())
+	}
+	err = cmd.Wait()
+	if code := cmd.ProcessState.ExitCode(); code != 3 || out.String() != "caught" {
+		t.Fatalf("helper exit code %d, output %q, err %v; want 3 and caught", code, out.String(), err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("helper took %s to exit after Ctrl+Break", d)
+	}
+}
+
+func TestGracefulCancelDisabled(t *testing.T) {
+	cmd := exec.Command("cmd.exe")
+	setGracefulCancel(cmd, 0)
+	if cmd.Cancel != nil || cmd.WaitDelay != 0 || cmd.SysProcAttr != nil {
+		t.Error("setGracefulCancel with no grace period changed the command")
+	}
+}
@@ -0,0 +1,58 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// -------------------- deadline_warning_percent --------------------
+
+const defaultDeadlineWarningPercent = 80
+
+// 命令用掉超时的一定比例时的定时器和计算剩余时间的时钟，可在调试时替换
+var (
+	deadlineAfter = time.After
+	deadlineNow   = time.Now
+)
+
+// startDeadlineWarning 在命令用掉 timeout 的 deadline_warning_percent% 时，
+// 向日志和事件日志（Event ID 1004）写一条警告，提醒之后命令可能被终止。
+// timeout 为 0（不限）或比例为 0 时什么都不做。返回的 stop 在命令结束后调用。
+func (s *winpspService) startDeadlineWarning(command string, timeout time.Duration, logf func(format string, args ...any)) (stop func()) {
+	percent := *s.config.Load().DeadlineWarningPercent
+	if timeout <= 0 || percent <= 0 {
+		return func() {}
+	}
+
+	start := deadlineNow()
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		select {
+		case <-done:
+			return
+		case <-deadlineAfter(timeout * time.Duration(percent) / 100):
+		}
+
+		remaining := int((timeout - deadlineNow().Sub(start)).Round(time.Second).Seconds())
+		if remaining < 0 {
+			remaining = 0
+		}
+		logf("Warning: Command has used %d%% of timeout; %d seconds remaining.", percent, remaining)
+		host, err := os.Hostname()
+		if err != nil {
+			host = "unknown-host"
+		}
+		reportEventAsync(eventWarning, eventIDCommandDeadline, host, commandName(command),
+			fmt.Sprintf("%d%%", percent), fmt.Sprintf("%ds", remaining))
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+	}
+}
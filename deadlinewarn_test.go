@@ -0,0 +1,126 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDeadlineClock 代替 deadlineAfter 和 deadlineNow：定时器只在 fire 时到时，
+// 同时把时钟拨到定时器的时间
+type fakeDeadlineClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waits   chan time.Duration // 每次 deadlineAfter 的参数
+	pending chan time.Time
+}
+
+func mockDeadlineClock(t *testing.T) *fakeDeadlineClock {
+	t.Helper()
+	c := &fakeDeadlineClock{now: time.Date(2026, 10, 14, 8, 30, 0, 0, time.UTC), waits: make(chan time.Duration, 1)}
+	origAfter, origNow := deadlineAfter, deadlineNow
+	t.Cleanup(func() { deadlineAfter, deadlineNow = origAfter, origNow })
+	deadlineAfter = func(d time.Duration) <-chan time.Time {
+		c.mu.Lock()
+		c.pending = make(chan time.Time, 1)
+		ch := c.pending
+		c.mu.Unlock()
+		c.waits <- d
+		return ch
+	}
+	deadlineNow = func() time.Time {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.now
+	}
+	return c
+}
+
+// fire 把时钟拨快 d，让等待中的定时器到时
+func (c *fakeDeadlineClock) fire(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.pending <- c.now
+}
+
+func TestStartDeadlineWarning(t *testing.T) {
+	tests := []struct {
+		timeout  time.Duration
+		percent  int
+		wantWait time.Duration // 0 表示不启动定时器
+		want     string
+	}{
+		{100 * time.Second, 80, 80 * time.Second, "Warning: Command has used 80% of timeout; 20 seconds remaining."},
+		{60 * time.Second, 50, 30 * time.Second, "Warning: Command has used 50% of timeout; 30 seconds remaining."},
+		{10 * time.Second, 95, 9500 * time.Millisecond, "Warning: Command has used 95% of timeout; 1 seconds remaining."},
+		{5 * time.Minute, 1, 3 * time.Second, "Warning: Command has used 1% of timeout; 297 seconds remaining."},
+		{0, 80, 0, ""},                // 不限时
+		{100 * time.Second, 0, 0, ""}, // 不警告
+	}
+	for _, tt := range tests {
+		clock := mockDeadlineClock(t)
+		s := &winpspService{}
+		s.config.Store(&Config{DeadlineWarningPercent: intPtr(tt.percent)})
+		var mu sync.Mutex
+		var logged []string
+		logf := func(format string, args ...any) {
+			mu.Lock()
+			defer mu.Unlock()
+			logged = append(logged, fmt.Sprintf(format, args...))
+		}
+
+		stop := s.startDeadlineWarning("backup.exe", tt.timeout, logf)
+		if tt.wantWait == 0 {
+			stop()
+			select {
+			case d := <-clock.waits:
+				t.Errorf("timeout %s, %d%%: timer started for %s", tt.timeout, tt.percent, d)
+			default:
+			}
+		} else {
+			if d := <-clock.waits; d != tt.wantWait {
+				t.Errorf("timeout %s, %d%%: timer for %s, want %s", tt.timeout, tt.percent, d, tt.wantWait)
+			}
+			clock.fire(tt.wantWait)
+			stop() // 等待警告写完
+		}
+
+		var want []string
+		if tt.want != "" {
+			want = []string{tt.want}
+		}
+		if fmt.Sprint(logged) != fmt.Sprint(want) {
+			t.Errorf("timeout %s, %d%%: logged %q, want %q", tt.timeout, tt.percent, logged, want)
+		}
+	}
+}
+
+// 命令在警告之前结束：stop 取消定时器，不写警告
+func TestStartDeadlineWarningStopped(t *testing.T) {
+	clock := mockDeadlineClock(t)
+	s := &winpspService{}
+	s.config.Store(&Config{DeadlineWarningPercent: intPtr(80)})
+	var logged []string
+	stop := s.startDeadlineWarning("backup.exe", time.Minute, func(format string, args ...any) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	})
+	<-clock.waits
+
+	returned := make(chan struct{})
+	go func() {
+		stop()
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop did not return before the deadline warning")
+	}
+	if len(logged) != 0 {
+		t.Errorf("warning logged after the command finished: %q", logged)
+	}
+}
@@ -0,0 +1,164 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// -------------------- 配置说明 --------------------
+
+// runDescribe 实现 --describe：加载配置，用一段英文说明下次关机时会做什么，
+// 给不读 JSON 的管理员看
+func runDescribe(configPath string) bool {
+	s := &winpspService{configPath: configPath}
+	if err := s.loadConfig(); err != nil {
+		if configAbsent(err) {
+			fmt.Println("On shutdown, WinPSP will do nothing: there is no config file or no command.")
+			return true
+		}
+		fmt.Printf("Config error: %v\n", err)
+		return false
+	}
+	fmt.Println(describeConfig(s.config.Load(), filepath.Dir(configPath)))
+	return true
+}
+
+// describeConfig 由已填充默认值的配置生成说明文字
+func describeConfig(cfg *Config, logDir string) string {
+	var sentences []string
+
+	// 主命令
+	var clauses []string
+	if len(cfg.Commands) > 0 {
+		names := make([]string, len(cfg.Commands))
+		for i := range cfg.Commands {
+			names[i] = cfg.Commands[i].displayName()
+		}
+		clauses = append(clauses, fmt.Sprintf("run %d commands in order (%s) within %s",
+			len(names), quoteList(names), describeSecs(*cfg.Timeout)))
+	} else {
+		clauses = append(clauses, fmt.Sprintf("run '%s' with a timeout of %s",
+			cfg.displayName(), describeSecs(*cfg.Timeout)))
+	}
+	if cfg.RetryCount > 0 {
+		clauses = append(clauses, fmt.Sprintf("retry up to %d %s (%s delay starting at %d seconds)",
+			cfg.RetryCount, plural(cfg.RetryCount, "time", "times"), cfg.RetryDelayStrategy, *cfg.RetryDelaySecs))
+	}
+	if cfg.WebhookURL != "" {
+		clauses = append(clauses, "post the result to "+cfg.WebhookURL)
+	}
+	sentences = append(sentences, "On shutdown, WinPSP will "+joinClauses(clauses)+".")
+
+	// 执行前检查
+	var skips []string
+	if cfg.MinUptimeSecs > 0 {
+		skips = append(skips, fmt.Sprintf("the machine has been up for less than %s", describeSecs(cfg.MinUptimeSecs)))
+	}
+	if cfg.MinRunIntervalSecs > 0 {
+		skips = append(skips, fmt.Sprintf("the last run was less than %s ago", describeSecs(cfg.MinRunIntervalSecs)))
+	}
+	if cfg.SkipOnBattery {
+		if cfg.SkipOnBatteryBelowPercent > 0 {
+			skips = append(skips, fmt.Sprintf("the machine is on battery below %d%%", cfg.SkipOnBatteryBelowPercent))
+		} else {
+			skips = append(skips, "the machine is on battery")
+		}
+	}
+	if cfg.RequireFreeDiskBytes > 0 {
+		path := cfg.RequireFreeDiskPath
+		if path == "" {
+			path = "the target disk"
+		}
+		skips = append(skips, fmt.Sprintf("%s has less than %d bytes free", path, cfg.RequireFreeDiskBytes))
+	}
+	if cfg.RequireNetwork {
+		skips = append(skips, "the network is unavailable")
+	}
+	if len(skips) > 0 {
+		s := "The command is skipped if " + joinOr(skips) + "."
+		if cfg.FallbackCommand != "" {
+			s = fmt.Sprintf("If %s, it runs '%s' instead.", joinOr(skips), commandName(cfg.FallbackCommand))
+		}
+		sentences = append(sentences, s)
+	}
+
+	// 附加命令
+	if cfg.PreHookCommand != "" {
+		s := fmt.Sprintf("Before the command it runs '%s'", commandName(cfg.PreHookCommand))
+		if cfg.PreHookRequired {
+			s += ", and skips the command if it fails"
+		}
+		sentences = append(sentences, s+".")
+	}
+	if cfg.OnSuccessCommand != "" {
+		sentences = append(sentences, fmt.Sprintf("If the command succeeds it runs '%s'.", commandName(cfg.OnSuccessCommand)))
+	}
+	if cfg.PostHookCommand != "" {
+		sentences = append(sentences, fmt.Sprintf("Afterwards it always runs '%s'.", commandName(cfg.PostHookCommand)))
+	}
+
+	// 日志
+	if *cfg.LogCount == 0 {
+		sentences = append(sentences, "No log files are written.")
+	} else {
+		sentences = append(sentences, fmt.Sprintf("Logs are kept in %s (%s).", logDir, cfg.logRetention.describe()))
+	}
+	if cfg.S3Bucket != "" {
+		sentences = append(sentences, fmt.Sprintf("Each log is uploaded to the bucket '%s'.", cfg.S3Bucket))
+	}
+	if cfg.ArchiveUNCPath != "" {
+		sentences = append(sentences, fmt.Sprintf("Each log is copied to %s.", cfg.ArchiveUNCPath))
+	}
+
+	return strings.Join(sentences, " ")
+}
+
+// describeSecs 把秒数写成 "5 minutes" 这样便于阅读的形式
+func describeSecs(secs int) string {
+	n, unit := secs, "second"
+	switch {
+	case secs >= 3600 && secs%3600 == 0:
+		n, unit = secs/3600, "hour"
+	case secs >= 60 && secs%60 == 0:
+		n, unit = secs/60, "minute"
+	}
+	return fmt.Sprintf("%d %s", n, plural(n, unit, unit+"s"))
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+func quoteList(items []string) string {
+	q := make([]string, len(items))
+	for i, s := range items {
+		q[i] = "'" + s + "'"
+	}
+	return strings.Join(q, ", ")
+}
+
+// joinClauses 连接为 "a, b, and c" 的形式
+func joinClauses(items []string) string {
+	return joinWith(items, "and")
+}
+
+func joinOr(items []string) string {
+	return joinWith(items, "or")
+}
+
+func joinWith(items []string, conj string) string {
+	switch len(items) {
+	case 1:
+		return items[0]
+	case 2:
+		return items[0] + " " + conj + " " + items[1]
+	default:
+		return strings.Join(items[:len(items)-1], ", ") + ", " + conj + " " + items[len(items)-1]
+	}
+}
@@ -0,0 +1,127 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDescribeSecs(t *testing.T) {
+	tests := []struct {
+		secs int
+		want string
+	}{
+		{1, "1 second"},
+		{45, "45 seconds"},
+		{60, "1 minute"},
+		{90, "90 seconds"},
+		{300, "5 minutes"},
+		{3600, "1 hour"},
+		{5400, "90 minutes"},
+		{7200, "2 hours"},
+	}
+	for _, tt := range tests {
+		if got := describeSecs(tt.secs); got != tt.want {
+			t.Errorf("describeSecs(%d) = %q, want %q", tt.secs, got, tt.want)
+		}
+	}
+}
+
+func TestJoinWith(t *testing.T) {
+	tests := []struct {
+		items []string
+		want  string
+	}{
+		{[]string{"a"}, "a"},
+		{[]string{"a", "b"}, "a and b"},
+		{[]string{"a", "b", "c"}, "a, b, and c"},
+	}
+	for _, tt := range tests {
+		if got := joinClauses(tt.items); got != tt.want {
+			t.Errorf("joinClauses(%q) = %q, want %q", tt.items, got, tt.want)
+		}
+	}
+	if got := joinOr([]string{"a", "b"}); got != "a or b" {
+		t.Errorf("joinOr = %q", got)
+	}
+}
+
+const describeBase = `"command": "C:\\Scripts\\backup.ps1", "timeout": 300`
+
+// describe 返回 describeConfig 对 describeBase 加上 extra 字段的说明，extra 中的同名字段覆盖 describeBase
+func describe(t *testing.T, extra string) string {
+	t.Helper()
+	data := "{" + describeBase
+	if extra != "" {
+		data += ", " + extra
+	}
+	s := loadedService(t, data+"}")
+	return describeConfig(s.config.Load(), `C:\ProgramData\WinPSP`)
+}
+
+func TestDescribeConfig(t *testing.T) {
+	base := describe(t, "")
+	want := fmt.Sprintf("On shutdown, WinPSP will run 'backup.ps1' with a timeout of 5 minutes. Logs are kept in C:\\ProgramData\\WinPSP (%d files).", defaultLogCount)
+	if base != want {
+		t.Fatalf("describeConfig = %q, want %q", base, want)
+	}
+
+	// 改动一个字段，说明中就出现对应的文字
+	tests := []struct {
+		extra string
+		want  []string
+	}{
+		{`"timeout": 3600`, []string{"with a timeout of 1 hour."}},
+		{`"retry_count": 2, "retry_delay_secs": 30, "retry_delay_strategy": "exponential"`,
+			[]string{"with a timeout of 5 minutes and retry up to 2 times (exponential delay starting at 30 seconds)."}},
+		{`"retry_count": 1, "webhook_url": "https://hooks.example.com/winpsp"`,
+			[]string{"retry up to 1 time (", ", and post the result to https://hooks.example.com/winpsp."}},
+		{`"command": "", "commands": [{"command": "stop-db.exe"}, {"name": "backup", "command": "C:\\Scripts\\backup.ps1"}]`,
+			[]string{"run 2 commands in order ('stop-db.exe', 'backup') within 5 minutes."}},
+		{`"min_uptime_secs": 600`, []string{"The command is skipped if the machine has been up for less than 10 minutes."}},
+		{`"min_uptime_secs": 600, "min_run_interval_secs": 3600, "skip_on_battery": true, "fallback_command": "notify.exe"`,
+			[]string{"If the machine has been up for less than 10 minutes, the last run was less than 1 hour ago, or the machine is on battery, it runs 'notify.exe' instead."}},
+		{`"skip_on_battery": true, "skip_on_battery_below_percent": 20`, []string{"on battery below 20%."}},
+		{`"require_network": true, "require_network_host": "backup.example.com"`, []string{"skipped if the network is unavailable."}},
+		{`"pre_hook_command": "C:\\Tools\\token.exe get", "pre_hook_required": true`,
+			[]string{"Before the command it runs 'token.exe', and skips the command if it fails."}},
+		{`"on_success_command": "notify.exe ok", "post_hook_command": "cleanup.bat"`,
+			[]string{"If the command succeeds it runs 'notify.exe'.", "Afterwards it always runs 'cleanup.bat'."}},
+		{`"log_count": 0`, []string{"No log files are written."}},
+		{`"log_count": 1`, []string{"(1 file)."}},
+		{`"archive_unc_path": "\\\\nas\\logs"`, []string{`Each log is copied to \\nas\logs.`}},
+	}
+	for _, tt := range tests {
+		got := describe(t, tt.extra)
+		if got == base {
+			t.Errorf("%s: description unchanged", tt.extra)
+		}
+		for _, w := range tt.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("%s: description %q does not contain %q", tt.extra, got, w)
+			}
+		}
+	}
+}
+
+func TestRunDescribe(t *testing.T) {
+	tests := []struct {
+		config string
+		wantOK bool
+		want   string
+	}{
+		{`{"command": "backup.exe"}`, true, "On shutdown, WinPSP will run 'backup.exe'"},
+		{`{"command": ""}`, true, "WinPSP will do nothing"},
+		{`{"command": `, false, "Config error:"},
+	}
+	for _, tt := range tests {
+		s := writeConfig(t, tt.config)
+		var ok bool
+		out := captureStdout(t, func() { ok = runDescribe(s.configPath) })
+		if ok != tt.wantOK || !strings.Contains(out, tt.want) {
+			t.Errorf("%s: runDescribe = %v, %q, want %v, %q", tt.config, ok, out, tt.wantOK, tt.want)
+		}
+	}
+}
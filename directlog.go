@@ -0,0 +1,127 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	logWriteBuffered = "buffered"
+	logWriteDirect   = "direct"
+)
+
+// -------------------- 直接写入日志 --------------------
+
+// log_write_mode = direct 时日志文件以 FILE_FLAG_WRITE_THROUGH | FILE_FLAG_NO_BUFFERING
+// 打开，每次写入在返回前落盘，关机途中进程被杀或系统崩溃也不会丢掉已写的日志。
+// NO_BUFFERING 要求偏移、长度和缓冲区地址都按扇区对齐：未写满的最后一个扇区
+// 用换行补齐后整块写入，再把文件长度截回实际长度；下次写入时重写这个扇区。
+// 即使补齐和截断之间被中断，文件末尾也只是多出几个空行。
+
+// directSectorSize 取 4096，同时满足 512 字节和 4K 扇区的对齐要求
+const directSectorSize = 4096
+
+const directBufferSize = 16 * directSectorSize
+
+// createFile 供调试时替换
+var createFile = windows.CreateFile
+
+type directWriter struct {
+	mu   sync.Mutex
+	h    windows.Handle
+	buf  []byte // 扇区对齐的缓冲区，buf[:tail] 是从 off 开始尚未写满扇区的数据
+	tail int
+	off  int64 // buf[0] 对应的文件偏移，扇区对齐
+}
+
+// openDirectLog 以直写方式打开（追加到）日志文件。返回的 *os.File 只用于
+// Close 和 Name，内容必须通过返回的 io.Writer 写入。
+func openDirectLog(path string) (*os.File, *directWriter, error) {
+	p, err := windows.UTF16PtrFromString(longPath(path))
+	if err != nil {
+		return nil, nil, err
+	}
+	h, err := createFile(p, windows.GENERIC_READ|windows.GENERIC_WRITE, windows.FILE_SHARE_READ, nil,
+		windows.OPEN_ALWAYS, windows.FILE_ATTRIBUTE_NORMAL|windows.FILE_FLAG_WRITE_THROUGH|windows.FILE_FLAG_NO_BUFFERING, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w := &directWriter{h: h, buf: alignedBuffer(directBufferSize, directSectorSize)}
+
+	// 追加：从最后一个不完整的扇区继续
+	size, err := windows.Seek(h, 0, 2)
+	if err != nil {
+		windows.CloseHandle(h)
+		return nil, nil, err
+	}
+	w.off = size &^ (directSectorSize - 1)
+	w.tail = int(size - w.off)
+	if w.tail > 0 {
+		var done uint32
+		if err := windows.ReadFile(h, w.buf[:directSectorSize], &done, w.overlapped()); err != nil {
+			windows.CloseHandle(h)
+			return nil, nil, err
+		}
+	}
+
+	return os.NewFile(uintptr(h), path), w, nil
+}
+
+// alignedBuffer 返回起始地址按 align 对齐、长度为 size 的切片
+func alignedBuffer(size, align int) []byte {
+	b := make([]byte, size+align)
+	skip := 0
+	if r := int(uintptr(unsafe.Pointer(&b[0])) & uintptr(align-1)); r != 0 {
+		skip = align - r
+	}
+	return b[skip : skip+size]
+}
+
+func (w *directWriter) overlapped() *windows.Overlapped {
+	return &windows.Overlapped{Offset: uint32(w.off), OffsetHigh: uint32(w.off >> 32)}
+}
+
+func (w *directWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[w.tail:], p)
+		w.tail += n
+		if err := w.flush(); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// flush 把 buf[:tail] 补齐到整扇区后写入，截回实际长度，再把写满的扇区移出缓冲区
+func (w *directWriter) flush() error {
+	size := (w.tail + directSectorSize - 1) &^ (directSectorSize - 1)
+	for i := w.tail; i < size; i++ {
+		w.buf[i] = '\n'
+	}
+
+	var done uint32
+	if err := windows.WriteFile(w.h, w.buf[:size], &done, w.overlapped()); err != nil {
+		return err
+	}
+	if err := windows.Ftruncate(w.h, w.off+int64(w.tail)); err != nil {
+		return err
+	}
+
+	full := w.tail &^ (directSectorSize - 1)
+	copy(w.buf, w.buf[full:w.tail])
+	w.off += int64(full)
+	w.tail -= full
+	return nil
+}
@@ -0,0 +1,119 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestAlignedBuffer(t *testing.T) {
+	for _, size := range []int{directSectorSize, directBufferSize, 3 * 512} {
+		for _, align := range []int{512, directSectorSize} {
+			b := alignedBuffer(size, align)
+			if len(b) != size || uintptr(unsafe.Pointer(&b[0]))%uintptr(align) != 0 {
+				t.Errorf("alignedBuffer(%d, %d): len %d, address %p", size, align, len(b), &b[0])
+			}
+		}
+	}
+}
+
+func TestOpenDirectLogFlags(t *testing.T) {
+	var gotFlags, gotMode uint32
+	orig := createFile
+	createFile = func(name *uint16, access, share uint32, sa *windows.SecurityAttributes, mode, attrs uint32, template windows.Handle) (windows.Handle, error) {
+		gotMode, gotFlags = mode, attrs
+		return orig(name, access, share, sa, mode, attrs, template)
+	}
+	t.Cleanup(func() { createFile = orig })
+
+	f, _, err := openDirectLog(filepath.Join(t.TempDir(), "winpsp.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	const want uint32 = windows.FILE_FLAG_WRITE_THROUGH | windows.FILE_FLAG_NO_BUFFERING
+	if gotFlags&want != want || gotMode != windows.OPEN_ALWAYS {
+		t.Errorf("CreateFile flags %#x, mode %d, want %#x and OPEN_ALWAYS", gotFlags, gotMode, want)
+	}
+}
+
+func TestDirectWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []int // 每次写入的字节数
+	}{
+		{"small", []int{10, 20, 1}},
+		{"one sector", []int{directSectorSize}},
+		{"across sectors", []int{directSectorSize - 1, 2, directSectorSize + 5}},
+		{"larger than the buffer", []int{directBufferSize*2 + 100, 7}},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "winpsp.log")
+		f, w, err := openDirectLog(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var want []byte
+		for i, n := range tt.writes {
+			p := bytes.Repeat([]byte{byte('a' + i)}, n)
+			if got, err := w.Write(p); err != nil || got != n {
+				t.Fatalf("%s: Write(%d) = %d, %v", tt.name, n, got, err)
+			}
+			want = append(want, p...)
+
+			// 每次写入返回后，文件中恰好是已写的内容，没有补齐用的换行
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, want) {
+				t.Fatalf("%s: after write %d the file has %d bytes, want %d", tt.name, i+1, len(data), len(want))
+			}
+		}
+		f.Close()
+	}
+}
+
+func TestDirectWriterAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "winpsp.log")
+	first := strings.Repeat("x", directSectorSize+10) + "\n"
+	if err := os.WriteFile(path, []byte(first), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新打开后从最后一个不完整的扇区继续写
+	for _, s := range []string{"second\n", "third\n"} {
+		f, w, err := openDirectLog(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != first+"second\nthird\n" {
+		t.Errorf("file has %d bytes ending in %q", len(data), data[len(data)-20:])
+	}
+}
+
+func TestShutdownDirectLog(t *testing.T) {
+	s := loadedService(t, `{"command": "cmd.exe /c echo direct output", "log_write_mode": "direct"}`)
+	s.interactive = true
+	log := shutdownLog(t, s)
+	if !strings.Contains(log, "] direct output") || !strings.HasSuffix(log, "Shutdown released\n") {
+		t.Errorf("log:\n%s", log)
+	}
+}
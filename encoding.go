@@ -0,0 +1,229 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+const (
+	outputEncodingAuto    = "auto"
+	outputEncodingUTF8    = "utf8"
+	outputEncodingUTF16LE = "utf16le"
+	outputEncodingUTF16BE = "utf16be"
+	outputEncodingCP1252  = "cp1252"
+)
+
+// -------------------- 输出编码 --------------------
+
+// 日志文件是 UTF-8。PowerShell 等程序默认输出 UTF-16LE，直接写入会出现
+// 夹杂 NUL 的乱码，所以命令输出先按 output_encoding 转成 UTF-8 再写入。
+
+func validOutputEncoding(name string) bool {
+	switch name {
+	case "", outputEncodingAuto, outputEncodingUTF8, outputEncodingUTF16LE, outputEncodingUTF16BE, outputEncodingCP1252:
+		return true
+	}
+	return false
+}
+
+// outputDecoder 返回把 name 编码的字节转换为 UTF-8 的解码器，nil 表示原样输出
+func outputDecoder(name string) (*encoding.Decoder, error) {
+	switch name {
+	case outputEncodingUTF8:
+		return nil, nil
+	case outputEncodingUTF16LE:
+		return unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewDecoder(), nil
+	case outputEncodingUTF16BE:
+		return unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewDecoder(), nil
+	case outputEncodingCP1252:
+		return charmap.Windows1252.NewDecoder(), nil
+	default:
+		return nil, fmt.Errorf("invalid output_encoding: %q", name)
+	}
+}
+
+// newOutputWriter 包装 w，写入的字节按 name 解码后以 UTF-8 写入 w。
+// 命令结束后必须 Close，把解码器里剩余的字节刷出。
+func newOutputWriter(w io.Writer, name string) (io.WriteCloser, error) {
+	if name == "" || name == outputEncodingAuto {
+		return &autoOutputWriter{w: w}, nil
+	}
+
+	dec, err := outputDecoder(name)
+	if err != nil {
+		return nil, err
+	}
+	if dec == nil {
+		return nopWriteCloser{w}, nil
+	}
+	return transform.NewWriter(w, dec), nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// autoOutputWriter 根据输出开头的 BOM 选择编码，没有 BOM 时按 UTF-8 原样输出
+type autoOutputWriter struct {
+	w    io.Writer
+	head []byte         // 判定编码前缓存的开头字节
+	out  io.WriteCloser // 判定后的实际写入目标
+}
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+func (a *autoOutputWriter) Write(p []byte) (int, error) {
+	if a.out != nil {
+		return a.out.Write(p)
+	}
+
+	a.head = append(a.head, p...)
+	// UTF-8 BOM 需要 3 个字节才能判定，其它情况 2 个字节就够
+	if len(a.head) < 2 || (len(a.head) < 3 && bytes.HasPrefix(bomUTF8, a.head)) {
+		return len(p), nil
+	}
+
+	a.detect()
+	if _, err := a.out.Write(a.head); err != nil {
+		return 0, err
+	}
+	a.head = nil
+	return len(p), nil
+}
+
+func (a *autoOutputWriter) detect() {
+	switch {
+	case bytes.HasPrefix(a.head, bomUTF16LE):
+		a.out = transform.NewWriter(a.w, unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM).NewDecoder())
+	case bytes.HasPrefix(a.head, bomUTF16BE):
+		a.out = transform.NewWriter(a.w, unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM).NewDecoder())
+	case bytes.HasPrefix(a.head, bomUTF8):
+		a.out = transform.NewWriter(a.w, unicode.UTF8BOM.NewDecoder())
+	default:
+		a.out = nopWriteCloser{a.w}
+	}
+}
+
+func (a *autoOutputWriter) Close() error {
+	if a.out == nil {
+		if len(a.head) == 0 {
+			return nil
+		}
+		a.detect()
+		if _, err := a.out.Write(a.head); err != nil {
+			return err
+		}
+	}
+	return a.out.Close()
+}
+
+// -------------------- 控制台代码页 --------------------
+
+// output_codepage 让命令按指定代码页输出（如 65001 = UTF-8），避免批处理
+// 按 ANSI 代码页输出的字符在 UTF-8 日志中变成乱码。
+// 有控制台时（交互模式）直接设置本进程控制台的代码页，子进程继承；
+// 服务没有控制台，子进程各自新建控制台，因此 .bat / .cmd 另外在
+// cmd.exe /C 中先执行 chcp（见 interpreter.go）。
+
+// 代码页是控制台的属性，共用控制台的子进程在运行期间一直受它影响，
+// 所以要保持到命令结束。关机处理与 periodic_command 可能同时运行：
+// 代码页相同的命令共用一次设置，最后一个结束时恢复；
+// 需要不同代码页的命令等前面的命令结束后再启动。由 consoleMu 保护。
+var (
+	codepageFree  = sync.NewCond(&consoleMu) // codepageUsers 变为 0 时通知
+	codepage      uint32                     // 当前设置的代码页
+	codepageUsers int                        // 正在使用 codepage 的命令数
+	codepageOld   [2]uint32                  // 第一次设置前的输入、输出代码页
+)
+
+// setConsoleCodepage 设置控制台输入输出代码页，返回恢复原代码页的函数。
+// cp 为 0 或没有控制台时什么也不做。
+func setConsoleCodepage(cp uint32) (restore func()) {
+	if cp == 0 {
+		return func() {}
+	}
+
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+	for codepageUsers > 0 && codepage != cp {
+		codepageFree.Wait()
+	}
+	if codepageUsers == 0 {
+		oldIn, err := windows.GetConsoleCP()
+		if err != nil {
+			return func() {}
+		}
+		oldOut, err := windows.GetConsoleOutputCP()
+		if err != nil {
+			return func() {}
+		}
+		if windows.SetConsoleCP(cp) != nil || windows.SetConsoleOutputCP(cp) != nil {
+			windows.SetConsoleCP(oldIn)
+			windows.SetConsoleOutputCP(oldOut)
+			return func() {}
+		}
+		codepage, codepageOld = cp, [2]uint32{oldIn, oldOut}
+	}
+	codepageUsers++
+
+	return func() {
+		consoleMu.Lock()
+		defer consoleMu.Unlock()
+		if codepageUsers--; codepageUsers == 0 {
+			windows.SetConsoleCP(codepageOld[0])
+			windows.SetConsoleOutputCP(codepageOld[1])
+			codepageFree.Broadcast()
+		}
+	}
+}
+
+// -------------------- 配置文件编码 --------------------
+
+// decodeConfig 去掉配置文件开头的 BOM 并转成 UTF-8。
+// 用记事本“另存为 Unicode”保存的 config.json 是带 BOM 的 UTF-16LE，
+// 直接交给 json.Unmarshal 会报 invalid character 'ÿ'。
+func decodeConfig(data []byte) ([]byte, error) {
+	var dec *encoding.Decoder
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		return data[len(bomUTF8):], nil
+	case bytes.HasPrefix(data, bomUTF16LE):
+		dec = unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM).NewDecoder()
+	case bytes.HasPrefix(data, bomUTF16BE):
+		dec = unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM).NewDecoder()
+	default:
+		return data, nil
+	}
+	out, err := dec.Bytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("decode UTF-16 config: %w", err)
+	}
+	return out, nil
+}
+
+// readConfigFile 读取配置文件，并按 BOM 转成 UTF-8
+func readConfigFile(path string) ([]byte, error) {
+	if stdinConfig != nil && path == stdinConfigPath {
+		return decodeConfig(stdinConfig)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeConfig(data)
+}
@@ -0,0 +1,231 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/unicode"
+)
+
+// utf16leText 以 PowerShell 等程序默认输出的带 BOM 的 UTF-16LE
+const utf16leText = "héllo 世界\r\nline 2\r\n"
+
+func utf16le(t *testing.T, s string, bom bool) []byte {
+	t.Helper()
+	policy := unicode.IgnoreBOM
+	if bom {
+		policy = unicode.UseBOM
+	}
+	b, err := unicode.UTF16(unicode.LittleEndian, policy).NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func init() {
+	// 以带 BOM 的 UTF-16LE 输出 utf16leText
+	testHelpers["utf16le"] = func(args []string) int {
+		b, _ := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewEncoder().Bytes([]byte(utf16leText))
+		os.Stdout.Write(b)
+		return 0
+	}
+}
+
+func TestNewOutputWriter(t *testing.T) {
+	tests := []struct {
+		encoding string
+		in       []byte
+		want     string
+	}{
+		{"", []byte("plain ascii\n"), "plain ascii\n"},
+		{outputEncodingAuto, []byte("héllo\n"), "héllo\n"},
+		{outputEncodingAuto, utf16le(t, utf16leText, true), utf16leText},
+		{outputEncodingAuto, append([]byte{0xFE, 0xFF}, 0, 'h', 0, 'i'), "hi"},
+		{outputEncodingAuto, append([]byte{0xEF, 0xBB, 0xBF}, "héllo"...), "héllo"},
+		{outputEncodingAuto, []byte{0xEF}, "\xEF"}, // 不足以判定编码的输出原样写出
+		{outputEncodingAuto, []byte("x"), "x"},
+		{outputEncodingAuto, nil, ""},
+		{outputEncodingUTF8, utf16le(t, "a", true), "\xFF\xFEa\x00"},
+		{outputEncodingUTF16LE, utf16le(t, utf16leText, false), utf16leText},
+		{outputEncodingUTF16LE, utf16le(t, utf16leText, true), utf16leText},
+		{outputEncodingUTF16BE, []byte{0, 'o', 0, 'k'}, "ok"},
+		{outputEncodingCP1252, []byte("caf\xE9 \x80 5\n"), "café € 5\n"},
+	}
+	for _, tt := range tests {
+		// 整块写入，以及每次一个字节写入（BOM 被拆开时也要能判定）
+		for _, chunk := range []int{len(tt.in), 1} {
+			var out bytes.Buffer
+			w, err := newOutputWriter(&out, tt.encoding)
+			if err != nil {
+				t.Fatalf("newOutputWriter(%q): %v", tt.encoding, err)
+			}
+			for p := tt.in; len(p) > 0; {
+				n := min(max(chunk, 1), len(p))
+				if _, err := w.Write(p[:n]); err != nil {
+					t.Fatalf("%q: Write: %v", tt.encoding, err)
+				}
+				p = p[n:]
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("%q: Close: %v", tt.encoding, err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("%q, %d-byte writes of %q: got %q, want %q", tt.encoding, chunk, tt.in, out.String(), tt.want)
+			}
+		}
+	}
+
+	if _, err := newOutputWriter(&bytes.Buffer{}, "latin1"); err == nil {
+		t.Error("newOutputWriter accepted an unknown encoding")
+	}
+}
+
+func TestValidOutputEncoding(t *testing.T) {
+	for _, name := range []string{"", "auto", "utf8", "utf16le", "utf16be", "cp1252"} {
+		if !validOutputEncoding(name) {
+			t.Errorf("validOutputEncoding(%q) = false", name)
+		}
+	}
+	for _, name := range []string{"UTF8", "utf-8", "latin1"} {
+		if validOutputEncoding(name) {
+			t.Errorf("validOutputEncoding(%q) = true", name)
+		}
+	}
+}
+
+// TestRunCommandUTF16Output 执行输出 UTF-16LE 的子进程，日志中应是 UTF-8
+func TestRunCommandUTF16Output(t *testing.T) {
+	command, env := helperCommandLine(t, "utf16le")
+	for _, enc := range []string{"", outputEncodingAuto, outputEncodingUTF16LE} {
+		var out bytes.Buffer
+		code, _, err := runCommandWithTimeout(command, 0, execOptions{Output: &out, Env: env, Encoding: enc})
+		if err != nil || code != 0 {
+			t.Fatalf("%q: exit code %d, %v", enc, code, err)
+		}
+		if out.String() != utf16leText {
+			t.Errorf("%q: output %q, want %q", enc, out.String(), utf16leText)
+		}
+	}
+}
+
+// cp1252Batch 是按 CP1252 保存的批处理：é = 0xE9，€ = 0x80
+const cp1252Batch = "@echo off\r\necho caf\xe9 \x80 %WINPSP_TEXT%\r\n"
+
+// TestOutputCodepage 检查 CP1252 的字符在日志中是正确的 UTF-8
+func TestOutputCodepage(t *testing.T) {
+	t.Setenv("WINPSP_TEXT", "naïve") // 环境变量是 Unicode，echo 时按控制台代码页输出
+	tests := []struct {
+		codepage int
+		encoding string
+		want     string
+		wantOK   bool
+	}{
+		{1252, outputEncodingCP1252, "café € naïve", true},
+		// 按 CP1252 输出但当作 UTF-8 写入：乱码
+		{1252, outputEncodingAuto, "café € naïve", false},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		script := filepath.Join(dir, "backup.bat")
+		if err := os.WriteFile(script, []byte(cp1252Batch), 0644); err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(map[string]any{
+			"command":         script,
+			"output_codepage": tt.codepage,
+			"output_encoding": tt.encoding,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := loadedService(t, string(data))
+		s.interactive = true
+		log := shutdownLog(t, s)
+		if got := strings.Contains(log, tt.want); got != tt.wantOK {
+			t.Errorf("output_codepage %d, output_encoding %s: log contains %q = %v, want %v:\n%s",
+				tt.codepage, tt.encoding, tt.want, got, tt.wantOK, log)
+		}
+		if tt.wantOK && !utf8.ValidString(log) {
+			t.Errorf("output_codepage %d: log is not valid UTF-8:\n%q", tt.codepage, log)
+		}
+	}
+}
+
+func TestOutputCodepageUTF8(t *testing.T) {
+	t.Setenv("WINPSP_TEXT", "café € naïve")
+	script := filepath.Join(t.TempDir(), "backup.bat")
+	if err := os.WriteFile(script, []byte("@echo %WINPSP_TEXT%\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(map[string]any{"command": script, "output_codepage": 65001})
+	s := loadedService(t, string(data))
+	s.interactive = true
+	if log := shutdownLog(t, s); !strings.Contains(log, "] café € naïve") {
+		t.Errorf("log:\n%s", log)
+	}
+}
+
+func TestReadConfigOutputCodepage(t *testing.T) {
+	for _, cp := range []int{-1, 65536} {
+		s := writeConfig(t, fmt.Sprintf(`{"command": "backup.bat", "output_codepage": %d}`, cp))
+		if err := s.loadConfig(); err == nil {
+			t.Errorf("output_codepage %d accepted", cp)
+		}
+	}
+}
+
+// notepadConfig 是记事本保存的配置，路径里有非 ASCII 字符
+const notepadConfig = "{\r\n  \"command\": \"C:\\\\Tools\\\\备份.exe /full\"\r\n}\r\n"
+
+func TestDecodeConfig(t *testing.T) {
+	be, err := unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewEncoder().Bytes([]byte(notepadConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{"UTF-8", []byte(notepadConfig), notepadConfig, false},
+		{"UTF-8 BOM", append([]byte("\xEF\xBB\xBF"), notepadConfig...), notepadConfig, false},
+		{"UTF-16LE BOM", utf16le(t, notepadConfig, true), notepadConfig, false},
+		{"UTF-16BE BOM", be, notepadConfig, false},
+		{"empty", nil, "", false},
+		{"odd UTF-16LE length", append(utf16le(t, "{}", true), 'x'), "{}\uFFFD", false},
+	}
+	for _, tt := range tests {
+		got, err := decodeConfig(tt.data)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: decodeConfig error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: decodeConfig = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigUTF16(t *testing.T) {
+	// 记事本“另存为 Unicode”
+	s := loadedService(t, string(utf16le(t, notepadConfig, true)))
+	if cfg := s.config.Load(); cfg.Command != `C:\Tools\备份.exe /full` {
+		t.Errorf("command = %q", cfg.Command)
+	}
+
+	// 没有 BOM 的 UTF-16LE 无法识别，仍然报配置错误
+	s = writeConfig(t, string(utf16le(t, notepadConfig, false)))
+	if err := s.loadConfig(); err == nil {
+		t.Error("loadConfig accepted UTF-16LE without a BOM")
+	}
+}
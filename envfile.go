@@ -0,0 +1,129 @@
+//go:build windows
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// -------------------- 环境变量 --------------------
+
+// loadEnvFile 解析 KEY=VALUE 格式的 .env 文件。
+// 忽略空行和 # 开头的注释；重复的键以最后一次为准；
+// 值两侧成对的引号会被去掉。
+func loadEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	vars := make(map[string]string)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		vars[key] = value
+	}
+	return vars, sc.Err()
+}
+
+// resolveEnvFile 在加载配置时读取 env_file（相对路径以配置文件目录为基准）
+func resolveEnvFile(cfg *Config, configPath string) error {
+	if cfg.EnvFile == "" {
+		return nil
+	}
+
+	path := cfg.EnvFile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(configPath), path)
+	}
+
+	vars, err := loadEnvFile(path)
+	if errors.Is(err, os.ErrNotExist) && !cfg.EnvFileRequired {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("env_file: %w", err)
+	}
+	cfg.envFileVars = vars
+	return nil
+}
+
+// isolatedEnvVars 是 env_inherit 为 false 时仍从 WinPSP 的环境传给子进程的变量，
+// 缺少它们时很多程序（包括 cmd.exe 和 PowerShell）无法正常运行
+var isolatedEnvVars = []string{"SystemRoot", "windir", "TEMP", "TMP"}
+
+// commandEnv 返回子进程的环境变量：继承的环境 < env_file < env。
+// 两者都未配置时返回 nil，即原样继承 WinPSP 的环境。
+func (cfg *Config) commandEnv() []string {
+	if cfg.EnvInherit != nil && !*cfg.EnvInherit {
+		return cfg.isolatedEnv()
+	}
+	if len(cfg.envFileVars) == 0 && len(cfg.Env) == 0 {
+		return nil
+	}
+
+	// os/exec 会按 Windows 规则（不区分大小写）去重，后出现的优先
+	env := os.Environ()
+	for k, v := range cfg.envFileVars {
+		env = append(env, k+"="+v)
+	}
+	for k, v := range cfg.Env {
+		env = append(env, k+"="+v)
+	}
+	return env
+}
+
+// isolatedEnv 从空的环境开始，只放入 isolatedEnvVars、env_file 和 env。
+// 变量名不区分大小写，后写入的优先，返回的每个变量只出现一次。
+func (cfg *Config) isolatedEnv() []string {
+	vars := make(map[string]string)
+	set := func(k, v string) { vars[strings.ToUpper(k)] = k + "=" + v }
+	for _, k := range isolatedEnvVars {
+		if v, ok := os.LookupEnv(k); ok {
+			set(k, v)
+		}
+	}
+	for k, v := range cfg.envFileVars {
+		set(k, v)
+	}
+	for k, v := range cfg.Env {
+		set(k, v)
+	}
+
+	// 非 nil，即使为空也不继承
+	env := make([]string, 0, len(vars))
+	for _, kv := range vars {
+		env = append(env, kv)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// appendEnv 向 commandEnv 的结果追加变量；env 为 nil（继承）时以当前环境为基础
+func appendEnv(env []string, kv ...string) []string {
+	if env == nil {
+		env = os.Environ()
+	}
+	return append(env, kv...)
+}
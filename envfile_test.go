@@ -0,0 +1,220 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func init() {
+	// 每行输出一个环境变量，加上前缀以便从日志中找出
+	testHelpers["environ"] = func(args []string) int {
+		for _, kv := range os.Environ() {
+			fmt.Println("env: " + kv)
+		}
+		return 0
+	}
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	tests := []struct {
+		name, data string
+		want       map[string]string
+		wantErr    bool
+	}{
+		{"empty", "", map[string]string{}, false},
+		{"comments and blank lines", "# deploy settings\n\n  # indented comment\nA=1\n", map[string]string{"A": "1"}, false},
+		{"duplicate keys", "A=1\nB=2\nA=3\n", map[string]string{"A": "3", "B": "2"}, false},
+		{"spaces", "  KEY  =  some value  \r\n", map[string]string{"KEY": "some value"}, false},
+		{"quotes", "A=\"x y\"\nB='z'\nC=\"unbalanced\nD=\"\"\n", map[string]string{"A": "x y", "B": "z", "C": "\"unbalanced", "D": ""}, false},
+		{"hash in value", "URL=http://host/#frag\n", map[string]string{"URL": "http://host/#frag"}, false},
+		{"equals in value", "ARGS=--a=1 --b=2\n", map[string]string{"ARGS": "--a=1 --b=2"}, false},
+		{"empty value", "EMPTY=\n", map[string]string{"EMPTY": ""}, false},
+		{"missing equals", "A=1\nNOVALUE\n", nil, true},
+		{"missing key", "=1\n", nil, true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), ".env")
+		if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := loadEnvFile(path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResolveEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "winpsp.json")
+	if err := os.WriteFile(filepath.Join(dir, "deploy.env"), []byte("TARGET=prod\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		file     string
+		required bool
+		want     map[string]string
+		wantErr  bool
+	}{
+		{"", false, nil, false},
+		{"deploy.env", false, map[string]string{"TARGET": "prod"}, false},                    // 相对于配置文件目录
+		{filepath.Join(dir, "deploy.env"), true, map[string]string{"TARGET": "prod"}, false}, // 绝对路径
+		{"missing.env", false, nil, false},
+		{"missing.env", true, nil, true},
+	}
+	for _, tt := range tests {
+		cfg := &Config{EnvFile: tt.file, EnvFileRequired: tt.required}
+		err := resolveEnvFile(cfg, configPath)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("resolveEnvFile(%q, required=%v) error = %v, wantErr %v", tt.file, tt.required, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr && !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("resolveEnvFile(%q): error %v does not wrap ErrNotExist", tt.file, err)
+		}
+		if err == nil && !reflect.DeepEqual(cfg.envFileVars, tt.want) {
+			t.Errorf("resolveEnvFile(%q) = %v, want %v", tt.file, cfg.envFileVars, tt.want)
+		}
+	}
+}
+
+// envValue 返回 env 中最后一个名为 key 的变量（不区分大小写，与 os/exec 去重的结果相同）
+func envValue(env []string, key string) (string, bool) {
+	value, found := "", false
+	for _, kv := range env {
+		if k, v, ok := strings.Cut(kv, "="); ok && strings.EqualFold(k, key) {
+			value, found = v, true
+		}
+	}
+	return value, found
+}
+
+func TestCommandEnv(t *testing.T) {
+	t.Setenv("WINPSP_TEST_INHERITED", "service")
+	t.Setenv("WINPSP_TEST_OVERRIDE", "service")
+
+	if env := (&Config{}).commandEnv(); env != nil {
+		t.Errorf("commandEnv without env_file or env = %d variables, want nil (inherit)", len(env))
+	}
+
+	cfg := &Config{
+		envFileVars: map[string]string{"WINPSP_TEST_OVERRIDE": "file", "WINPSP_TEST_FILE": "file"},
+		Env:         map[string]string{"winpsp_test_override": "env"},
+	}
+	env := cfg.commandEnv()
+	for key, want := range map[string]string{
+		"WINPSP_TEST_INHERITED": "service",
+		"WINPSP_TEST_FILE":      "file",
+		"WINPSP_TEST_OVERRIDE":  "env", // env 优先于 env_file，env_file 优先于继承的环境
+	} {
+		if got, ok := envValue(env, key); !ok || got != want {
+			t.Errorf("%s = %q (found %v), want %q", key, got, ok, want)
+		}
+	}
+}
+
+func TestIsolatedEnv(t *testing.T) {
+	t.Setenv("WINPSP_TEST_INHERITED", "service")
+	t.Setenv("TEMP", `C:\Windows\Temp`)
+
+	inherit := false
+	tests := []struct {
+		name    string
+		cfg     *Config
+		want    map[string]string // 除 isolatedEnvVars 之外的变量
+		changed map[string]string // 覆盖了 isolatedEnvVars 中的变量
+	}{
+		{"only system variables", &Config{}, nil, nil},
+		{"env", &Config{Env: map[string]string{"APP_MODE": "audit"}}, map[string]string{"APP_MODE": "audit"}, nil},
+		{
+			"env over env_file",
+			&Config{
+				envFileVars: map[string]string{"TARGET": "file", "REGION": "eu"},
+				Env:         map[string]string{"target": "env"},
+			},
+			map[string]string{"TARGET": "env", "REGION": "eu"}, nil,
+		},
+		{"env over system variables", &Config{Env: map[string]string{"temp": `D:\scratch`}}, nil, map[string]string{"TEMP": `D:\scratch`}},
+	}
+	for _, tt := range tests {
+		tt.cfg.EnvInherit = &inherit
+		env := tt.cfg.commandEnv()
+
+		want := map[string]string{}
+		for _, k := range isolatedEnvVars {
+			if v, ok := os.LookupEnv(k); ok {
+				want[strings.ToUpper(k)] = v
+			}
+		}
+		for _, m := range []map[string]string{tt.want, tt.changed} {
+			for k, v := range m {
+				want[strings.ToUpper(k)] = v
+			}
+		}
+		got := map[string]string{}
+		for _, kv := range env {
+			k, v, _ := strings.Cut(kv, "=")
+			if _, dup := got[strings.ToUpper(k)]; dup {
+				t.Errorf("%s: %s appears more than once", tt.name, k)
+			}
+			got[strings.ToUpper(k)] = v
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: environment %v, want %v", tt.name, got, want)
+		}
+		if !sort.StringsAreSorted(env) {
+			t.Errorf("%s: environment not sorted: %q", tt.name, env)
+		}
+	}
+}
+
+// env_inherit 为 false 时，子进程看到的只有系统变量和 env 中的变量
+func TestShutdownEnvNotInherited(t *testing.T) {
+	t.Setenv("WINPSP_TEST_SECRET", "service token")
+	command, _ := helperCommandLine(t, "environ")
+	cmdline, _ := json.Marshal(command)
+	s := loadedService(t, fmt.Sprintf(`{
+  "command": %s,
+  "env_inherit": false,
+  "env": {"WINPSP_TEST_HELPER": "environ", "APP_MODE": "audit"}
+}`, cmdline))
+	s.interactive = true
+	log := shutdownLog(t, s)
+
+	var got []string
+	for _, line := range strings.Split(log, "\n") {
+		if _, kv, ok := strings.Cut(strings.TrimRight(line, "\r"), "env: "); ok {
+			name, _, _ := strings.Cut(kv, "=")
+			got = append(got, strings.ToUpper(name))
+		}
+	}
+	sort.Strings(got)
+
+	want := []string{"APP_MODE", testHelperEnv}
+	for _, k := range isolatedEnvVars {
+		if _, ok := os.LookupEnv(k); ok {
+			want = append(want, strings.ToUpper(k))
+		}
+	}
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("child environment %q, want %q", got, want)
+	}
+	if msg := fmt.Sprintf("Environment not inherited: %d variables provided", len(want)); !strings.Contains(log, msg) {
+		t.Errorf("log does not contain %q:\n%s", msg, log)
+	}
+}
@@ -0,0 +1,193 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// 事件 ID
+const (
+	eventIDServiceStarted    uint32 = 1
+	eventIDConfigError       uint32 = 2
+	eventIDRemoteConfigError uint32 = 3
+	eventIDNotSystem         uint32 = 4
+	eventIDPanic             uint32 = 5
+	eventIDConfigLoaded      uint32 = 6
+	eventIDStopWaiting       uint32 = 7
+	eventIDStartupCommand    uint32 = 8
+	eventIDNoAdminManifest   uint32 = 9
+	eventIDStartMessage      uint32 = 10 // on_start_message
+	eventIDStopMessage       uint32 = 11 // on_stop_message
+
+	// 命令执行事件，供 SIEM 按 ID 监控；插入字符串依次为
+	// 主机名、命令名、退出码、耗时
+	eventIDCommandStart   uint32 = 1000
+	eventIDCommandSuccess uint32 = 1001
+	eventIDCommandTimeout uint32 = 1002
+	eventIDCommandFailure uint32 = 1003
+
+	// 命令用掉超时的 deadline_warning_percent%；插入字符串依次为
+	// 主机名、命令名、已用比例、剩余时间
+	eventIDCommandDeadline uint32 = 1004
+
+	eventIDLogUploadFailed  uint32 = 1100
+	eventIDLogArchiveFailed uint32 = 1101
+)
+
+// 事件级别
+const (
+	eventInfo = iota
+	eventWarning
+	eventError
+)
+
+// writeEvent 写入 Windows 应用程序事件日志。
+// 事件日志只是辅助诊断手段，任何失败都静默忽略。可在调试时替换
+var writeEvent = func(kind int, eid uint32, msg string) {
+	l, err := eventlog.Open(serviceName)
+	if err != nil {
+		return
+	}
+	defer l.Close()
+
+	switch kind {
+	case eventError:
+		_ = l.Error(eid, msg)
+	case eventWarning:
+		_ = l.Warning(eid, msg)
+	default:
+		_ = l.Info(eid, msg)
+	}
+}
+
+// 尚未写完的异步事件
+var pendingEvents sync.WaitGroup
+
+// reportEventAsync 在后台写入带多个插入字符串的事件，不阻塞调用方
+func reportEventAsync(kind int, eid uint32, strs ...string) {
+	pendingEvents.Add(1)
+	go func() {
+		defer pendingEvents.Done()
+		reportEvent(kind, eid, strs)
+	}()
+}
+
+// flushEvents 等待后台事件写完，最多等待 timeout，避免进程退出时丢失事件
+func flushEvents(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		pendingEvents.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+func reportEvent(kind int, eid uint32, strs []string) {
+	src, err := windows.UTF16PtrFromString(serviceName)
+	if err != nil {
+		return
+	}
+	h, err := windows.RegisterEventSource(nil, src)
+	if err != nil {
+		return
+	}
+	defer windows.DeregisterEventSource(h)
+
+	etype := uint16(windows.EVENTLOG_INFORMATION_TYPE)
+	switch kind {
+	case eventError:
+		etype = windows.EVENTLOG_ERROR_TYPE
+	case eventWarning:
+		etype = windows.EVENTLOG_WARNING_TYPE
+	}
+
+	ptrs := make([]*uint16, 0, len(strs))
+	for _, str := range strs {
+		p, err := windows.UTF16PtrFromString(str)
+		if err != nil {
+			return
+		}
+		ptrs = append(ptrs, p)
+	}
+	if len(ptrs) == 0 {
+		return
+	}
+	_ = windows.ReportEvent(h, etype, 0, eid, 0, uint16(len(ptrs)), 0, &ptrs[0], nil)
+}
+
+// -------------------- 事件来源注册 --------------------
+
+// eventSourceKey 是应用程序日志下各事件来源的注册表位置
+const eventSourceKey = `SYSTEM\CurrentControlSet\Services\EventLog\Application`
+
+// registerEventSource 在注册表中创建事件来源，EventMessageFile 指向 WinPSP 自身。
+// 来源已存在时（例如卸载前手动注册过）覆盖其中的值。
+func registerEventSource(source, exe string) error {
+	k, _, err := registry.CreateKey(registry.LOCAL_MACHINE, eventSourceKey+`\`+source, registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer k.Close()
+
+	if err := k.SetExpandStringValue("EventMessageFile", exe); err != nil {
+		return err
+	}
+	if err := k.SetDWordValue("TypesSupported",
+		windows.EVENTLOG_ERROR_TYPE|windows.EVENTLOG_WARNING_TYPE|windows.EVENTLOG_INFORMATION_TYPE); err != nil {
+		return err
+	}
+	return k.SetDWordValue("CustomSource", 1)
+}
+
+// unregisterEventSource 删除事件来源，来源不存在不算错误
+func unregisterEventSource(source string) error {
+	err := registry.DeleteKey(registry.LOCAL_MACHINE, eventSourceKey+`\`+source)
+	if errors.Is(err, registry.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// reportCommandEvent 异步写入命令执行事件
+func reportCommandEvent(eid uint32, command string, exitCode int, duration time.Duration) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown-host"
+	}
+
+	kind := eventInfo
+	switch eid {
+	case eventIDCommandTimeout:
+		kind = eventWarning
+	case eventIDCommandFailure:
+		kind = eventError
+	}
+
+	reportEventAsync(kind, eid, host, commandName(command),
+		fmt.Sprint(exitCode), duration.Round(100*time.Millisecond).String())
+}
+
+var procOutputDebugStringW = modkernel32.NewProc("OutputDebugStringW")
+
+// debugf 输出调试级别信息（OutputDebugString，可用 DebugView 查看）。
+// 用于不值得写入事件日志、也没有日志文件可写的情况。
+func debugf(format string, args ...any) {
+	p, err := windows.UTF16PtrFromString(fmt.Sprintf(format, args...))
+	if err != nil {
+		return
+	}
+	procOutputDebugStringW.Call(uintptr(unsafe.Pointer(p)))
+}
@@ -0,0 +1,66 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// TestRegisterEventSource 在 HKLM 中注册一个临时的事件来源，需要管理员权限
+func TestRegisterEventSource(t *testing.T) {
+	if !windows.GetCurrentProcessToken().IsElevated() {
+		t.Skip("registering an event source requires an elevated process")
+	}
+	source := fmt.Sprintf("WinPSPTest-%d", time.Now().UnixNano())
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { unregisterEventSource(source) })
+
+	// 第二次注册（来源已存在）覆盖原来的值
+	for _, path := range []string{`C:\Old\winpsp.exe`, exe} {
+		if err := registerEventSource(source, path); err != nil {
+			t.Fatalf("registerEventSource(%s): %v", path, err)
+		}
+	}
+
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, eventSourceKey+`\`+source, registry.QUERY_VALUE)
+	if err != nil {
+		t.Fatalf("source key not created: %v", err)
+	}
+	msgFile, typ, err := k.GetStringValue("EventMessageFile")
+	if err != nil || msgFile != exe || typ != registry.EXPAND_SZ {
+		t.Errorf("EventMessageFile = %q (type %d), %v, want %q as REG_EXPAND_SZ", msgFile, typ, err, exe)
+	}
+	types, _, err := k.GetIntegerValue("TypesSupported")
+	if want := uint64(windows.EVENTLOG_ERROR_TYPE | windows.EVENTLOG_WARNING_TYPE | windows.EVENTLOG_INFORMATION_TYPE); err != nil || types != want {
+		t.Errorf("TypesSupported = %d, %v, want %d", types, err, want)
+	}
+	if custom, _, err := k.GetIntegerValue("CustomSource"); err != nil || custom != 1 {
+		t.Errorf("CustomSource = %d, %v, want 1", custom, err)
+	}
+	k.Close()
+	if err := eventSourceRegistered(source); err != nil {
+		t.Errorf("eventSourceRegistered after registering: %v", err)
+	}
+
+	// 注销后键不存在；再注销一次（来源已不存在）不算错误
+	for i := 1; i <= 2; i++ {
+		if err := unregisterEventSource(source); err != nil {
+			t.Fatalf("unregisterEventSource #%d: %v", i, err)
+		}
+	}
+	if _, err := registry.OpenKey(registry.LOCAL_MACHINE, eventSourceKey+`\`+source, registry.QUERY_VALUE); err == nil {
+		t.Error("source key still exists after unregistering")
+	}
+	if err := eventSourceRegistered(source); err == nil {
+		t.Error("eventSourceRegistered succeeded after unregistering")
+	}
+}
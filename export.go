@@ -0,0 +1,49 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+const redacted = "[REDACTED]"
+
+// -------------------- 导出有效配置 --------------------
+
+// exportConfig 把实际运行时使用的配置（profile 已合并、默认值已填充）
+// 以 JSON 写到 outPath，outPath 为空时写到 stdout。密钥类字段会被替换。
+func exportConfig(configPath, outPath string) error {
+	s := &winpspService{configPath: configPath}
+	cfg, err := s.readConfig()
+	if err != nil {
+		return err
+	}
+
+	// 导出 "secret:KEY" 引用本身，而不是从后端取到的值
+	cfg.restoreSecretRefs()
+	redactConfig(cfg)
+	// profile 已经合并进来了，再导出只会让人误以为还要再套一次
+	cfg.Profiles = nil
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if outPath == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(outPath, data, 0600)
+}
+
+// redactConfig 替换配置中的密钥，避免随导出结果外泄
+func redactConfig(cfg *Config) {
+	for _, p := range []*string{&cfg.S3SecretAccessKey, &cfg.S3SessionToken, &cfg.WebhookToken, &cfg.VaultToken} {
+		if *p != "" {
+			*p = redacted
+		}
+	}
+}
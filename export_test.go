@@ -0,0 +1,91 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useProfile 模拟 --profile name，name 为空时按主机名自动选用
+func useProfile(t *testing.T, name string) {
+	t.Helper()
+	origName, origChosen := chosenProfile, profileChosen
+	chosenProfile, profileChosen = name, name != ""
+	t.Cleanup(func() { chosenProfile, profileChosen = origName, origChosen })
+}
+
+// exportedConfig 导出 configPath 的有效配置，返回解析后的 JSON 对象
+func exportedConfig(t *testing.T, configPath string) map[string]any {
+	t.Helper()
+	out := filepath.Join(t.TempDir(), "effective.json")
+	if err := exportConfig(configPath, out); err != nil {
+		t.Fatalf("exportConfig: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("exported config is not JSON: %v\n%s", err, data)
+	}
+	return m
+}
+
+func TestExportConfigProfiles(t *testing.T) {
+	path, host := writeProfilesFixture(t)
+	tests := []struct {
+		profile     string
+		wantCommand string
+		wantTimeout float64
+	}{
+		{"office", `C:\工具\关机前清理临时文件并同步所有用户的桌面和文档目录到文件服务器.exe --full --目标 D:\备份\桌面与文档`, 60},
+		{"", `C:\Tools\backup.exe`, 300}, // 与主机名同名的 profile
+		{host, `C:\Tools\backup.exe`, 300},
+	}
+	for _, tt := range tests {
+		useProfile(t, tt.profile)
+		m := exportedConfig(t, path)
+		if m["command"] != tt.wantCommand || m["timeout"] != tt.wantTimeout {
+			t.Errorf("profile %q: exported command %v, timeout %v, want %q, %v",
+				tt.profile, m["command"], m["timeout"], tt.wantCommand, tt.wantTimeout)
+		}
+		if m["log_count"] != float64(3) {
+			t.Errorf("profile %q: exported log_count %v, want 3 from the top level", tt.profile, m["log_count"])
+		}
+		if m["profiles"] != nil {
+			t.Errorf("profile %q: profiles exported although already merged", tt.profile)
+		}
+	}
+}
+
+func TestExportConfigRedacts(t *testing.T) {
+	useProfile(t, "")
+	s := writeConfig(t, `{
+  "command": "C:\\Tools\\backup.exe",
+  "webhook_url": "https://hooks.example.com/winpsp",
+  "webhook_token": "tok-123",
+  "s3_secret_access_key": "wJalrXUtnFEMI",
+  "s3_session_token": "FwoGZXIvYXdzEBYaDH"
+}`)
+	m := exportedConfig(t, s.configPath)
+	for _, key := range []string{"webhook_token", "s3_secret_access_key", "s3_session_token"} {
+		if m[key] != redacted {
+			t.Errorf("%s exported as %v, want %s", key, m[key], redacted)
+		}
+	}
+	if m["vault_token"] != "" {
+		t.Errorf("empty vault_token exported as %v", m["vault_token"])
+	}
+
+	// 不指定输出文件时写到 stdout
+	var err error
+	out := captureStdout(t, func() { err = exportConfig(s.configPath, "") })
+	if err != nil || !strings.Contains(out, `"webhook_url": "https://hooks.example.com/winpsp"`) || strings.Contains(out, "tok-123") {
+		t.Errorf("exportConfig to stdout = %v:\n%s", err, out)
+	}
+}
@@ -0,0 +1,116 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// -------------------- 服务健康检查 --------------------
+
+// healthCheck 是 --check-service-health 的一项检查
+type healthCheck struct {
+	name string
+	run  func() error
+}
+
+// runHealthCheck 实现 --check-service-health：从服务安装到事件日志来源逐项检查，
+// 每项都执行（前面失败不影响后面），任何一项失败返回 false。
+func runHealthCheck(configPath string) bool {
+	fmt.Printf("Service: %s\n", serviceName)
+	fmt.Printf("Config file: %s\n", configPath)
+
+	ok := true
+	for _, c := range healthChecks(configPath) {
+		if err := c.run(); err != nil {
+			fmt.Printf("  FAIL  %s: %v\n", c.name, err)
+			ok = false
+			continue
+		}
+		fmt.Printf("  PASS  %s\n", c.name)
+	}
+	return ok
+}
+
+// 服务管理器和注册表的查询，可在调试时替换
+var (
+	serviceInstalled = func(name string) error {
+		return withService(name, func(*mgr.Service) error { return nil })
+	}
+	serviceState = func(name string) (svc.State, error) {
+		var state svc.State
+		err := withService(name, func(m *mgr.Service) error {
+			st, err := m.Query()
+			state = st.State
+			return err
+		})
+		return state, err
+	}
+	eventSourceCheck = eventSourceRegistered
+)
+
+func healthChecks(configPath string) []healthCheck {
+	s := &winpspService{configPath: configPath}
+	cfgErr := s.loadConfig()
+
+	return []healthCheck{
+		{"service installed", func() error { return serviceInstalled(serviceName) }},
+		{"service running", func() error {
+			state, err := serviceState(serviceName)
+			if err != nil {
+				return err
+			}
+			if state != svc.Running {
+				return fmt.Errorf("state is %s", stateString(state))
+			}
+			return nil
+		}},
+		{"config valid", func() error { return cfgErr }},
+		{"log directory writable", func() error { return dirWritable(filepath.Dir(configPath)) }},
+		{"command executable exists", func() error {
+			if cfgErr != nil {
+				return errors.New("config not loaded")
+			}
+			for _, e := range s.config.Load().commandEntries() {
+				if e.wmi {
+					continue
+				}
+				if err := commandReachable(e.Command, s.commandBaseDir()); err != nil {
+					return fmt.Errorf("%s: %w", e.displayName(), err)
+				}
+			}
+			return nil
+		}},
+		{"event log source registered", func() error { return eventSourceCheck(serviceName) }},
+	}
+}
+
+// dirWritable 在目录中创建并删除一个临时文件
+func dirWritable(dir string) error {
+	f, err := os.CreateTemp(dir, "winpsp-health-*.tmp")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// eventSourceRegistered 检查应用程序日志下是否注册了事件来源。
+// 未注册时事件仍能写入，但事件查看器无法显示消息文本。
+func eventSourceRegistered(source string) error {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, eventSourceKey+`\`+source, registry.QUERY_VALUE)
+	if err != nil {
+		if errors.Is(err, registry.ErrNotExist) {
+			return fmt.Errorf("source %s is not registered (winpsp --install --auto-register-event-source)", source)
+		}
+		return err
+	}
+	return k.Close()
+}
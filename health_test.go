@@ -0,0 +1,85 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// mockHealth 替换服务管理器和注册表的查询
+func mockHealth(t *testing.T, installed error, state svc.State, source error) {
+	t.Helper()
+	origInstalled, origState, origSource := serviceInstalled, serviceState, eventSourceCheck
+	serviceInstalled = func(string) error { return installed }
+	serviceState = func(string) (svc.State, error) { return state, installed }
+	eventSourceCheck = func(string) error { return source }
+	t.Cleanup(func() { serviceInstalled, serviceState, eventSourceCheck = origInstalled, origState, origSource })
+}
+
+func TestRunHealthCheck(t *testing.T) {
+	const validConfig = `{"command": "cmd.exe /c exit 0"}`
+	notInstalled := errors.New("service WinPSP is not installed")
+	noSource := errors.New("source WinPSP is not registered")
+
+	tests := []struct {
+		name      string
+		installed error
+		state     svc.State
+		source    error
+		config    string                         // 空表示没有配置文件
+		setup     func(t *testing.T, dir string) // 可选
+		wantFail  []string                       // 失败的检查，其余都应通过
+	}{
+		{"all pass", nil, svc.Running, nil, validConfig, nil, nil},
+		{"not installed", notInstalled, 0, nil, validConfig, nil, []string{"service installed", "service running"}},
+		{"stopped", nil, svc.Stopped, nil, validConfig, nil, []string{"service running"}},
+		{"no config", nil, svc.Running, nil, "", nil, []string{"config valid", "command executable exists"}},
+		{"invalid config", nil, svc.Running, nil, `{"command": `, nil, []string{"config valid", "command executable exists"}},
+		{"missing command", nil, svc.Running, nil, `{"command": "C:\\WinPSP-missing\\backup.exe"}`, nil, []string{"command executable exists"}},
+		{"read-only log directory", nil, svc.Running, nil, validConfig, denyWrites, []string{"log directory writable"}},
+		{"event source not registered", nil, svc.Running, noSource, validConfig, nil, []string{"event log source registered"}},
+		{"everything wrong", notInstalled, 0, noSource, "", denyWrites, []string{
+			"service installed", "service running", "config valid", "log directory writable",
+			"command executable exists", "event log source registered",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockHealth(t, tt.installed, tt.state, tt.source)
+			dir := t.TempDir()
+			path := filepath.Join(dir, "config.json")
+			if tt.config != "" {
+				if err := os.WriteFile(path, []byte(tt.config), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.setup != nil {
+				tt.setup(t, dir)
+			}
+
+			var ok bool
+			out := captureStdout(t, func() { ok = runHealthCheck(path) })
+			if ok != (len(tt.wantFail) == 0) {
+				t.Errorf("runHealthCheck = %v, want %v:\n%s", ok, len(tt.wantFail) == 0, out)
+			}
+			// 每项检查都执行，各自报告结果
+			for _, c := range healthChecks(path) {
+				status := "PASS"
+				for _, f := range tt.wantFail {
+					if f == c.name {
+						status = "FAIL"
+					}
+				}
+				if !strings.Contains(out, "  "+status+"  "+c.name) {
+					t.Errorf("%s: want %s:\n%s", c.name, status, out)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// -------------------- 心跳文件 --------------------
+
+const defaultHeartbeatIntervalSecs = 10
+
+// startHeartbeat 创建 heartbeat_file，命令运行期间每隔 interval 更新其修改时间，
+// 外部监控据此判断 WinPSP 是否卡住。返回的 stop 停止更新并删除文件。
+// 文件只是辅助手段，任何失败都只记录，不影响命令执行。
+func startHeartbeat(path string, interval time.Duration, logf func(format string, args ...any)) (stop func()) {
+	content := fmt.Sprintf("pid=%d\nstarted=%s\n", os.Getpid(), time.Now().Format(time.RFC3339))
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		logf("Warning: heartbeat file not written: %v", err)
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-t.C:
+				os.Chtimes(path, now, now)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+		if err := os.Remove(path); err != nil {
+			logf("Warning: heartbeat file not deleted: %v", err)
+		}
+	}
+}
+
+// heartbeatPath 返回 heartbeat_file 的绝对路径，相对路径基于配置文件所在目录
+func (s *winpspService) heartbeatPath() string {
+	p := s.config.Load().HeartbeatFile
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(filepath.Dir(s.configPath), p)
+}
@@ -0,0 +1,123 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatPath(t *testing.T) {
+	const configPath = `C:\ProgramData\WinPSP\winpsp.json`
+	tests := []struct {
+		file, want string
+	}{
+		{"heartbeat", `C:\ProgramData\WinPSP\heartbeat`},
+		{`run\heartbeat.txt`, `C:\ProgramData\WinPSP\run\heartbeat.txt`},
+		{`D:\Monitor\winpsp.alive`, `D:\Monitor\winpsp.alive`},
+	}
+	for _, tt := range tests {
+		s := &winpspService{configPath: configPath}
+		s.config.Store(&Config{HeartbeatFile: tt.file})
+		if got := s.heartbeatPath(); got != tt.want {
+			t.Errorf("heartbeatPath(%q) = %q, want %q", tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestStartHeartbeat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "heartbeat")
+	var warnings []string
+	logf := func(format string, args ...any) { warnings = append(warnings, fmt.Sprintf(format, args...)) }
+
+	stop := startHeartbeat(path, 100*time.Millisecond, logf)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), fmt.Sprintf("pid=%d\n", os.Getpid())) {
+		t.Errorf("heartbeat file content %q", data)
+	}
+
+	// 把修改时间改到一小时前，下一次心跳应把它更新为当前时间
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if age := time.Since(fi.ModTime()); age > 5*time.Second {
+		t.Errorf("heartbeat mtime is %s old, want updated within the last interval", age)
+	}
+
+	stop()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("heartbeat file still there after stop: %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("warnings: %q", warnings)
+	}
+}
+
+func TestStartHeartbeatUnwritable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "heartbeat")
+	var warnings []string
+	stop := startHeartbeat(path, 100*time.Millisecond, func(format string, args ...any) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	})
+	stop()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "heartbeat file not written") {
+		t.Errorf("warnings: %q", warnings)
+	}
+}
+
+func TestShutdownHeartbeat(t *testing.T) {
+	exe, _ := helperCommandLine(t, "sleep")
+	t.Setenv(testHelperEnv, "sleep")
+	data, err := json.Marshal(map[string]any{
+		"command":                 exe + " 3500",
+		"heartbeat_file":          "heartbeat",
+		"heartbeat_interval_secs": 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := loadedService(t, string(data))
+	s.interactive = true
+	path := s.heartbeatPath()
+
+	done := make(chan error, 1)
+	go func() { done <- s.handleShutdownOnce() }()
+
+	// 命令运行期间 mtime 不断前进
+	var mtimes []time.Time
+	for running := true; running; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			running = false
+		case <-time.After(200 * time.Millisecond):
+			if fi, err := os.Stat(path); err == nil {
+				if n := len(mtimes); n == 0 || fi.ModTime().After(mtimes[n-1]) {
+					mtimes = append(mtimes, fi.ModTime())
+				}
+			}
+		}
+	}
+	if len(mtimes) < 2 {
+		t.Errorf("heartbeat mtime updated %d time(s) during a 3.5 s command, want at least 2 values", len(mtimes))
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("heartbeat file still there after the command finished: %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+//go:build windows
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite" // 纯 Go 实现，不需要 CGO
+)
+
+const defaultHistoryRows = 10
+
+const historySchema = `CREATE TABLE IF NOT EXISTS runs (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	ts          TEXT    NOT NULL,
+	command     TEXT    NOT NULL,
+	exit_code   INTEGER NOT NULL,
+	duration_ms INTEGER NOT NULL,
+	timed_out   INTEGER NOT NULL,
+	log_path    TEXT    NOT NULL
+)`
+
+// -------------------- 运行历史数据库 --------------------
+
+// historyDBPath 返回 history_db_path 的绝对路径（相对路径以配置文件目录为基准），未配置返回空串
+func historyDBPath(cfg *Config, configPath string) string {
+	if cfg.HistoryDBPath == "" {
+		return ""
+	}
+	if filepath.IsAbs(cfg.HistoryDBPath) {
+		return cfg.HistoryDBPath
+	}
+	return filepath.Join(filepath.Dir(configPath), cfg.HistoryDBPath)
+}
+
+func openHistoryDB(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(historySchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// recordHistory 把一次运行追加到历史数据库
+func recordHistory(path string, rec *runRecord) error {
+	db, err := openHistoryDB(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`INSERT INTO runs (ts, command, exit_code, duration_ms, timed_out, log_path)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		rec.LastRun.UTC().Format(time.RFC3339), rec.Command, rec.ExitCode,
+		rec.DurationMs, rec.TimedOut, rec.LogPath)
+	return err
+}
+
+// printHistory 实现 --history [N]：按时间倒序打印最近 N 次运行
+func printHistory(path string, n int) error {
+	db, err := openHistoryDB(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT id, ts, command, exit_code, duration_ms, timed_out
+		FROM runs ORDER BY id DESC LIMIT ?`, n)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	printHistoryHeader()
+	for rows.Next() {
+		var (
+			id, exitCode, durationMs int64
+			ts, command              string
+			timedOut                 bool
+		)
+		if err := rows.Scan(&id, &ts, &command, &exitCode, &durationMs, &timedOut); err != nil {
+			return err
+		}
+
+		t, _ := time.Parse(time.RFC3339, ts)
+		printHistoryRow(id, t, durationMs, exitCode, timedOut, command)
+	}
+	return rows.Err()
+}
+
+func printHistoryHeader() {
+	fmt.Printf("%-6s %-20s %10s %5s %-7s %s\n", "ID", "TIME", "DURATION", "EXIT", "TIMEOUT", "COMMAND")
+}
+
+// printHistoryRow 打印 --history 的一行，数据库和 run_history_gzip 共用
+func printHistoryRow(id int64, t time.Time, durationMs, exitCode int64, timedOut bool, command string) {
+	ts := "-"
+	if !t.IsZero() {
+		ts = t.Local().Format("2006-01-02 15:04:05")
+	}
+	timeout := "no"
+	if timedOut {
+		timeout = "yes"
+	}
+	duration := (time.Duration(durationMs) * time.Millisecond).Round(100 * time.Millisecond)
+	fmt.Printf("%-6d %-20s %10s %5d %-7s %s\n", id, ts, duration, exitCode, timeout, command)
+}
+
+// purgeHistory 删除早于 days 天的记录，返回删除的行数
+func purgeHistory(path string, days int) (int64, error) {
+	if days <= 0 {
+		return 0, errors.New("--older-than-days must be greater than 0")
+	}
+
+	db, err := openHistoryDB(path)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	cutoff := time.Now().UTC().AddDate(0, 0, -days).Format(time.RFC3339)
+	res, err := db.Exec(`DELETE FROM runs WHERE ts < ?`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,129 @@
+//go:build windows
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memoryHistoryDB 返回一个共享的内存数据库的名字。测试期间保持一个连接，
+// recordHistory 等每次打开、关闭时使用的是同一个数据库。
+func memoryHistoryDB(t *testing.T) (string, *sql.DB) {
+	t.Helper()
+	name := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+	db, err := openHistoryDB(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return name, db
+}
+
+func TestHistoryDBPath(t *testing.T) {
+	const configPath = `C:\ProgramData\WinPSP\winpsp.json`
+	tests := []struct {
+		path, want string
+	}{
+		{"", ""},
+		{"history.db", `C:\ProgramData\WinPSP\history.db`},
+		{`db\history.db`, `C:\ProgramData\WinPSP\db\history.db`},
+		{`D:\WinPSP\history.db`, `D:\WinPSP\history.db`},
+	}
+	for _, tt := range tests {
+		if got := historyDBPath(&Config{HistoryDBPath: tt.path}, configPath); got != tt.want {
+			t.Errorf("historyDBPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRecordHistory(t *testing.T) {
+	path, db := memoryHistoryDB(t)
+	ts := time.Date(2026, 10, 14, 8, 30, 0, 0, time.UTC)
+	recs := []runRecord{
+		{LastRun: ts, Command: `C:\Tools\backup.exe /full`, ExitCode: 0, DurationMs: 1500, LogPath: `C:\logs\a.log`},
+		{LastRun: ts.Add(time.Hour), Command: "shutdown-hook.bat", ExitCode: 1, TimedOut: true, DurationMs: 300000},
+	}
+	for i := range recs {
+		if err := recordHistory(path, &recs[i]); err != nil {
+			t.Fatalf("recordHistory: %v", err)
+		}
+	}
+
+	rows, err := db.Query(`SELECT ts, command, exit_code, duration_ms, timed_out, log_path FROM runs ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []runRecord
+	for rows.Next() {
+		var r runRecord
+		var s string
+		if err := rows.Scan(&s, &r.Command, &r.ExitCode, &r.DurationMs, &r.TimedOut, &r.LogPath); err != nil {
+			t.Fatal(err)
+		}
+		r.LastRun, _ = time.Parse(time.RFC3339, s)
+		got = append(got, r)
+	}
+	if len(got) != len(recs) {
+		t.Fatalf("%d rows, want %d", len(got), len(recs))
+	}
+	for i, want := range recs {
+		g := got[i]
+		if !g.LastRun.Equal(want.LastRun) || g.Command != want.Command || g.ExitCode != want.ExitCode ||
+			g.DurationMs != want.DurationMs || g.TimedOut != want.TimedOut || g.LogPath != want.LogPath {
+			t.Errorf("row %d = %+v, want %+v", i+1, g, want)
+		}
+	}
+}
+
+func TestPrintHistory(t *testing.T) {
+	path, _ := memoryHistoryDB(t)
+	for i := 1; i <= 5; i++ {
+		rec := runRecord{LastRun: time.Now(), Command: fmt.Sprintf("run%d.exe", i), ExitCode: i, DurationMs: 1000}
+		if err := recordHistory(path, &rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var err error
+	out := captureStdout(t, func() { err = printHistory(path, 3) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "ID") {
+		t.Fatalf("printHistory(3) printed:\n%s", out)
+	}
+	// 最新的在前
+	for i, want := range []string{"run5.exe", "run4.exe", "run3.exe"} {
+		if f := strings.Fields(lines[i+1]); f[0] != fmt.Sprint(6-i-1) || f[len(f)-1] != want {
+			t.Errorf("line %d = %q, want id %d and %s", i+1, lines[i+1], 6-i-1, want)
+		}
+	}
+}
+
+func TestPurgeHistory(t *testing.T) {
+	path, db := memoryHistoryDB(t)
+	for _, age := range []int{40, 31, 29, 0} {
+		rec := runRecord{LastRun: time.Now().AddDate(0, 0, -age), Command: fmt.Sprintf("%d-days.exe", age)}
+		if err := recordHistory(path, &rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := purgeHistory(path, 0); err == nil {
+		t.Error("purgeHistory(0) succeeded")
+	}
+	n, err := purgeHistory(path, 30)
+	if err != nil || n != 2 {
+		t.Fatalf("purgeHistory(30) = %d, %v, want 2", n, err)
+	}
+	var left int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM runs WHERE command IN ('29-days.exe', '0-days.exe')`).Scan(&left); err != nil || left != 2 {
+		t.Errorf("runs newer than 30 days left: %d, %v, want 2", left, err)
+	}
+}
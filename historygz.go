@@ -0,0 +1,138 @@
+//go:build windows
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	historyGzipFileName    = "winpsp-history.jsonl.gz"
+	historyGzipRotatedName = "winpsp-history.1.jsonl.gz"
+	defaultHistoryMaxMB    = 10
+
+	// 单条记录（包括 commands）的最大长度
+	historyMaxLineBytes = 1 << 20
+)
+
+// -------------------- run_history_gzip --------------------
+
+// run_history_gzip 时每次运行的 winpsp-last-run.json 内容同时追加到配置目录的
+// winpsp-history.jsonl.gz（JSON Lines）。每次追加写一个独立的 gzip 成员，
+// 多个成员连在一起仍是有效的 gzip 文件，不需要重写已有内容。
+// 文件超过 history_max_mb 时改名为 winpsp-history.1.jsonl.gz（覆盖上一个），再新建。
+
+// historyGzipPath 返回历史文件的路径
+func historyGzipPath(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), historyGzipFileName)
+}
+
+// appendHistoryGzip 把一次运行追加到 path，写之前按 maxBytes 轮换
+func appendHistoryGzip(path string, rec *runRecord, maxBytes int64) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(path); err == nil && info.Size() > maxBytes {
+		rotated := filepath.Join(filepath.Dir(path), historyGzipRotatedName)
+		if err := os.Rename(path, rotated); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(f)
+	_, err = zw.Write(append(line, '\n'))
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// readHistoryGzip 按时间顺序读出轮换的文件和当前文件中的记录，文件不存在时跳过
+func readHistoryGzip(path string) ([]runRecord, error) {
+	var recs []runRecord
+	for _, p := range []string{filepath.Join(filepath.Dir(path), historyGzipRotatedName), path} {
+		r, err := readHistoryFile(p)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(p), err)
+		}
+		recs = append(recs, r...)
+	}
+	return recs, nil
+}
+
+func readHistoryFile(path string) ([]runRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(bufio.NewReader(f))
+	if errors.Is(err, io.EOF) {
+		return nil, nil // 空文件
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var recs []runRecord
+	sc := bufio.NewScanner(zr)
+	sc.Buffer(make([]byte, 0, 64*1024), historyMaxLineBytes)
+	for n := 1; sc.Scan(); n++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var rec runRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			// 成员被截断时 Scanner 仍会返回最后写了一半的行
+			if !sc.Scan() && errors.Is(sc.Err(), io.ErrUnexpectedEOF) {
+				return recs, nil
+			}
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		recs = append(recs, rec)
+	}
+	if err := sc.Err(); err != nil {
+		// 关机时写了一半的最后一个成员：保留已经读出的记录
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return recs, nil
+		}
+		return nil, err
+	}
+	return recs, nil
+}
+
+// printHistoryGzip 实现未配置 history_db_path 时的 --history [N]：按时间倒序打印最近 N 次运行
+func printHistoryGzip(path string, n int) error {
+	recs, err := readHistoryGzip(path)
+	if err != nil {
+		return err
+	}
+
+	printHistoryHeader()
+	for i := len(recs) - 1; i >= 0 && i >= len(recs)-n; i-- {
+		r := &recs[i]
+		printHistoryRow(int64(i+1), r.LastRun, r.DurationMs, int64(r.ExitCode), r.TimedOut, r.Command)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+//go:build windows
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// historyRecords 返回 n 条不同的运行记录
+func historyRecords(n int) []runRecord {
+	ts := time.Date(2026, 10, 14, 8, 30, 0, 0, time.UTC)
+	recs := make([]runRecord, n)
+	for i := range recs {
+		recs[i] = runRecord{
+			LastRun:    ts.Add(time.Duration(i) * time.Hour),
+			Command:    fmt.Sprintf("run%d.exe", i+1),
+			Result:     "success",
+			ExitCode:   i,
+			Attempts:   1,
+			DurationMs: int64(1000 * (i + 1)),
+		}
+	}
+	recs[n-1].Commands = []commandRecord{{Name: "backup", ExitCode: n - 1, Attempts: 1, DurationMs: 500}}
+	return recs
+}
+
+func TestAppendHistoryGzip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), historyGzipFileName)
+	recs := historyRecords(3)
+	for i := range recs {
+		if err := appendHistoryGzip(path, &recs[i], defaultHistoryMaxMB<<20); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 每次追加一个 gzip 成员：整个文件按标准 gzip 解压，内容是每行一个 JSON 对象
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("not a gzip file: %v", err)
+	}
+	var got []runRecord
+	sc := bufio.NewScanner(zr)
+	for sc.Scan() {
+		if !json.Valid(sc.Bytes()) {
+			t.Fatalf("line %d is not JSON: %q", len(got)+1, sc.Text())
+		}
+		var rec runRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, rec)
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+	if !reflect.DeepEqual(got, recs) {
+		t.Errorf("records in the file:\n%+v\nwant\n%+v", got, recs)
+	}
+
+	if read, err := readHistoryGzip(path); err != nil || !reflect.DeepEqual(read, recs) {
+		t.Errorf("readHistoryGzip = %+v, %v", read, err)
+	}
+}
+
+func TestAppendHistoryGzipRotate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, historyGzipFileName)
+	rotated := filepath.Join(dir, historyGzipRotatedName)
+	recs := historyRecords(3)
+
+	// maxBytes 为 1：有记录的文件在下一次追加前都会被轮换
+	steps := []struct {
+		current, rotated []runRecord
+	}{
+		{recs[:1], nil},
+		{recs[1:2], recs[:1]},
+		{recs[2:3], recs[1:2]}, // 覆盖上一个轮换的文件
+	}
+	for i, st := range steps {
+		if err := appendHistoryGzip(path, &recs[i], 1); err != nil {
+			t.Fatal(err)
+		}
+		cur, err := readHistoryFile(path)
+		if err != nil || !reflect.DeepEqual(cur, st.current) {
+			t.Errorf("append %d: current file %+v, %v, want %+v", i+1, cur, err, st.current)
+		}
+		old, err := readHistoryFile(rotated)
+		if st.rotated == nil {
+			if !os.IsNotExist(err) {
+				t.Errorf("append %d: rotated file exists: %v", i+1, err)
+			}
+		} else if err != nil || !reflect.DeepEqual(old, st.rotated) {
+			t.Errorf("append %d: rotated file %+v, %v, want %+v", i+1, old, err, st.rotated)
+		}
+	}
+
+	// 先读轮换的文件，按时间顺序
+	if got, err := readHistoryGzip(path); err != nil || !reflect.DeepEqual(got, recs[1:]) {
+		t.Errorf("readHistoryGzip = %+v, %v, want %+v", got, err, recs[1:])
+	}
+}
+
+func TestReadHistoryFile(t *testing.T) {
+	recs := historyRecords(2)
+	var full []byte
+	var sizes []int
+	for i := range recs {
+		path := filepath.Join(t.TempDir(), historyGzipFileName)
+		if err := appendHistoryGzip(path, &recs[i], defaultHistoryMaxMB<<20); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := os.ReadFile(path)
+		full = append(full, data...)
+		sizes = append(sizes, len(data))
+	}
+	var badJSON strings.Builder
+	zw := gzip.NewWriter(&badJSON)
+	zw.Write([]byte(`{"command": "run1.exe"}` + "\n" + `{"command": ` + "\n"))
+	zw.Close()
+
+	tests := []struct {
+		name    string
+		data    string
+		want    []runRecord
+		wantErr bool
+	}{
+		{"empty file", "", nil, false},
+		{"two members", string(full), recs, false},
+		{"last member cut off", string(full[:sizes[0]+sizes[1]/2]), recs[:1], false}, // 关机时写了一半
+		{"not gzip", `{"command": "run1.exe"}`, nil, true},
+		{"invalid JSON line", badJSON.String(), nil, true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), historyGzipFileName)
+		if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readHistoryFile(path)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: readHistoryFile = %+v, %v, want %+v, error %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	if got, err := readHistoryGzip(filepath.Join(t.TempDir(), historyGzipFileName)); err != nil || got != nil {
+		t.Errorf("readHistoryGzip without files = %+v, %v", got, err)
+	}
+}
+
+func TestPrintHistoryGzip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), historyGzipFileName)
+	recs := historyRecords(5)
+	for i := range recs {
+		if err := appendHistoryGzip(path, &recs[i], defaultHistoryMaxMB<<20); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var err error
+	out := captureStdout(t, func() { err = printHistoryGzip(path, 3) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "ID") {
+		t.Fatalf("printHistoryGzip(3) printed:\n%s", out)
+	}
+	// 最新的在前
+	for i, want := range []string{"run5.exe", "run4.exe", "run3.exe"} {
+		if f := strings.Fields(lines[i+1]); f[0] != fmt.Sprint(5-i) || f[len(f)-1] != want {
+			t.Errorf("line %d = %q, want id %d and %s", i+1, lines[i+1], 5-i, want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	moduser32            = windows.NewLazySystemDLL("user32.dll")
+	procGetLastInputInfo = moduser32.NewProc("GetLastInputInfo")
+
+	modwtsapi32                     = windows.NewLazySystemDLL("wtsapi32.dll")
+	procWTSQuerySessionInformationW = modwtsapi32.NewProc("WTSQuerySessionInformationW")
+)
+
+const (
+	wtsSessionInfo   = 24         // WTS_INFO_CLASS 中的 WTSSessionInfo
+	noConsoleSession = 0xFFFFFFFF // WTSGetActiveConsoleSessionId：没有会话连接到控制台
+)
+
+// -------------------- 空闲时间 --------------------
+
+// lastInputInfo 对应 LASTINPUTINFO
+type lastInputInfo struct {
+	CbSize uint32
+	DwTime uint32 // 最后一次输入时的 GetTickCount 值
+}
+
+// wtsInfo 对应 WTSINFOW。时间是 FILETIME 格式的 LARGE_INTEGER，按 8 字节对齐；
+// 用两个 uint32 表示并显式补齐，在 386 上布局也与 C 相同。
+type wtsInfo struct {
+	State                   uint32
+	SessionID               uint32
+	IncomingBytes           uint32
+	OutgoingBytes           uint32
+	IncomingFrames          uint32
+	OutgoingFrames          uint32
+	IncomingCompressedBytes uint32
+	OutgoingCompressedBytes uint32
+	WinStationName          [32]uint16
+	Domain                  [17]uint16
+	UserName                [21]uint16
+	_                       uint32
+	ConnectTime             windows.Filetime
+	DisconnectTime          windows.Filetime
+	LastInputTime           windows.Filetime
+	LogonTime               windows.Filetime
+	CurrentTime             windows.Filetime
+}
+
+// getIdleTime 返回 WinPSP 自己的会话中距最后一次键盘或鼠标输入的时间，
+// 只用于交互模式（服务在会话 0 中，那里没有用户输入）。可在调试时替换
+var getIdleTime = func() (time.Duration, error) {
+	info := lastInputInfo{CbSize: uint32(unsafe.Sizeof(lastInputInfo{}))}
+	r, _, err := procGetLastInputInfo.Call(uintptr(unsafe.Pointer(&info)))
+	if r == 0 {
+		return 0, err
+	}
+	// GetTickCount 是 32 位的，约 49.7 天回绕一次，无符号减法照样得到正确的差值
+	now := uint32(windows.DurationSinceBoot().Milliseconds())
+	return time.Duration(now-info.DwTime) * time.Millisecond, nil
+}
+
+// getConsoleIdleTime 返回控制台会话（本机登录的用户）距最后一次输入的时间，
+// 由终端服务按会话记录，服务模式使用。控制台没有用户登录时返回自开机以来的时间。可在调试时替换
+var getConsoleIdleTime = func() (time.Duration, error) {
+	session := windows.WTSGetActiveConsoleSessionId()
+	if session == noConsoleSession {
+		return windows.DurationSinceBoot(), nil
+	}
+
+	var buf *wtsInfo
+	var n uint32
+	r, _, err := procWTSQuerySessionInformationW.Call(0, // WTS_CURRENT_SERVER_HANDLE
+		uintptr(session), wtsSessionInfo, uintptr(unsafe.Pointer(&buf)), uintptr(unsafe.Pointer(&n)))
+	if r == 0 {
+		return 0, fmt.Errorf("WTSQuerySessionInformation: %w", err)
+	}
+	defer windows.WTSFreeMemory(uintptr(unsafe.Pointer(buf)))
+	if n < uint32(unsafe.Sizeof(wtsInfo{})) {
+		return 0, errors.New("WTSQuerySessionInformation: short WTSINFO")
+	}
+
+	if buf.UserName[0] == 0 {
+		return windows.DurationSinceBoot(), nil
+	}
+	last, now := buf.LastInputTime.Nanoseconds(), buf.CurrentTime.Nanoseconds()
+	if buf.LastInputTime == (windows.Filetime{}) || now < last {
+		return 0, errors.New("last input time not reported for the console session")
+	}
+	return time.Duration(now - last), nil
+}
+
+// idleSkipReason 检查 idle_min_minutes，返回空字符串表示照常执行。
+// 有破坏性的命令（如清空临时目录）只应在无人使用时的关机中执行，
+// 而不是用户刚刚还在操作时点的重启。无法取得空闲时间时照常执行。
+// interactive 为 false（服务）时查询控制台会话，而不是服务所在的会话 0。
+func idleSkipReason(cfg *Config, interactive bool, logf func(format string, args ...any)) string {
+	if cfg.IdleMinMinutes <= 0 {
+		return ""
+	}
+	idleTime := getConsoleIdleTime
+	if interactive {
+		idleTime = getIdleTime
+	}
+	idle, err := idleTime()
+	if err != nil {
+		logf("Warning: idle time unknown, running the command: %v", err)
+		return ""
+	}
+	logf("System idle for %d minute(s)", int(idle.Minutes()))
+	if idle < time.Duration(cfg.IdleMinMinutes)*time.Minute {
+		return fmt.Sprintf("system was idle for only %d minute(s), idle_min_minutes is %d", int(idle.Minutes()), cfg.IdleMinMinutes)
+	}
+	return ""
+}
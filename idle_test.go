@@ -0,0 +1,87 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// mockIdleTime 让 getIdleTime（交互模式）和 getConsoleIdleTime（服务模式）返回
+// 不同的值，测试可以看出用的是哪一个
+func mockIdleTime(t *testing.T, session, console time.Duration, err error) {
+	t.Helper()
+	origIdle, origConsole := getIdleTime, getConsoleIdleTime
+	getIdleTime = func() (time.Duration, error) { return session, err }
+	getConsoleIdleTime = func() (time.Duration, error) { return console, err }
+	t.Cleanup(func() { getIdleTime, getConsoleIdleTime = origIdle, origConsole })
+}
+
+func TestIdleSkipReason(t *testing.T) {
+	tests := []struct {
+		name        string
+		minMinutes  int
+		interactive bool
+		session     time.Duration
+		console     time.Duration
+		err         error
+		want        string
+		wantLog     string
+	}{
+		{"disabled", 0, false, 0, 0, nil, "", ""},
+		{"service, idle long enough", 10, false, 0, 15 * time.Minute, nil, "", "System idle for 15 minute(s)"},
+		{"service, recent input", 10, false, time.Hour, 3*time.Minute + 59*time.Second, nil,
+			"system was idle for only 3 minute(s), idle_min_minutes is 10", "System idle for 3 minute(s)"},
+		{"service, exactly the minimum", 10, false, 0, 10 * time.Minute, nil, "", "System idle for 10 minute(s)"},
+		{"interactive, recent input", 10, true, time.Minute, time.Hour, nil,
+			"system was idle for only 1 minute(s), idle_min_minutes is 10", "System idle for 1 minute(s)"},
+		{"interactive, idle long enough", 10, true, time.Hour, 0, nil, "", "System idle for 60 minute(s)"},
+		{"unknown idle time", 10, false, 0, 0, errors.New("Access is denied."), "", "Warning: idle time unknown, running the command: Access is denied."},
+	}
+	for _, tt := range tests {
+		mockIdleTime(t, tt.session, tt.console, tt.err)
+		var logged []string
+		logf := func(format string, args ...any) { logged = append(logged, fmt.Sprintf(format, args...)) }
+
+		got := idleSkipReason(&Config{IdleMinMinutes: tt.minMinutes}, tt.interactive, logf)
+		if got != tt.want {
+			t.Errorf("%s: idleSkipReason = %q, want %q", tt.name, got, tt.want)
+		}
+		if log := strings.Join(logged, "\n"); log != tt.wantLog {
+			t.Errorf("%s: logged %q, want %q", tt.name, log, tt.wantLog)
+		}
+	}
+}
+
+func TestGetIdleTime(t *testing.T) {
+	idle, err := getIdleTime()
+	if err != nil {
+		t.Fatalf("getIdleTime: %v", err)
+	}
+	if up := windows.DurationSinceBoot(); idle < 0 || idle > up+time.Second {
+		t.Errorf("getIdleTime = %s, uptime %s", idle, up)
+	}
+
+	// 没有用户登录到控制台的机器（如 CI）上返回开机以来的时间
+	if idle, err := getConsoleIdleTime(); err != nil {
+		t.Logf("getConsoleIdleTime: %v", err)
+	} else if idle < 0 || idle > windows.DurationSinceBoot()+time.Second {
+		t.Errorf("getConsoleIdleTime = %s, uptime %s", idle, windows.DurationSinceBoot())
+	}
+}
+
+func TestWTSInfoLayout(t *testing.T) {
+	// 与 C 的 WTSINFOW 相同：LastInputTime 在偏移 192，总大小 216（32 位和 64 位相同）
+	if off := unsafe.Offsetof(wtsInfo{}.LastInputTime); off != 192 {
+		t.Errorf("LastInputTime at offset %d, want 192", off)
+	}
+	if size := unsafe.Sizeof(wtsInfo{}); size != 216 {
+		t.Errorf("sizeof(WTSINFOW) = %d, want 216", size)
+	}
+}
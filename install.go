@@ -0,0 +1,480 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const serviceDescription = "Windows Pre-Shutdown Processor"
+
+const serviceControlTimeout = 30 * time.Second
+
+// -------------------- 服务实例 --------------------
+
+// 一台机器可以安装多个 WinPSP 实例，每个实例有自己的服务名、配置和日志目录。
+// 默认实例名为 WinPSP，目录为 C:\ProgramData\WinPSP\；
+// 其它实例的目录为 C:\ProgramData\WinPSP-<NAME>\。
+
+// instanceConfigPath 返回实例的默认配置文件路径
+func instanceConfigPath(name string) string {
+	dir := defaultServiceName
+	if !strings.EqualFold(name, defaultServiceName) {
+		dir = defaultServiceName + "-" + name
+	}
+	return filepath.Join(programDataDir, dir, "config.json")
+}
+
+// instanceDisplayName 返回实例在服务管理器中的显示名
+func instanceDisplayName(name string) string {
+	if strings.EqualFold(name, defaultServiceName) {
+		return defaultServiceName
+	}
+	return defaultServiceName + " (" + name + ")"
+}
+
+func validateServiceName(name string) error {
+	if name == "" || strings.ContainsAny(name, `\/:*?"<>|`) || len(name) > 200 {
+		return fmt.Errorf("invalid service name: %q", name)
+	}
+	return nil
+}
+
+// -------------------- 安装 / 卸载 / 控制 --------------------
+
+// installService 创建服务。args 追加到 binPath，让服务启动时使用相同的实例参数。
+// 指定了触发器时服务改为按需启动，由触发条件启动（见 trigger.go）。
+// deps 是依赖的服务：Windows 先启动它们，关机时在 WinPSP 之后才停止。
+// eventSource 为 true 时同时注册同名的事件来源。
+func installService(name string, args []string, triggers []serviceTriggerSpec, deps []string, eventSource bool) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", name)
+	}
+
+	c, args := newServiceConfig(name, args, triggers, deps)
+	s, err := m.CreateService(name, exe, c, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if len(triggers) > 0 {
+		if err := setServiceTriggers(s.Handle, triggers); err != nil {
+			s.Delete()
+			return fmt.Errorf("register service triggers: %w", err)
+		}
+	}
+
+	if eventSource {
+		if err := registerEventSource(name, exe); err != nil {
+			s.Delete()
+			return fmt.Errorf("register event source: %w", err)
+		}
+	}
+
+	fmt.Printf("Service %s installed.\n", name)
+	for _, t := range triggers {
+		fmt.Printf("Trigger start: %s\n", t)
+	}
+	if len(deps) > 0 {
+		fmt.Printf("Depends on: %s\n", strings.Join(deps, ", "))
+	}
+	if eventSource {
+		fmt.Printf("Event Log source registered: %s\n", name)
+	}
+	fmt.Printf("Config file: %s\n", instanceConfigPath(name))
+	return nil
+}
+
+// newServiceConfig 返回 CreateService 使用的服务配置和 binPath 参数
+func newServiceConfig(name string, args []string, triggers []serviceTriggerSpec, deps []string) (mgr.Config, []string) {
+	if !strings.EqualFold(name, defaultServiceName) {
+		args = append([]string{"--service-name", name}, args...)
+	}
+
+	startType := uint32(mgr.StartAutomatic)
+	if len(triggers) > 0 {
+		startType = mgr.StartManual
+	}
+
+	return mgr.Config{
+		StartType:        startType,
+		DisplayName:      instanceDisplayName(name),
+		Description:      serviceDescription,
+		ServiceStartName: "LocalSystem",
+		Dependencies:     deps,
+	}, args
+}
+
+// installDependencies 返回安装时声明的依赖：--depends-on 优先，
+// 未指定时使用配置中的 service_dependencies
+func installDependencies(dependsOn, configPath string) []string {
+	if deps := parseDependencies(dependsOn); deps != nil {
+		return deps
+	}
+	return configDependencies(configPath)
+}
+
+// parseDependencies 解析 --depends-on 的逗号分隔列表，忽略空项
+func parseDependencies(v string) []string {
+	var deps []string
+	for _, d := range strings.Split(v, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			deps = append(deps, d)
+		}
+	}
+	return deps
+}
+
+// configDependencies 返回配置中的 service_dependencies，配置无法加载时返回 nil
+func configDependencies(configPath string) []string {
+	s := &winpspService{configPath: configPath}
+	if err := s.loadConfig(); err != nil {
+		return nil
+	}
+	return s.config.Load().ServiceDependencies
+}
+
+// uninstallService 停止（如在运行）并删除服务
+func uninstallService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", name)
+	}
+	defer s.Close()
+
+	if st, err := s.Query(); err == nil && st.State != svc.Stopped {
+		_ = controlAndWait(s, svc.Stop, svc.Stopped)
+	}
+
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	if err := unregisterEventSource(name); err != nil {
+		fmt.Printf("Warning: Event Log source not removed: %v\n", err)
+	}
+	fmt.Printf("Service %s removed.\n", name)
+	return nil
+}
+
+func startService(name string) error {
+	return withService(name, func(s *mgr.Service) error {
+		if err := s.Start(); err != nil {
+			return err
+		}
+		return waitForState(s, svc.Running)
+	})
+}
+
+func stopService(name string) error {
+	return withService(name, func(s *mgr.Service) error {
+		return controlAndWait(s, svc.Stop, svc.Stopped)
+	})
+}
+
+// reloadService 让正在运行的服务重新读取配置（SERVICE_CONTROL_PARAMCHANGE）
+func reloadService(name string) error {
+	return withService(name, func(s *mgr.Service) error {
+		_, err := s.Control(svc.ParamChange)
+		return err
+	})
+}
+
+func printServiceStatus(name string) error {
+	return withService(name, func(s *mgr.Service) error {
+		st, err := s.Query()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %s\n", name, stateString(st.State))
+		return nil
+	})
+}
+
+func withService(name string, fn func(s *mgr.Service) error) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		if errors.Is(err, windows.ERROR_SERVICE_DOES_NOT_EXIST) {
+			return fmt.Errorf("service %s is not installed", name)
+		}
+		return err
+	}
+	defer s.Close()
+
+	return fn(s)
+}
+
+func controlAndWait(s *mgr.Service, c svc.Cmd, want svc.State) error {
+	if _, err := s.Control(c); err != nil {
+		return err
+	}
+	return waitForState(s, want)
+}
+
+func waitForState(s *mgr.Service, want svc.State) error {
+	deadline := time.Now().Add(serviceControlTimeout)
+	for {
+		st, err := s.Query()
+		if err != nil {
+			return err
+		}
+		if st.State == want {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for service %s (state: %s)", s.Name, stateString(st.State))
+		}
+		time.Sleep(300 * time.Millisecond)
+	}
+}
+
+func stateString(st svc.State) string {
+	switch st {
+	case svc.Stopped:
+		return "stopped"
+	case svc.StartPending:
+		return "start pending"
+	case svc.StopPending:
+		return "stop pending"
+	case svc.Running:
+		return "running"
+	case svc.ContinuePending:
+		return "continue pending"
+	case svc.PausePending:
+		return "pause pending"
+	case svc.Paused:
+		return "paused"
+	default:
+		return fmt.Sprintf("unknown (%d)", st)
+	}
+}
+
+// -------------------- 实例列表 --------------------
+
+type instanceInfo struct {
+	Name        string
+	DisplayName string
+	State       string
+	ConfigPath  string
+}
+
+// isWinPSPService 判断一个服务是否是 WinPSP 实例
+func isWinPSPService(displayName, binaryPath string) bool {
+	return strings.HasPrefix(strings.ToLower(displayName), strings.ToLower(defaultServiceName)) ||
+		strings.Contains(strings.ToLower(binaryPath), "winpsp")
+}
+
+// instanceConfigFromBinaryPath 从服务命令行推断实例使用的配置文件
+func instanceConfigFromBinaryPath(name, binaryPath string) string {
+	args, err := splitCommandLine(binaryPath)
+	if err != nil {
+		return instanceConfigPath(name)
+	}
+	for i := 1; i+1 < len(args); i++ {
+		switch args[i] {
+		case "--config", "-config":
+			return args[i+1]
+		case "--config-url", "-config-url":
+			return args[i+1]
+		}
+	}
+	return instanceConfigPath(name)
+}
+
+// scmService 是服务管理器中一个服务的名字、配置和状态
+type scmService struct {
+	Name   string
+	Config mgr.Config
+	State  string
+}
+
+// listSCMServices 枚举服务管理器中的所有服务，可在调试时替换
+var listSCMServices = func() ([]scmService, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, err
+	}
+	defer m.Disconnect()
+
+	names, err := m.ListServices()
+	if err != nil {
+		return nil, err
+	}
+
+	var list []scmService
+	for _, name := range names {
+		s, err := m.OpenService(name)
+		if err != nil {
+			continue
+		}
+		cfg, err := s.Config()
+		if err != nil {
+			s.Close()
+			continue
+		}
+
+		state := "unknown"
+		if st, err := s.Query(); err == nil {
+			state = stateString(st.State)
+		}
+		s.Close()
+
+		list = append(list, scmService{Name: name, Config: cfg, State: state})
+	}
+	return list, nil
+}
+
+// listInstances 枚举服务管理器中所有的 WinPSP 实例
+func listInstances() ([]instanceInfo, error) {
+	services, err := listSCMServices()
+	if err != nil {
+		return nil, err
+	}
+
+	var list []instanceInfo
+	for _, s := range services {
+		if !isWinPSPService(s.Config.DisplayName, s.Config.BinaryPathName) {
+			continue
+		}
+		list = append(list, instanceInfo{
+			Name:        s.Name,
+			DisplayName: s.Config.DisplayName,
+			State:       s.State,
+			ConfigPath:  instanceConfigFromBinaryPath(s.Name, s.Config.BinaryPathName),
+		})
+	}
+	return list, nil
+}
+
+func printInstances() error {
+	list, err := listInstances()
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		fmt.Println("No WinPSP instances installed.")
+		return nil
+	}
+
+	fmt.Printf("%-20s %-28s %-16s %s\n", "NAME", "DISPLAY NAME", "STATE", "CONFIG")
+	for _, in := range list {
+		fmt.Printf("%-20s %-28s %-16s %s\n", in.Name, in.DisplayName, in.State, in.ConfigPath)
+	}
+	return nil
+}
+
+// -------------------- 安装后检查 --------------------
+
+// --install 的步骤，可在调试时替换
+var (
+	createServiceStep = installService
+	verifyInstallStep = verifyInstall
+)
+
+// installAndVerify 实现 --install：创建服务，storeToken 时保存 webhook 令牌，
+// verify 时再检查服务将使用的配置 configPath。检查失败只打印警告，不影响返回值。
+func installAndVerify(name string, args []string, triggers []serviceTriggerSpec, deps []string, eventSource, storeToken, verify bool, configPath string) error {
+	if err := createServiceStep(name, args, triggers, deps, eventSource); err != nil {
+		return err
+	}
+	if storeToken {
+		if err := storeWebhookToken(configPath); err != nil {
+			return err
+		}
+	}
+	if verify {
+		verifyInstallStep(configPath)
+	}
+	return nil
+}
+
+// verifyInstall 实现 --install --verify：安装后立即检查服务将使用的配置，
+// 以及其中的命令能否找到，把配置错误暴露在部署时而不是下次关机时。
+// 只打印警告，不回滚安装。
+func verifyInstall(configPath string) bool {
+	fmt.Printf("Verifying config: %s\n", configPath)
+
+	s := &winpspService{configPath: configPath}
+	if err := s.loadConfig(); err != nil {
+		if configAbsent(err) {
+			fmt.Println("Warning: no config file or no command yet; nothing will run at shutdown.")
+		} else {
+			fmt.Printf("Warning: config error: %v\n", err)
+		}
+		return false
+	}
+
+	ok := true
+	entries := s.config.Load().commandEntries()
+	for i := range entries {
+		if entries[i].wmi {
+			continue
+		}
+		if err := commandReachable(entries[i].Command, s.commandBaseDir()); err != nil {
+			fmt.Printf("Warning: %s: %v\n", entries[i].displayName(), err)
+			ok = false
+		}
+	}
+	if ok {
+		fmt.Println("Verify: OK")
+	}
+	return ok
+}
+
+// commandReachable 检查命令的可执行文件是否存在，路径规则与执行时相同
+// （不含路径分隔符的名字按当前进程的 PATH 查找）
+func commandReachable(command, baseDir string) error {
+	_, err := commandExecutable(command, baseDir)
+	return err
+}
+
+// commandExecutable 返回命令的可执行文件的路径，规则同 commandReachable
+func commandExecutable(command, baseDir string) (string, error) {
+	parts, err := splitCommandLine(command)
+	if err != nil {
+		return "", err
+	}
+	if len(parts) == 0 {
+		return "", errors.New("empty command line")
+	}
+
+	exe := parts[0]
+	if !filepath.IsAbs(exe) && strings.ContainsAny(exe, `\/`) {
+		exe = filepath.Join(baseDir, exe)
+	}
+	return exec.LookPath(exe)
+}
@@ -0,0 +1,364 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+func TestInstanceNames(t *testing.T) {
+	tests := []struct {
+		name, wantConfig, wantDisplay string
+	}{
+		{"WinPSP", `C:\ProgramData\WinPSP\config.json`, "WinPSP"},
+		{"winpsp", `C:\ProgramData\WinPSP\config.json`, "WinPSP"},
+		{"Oracle", `C:\ProgramData\WinPSP-Oracle\config.json`, "WinPSP (Oracle)"},
+		{"Postgres", `C:\ProgramData\WinPSP-Postgres\config.json`, "WinPSP (Postgres)"},
+	}
+	for _, tt := range tests {
+		if got := instanceConfigPath(tt.name); got != tt.wantConfig {
+			t.Errorf("instanceConfigPath(%q) = %q, want %q", tt.name, got, tt.wantConfig)
+		}
+		if got := instanceDisplayName(tt.name); got != tt.wantDisplay {
+			t.Errorf("instanceDisplayName(%q) = %q, want %q", tt.name, got, tt.wantDisplay)
+		}
+	}
+}
+
+func TestValidateServiceName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"WinPSP", false},
+		{"Oracle-19c", false},
+		{"DB 2", false},
+		{"", true},
+		{`a\b`, true},
+		{"a/b", true},
+		{"C:", true},
+		{"db*", true},
+		{`"db"`, true},
+		{"a|b", true},
+		{strings.Repeat("x", 200), false},
+		{strings.Repeat("x", 201), true},
+	}
+	for _, tt := range tests {
+		if err := validateServiceName(tt.name); (err != nil) != tt.wantErr {
+			t.Errorf("validateServiceName(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseDependencies(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{" , ", nil},
+		{"MSSQLSERVER", []string{"MSSQLSERVER"}},
+		{"MSSQLSERVER, SQLSERVERAGENT,", []string{"MSSQLSERVER", "SQLSERVERAGENT"}},
+	}
+	for _, tt := range tests {
+		got := parseDependencies(tt.in)
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) || (got == nil) != (tt.want == nil) {
+			t.Errorf("parseDependencies(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNewServiceConfig(t *testing.T) {
+	tests := []struct {
+		name          string
+		args          []string
+		triggers      []serviceTriggerSpec
+		deps          []string
+		wantArgs      []string
+		wantStartType uint32
+	}{
+		{"WinPSP", nil, nil, nil, nil, mgr.StartAutomatic},
+		{"WinPSP", []string{"--config", `D:\winpsp.json`}, nil, []string{"MSSQLSERVER"},
+			[]string{"--config", `D:\winpsp.json`}, mgr.StartAutomatic},
+		{"Oracle", []string{"--profile", "db"}, nil, []string{"OracleServiceORCL", "OracleOraDB19Home1TNSListener"},
+			[]string{"--service-name", "Oracle", "--profile", "db"}, mgr.StartAutomatic},
+		{"WinPSP", nil, []serviceTriggerSpec{{Type: 1}}, nil, nil, mgr.StartManual},
+	}
+	for _, tt := range tests {
+		c, args := newServiceConfig(tt.name, tt.args, tt.triggers, tt.deps)
+		if fmt.Sprint(args) != fmt.Sprint(tt.wantArgs) {
+			t.Errorf("%s %q: args %q, want %q", tt.name, tt.args, args, tt.wantArgs)
+		}
+		if c.StartType != tt.wantStartType || c.DisplayName != instanceDisplayName(tt.name) ||
+			c.ServiceStartName != "LocalSystem" || fmt.Sprint(c.Dependencies) != fmt.Sprint(tt.deps) {
+			t.Errorf("%s: service config %+v", tt.name, c)
+		}
+	}
+}
+
+func TestInstallDependencies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	tests := []struct {
+		dependsOn string
+		config    string // 空表示没有配置文件
+		want      []string
+	}{
+		{"", "", nil},
+		{"MSSQLSERVER, SQLSERVERAGENT", "", []string{"MSSQLSERVER", "SQLSERVERAGENT"}},
+		{"", `{"command": "backup.exe", "service_dependencies": ["postgresql-x64-16"]}`, []string{"postgresql-x64-16"}},
+		// --depends-on 优先于配置
+		{"MSSQLSERVER", `{"command": "backup.exe", "service_dependencies": ["postgresql-x64-16"]}`, []string{"MSSQLSERVER"}},
+		{"", `{"command": `, nil},
+	}
+	for _, tt := range tests {
+		os.Remove(path)
+		if tt.config != "" {
+			if err := os.WriteFile(path, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := installDependencies(tt.dependsOn, path); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("installDependencies(%q, %s) = %q, want %q", tt.dependsOn, tt.config, got, tt.want)
+		}
+	}
+}
+
+// TestInstallTwoInstances 在服务管理器中同时安装两个实例，需要管理员权限
+func TestInstallTwoInstances(t *testing.T) {
+	if !windows.GetCurrentProcessToken().IsElevated() {
+		t.Skip("installing services requires an elevated process")
+	}
+	names := []string{"WinPSPTest-A", "WinPSPTest-B"}
+	for _, name := range names {
+		args := []string{"--config", instanceConfigPath(name)}
+		if err := installService(name, args, nil, nil, false); err != nil {
+			t.Fatalf("installService(%s): %v", name, err)
+		}
+		t.Cleanup(func() { uninstallService(name) })
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Disconnect()
+	for _, name := range names {
+		s, err := m.OpenService(name)
+		if err != nil {
+			t.Fatalf("%s not installed: %v", name, err)
+		}
+		cfg, err := s.Config()
+		s.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.DisplayName != instanceDisplayName(name) {
+			t.Errorf("%s display name %q", name, cfg.DisplayName)
+		}
+		// 服务启动时用 --service-name 找回自己的实例名和配置
+		if !strings.Contains(cfg.BinaryPathName, "--service-name "+name) {
+			t.Errorf("%s binary path %q lacks --service-name", name, cfg.BinaryPathName)
+		}
+		if got := instanceConfigFromBinaryPath(name, cfg.BinaryPathName); got != instanceConfigPath(name) {
+			t.Errorf("%s config from binary path = %q, want %q", name, got, instanceConfigPath(name))
+		}
+		if len(cfg.Dependencies) != 0 {
+			t.Errorf("%s dependencies %q, want none", name, cfg.Dependencies)
+		}
+	}
+
+	// 同名实例不能重复安装
+	if err := installService(names[0], nil, nil, nil, false); err == nil {
+		t.Errorf("installService(%s) succeeded twice", names[0])
+	}
+}
+
+// TestInstallWithDependencies 检查服务管理器中记录的依赖，需要管理员权限
+func TestInstallWithDependencies(t *testing.T) {
+	if !windows.GetCurrentProcessToken().IsElevated() {
+		t.Skip("installing services requires an elevated process")
+	}
+	const name = "WinPSPTest-Deps"
+	deps := []string{"EventLog", "Winmgmt"}
+	if err := installService(name, nil, nil, deps, false); err != nil {
+		t.Fatalf("installService: %v", err)
+	}
+	t.Cleanup(func() { uninstallService(name) })
+
+	var got []string
+	err := withService(name, func(s *mgr.Service) error {
+		cfg, err := s.Config()
+		got = cfg.Dependencies
+		return err
+	})
+	if err != nil || fmt.Sprint(got) != fmt.Sprint(deps) {
+		t.Errorf("dependencies = %q, %v, want %q", got, err, deps)
+	}
+}
+
+func TestIsWinPSPService(t *testing.T) {
+	tests := []struct {
+		displayName, binaryPath string
+		want                    bool
+	}{
+		{"WinPSP", `"C:\Program Files\WinPSP\winpsp.exe"`, true},
+		{"WinPSP (Oracle)", `C:\Tools\psp.exe --service-name Oracle`, true},
+		{"winpsp", `C:\Tools\psp.exe`, true},
+		{"Pre-shutdown", `"C:\Program Files\WinPSP\WinPSP.exe" --service-name x`, true},
+		{"Windows Update", `C:\Windows\system32\svchost.exe -k netsvcs -p`, false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if got := isWinPSPService(tt.displayName, tt.binaryPath); got != tt.want {
+			t.Errorf("isWinPSPService(%q, %q) = %v, want %v", tt.displayName, tt.binaryPath, got, tt.want)
+		}
+	}
+}
+
+func TestInstanceConfigFromBinaryPath(t *testing.T) {
+	const exe = `"C:\Program Files\WinPSP\winpsp.exe"`
+	tests := []struct {
+		name, binaryPath, want string
+	}{
+		{"WinPSP", exe, `C:\ProgramData\WinPSP\config.json`},
+		{"Oracle", exe + ` --service-name Oracle`, `C:\ProgramData\WinPSP-Oracle\config.json`},
+		{"Oracle", exe + ` --service-name Oracle --config "D:\WinPSP Configs\oracle.json"`, `D:\WinPSP Configs\oracle.json`},
+		{"WinPSP", exe + ` -config C:\cfg\winpsp.json`, `C:\cfg\winpsp.json`},
+		{"WinPSP", exe + ` --config-url https://config.example.com/winpsp.json`, "https://config.example.com/winpsp.json"},
+		{"WinPSP", exe + ` --config`, `C:\ProgramData\WinPSP\config.json`},
+		{"Oracle", `"C:\unterminated --config x`, `C:\ProgramData\WinPSP-Oracle\config.json`},
+	}
+	for _, tt := range tests {
+		if got := instanceConfigFromBinaryPath(tt.name, tt.binaryPath); got != tt.want {
+			t.Errorf("instanceConfigFromBinaryPath(%q, %q) = %q, want %q", tt.name, tt.binaryPath, got, tt.want)
+		}
+	}
+}
+
+// mockSCMServices 让 listInstances 看到 services，而不是本机的服务管理器
+func mockSCMServices(t *testing.T, services []scmService, err error) {
+	t.Helper()
+	orig := listSCMServices
+	listSCMServices = func() ([]scmService, error) { return services, err }
+	t.Cleanup(func() { listSCMServices = orig })
+}
+
+func TestListInstances(t *testing.T) {
+	const exe = `"C:\Program Files\WinPSP\winpsp.exe"`
+	mockSCMServices(t, []scmService{
+		{Name: "wuauserv", Config: mgr.Config{DisplayName: "Windows Update", BinaryPathName: `C:\Windows\system32\svchost.exe -k netsvcs -p`}, State: "running"},
+		{Name: "WinPSP", Config: mgr.Config{DisplayName: "WinPSP", BinaryPathName: exe}, State: "running"},
+		{Name: "Oracle", Config: mgr.Config{DisplayName: "WinPSP (Oracle)", BinaryPathName: exe + ` --service-name Oracle --config D:\oracle.json`}, State: "stopped"},
+		{Name: "Spooler", Config: mgr.Config{DisplayName: "Print Spooler", BinaryPathName: `C:\Windows\System32\spoolsv.exe`}, State: "running"},
+	}, nil)
+
+	list, err := listInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []instanceInfo{
+		{"WinPSP", "WinPSP", "running", `C:\ProgramData\WinPSP\config.json`},
+		{"Oracle", "WinPSP (Oracle)", "stopped", `D:\oracle.json`},
+	}
+	if fmt.Sprint(list) != fmt.Sprint(want) {
+		t.Errorf("listInstances = %+v, want %+v", list, want)
+	}
+
+	out := captureStdout(t, func() { err = printInstances() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "NAME") || !strings.HasPrefix(lines[2], "Oracle ") ||
+		!strings.HasSuffix(lines[2], `D:\oracle.json`) {
+		t.Errorf("printInstances printed:\n%s", out)
+	}
+}
+
+func TestListInstancesNone(t *testing.T) {
+	mockSCMServices(t, []scmService{
+		{Name: "Spooler", Config: mgr.Config{DisplayName: "Print Spooler", BinaryPathName: `C:\Windows\System32\spoolsv.exe`}, State: "running"},
+	}, nil)
+	var err error
+	out := captureStdout(t, func() { err = printInstances() })
+	if err != nil || out != "No WinPSP instances installed.\n" {
+		t.Errorf("printInstances = %q, %v", out, err)
+	}
+
+	mockSCMServices(t, nil, windows.ERROR_ACCESS_DENIED)
+	if _, err := listInstances(); err == nil {
+		t.Error("listInstances succeeded although the SCM could not be queried")
+	}
+}
+
+func TestInstallAndVerify(t *testing.T) {
+	tests := []struct {
+		name       string
+		installErr error
+		verify     bool
+		wantVerify bool
+	}{
+		{"verify", nil, true, true},
+		{"no verify", nil, false, false},
+		{"install failed", errors.New("Access is denied."), true, false},
+	}
+	for _, tt := range tests {
+		origCreate, origVerify := createServiceStep, verifyInstallStep
+		var installed, verified string
+		createServiceStep = func(name string, args []string, triggers []serviceTriggerSpec, deps []string, eventSource bool) error {
+			installed = name
+			return tt.installErr
+		}
+		verifyInstallStep = func(configPath string) bool {
+			verified = configPath
+			return false // 检查失败只是警告
+		}
+
+		const configPath = `C:\ProgramData\WinPSP-Oracle\config.json`
+		err := installAndVerify("Oracle", nil, nil, nil, false, false, tt.verify, configPath)
+		createServiceStep, verifyInstallStep = origCreate, origVerify
+
+		if err != tt.installErr || installed != "Oracle" {
+			t.Errorf("%s: installAndVerify = %v, installed %q", tt.name, err, installed)
+		}
+		if (verified != "") != tt.wantVerify || (tt.wantVerify && verified != configPath) {
+			t.Errorf("%s: verified %q, want verify %v", tt.name, verified, tt.wantVerify)
+		}
+	}
+}
+
+func TestVerifyInstall(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string // 空表示没有配置文件
+		want   bool
+		output string
+	}{
+		{"valid", `{"command": "cmd.exe /c exit 0"}`, true, "Verify: OK"},
+		{"no config", "", false, "no config file"},
+		{"invalid config", `{"command": `, false, "config error"},
+		{"command not found", `{"command": "C:\\WinPSP-missing\\backup.exe /full"}`, false, "Warning: backup.exe:"},
+		{"second command not found", `{"commands": [{"command": "cmd.exe /c exit 0"}, {"name": "sync", "command": "winpsp-missing-tool.exe"}]}`, false, "Warning: sync:"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "config.json")
+		if tt.config != "" {
+			if err := os.WriteFile(path, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var ok bool
+		out := captureStdout(t, func() { ok = verifyInstall(path) })
+		if ok != tt.want || !strings.Contains(out, tt.output) {
+			t.Errorf("%s: verifyInstall = %v, want %v, output containing %q:\n%s", tt.name, ok, tt.want, tt.output, out)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+//go:build windows
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	integrityLow    = "low"
+	integrityMedium = "medium"
+	integrityHigh   = "high"
+	integritySystem = "system"
+)
+
+// -------------------- 完整性级别 --------------------
+
+// integrity_level 为 low / medium 时，复制服务自身的令牌，把强制标签降到对应级别，
+// 再用它创建命令进程（CreateProcessAsUser），命令无法写入更高完整性的对象。
+// 令牌只能降级不能升级：high / system 不做任何修改，命令与服务同级运行。
+
+// lowerTokenIntegrity 返回降到指定 SID 对应级别的主令牌副本，可在调试时替换
+var lowerTokenIntegrity = func(label windows.WELL_KNOWN_SID_TYPE) (windows.Token, error) {
+	self, err := windows.OpenCurrentProcessToken()
+	if err != nil {
+		return 0, err
+	}
+	defer self.Close()
+
+	var token windows.Token
+	if err := windows.DuplicateTokenEx(self, windows.MAXIMUM_ALLOWED, nil,
+		windows.SecurityImpersonation, windows.TokenPrimary, &token); err != nil {
+		return 0, err
+	}
+
+	sid, err := windows.CreateWellKnownSid(label)
+	if err != nil {
+		token.Close()
+		return 0, err
+	}
+	tml := windows.Tokenmandatorylabel{
+		Label: windows.SIDAndAttributes{Sid: sid, Attributes: windows.SE_GROUP_INTEGRITY},
+	}
+	if err := windows.SetTokenInformation(token, windows.TokenIntegrityLevel,
+		(*byte)(unsafe.Pointer(&tml)), tml.Size()); err != nil {
+		token.Close()
+		return 0, err
+	}
+	return token, nil
+}
+
+// integrityLabel 返回级别对应的强制标签 SID；high / system 和空值返回 false
+func integrityLabel(level string) (windows.WELL_KNOWN_SID_TYPE, bool) {
+	switch level {
+	case integrityLow:
+		return windows.WinLowLabelSid, true
+	case integrityMedium:
+		return windows.WinMediumLabelSid, true
+	}
+	return 0, false
+}
+
+// setIntegrityLevel 让 cmd 以降级后的令牌启动，返回在 Start 之后关闭令牌的函数。
+// 降级失败时返回错误而不是以原级别运行命令。
+func setIntegrityLevel(cmd *exec.Cmd, level string) (release func(), err error) {
+	label, ok := integrityLabel(level)
+	if !ok {
+		return func() {}, nil
+	}
+	token, err := lowerTokenIntegrity(label)
+	if err != nil {
+		return func() {}, err
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Token = syscall.Token(token)
+	return func() { token.Close() }, nil
+}
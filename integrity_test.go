@@ -0,0 +1,124 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestIntegrityLabel(t *testing.T) {
+	tests := []struct {
+		level  string
+		want   windows.WELL_KNOWN_SID_TYPE
+		wantOK bool
+	}{
+		{integrityLow, windows.WinLowLabelSid, true},
+		{integrityMedium, windows.WinMediumLabelSid, true},
+		{integrityHigh, 0, false},
+		{integritySystem, 0, false},
+		{"", 0, false},
+		{"Low", 0, false}, // 其他写法由 readConfig 拒绝
+	}
+	for _, tt := range tests {
+		got, ok := integrityLabel(tt.level)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("integrityLabel(%q) = %v, %v, want %v, %v", tt.level, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+// mockLowerTokenIntegrity 替换 lowerTokenIntegrity，记录请求的标签，返回本进程令牌的副本
+func mockLowerTokenIntegrity(t *testing.T, fail error) *[]windows.WELL_KNOWN_SID_TYPE {
+	t.Helper()
+	var calls []windows.WELL_KNOWN_SID_TYPE
+	orig := lowerTokenIntegrity
+	t.Cleanup(func() { lowerTokenIntegrity = orig })
+	lowerTokenIntegrity = func(label windows.WELL_KNOWN_SID_TYPE) (windows.Token, error) {
+		calls = append(calls, label)
+		if fail != nil {
+			return 0, fail
+		}
+		return windows.OpenCurrentProcessToken()
+	}
+	return &calls
+}
+
+func TestSetIntegrityLevel(t *testing.T) {
+	tests := []struct {
+		level     string
+		wantLabel windows.WELL_KNOWN_SID_TYPE
+		wantToken bool
+	}{
+		{"", 0, false},
+		{integrityHigh, 0, false},
+		{integritySystem, 0, false},
+		{integrityLow, windows.WinLowLabelSid, true},
+		{integrityMedium, windows.WinMediumLabelSid, true},
+	}
+	for _, tt := range tests {
+		calls := mockLowerTokenIntegrity(t, nil)
+		cmd := exec.Command("whoami.exe")
+		release, err := setIntegrityLevel(cmd, tt.level)
+		if err != nil {
+			t.Fatalf("setIntegrityLevel(%q): %v", tt.level, err)
+		}
+		hasToken := cmd.SysProcAttr != nil && cmd.SysProcAttr.Token != 0
+		if hasToken != tt.wantToken {
+			t.Errorf("setIntegrityLevel(%q): token set = %v, want %v", tt.level, hasToken, tt.wantToken)
+		}
+		if tt.wantToken && (len(*calls) != 1 || (*calls)[0] != tt.wantLabel) {
+			t.Errorf("setIntegrityLevel(%q): lowerTokenIntegrity calls %v, want [%v]", tt.level, *calls, tt.wantLabel)
+		}
+		if !tt.wantToken && len(*calls) != 0 {
+			t.Errorf("setIntegrityLevel(%q): token lowered, want no change", tt.level)
+		}
+		release()
+	}
+}
+
+func TestSetIntegrityLevelFails(t *testing.T) {
+	mockLowerTokenIntegrity(t, errors.New("access denied"))
+	cmd := exec.Command("whoami.exe")
+	release, err := setIntegrityLevel(cmd, integrityLow)
+	defer release()
+	if err == nil {
+		t.Fatal("setIntegrityLevel succeeded although the token could not be lowered")
+	}
+	if cmd.SysProcAttr != nil && cmd.SysProcAttr.Token != 0 {
+		t.Error("token set although lowering failed")
+	}
+}
+
+// tokenIntegrity 返回令牌的完整性级别 SID
+func tokenIntegrity(t *testing.T, token windows.Token) *windows.SID {
+	t.Helper()
+	var n uint32
+	windows.GetTokenInformation(token, windows.TokenIntegrityLevel, nil, 0, &n)
+	buf := make([]byte, n)
+	if err := windows.GetTokenInformation(token, windows.TokenIntegrityLevel, &buf[0], n, &n); err != nil {
+		t.Fatal(err)
+	}
+	return (*windows.Tokenmandatorylabel)(unsafe.Pointer(&buf[0])).Label.Sid
+}
+
+func TestLowerTokenIntegrity(t *testing.T) {
+	for _, label := range []windows.WELL_KNOWN_SID_TYPE{windows.WinLowLabelSid, windows.WinMediumLabelSid} {
+		token, err := lowerTokenIntegrity(label)
+		if err != nil {
+			t.Fatalf("lowerTokenIntegrity(%v): %v", label, err)
+		}
+		want, err := windows.CreateWellKnownSid(label)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tokenIntegrity(t, token); !got.Equals(want) {
+			t.Errorf("lowerTokenIntegrity(%v): integrity %s, want %s", label, got, want)
+		}
+		token.Close()
+	}
+}
@@ -0,0 +1,85 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// -------------------- 脚本解释器 --------------------
+
+// .ps1 / .bat / .cmd 不是可执行文件，CreateProcess 无法直接启动。
+// command 指向这类脚本时自动改为通过解释器执行，参数原样传给脚本：
+// .ps1 用 powershell.exe -File，.bat / .cmd 用 cmd.exe /C。
+
+const defaultPSExecutionPolicy = "Bypass"
+
+var psExecutionPolicies = []string{"Bypass", "Unrestricted", "RemoteSigned", "AllSigned", "Restricted", "Default", "Undefined"}
+
+// canonicalPSExecutionPolicy 返回标准大小写的执行策略名，无效时返回空串
+func canonicalPSExecutionPolicy(name string) string {
+	for _, p := range psExecutionPolicies {
+		if strings.EqualFold(name, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// resolveInterpreter 在 exe 是脚本时返回解释器、参数，以及需要原样交给
+// CreateProcess 的命令行（为空时按参数正常转义）；不是脚本时原样返回。
+func resolveInterpreter(exe string, args []string, opts *execOptions) (name string, argv []string, cmdLine string) {
+	switch strings.ToLower(filepath.Ext(exe)) {
+	case ".ps1":
+		policy := opts.PSExecutionPolicy
+		if policy == "" {
+			policy = defaultPSExecutionPolicy
+		}
+		psArgs := []string{"-NonInteractive", "-NoProfile", "-ExecutionPolicy", policy, "-File", exe}
+		return "powershell.exe", append(psArgs, args...), ""
+
+	case ".bat", ".cmd":
+		// cmd.exe /C 按自己的规则处理引号：命令部分再整体加一层引号，
+		// cmd 去掉最外层引号后，脚本路径和参数中的引号保持原样
+		script := make([]string, 0, len(args)+1)
+		for _, a := range append([]string{exe}, args...) {
+			script = append(script, syscall.EscapeArg(a))
+		}
+		line := strings.Join(script, " ")
+		if opts.Codepage != 0 {
+			line = fmt.Sprintf("chcp %d >NUL && %s", opts.Codepage, line)
+		}
+		cmdArgs := append(append([]string{}, opts.CmdExtraArgs...), "/C", `"`+line+`"`)
+		return "cmd.exe", cmdArgs, "cmd.exe " + strings.Join(cmdArgs, " ")
+	}
+	return exe, args, ""
+}
+
+// setHideWindow 让 .bat / .ps1 等控制台程序在关机时不闪出窗口
+func setHideWindow(cmd *exec.Cmd, hidden bool) {
+	if !hidden {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.HideWindow = true
+	cmd.SysProcAttr.CreationFlags |= windows.CREATE_NO_WINDOW
+}
+
+// setCmdLine 让 CreateProcess 使用 resolveInterpreter 给出的命令行
+func setCmdLine(cmd *exec.Cmd, cmdLine string) {
+	if cmdLine == "" {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CmdLine = cmdLine
+}
@@ -0,0 +1,190 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestResolveInterpreter(t *testing.T) {
+	tests := []struct {
+		exe         string
+		args        []string
+		opts        execOptions
+		wantName    string
+		wantArgs    []string
+		wantCmdLine string
+	}{
+		{`C:\Tools\backup.exe`, []string{"/full"}, execOptions{}, `C:\Tools\backup.exe`, []string{"/full"}, ""},
+		{
+			`C:\Scripts\backup.ps1`, nil, execOptions{},
+			"powershell.exe", []string{"-NonInteractive", "-NoProfile", "-ExecutionPolicy", "Bypass", "-File", `C:\Scripts\backup.ps1`}, "",
+		},
+		{
+			`C:\My Scripts\Backup.PS1`, []string{"-Target", `D:\Backup dir`}, execOptions{PSExecutionPolicy: "AllSigned"},
+			"powershell.exe", []string{"-NonInteractive", "-NoProfile", "-ExecutionPolicy", "AllSigned", "-File", `C:\My Scripts\Backup.PS1`, "-Target", `D:\Backup dir`}, "",
+		},
+		{
+			`C:\Scripts\stop.bat`, []string{"now"}, execOptions{},
+			"cmd.exe", []string{"/C", `"C:\Scripts\stop.bat now"`}, `cmd.exe /C "C:\Scripts\stop.bat now"`,
+		},
+		{
+			`C:\My Scripts\stop.cmd`, []string{"a b"}, execOptions{Codepage: 65001, CmdExtraArgs: []string{"/V:ON"}},
+			"cmd.exe", []string{"/V:ON", "/C", `"chcp 65001 >NUL && "C:\My Scripts\stop.cmd" "a b""`},
+			`cmd.exe /V:ON /C "chcp 65001 >NUL && "C:\My Scripts\stop.cmd" "a b""`,
+		},
+	}
+	for _, tt := range tests {
+		name, args, cmdLine := resolveInterpreter(tt.exe, tt.args, &tt.opts)
+		if name != tt.wantName || !reflect.DeepEqual(args, tt.wantArgs) || cmdLine != tt.wantCmdLine {
+			t.Errorf("resolveInterpreter(%q, %q) = %q, %q, %q, want %q, %q, %q",
+				tt.exe, tt.args, name, args, cmdLine, tt.wantName, tt.wantArgs, tt.wantCmdLine)
+		}
+	}
+}
+
+func TestReadConfigPSExecutionPolicy(t *testing.T) {
+	tests := []struct {
+		policy  string
+		want    string
+		wantErr bool
+	}{
+		{"", "Bypass", false},
+		{"RemoteSigned", "RemoteSigned", false},
+		{"allsigned", "AllSigned", false},
+		{"Strict", "", true},
+	}
+	for _, tt := range tests {
+		s := writeConfig(t, `{"command": "C:\\Scripts\\backup.ps1", "ps_execution_policy": "`+tt.policy+`"}`)
+		err := s.loadConfig()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ps_execution_policy %q: error = %v, wantErr %v", tt.policy, err, tt.wantErr)
+			continue
+		}
+		if err == nil && s.config.Load().PSExecutionPolicy != tt.want {
+			t.Errorf("ps_execution_policy %q = %q, want %q", tt.policy, s.config.Load().PSExecutionPolicy, tt.want)
+		}
+	}
+}
+
+func TestRunPowerShellScript(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "my scripts")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(dir, "backup.ps1")
+	if err := os.WriteFile(script, []byte("param($Target)\r\nWrite-Output \"target=$Target\"\r\nexit 3\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	code, _, err := runCommandWithTimeout(`"`+script+`" -Target "D:\Backup dir"`, time.Minute, execOptions{Output: &out})
+	if code != 3 || !isExitError(err) {
+		t.Fatalf("runCommandWithTimeout = %d, %v, want exit code 3:\n%s", code, err, out.String())
+	}
+	if got := strings.TrimSpace(out.String()); got != `target=D:\Backup dir` {
+		t.Errorf("script output %q", got)
+	}
+}
+
+// batchFixture 用 /V:ON 时第二行输出 delayed=second，否则输出 delayed=!X!
+const batchFixture = "@echo off\r\n" +
+	"set X=first\r\n" +
+	"set X=second& echo delayed=!X!\r\n" +
+	"echo arg1=%~1\r\n" +
+	"exit /b 4\r\n"
+
+func TestRunBatchFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "my scripts")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		file      string
+		extraArgs []string
+		want      string
+	}{
+		{"stop.bat", nil, "delayed=!X!\narg1=D:\\Backup dir"},
+		{"stop.cmd", nil, "delayed=!X!\narg1=D:\\Backup dir"},
+		{"stop.bat", []string{"/V:ON"}, "delayed=second\narg1=D:\\Backup dir"},
+	}
+	for _, tt := range tests {
+		script := filepath.Join(dir, tt.file)
+		if err := os.WriteFile(script, []byte(batchFixture), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		code, _, err := runCommandWithTimeout(`"`+script+`" "D:\Backup dir"`, time.Minute, execOptions{Output: &out, CmdExtraArgs: tt.extraArgs})
+		if code != 4 || !isExitError(err) {
+			t.Errorf("%s %v: exit code %d, %v, want 4:\n%s", tt.file, tt.extraArgs, code, err, out.String())
+			continue
+		}
+		if got := strings.TrimSpace(strings.ReplaceAll(out.String(), "\r\n", "\n")); got != tt.want {
+			t.Errorf("%s %v: output %q, want %q", tt.file, tt.extraArgs, got, tt.want)
+		}
+	}
+}
+
+func TestSetHideWindow(t *testing.T) {
+	tests := []struct {
+		name    string
+		hidden  bool
+		cmdLine string // 先由 setCmdLine 设置，不能被覆盖
+		want    *syscall.SysProcAttr
+	}{
+		{"visible", false, "", nil},
+		{"visible with command line", false, `cmd.exe /c "a.bat"`, &syscall.SysProcAttr{CmdLine: `cmd.exe /c "a.bat"`}},
+		{"hidden", true, "", &syscall.SysProcAttr{HideWindow: true, CreationFlags: windows.CREATE_NO_WINDOW}},
+		{"hidden with command line", true, `cmd.exe /c "a.bat"`,
+			&syscall.SysProcAttr{CmdLine: `cmd.exe /c "a.bat"`, HideWindow: true, CreationFlags: windows.CREATE_NO_WINDOW}},
+	}
+	for _, tt := range tests {
+		cmd := exec.Command("cmd.exe")
+		setCmdLine(cmd, tt.cmdLine)
+		setHideWindow(cmd, tt.hidden)
+		if !reflect.DeepEqual(cmd.SysProcAttr, tt.want) {
+			t.Errorf("%s: SysProcAttr = %+v, want %+v", tt.name, cmd.SysProcAttr, tt.want)
+		}
+	}
+
+	// 已有的创建标志保留
+	cmd := exec.Command("cmd.exe")
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: windows.CREATE_NEW_PROCESS_GROUP}
+	setHideWindow(cmd, true)
+	if want := uint32(windows.CREATE_NEW_PROCESS_GROUP | windows.CREATE_NO_WINDOW); cmd.SysProcAttr.CreationFlags != want {
+		t.Errorf("CreationFlags = %#x, want %#x", cmd.SysProcAttr.CreationFlags, want)
+	}
+}
+
+func TestCommandHidden(t *testing.T) {
+	tests := []struct {
+		config      string
+		interactive bool
+		want        bool
+	}{
+		{`{"command": "a.bat"}`, false, true},
+		{`{"command": "a.bat"}`, true, false},
+		{`{"command": "a.bat", "command_hidden": false}`, false, false},
+		{`{"command": "a.bat", "command_hidden": true}`, true, true},
+	}
+	for _, tt := range tests {
+		s := loadedService(t, tt.config)
+		s.interactive = tt.interactive
+		if got := s.commandHidden(); got != tt.want {
+			t.Errorf("%s, interactive %v: commandHidden = %v, want %v", tt.config, tt.interactive, got, tt.want)
+		}
+		if opts := s.execOptions(nil, nil, nil); opts.Hidden != tt.want {
+			t.Errorf("%s, interactive %v: execOptions().Hidden = %v, want %v", tt.config, tt.interactive, opts.Hidden, tt.want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// JOBOBJECT_CPU_RATE_CONTROL_INFORMATION（x/sys 未定义）
+type jobObjectCPURateControlInformation struct {
+	ControlFlags uint32
+	CPURate      uint32 // 以 1/100 百分比为单位：10000 = 100%
+}
+
+const (
+	jobObjectCPURateControlEnable  = 0x1
+	jobObjectCPURateControlHardCap = 0x4
+)
+
+// -------------------- Job Object 资源限制 --------------------
+
+// jobLimits 描述子进程（及其派生的所有进程）的资源上限，0 表示不限制
+type jobLimits struct {
+	MemoryMB       int
+	CPURatePercent int
+}
+
+func (l jobLimits) enabled() bool {
+	return l.MemoryMB > 0 || l.CPURatePercent > 0
+}
+
+func (l jobLimits) String() string {
+	return fmt.Sprintf("memory=%d MB, cpu=%d%%", l.MemoryMB, l.CPURatePercent)
+}
+
+// Job Object 和进程线程相关的系统调用，可在调试时替换
+var (
+	createJobObject          = windows.CreateJobObject
+	setJobInformation        = setInformationJobObject
+	openProcess              = windows.OpenProcess
+	assignProcessToJobObject = windows.AssignProcessToJobObject
+	closeHandle              = windows.CloseHandle
+	resumeProcess            = resumeProcessThreads
+)
+
+// startSuspended 让命令以挂起状态启动，放入 Job Object 之后再由 resumeProcess 恢复，
+// 否则命令在放入之前就可能已经派生子进程或用掉内存，不受限制
+func startSuspended(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= windows.CREATE_SUSPENDED
+}
+
+// setInformationJobObject 设置 job 的一项限制，info 指向 class 对应的结构
+func setInformationJobObject(job windows.Handle, class uint32, info unsafe.Pointer, size uint32) error {
+	_, err := windows.SetInformationJobObject(job, class, uintptr(info), size)
+	return err
+}
+
+// applyJobLimits 创建带限制的 Job Object 并把进程放入其中。
+// 调用方在进程结束后关闭返回的句柄。
+func applyJobLimits(pid int, l jobLimits) (windows.Handle, error) {
+	job, err := createJobObject(nil, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	if l.MemoryMB > 0 {
+		var info windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION
+		info.BasicLimitInformation.LimitFlags = windows.JOB_OBJECT_LIMIT_PROCESS_MEMORY
+		// 32 位系统上 uintptr 装不下超过 4 GB 的值，按地址空间上限截断
+		limit := uint64(l.MemoryMB) << 20
+		if limit > uint64(^uintptr(0)) {
+			limit = uint64(^uintptr(0))
+		}
+		info.ProcessMemoryLimit = uintptr(limit)
+		if err := setJobInformation(job, windows.JobObjectExtendedLimitInformation,
+			unsafe.Pointer(&info), uint32(unsafe.Sizeof(info))); err != nil {
+			closeHandle(job)
+			return 0, fmt.Errorf("set memory limit: %w", err)
+		}
+	}
+
+	if l.CPURatePercent > 0 {
+		info := jobObjectCPURateControlInformation{
+			ControlFlags: jobObjectCPURateControlEnable | jobObjectCPURateControlHardCap,
+			CPURate:      uint32(l.CPURatePercent) * 100,
+		}
+		if err := setJobInformation(job, windows.JobObjectCpuRateControlInformation,
+			unsafe.Pointer(&info), uint32(unsafe.Sizeof(info))); err != nil {
+			closeHandle(job)
+			return 0, fmt.Errorf("set cpu rate: %w", err)
+		}
+	}
+
+	proc, err := openProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(pid))
+	if err != nil {
+		closeHandle(job)
+		return 0, err
+	}
+	defer closeHandle(proc)
+
+	if err := assignProcessToJobObject(job, proc); err != nil {
+		closeHandle(job)
+		return 0, err
+	}
+	return job, nil
+}
+
+// resumeProcessThreads 恢复以 CREATE_SUSPENDED 启动的进程。
+// exec.Cmd 不提供主线程的句柄；挂起的进程只有主线程，按进程 ID 找到它。
+func resumeProcessThreads(pid int) error {
+	tids, err := processThreads(pid)
+	if err != nil {
+		return err
+	}
+	if len(tids) == 0 {
+		return fmt.Errorf("no threads found for process %d", pid)
+	}
+	for _, tid := range tids {
+		thread, err := windows.OpenThread(windows.THREAD_SUSPEND_RESUME, false, tid)
+		if err != nil {
+			return err
+		}
+		_, err = windows.ResumeThread(thread)
+		windows.CloseHandle(thread)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processThreads 返回进程的所有线程 ID
+func processThreads(pid int) ([]uint32, error) {
+	snap, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPTHREAD, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(snap)
+
+	var tids []uint32
+	entry := windows.ThreadEntry32{Size: uint32(unsafe.Sizeof(windows.ThreadEntry32{}))}
+	for err = windows.Thread32First(snap, &entry); err == nil; err = windows.Thread32Next(snap, &entry) {
+		if entry.OwnerProcessID == uint32(pid) {
+			tids = append(tids, entry.ThreadID)
+		}
+	}
+	if !errors.Is(err, windows.ERROR_NO_MORE_FILES) {
+		return nil, err
+	}
+	return tids, nil
+}
@@ -0,0 +1,222 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestJobLimitsEnabled(t *testing.T) {
+	tests := []struct {
+		l    jobLimits
+		want bool
+	}{
+		{jobLimits{}, false},
+		{jobLimits{MemoryMB: 512}, true},
+		{jobLimits{CPURatePercent: 50}, true},
+		{jobLimits{MemoryMB: 512, CPURatePercent: 50}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.l.enabled(); got != tt.want {
+			t.Errorf("%v.enabled() = %v, want %v", tt.l, got, tt.want)
+		}
+	}
+}
+
+// 模拟的 Job Object 和进程句柄，不会传给真正的系统调用
+const (
+	fakeJob     windows.Handle = 0x7001
+	fakeProcess windows.Handle = 0x7002
+)
+
+var procSuspendThread = windows.NewLazySystemDLL("kernel32.dll").NewProc("SuspendThread")
+
+// jobAPI 记录模拟的 Job Object 调用
+type jobAPI struct {
+	assignErr error // AssignProcessToJobObject 返回的错误
+
+	classes   []uint32 // SetInformationJobObject 设置的信息类
+	memory    uintptr
+	cpu       jobObjectCPURateControlInformation
+	assigned  []uint32 // 放入 job 的进程 ID
+	suspended bool     // 放入 job 时进程仍处于挂起状态
+	closed    []windows.Handle
+}
+
+// mockJobAPI 替换 Job Object 相关的系统调用，测试结束时恢复
+func mockJobAPI(t *testing.T, assignErr error) *jobAPI {
+	t.Helper()
+	origCreate, origSet, origOpen, origAssign, origClose := createJobObject, setJobInformation, openProcess, assignProcessToJobObject, closeHandle
+	t.Cleanup(func() {
+		createJobObject, setJobInformation, openProcess, assignProcessToJobObject, closeHandle = origCreate, origSet, origOpen, origAssign, origClose
+	})
+
+	api := &jobAPI{assignErr: assignErr}
+	var pid uint32
+	createJobObject = func(*windows.SecurityAttributes, *uint16) (windows.Handle, error) {
+		return fakeJob, nil
+	}
+	setJobInformation = func(job windows.Handle, class uint32, info unsafe.Pointer, size uint32) error {
+		api.classes = append(api.classes, class)
+		switch class {
+		case windows.JobObjectExtendedLimitInformation:
+			api.memory = (*windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION)(info).ProcessMemoryLimit
+		case windows.JobObjectCpuRateControlInformation:
+			api.cpu = *(*jobObjectCPURateControlInformation)(info)
+		}
+		return nil
+	}
+	openProcess = func(access uint32, inherit bool, id uint32) (windows.Handle, error) {
+		pid = id
+		return fakeProcess, nil
+	}
+	assignProcessToJobObject = func(job, proc windows.Handle) error {
+		api.assigned = append(api.assigned, pid)
+		api.suspended = processSuspended(t, pid)
+		return api.assignErr
+	}
+	closeHandle = func(h windows.Handle) error {
+		api.closed = append(api.closed, h)
+		return nil
+	}
+	return api
+}
+
+// processSuspended 判断进程的所有线程是否都处于挂起状态，不改变其状态
+func processSuspended(t *testing.T, pid uint32) bool {
+	t.Helper()
+	tids, err := processThreads(int(pid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tid := range tids {
+		thread, err := windows.OpenThread(windows.THREAD_SUSPEND_RESUME, false, tid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prev, _, _ := procSuspendThread.Call(uintptr(thread))
+		windows.ResumeThread(thread)
+		windows.CloseHandle(thread)
+		if prev == 0 {
+			return false
+		}
+	}
+	return len(tids) > 0
+}
+
+func TestApplyJobLimits(t *testing.T) {
+	tests := []struct {
+		l       jobLimits
+		classes []uint32
+		memory  uintptr
+		cpu     jobObjectCPURateControlInformation
+	}{
+		{jobLimits{MemoryMB: 256}, []uint32{windows.JobObjectExtendedLimitInformation}, 256 << 20, jobObjectCPURateControlInformation{}},
+		{jobLimits{CPURatePercent: 25}, []uint32{windows.JobObjectCpuRateControlInformation}, 0,
+			jobObjectCPURateControlInformation{ControlFlags: jobObjectCPURateControlEnable | jobObjectCPURateControlHardCap, CPURate: 2500}},
+		{jobLimits{MemoryMB: 64, CPURatePercent: 100}, []uint32{windows.JobObjectExtendedLimitInformation, windows.JobObjectCpuRateControlInformation}, 64 << 20,
+			jobObjectCPURateControlInformation{ControlFlags: jobObjectCPURateControlEnable | jobObjectCPURateControlHardCap, CPURate: 10000}},
+	}
+	for _, tt := range tests {
+		api := mockJobAPI(t, nil)
+		job, err := applyJobLimits(1234, tt.l)
+		if err != nil || job != fakeJob {
+			t.Fatalf("applyJobLimits(%v) = %#x, %v", tt.l, job, err)
+		}
+		if fmt.Sprint(api.classes) != fmt.Sprint(tt.classes) || api.memory != tt.memory || api.cpu != tt.cpu {
+			t.Errorf("%v: set %v, memory %d, cpu %+v; want %v, %d, %+v", tt.l, api.classes, api.memory, api.cpu, tt.classes, tt.memory, tt.cpu)
+		}
+		if len(api.assigned) != 1 || api.assigned[0] != 1234 {
+			t.Errorf("%v: assigned %v, want [1234]", tt.l, api.assigned)
+		}
+		// job 句柄交给调用方，进程句柄用完关闭
+		if fmt.Sprint(api.closed) != fmt.Sprint([]windows.Handle{fakeProcess}) {
+			t.Errorf("%v: closed %v, want only the process handle", tt.l, api.closed)
+		}
+	}
+}
+
+func TestApplyJobLimitsAssignFails(t *testing.T) {
+	api := mockJobAPI(t, windows.ERROR_ACCESS_DENIED)
+	job, err := applyJobLimits(1234, jobLimits{MemoryMB: 256})
+	if !errors.Is(err, windows.ERROR_ACCESS_DENIED) || job != 0 {
+		t.Errorf("applyJobLimits = %#x, %v, want ERROR_ACCESS_DENIED", job, err)
+	}
+	closed := map[windows.Handle]bool{}
+	for _, h := range api.closed {
+		closed[h] = true
+	}
+	if !closed[fakeJob] || !closed[fakeProcess] || len(api.closed) != 2 {
+		t.Errorf("closed %v, want the job and the process handle", api.closed)
+	}
+}
+
+// 有资源限制时命令挂起启动，放入 job 之后才开始运行；放入失败时命令照常运行，只记录警告
+func TestRunCmdJobLimits(t *testing.T) {
+	command, env := helperCommandLine(t, "lines")
+	tests := []struct {
+		assignErr error
+		warning   string
+	}{
+		{nil, "Warning: job limits applied (memory=256 MB, cpu=0%)"},
+		{windows.ERROR_ACCESS_DENIED, "Warning: job limits not applied: Access is denied."},
+	}
+	for _, tt := range tests {
+		api := mockJobAPI(t, tt.assignErr)
+		var out bytes.Buffer
+		var logged []string
+		opts := execOptions{
+			Output: &out,
+			Env:    env,
+			Limits: jobLimits{MemoryMB: 256},
+			Logf:   func(format string, args ...any) { logged = append(logged, fmt.Sprintf(format, args...)) },
+		}
+		code, _, err := runCommandWithTimeout(command+" 3 0", time.Minute, opts)
+		if err != nil || code != 0 {
+			t.Fatalf("assign error %v: exit code %d, %v", tt.assignErr, code, err)
+		}
+		if !api.suspended {
+			t.Errorf("assign error %v: process was running before it was put in the job", tt.assignErr)
+		}
+		if out.String() != "line 1\nline 2\nline 3\n" {
+			t.Errorf("assign error %v: output %q", tt.assignErr, out.String())
+		}
+		if strings.Join(logged, "|") != tt.warning {
+			t.Errorf("assign error %v: logged %q, want %q", tt.assignErr, logged, tt.warning)
+		}
+		if tt.assignErr == nil && (len(api.closed) != 2 || api.closed[1] != fakeJob) {
+			t.Errorf("job handle not closed after the command: %v", api.closed)
+		}
+	}
+}
+
+// 无法恢复挂起的进程时结束它并返回错误，而不是一直等待
+func TestRunCmdResumeFails(t *testing.T) {
+	mockJobAPI(t, nil)
+	origResume := resumeProcess
+	resumeProcess = func(int) error { return windows.ERROR_ACCESS_DENIED }
+	t.Cleanup(func() { resumeProcess = origResume })
+
+	command, env := helperCommandLine(t, "lines")
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := runCommandWithTimeout(command+" 3 0", 0, execOptions{Env: env, Limits: jobLimits{CPURatePercent: 50}})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "resume process") {
+			t.Errorf("error %v, want resume process failure", err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("runCmd did not return after resume failed")
+	}
+}
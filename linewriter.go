@@ -0,0 +1,217 @@
+//go:build windows
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/ring"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLogTimestampFormat = "2006-01-02 15:04:05"
+	defaultFailureOutputLines = 20
+)
+
+// 单行输出的上限，超过后已读到的部分加注明写出，剩余输出不再加时间戳，原样写入
+const maxOutputLineBytes = 1024 * 1024
+
+// -------------------- 逐行时间戳 --------------------
+
+// timestampWriter 把命令输出按行切分，每行加上到达时的时间戳再写入 w。
+// 长时间运行的命令卡在哪里，可以从日志的时间戳直接看出来。
+// layout 为空时不加时间戳；runID 非空时在时间戳之后加 [run:<runID>]，与 WinPSP 写的行相同；
+// dedup 大于 0 时合并该时间窗口内连续重复的行。
+type timestampWriter struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+}
+
+func newTimestampWriter(w io.Writer, layout, runID string, dedup time.Duration) *timestampWriter {
+	pr, pw := io.Pipe()
+	t := &timestampWriter{pw: pw, done: make(chan struct{})}
+
+	var run string
+	if runID != "" {
+		run = "[run:" + runID + "] "
+	}
+	writeLine := func(now time.Time, line []byte) {
+		if layout != "" {
+			fmt.Fprintf(w, "[%s] %s%s\n", now.Format(layout), run, line)
+		} else {
+			fmt.Fprintf(w, "%s%s\n", run, line)
+		}
+	}
+
+	go func() {
+		defer close(t.done)
+
+		d := lineDeduper{window: dedup}
+		br := bufio.NewReaderSize(pr, maxOutputLineBytes)
+		for {
+			line, err := br.ReadSlice('\n')
+			if errors.Is(err, bufio.ErrBufferFull) {
+				// 行过长：已读到的部分照常加时间戳写出并注明，之后放弃切分，
+				// 剩余部分原样写入，避免写端阻塞
+				now := time.Now()
+				if summary := d.flush(); summary != "" {
+					writeLine(now, []byte(summary))
+				}
+				writeLine(now, append(line, fmt.Sprintf(" [line longer than %d bytes, rest of output written without timestamps]", maxOutputLineBytes)...))
+				io.Copy(w, br)
+				return
+			}
+			if len(line) > 0 {
+				line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+				now := time.Now()
+				summary, drop := d.add(line, now)
+				if summary != "" {
+					writeLine(now, []byte(summary))
+				}
+				if !drop {
+					writeLine(now, line)
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		if summary := d.flush(); summary != "" {
+			writeLine(time.Now(), []byte(summary))
+		}
+	}()
+	return t
+}
+
+func (t *timestampWriter) Write(p []byte) (int, error) {
+	return t.pw.Write(p)
+}
+
+// Close 写出最后一个不完整的行，并等待全部输出写入 w
+func (t *timestampWriter) Close() error {
+	t.pw.Close()
+	<-t.done
+	return nil
+}
+
+// lineDeduper 合并连续重复的输出行（例如反复打印的同一条状态）。
+// 同一行在首次出现后 window 内再次出现时不写出，只计数；
+// 出现不同的行、窗口过期或输出结束时写出一行重复次数。
+type lineDeduper struct {
+	window  time.Duration // 0 = 不合并
+	last    []byte
+	since   time.Time
+	repeats int
+}
+
+// add 返回写出 line 之前应先写出的重复提示（没有时为空），以及 line 是否被合并
+func (d *lineDeduper) add(line []byte, now time.Time) (summary string, drop bool) {
+	if d.window <= 0 {
+		return "", false
+	}
+	if d.last != nil && bytes.Equal(line, d.last) && now.Sub(d.since) < d.window {
+		d.repeats++
+		return "", true
+	}
+	summary = d.flush()
+	d.last = append(d.last[:0], line...)
+	d.since = now
+	return summary, false
+}
+
+func (d *lineDeduper) flush() string {
+	if d.repeats == 0 {
+		return ""
+	}
+	s := fmt.Sprintf("[previous line repeated %d times]", d.repeats)
+	d.repeats = 0
+	return s
+}
+
+// lockedWriter 让多个 goroutine 安全地写入同一个 Writer
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// -------------------- 输出末尾 --------------------
+
+// tailBuffer 保留最近写入的 n 行输出，命令失败时写入日志末尾，
+// 不用翻完整个输出就能看到出错的地方
+type tailBuffer struct {
+	mu      sync.Mutex
+	r       *ring.Ring
+	partial []byte
+	count   int // 写入的完整行数，用于计算丢弃了多少行
+}
+
+func newTailBuffer(n int) *tailBuffer {
+	return &tailBuffer{r: ring.New(n)}
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	data := append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		t.r.Value = strings.TrimRight(string(data[:i]), "\r")
+		t.r = t.r.Next()
+		t.count++
+		data = data[i+1:]
+	}
+	// 复制剩余部分，不保留对调用者缓冲区的引用
+	t.partial = append([]byte(nil), data...)
+	return len(p), nil
+}
+
+// Lines 按时间顺序返回保留的行（包括最后一个不完整的行）
+func (t *tailBuffer) Lines() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var lines []string
+	t.r.Do(func(v any) {
+		if v != nil {
+			lines = append(lines, v.(string))
+		}
+	})
+	if len(t.partial) > 0 {
+		lines = append(lines, strings.TrimRight(string(t.partial), "\r"))
+		if len(lines) > t.r.Len() {
+			lines = lines[1:]
+		}
+	}
+	return lines
+}
+
+// Dropped 返回因超出 n 行而被丢弃的行数
+func (t *tailBuffer) Dropped() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := t.count
+	if len(t.partial) > 0 {
+		n++
+	}
+	if n <= t.r.Len() {
+		return 0
+	}
+	return n - t.r.Len()
+}
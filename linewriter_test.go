@@ -0,0 +1,354 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	// 输出 args[0] 行 "line N"，以 args[1] 退出
+	testHelpers["lines"] = func(args []string) int {
+		n, _ := strconv.Atoi(args[0])
+		code, _ := strconv.Atoi(args[1])
+		for i := 1; i <= n; i++ {
+			fmt.Printf("line %d\r\n", i)
+		}
+		return code
+	}
+	// 输出 args[0] 次同一行
+	testHelpers["same"] = func(args []string) int {
+		n, _ := strconv.Atoi(args[0])
+		for range n {
+			fmt.Print("copying file\r\n")
+		}
+		return 0
+	}
+}
+
+func TestTimestampWriter(t *testing.T) {
+	const layout = "2006-01-02 15:04:05.000"
+	stamp := regexp.MustCompile(`^\[\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3}\] `)
+	tests := []struct {
+		in        string
+		wantLines []string
+	}{
+		{"", nil},
+		{"one\n", []string{"one"}},
+		{"one\ntwo\n", []string{"one", "two"}},
+		{"one\r\ntwo\r\n", []string{"one", "two"}},
+		{"no newline", []string{"no newline"}},
+		{"\n\n", []string{"", ""}},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		w := newTimestampWriter(&out, layout, "", 0)
+		w.Write([]byte(tt.in))
+		w.Close()
+
+		got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+		if out.Len() == 0 {
+			got = nil
+		}
+		if len(got) != len(tt.wantLines) {
+			t.Errorf("%q: output %q, want lines %q", tt.in, out.String(), tt.wantLines)
+			continue
+		}
+		for i, line := range got {
+			if !stamp.MatchString(line) || stamp.ReplaceAllString(line, "") != tt.wantLines[i] {
+				t.Errorf("%q: line %d = %q, want a timestamp and %q", tt.in, i+1, line, tt.wantLines[i])
+			}
+		}
+	}
+}
+
+func TestLineDeduper(t *testing.T) {
+	start := time.Date(2026, 10, 14, 8, 30, 0, 0, time.UTC)
+	steps := []struct {
+		line        string
+		at          time.Duration // 相对 start
+		wantSummary string
+		wantDrop    bool
+	}{
+		{"progress 50%", 0, "", false},
+		{"progress 50%", time.Second, "", true},
+		{"progress 50%", 1500 * time.Millisecond, "", true},
+		// 窗口从首次出现算起，过期后重新写出这一行
+		{"progress 50%", 2500 * time.Millisecond, "[previous line repeated 2 times]", false},
+		{"progress 51%", 3 * time.Second, "", false},
+		{"progress 51%", 3100 * time.Millisecond, "", true},
+		{"done", 3200 * time.Millisecond, "[previous line repeated 1 times]", false},
+		{"done", 10 * time.Second, "", false},
+	}
+	d := lineDeduper{window: 2 * time.Second}
+	for i, st := range steps {
+		summary, drop := d.add([]byte(st.line), start.Add(st.at))
+		if summary != st.wantSummary || drop != st.wantDrop {
+			t.Errorf("step %d (%q): add = %q, %v, want %q, %v", i+1, st.line, summary, drop, st.wantSummary, st.wantDrop)
+		}
+	}
+	if s := d.flush(); s != "" {
+		t.Errorf("flush = %q, want nothing", s)
+	}
+
+	// 未启用时不合并
+	off := lineDeduper{}
+	for range 3 {
+		if summary, drop := off.add([]byte("same"), start); summary != "" || drop {
+			t.Fatalf("add without a window = %q, %v", summary, drop)
+		}
+	}
+}
+
+func TestTimestampWriterDedup(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"a\na\na\nb\n", "a\n[previous line repeated 2 times]\nb\n"},
+		// 输出结束时写出最后一行的重复次数
+		{"a\nb\nb\r\nb", "a\nb\n[previous line repeated 2 times]\n"},
+		{"a\nb\na\n", "a\nb\na\n"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		w := newTimestampWriter(&out, "", "", time.Minute)
+		w.Write([]byte(tt.in))
+		w.Close()
+		if out.String() != tt.want {
+			t.Errorf("%q: output %q, want %q", tt.in, out.String(), tt.want)
+		}
+	}
+}
+
+func TestRunCommandDedup(t *testing.T) {
+	// 同一行输出 500 次
+	exe, env := helperCommandLine(t, "same")
+	var out bytes.Buffer
+	code, _, err := runCommandWithTimeout(exe+" 500", 30*time.Second, execOptions{
+		Output:          &out,
+		Env:             env,
+		TimestampFormat: defaultLogTimestampFormat,
+		DedupWindow:     time.Minute,
+	})
+	if err != nil || code != 0 {
+		t.Fatalf("runCommandWithTimeout = %d, %v", code, err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], "] copying file") ||
+		!strings.HasSuffix(lines[1], "] [previous line repeated 499 times]") {
+		t.Errorf("output:\n%s", out.String())
+	}
+}
+
+// 超过 maxOutputLineBytes 的行：已读到的部分加时间戳和注明写出，其余原样写入，一个字节都不丢
+func TestTimestampWriterLongLine(t *testing.T) {
+	long := strings.Repeat("x", maxOutputLineBytes+maxOutputLineBytes/2)
+	var out bytes.Buffer
+	w := newTimestampWriter(&out, defaultLogTimestampFormat, "", 0)
+	w.Write([]byte("before\n"))
+	for i := 0; i < len(long); i += 64 * 1024 {
+		w.Write([]byte(long[i:min(i+64*1024, len(long))]))
+	}
+	w.Write([]byte("\nafter\n"))
+	w.Close()
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("%d lines, want 4", len(lines))
+	}
+	stamp := regexp.MustCompile(`^\[\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\] `)
+	if !stamp.MatchString(lines[0]) || stamp.ReplaceAllString(lines[0], "") != "before" {
+		t.Errorf("line 1 = %q", lines[0])
+	}
+	marker := fmt.Sprintf(" [line longer than %d bytes, rest of output written without timestamps]", maxOutputLineBytes)
+	first, ok := strings.CutSuffix(stamp.ReplaceAllString(lines[1], ""), marker)
+	if !stamp.MatchString(lines[1]) || !ok {
+		t.Fatalf("line 2 has no timestamp or marker: %q...%q", lines[1][:40], lines[1][len(lines[1])-100:])
+	}
+	if got := first + lines[2]; got != long {
+		t.Errorf("long line: %d + %d bytes survived, want %d", len(first), len(lines[2]), len(long))
+	}
+	if lines[3] != "after" {
+		t.Errorf("line after the long line = %q, want it unchanged", lines[3])
+	}
+}
+
+func TestTimestampWriterNoLayout(t *testing.T) {
+	var out bytes.Buffer
+	w := newTimestampWriter(&out, "", "", 0)
+	w.Write([]byte("one\r\ntwo"))
+	w.Close()
+	if out.String() != "one\ntwo\n" {
+		t.Errorf("output without layout = %q", out.String())
+	}
+}
+
+func TestTimestampWriterRunID(t *testing.T) {
+	tests := []struct {
+		layout string
+		want   string
+	}{
+		{"ts", "[ts] [run:0123abcd] one\n[ts] [run:0123abcd] two\n"}, // 不含时间字段的布局，输出固定
+		{"", "[run:0123abcd] one\n[run:0123abcd] two\n"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		w := newTimestampWriter(&out, tt.layout, "0123abcd", 0)
+		w.Write([]byte("one\ntwo\n"))
+		w.Close()
+		if out.String() != tt.want {
+			t.Errorf("layout %q: output = %q, want %q", tt.layout, out.String(), tt.want)
+		}
+	}
+}
+
+// 每行的时间戳是行到达的时间，而不是命令结束的时间
+func TestTimestampWriterArrivalTime(t *testing.T) {
+	var out syncBuffer
+	w := newTimestampWriter(&out, time.RFC3339Nano, "", 0)
+	w.Write([]byte("first\n"))
+	time.Sleep(300 * time.Millisecond)
+	w.Write([]byte("second\n"))
+	w.Close()
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("output %q", out.String())
+	}
+	var ts [2]time.Time
+	for i, line := range lines {
+		end := strings.IndexByte(line, ']')
+		var err error
+		if ts[i], err = time.Parse(time.RFC3339Nano, line[1:end]); err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+	}
+	if d := ts[1].Sub(ts[0]); d < 250*time.Millisecond {
+		t.Errorf("lines written %s apart, want about 300ms", d)
+	}
+}
+
+func TestOutputTimestampFormat(t *testing.T) {
+	tests := []struct {
+		config, want string
+	}{
+		{`{"command": "a.exe"}`, defaultLogTimestampFormat},
+		{`{"command": "a.exe", "log_timestamp_format": "15:04:05"}`, "15:04:05"},
+		{`{"command": "a.exe", "output_prefix_timestamp": false}`, ""},
+	}
+	for _, tt := range tests {
+		s := loadedService(t, tt.config)
+		if got := s.outputTimestampFormat(); got != tt.want {
+			t.Errorf("%s: outputTimestampFormat = %q, want %q", tt.config, got, tt.want)
+		}
+	}
+}
+
+func TestRunCommandTimestamps(t *testing.T) {
+	var out bytes.Buffer
+	code, _, err := runCommandWithTimeout(`cmd.exe /c "echo first& echo second"`, 0,
+		execOptions{Output: &out, TimestampFormat: defaultLogTimestampFormat})
+	if err != nil || code != 0 {
+		t.Fatalf("exit code %d, %v", code, err)
+	}
+	stamp := regexp.MustCompile(`^\[\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\] (first|second) ?$`)
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("output %q, want two lines", out.String())
+	}
+	for _, line := range lines {
+		if !stamp.MatchString(line) {
+			t.Errorf("line %q has no timestamp", line)
+		}
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	tests := []struct {
+		n           int
+		writes      []string
+		wantLines   []string
+		wantDropped int
+	}{
+		{3, nil, nil, 0},
+		{3, []string{"a\n", "b\n"}, []string{"a", "b"}, 0},
+		{3, []string{"a\nb\nc\n"}, []string{"a", "b", "c"}, 0},
+		{3, []string{"a\nb\nc\nd\ne\n"}, []string{"c", "d", "e"}, 2},
+		{3, []string{"a\r\nb\r\n"}, []string{"a", "b"}, 0},
+		{3, []string{"par", "tial\nnext"}, []string{"partial", "next"}, 0},
+		{2, []string{"a\nb\nunfinished"}, []string{"b", "unfinished"}, 1},
+		{1, []string{"a\n", "b\n", "c\n"}, []string{"c"}, 2},
+	}
+	for _, tt := range tests {
+		tb := newTailBuffer(tt.n)
+		for _, w := range tt.writes {
+			tb.Write([]byte(w))
+		}
+		got := tb.Lines()
+		if strings.Join(got, "|") != strings.Join(tt.wantLines, "|") || len(got) != len(tt.wantLines) {
+			t.Errorf("n=%d, writes %q: Lines = %q, want %q", tt.n, tt.writes, got, tt.wantLines)
+		}
+		if d := tb.Dropped(); d != tt.wantDropped {
+			t.Errorf("n=%d, writes %q: Dropped = %d, want %d", tt.n, tt.writes, d, tt.wantDropped)
+		}
+	}
+}
+
+// tailBuffer 不能保留调用者的缓冲区：写入后缓冲区被复用时内容不变
+func TestTailBufferCopies(t *testing.T) {
+	tb := newTailBuffer(2)
+	buf := []byte("first\nsec")
+	tb.Write(buf)
+	copy(buf, "XXXXXXXXXX")
+	tb.Write([]byte("ond\n"))
+	if got := tb.Lines(); strings.Join(got, "|") != "first|second" {
+		t.Errorf("Lines = %q", got)
+	}
+}
+
+func TestShutdownFailureOutput(t *testing.T) {
+	command, _ := helperCommandLine(t, "lines")
+	t.Setenv(testHelperEnv, "lines")
+	tests := []struct {
+		lines, exitCode, keep int
+		want                  string // 空表示日志中没有输出末尾
+	}{
+		{30, 1, 5, "--- Last 5 lines of output ---|line 26|line 27|line 28|line 29|line 30|--- End of output ---"},
+		{3, 1, 5, "--- Last 3 lines of output ---|line 1|line 2|line 3|--- End of output ---"},
+		{30, 0, 5, ""},
+		{30, 1, 0, ""},
+	}
+	for _, tt := range tests {
+		cmdline, _ := json.Marshal(fmt.Sprintf("%s %d %d", command, tt.lines, tt.exitCode))
+		s := loadedService(t, fmt.Sprintf(`{"command": %s, "failure_output_lines": %d}`, cmdline, tt.keep))
+		s.interactive = true
+		log := shutdownLog(t, s)
+
+		// 只取 logLine 写出的内容，去掉时间戳和运行 ID
+		var tail []string
+		in := false
+		for _, l := range strings.Split(log, "\n") {
+			_, msg, ok := strings.Cut(l, "] [run:")
+			if !ok {
+				continue
+			}
+			_, msg, _ = strings.Cut(msg, "] ")
+			in = in || strings.HasPrefix(msg, "--- Last ")
+			if in {
+				tail = append(tail, msg)
+			}
+			in = in && msg != "--- End of output ---"
+		}
+		if got := strings.Join(tail, "|"); got != tt.want {
+			t.Errorf("%d lines, exit code %d, failure_output_lines %d: tail in log = %q, want %q",
+				tt.lines, tt.exitCode, tt.keep, got, tt.want)
+		}
+	}
+}
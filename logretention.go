@@ -0,0 +1,187 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	retentionCount = "count"
+	retentionSize  = "size_mb"
+	retentionAge   = "age_days"
+	retentionAll   = "all"
+)
+
+// -------------------- 日志保留策略 --------------------
+
+// LogRetentionPolicy 决定轮换时删除哪些旧日志。count / size_mb / age_days 模式
+// 只按一种规则、上限为 value；all 模式同时应用 count、size_mb、age_days 中非零的各项。
+// 没有配置时由 log_count 和 max_log_dir_size_mb 得出，等同于旧版本的行为。
+type LogRetentionPolicy struct {
+	Mode  string `json:"mode"`
+	Value int    `json:"value,omitempty"`
+
+	// 只用于 all 模式，0 = 该项不限制
+	Count   int `json:"count,omitempty"`
+	SizeMB  int `json:"size_mb,omitempty"`
+	AgeDays int `json:"age_days,omitempty"`
+}
+
+// logRetention 是生效的保留规则，各项为 0 表示不限制
+type logRetention struct {
+	count    int
+	maxBytes int64
+	maxAge   time.Duration
+}
+
+// resolveLogRetention 校验 log_retention_policy 并得出 cfg.logRetention。
+// 需要在 log_count 填入默认值之后调用。
+func (cfg *Config) resolveLogRetention() error {
+	p := cfg.LogRetentionPolicy
+	if p == nil {
+		cfg.logRetention = logRetention{count: *cfg.LogCount, maxBytes: int64(cfg.MaxLogDirSizeMB) << 20}
+		return nil
+	}
+
+	if p.Mode != retentionAll && (p.Count != 0 || p.SizeMB != 0 || p.AgeDays != 0) {
+		return errors.New("log_retention_policy: count, size_mb and age_days are only used with mode \"all\"")
+	}
+	if p.Mode != retentionAll && p.Value <= 0 {
+		return fmt.Errorf("log_retention_policy: invalid value %d for mode %q", p.Value, p.Mode)
+	}
+
+	var r logRetention
+	switch p.Mode {
+	case retentionCount:
+		r.count = p.Value
+	case retentionSize:
+		r.maxBytes = int64(p.Value) << 20
+	case retentionAge:
+		r.maxAge = time.Duration(p.Value) * 24 * time.Hour
+	case retentionAll:
+		if p.Count < 0 || p.SizeMB < 0 || p.AgeDays < 0 {
+			return errors.New("log_retention_policy: count, size_mb and age_days must not be negative")
+		}
+		if p.Count == 0 && p.SizeMB == 0 && p.AgeDays == 0 {
+			return errors.New("log_retention_policy: mode \"all\" needs at least one of count, size_mb, age_days")
+		}
+		r = logRetention{count: p.Count, maxBytes: int64(p.SizeMB) << 20, maxAge: time.Duration(p.AgeDays) * 24 * time.Hour}
+	case "":
+		return errors.New("log_retention_policy: mode is required")
+	default:
+		return fmt.Errorf("invalid log_retention_policy mode: %q", p.Mode)
+	}
+	cfg.logRetention = r
+	return nil
+}
+
+// describe 返回保留规则的说明，如 "7 files, 30 days"
+func (r logRetention) describe() string {
+	var parts []string
+	if r.count > 0 {
+		parts = append(parts, fmt.Sprintf("%d %s", r.count, plural(r.count, "file", "files")))
+	}
+	if r.maxBytes > 0 {
+		parts = append(parts, fmt.Sprintf("up to %d MB", r.maxBytes>>20))
+	}
+	if r.maxAge > 0 {
+		days := int(r.maxAge / (24 * time.Hour))
+		parts = append(parts, fmt.Sprintf("%d %s", days, plural(days, "day", "days")))
+	}
+	if len(parts) == 0 {
+		return "no limit"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// pruneStrategy 删除 logs（按时间升序）中超出限制的文件，返回留下的
+type pruneStrategy func(dir string, logs []fs.DirEntry) []fs.DirEntry
+
+// dirStrategies 返回对整个目录依次应用的规则：先按时间，再按总大小
+func (r logRetention) dirStrategies() []pruneStrategy {
+	var s []pruneStrategy
+	if r.maxAge > 0 {
+		s = append(s, pruneByAge(r.maxAge))
+	}
+	if r.maxBytes > 0 {
+		s = append(s, pruneBySize(r.maxBytes))
+	}
+	return s
+}
+
+// pruneLogs 按保留规则删除最早的日志。数量只在 logs（同一类日志，如关机日志、
+// 周期日志或某条命令的日志）中计算；时间和总大小按目录中全部 WinPSP 日志计算，
+// 否则各类日志合起来会超出 max_log_dir_size_mb。
+func pruneLogs(dir string, logs []fs.DirEntry, r logRetention) {
+	if r.count > 0 {
+		pruneByCount(r.count)(dir, logs)
+	}
+	strategies := r.dirStrategies()
+	if len(strategies) == 0 {
+		return
+	}
+	all, err := listAllLogFiles(dir)
+	if err != nil {
+		return
+	}
+	for _, prune := range strategies {
+		all = prune(dir, all)
+	}
+}
+
+func pruneByCount(maxCount int) pruneStrategy {
+	return func(dir string, logs []fs.DirEntry) []fs.DirEntry {
+		if len(logs) <= maxCount {
+			return logs
+		}
+		for _, e := range logs[:len(logs)-maxCount] {
+			_ = os.Remove(filepath.Join(dir, e.Name()))
+		}
+		return logs[len(logs)-maxCount:]
+	}
+}
+
+func pruneBySize(maxBytes int64) pruneStrategy {
+	return func(dir string, logs []fs.DirEntry) []fs.DirEntry {
+		sizes := make([]int64, len(logs))
+		var total int64
+		for i, e := range logs {
+			if info, err := e.Info(); err == nil {
+				sizes[i] = info.Size()
+				total += sizes[i]
+			}
+		}
+
+		i := 0
+		for ; i < len(logs) && total > maxBytes; i++ {
+			if err := os.Remove(filepath.Join(dir, logs[i].Name())); err == nil {
+				total -= sizes[i]
+			}
+		}
+		return logs[i:]
+	}
+}
+
+// pruneByAge 删除最后修改时间早于 maxAge 之前的日志
+func pruneByAge(maxAge time.Duration) pruneStrategy {
+	return func(dir string, logs []fs.DirEntry) []fs.DirEntry {
+		cutoff := time.Now().Add(-maxAge)
+		var kept []fs.DirEntry
+		for _, e := range logs {
+			if info, err := e.Info(); err == nil && info.ModTime().Before(cutoff) {
+				if os.Remove(filepath.Join(dir, e.Name())) == nil {
+					continue
+				}
+			}
+			kept = append(kept, e)
+		}
+		return kept
+	}
+}
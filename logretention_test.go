@@ -0,0 +1,170 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+func TestResolveLogRetention(t *testing.T) {
+	tests := []struct {
+		name, json string
+		want       logRetention
+		wantErr    string
+	}{
+		{"default", `{"command": "a.exe"}`, logRetention{count: defaultLogCount}, ""},
+		{"legacy fields", `{"command": "a.exe", "log_count": 5, "max_log_dir_size_mb": 100}`, logRetention{count: 5, maxBytes: 100 << 20}, ""},
+		{"count", `{"command": "a.exe", "log_retention_policy": {"mode": "count", "value": 7}}`, logRetention{count: 7}, ""},
+		{"size_mb", `{"command": "a.exe", "log_retention_policy": {"mode": "size_mb", "value": 50}}`, logRetention{maxBytes: 50 << 20}, ""},
+		{"age_days", `{"command": "a.exe", "log_retention_policy": {"mode": "age_days", "value": 30}}`, logRetention{maxAge: 30 * day}, ""},
+		{"all", `{"command": "a.exe", "log_retention_policy": {"mode": "all", "count": 30, "size_mb": 500, "age_days": 90}}`, logRetention{count: 30, maxBytes: 500 << 20, maxAge: 90 * day}, ""},
+		{"all, age only", `{"command": "a.exe", "log_retention_policy": {"mode": "all", "age_days": 14}}`, logRetention{maxAge: 14 * day}, ""},
+		{"log_count 0 with a policy", `{"command": "a.exe", "log_count": 0, "log_retention_policy": {"mode": "count", "value": 3}}`, logRetention{count: 3}, ""},
+
+		{"missing mode", `{"command": "a.exe", "log_retention_policy": {"value": 3}}`, logRetention{}, "mode is required"},
+		{"unknown mode", `{"command": "a.exe", "log_retention_policy": {"mode": "weekly", "value": 3}}`, logRetention{}, `invalid log_retention_policy mode: "weekly"`},
+		{"zero value", `{"command": "a.exe", "log_retention_policy": {"mode": "count"}}`, logRetention{}, `invalid value 0 for mode "count"`},
+		{"negative value", `{"command": "a.exe", "log_retention_policy": {"mode": "size_mb", "value": -1}}`, logRetention{}, `invalid value -1 for mode "size_mb"`},
+		{"all fields with a single mode", `{"command": "a.exe", "log_retention_policy": {"mode": "age_days", "value": 30, "count": 5}}`, logRetention{}, `only used with mode "all"`},
+		{"all without limits", `{"command": "a.exe", "log_retention_policy": {"mode": "all"}}`, logRetention{}, "needs at least one of"},
+		{"all with a negative limit", `{"command": "a.exe", "log_retention_policy": {"mode": "all", "count": 5, "age_days": -1}}`, logRetention{}, "must not be negative"},
+		{"with log_count", `{"command": "a.exe", "log_count": 5, "log_retention_policy": {"mode": "count", "value": 3}}`, logRetention{}, "not both"},
+		{"with max_log_dir_size_mb", `{"command": "a.exe", "max_log_dir_size_mb": 100, "log_retention_policy": {"mode": "count", "value": 3}}`, logRetention{}, "not both"},
+	}
+	for _, tt := range tests {
+		cfg, err := writeConfig(t, tt.json).readConfig()
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if cfg.logRetention != tt.want {
+			t.Errorf("%s: retention %+v, want %+v", tt.name, cfg.logRetention, tt.want)
+		}
+	}
+}
+
+// retentionDir 在临时目录中创建 a 到 e 五个日志（按文件名从早到晚），
+// 最后修改时间分别为 40、20、10、2、0 天前，大小为 1、1、2、1、1 MB
+func retentionDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := []struct {
+		name string
+		age  time.Duration
+		size int64
+	}{
+		{"a", 40 * day, 1 << 20},
+		{"b", 20 * day, 1 << 20},
+		{"c", 10 * day, 2 << 20},
+		{"d", 2 * day, 1 << 20},
+		{"e", time.Hour, 1 << 20},
+	}
+	for i, f := range files {
+		path := filepath.Join(dir, retentionLogName(i))
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Truncate(path, f.size); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(-f.age)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// retentionLogName 返回 retentionDir 中第 i 个日志的文件名
+func retentionLogName(i int) string {
+	return logFilePrefix + "2026101" + string(rune('0'+i)) + "-083000" + logFileExt
+}
+
+// remainingLogs 把目录中留下的日志表示为 "a b c" 这样的字母
+func remainingLogs(t *testing.T, dir string) string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, e := range entries {
+		for i := 0; i < 5; i++ {
+			if e.Name() == retentionLogName(i) {
+				left = append(left, string(rune('a'+i)))
+			}
+		}
+	}
+	return strings.Join(left, " ")
+}
+
+func TestPruneStrategies(t *testing.T) {
+	tests := []struct {
+		name  string
+		prune pruneStrategy
+		want  string
+	}{
+		{"age 30 days", pruneByAge(30 * day), "b c d e"},
+		{"age 15 days", pruneByAge(15 * day), "c d e"},
+		{"age longer than any log", pruneByAge(100 * day), "a b c d e"},
+		{"count 2", pruneByCount(2), "d e"},
+		{"count above the number of logs", pruneByCount(10), "a b c d e"},
+		{"size 4 MB", pruneBySize(4 << 20), "c d e"},
+		{"size 3 MB", pruneBySize(3 << 20), "d e"},
+		{"size above the total", pruneBySize(6 << 20), "a b c d e"},
+	}
+	for _, tt := range tests {
+		dir := retentionDir(t)
+		logs, err := listLogFiles(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kept := tt.prune(dir, logs)
+		if got := remainingLogs(t, dir); got != tt.want {
+			t.Errorf("%s: logs left %q, want %q", tt.name, got, tt.want)
+		}
+		// 返回值就是留下的日志
+		if len(kept) != len(strings.Fields(tt.want)) {
+			t.Errorf("%s: returned %d logs, want %d", tt.name, len(kept), len(strings.Fields(tt.want)))
+		}
+	}
+}
+
+func TestPruneLogs(t *testing.T) {
+	tests := []struct {
+		name      string
+		retention logRetention
+		want      string
+	}{
+		{"no limit", logRetention{}, "a b c d e"},
+		{"count", logRetention{count: 3}, "c d e"},
+		{"age", logRetention{maxAge: 30 * day}, "b c d e"},
+		{"size", logRetention{maxBytes: 4 << 20}, "c d e"},
+		// 数量删去 a，时间删去 b，剩下 c d e 共 4 MB，大小再删去 c
+		{"all", logRetention{count: 4, maxAge: 15 * day, maxBytes: 3 << 20}, "d e"},
+		{"all, size not reached", logRetention{count: 4, maxAge: 15 * day, maxBytes: 4 << 20}, "c d e"},
+	}
+	for _, tt := range tests {
+		dir := retentionDir(t)
+		logs, err := listLogFiles(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pruneLogs(dir, logs, tt.retention)
+		if got := remainingLogs(t, dir); got != tt.want {
+			t.Errorf("%s: logs left %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
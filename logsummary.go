@@ -0,0 +1,104 @@
+//go:build windows
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -------------------- --export-log-summary --------------------
+
+// 不启用 history_db_path 时，日志文件本身就是运行历史。
+// --export-log-summary 扫描日志目录，从每个日志中提取运行结果，
+// 按开始时间倒序输出 JSON 数组。
+
+// logSummary 是一个日志文件对应的一次运行
+type logSummary struct {
+	Start      time.Time `json:"start"`       // 来自文件名
+	DurationMs int64     `json:"duration_ms"` // 文件名时间到文件最后修改时间
+	ExitCode   *int      `json:"exit_code"`   // 最后一个 "Exit code:" 行，没有时为 null
+	TimedOut   bool      `json:"timed_out"`   // 出现过 "Timeout after" 行
+	Skipped    bool      `json:"skipped"`     // 出现过 "Skipping:" 行，命令没有执行
+	LogPath    string    `json:"log_path"`
+}
+
+// exportLogSummary 把目录中日志的摘要写到 w，limit > 0 时只输出最近的 limit 个
+func exportLogSummary(dir string, limit int, w io.Writer) error {
+	logs, err := listLogFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	summaries := []logSummary{}
+	for i := len(logs) - 1; i >= 0; i-- {
+		if limit > 0 && len(summaries) >= limit {
+			break
+		}
+		sum, ok := summarizeLog(filepath.Join(dir, logs[i].Name()))
+		if ok {
+			summaries = append(summaries, sum)
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(summaries)
+}
+
+// summarizeLog 解析一个日志文件；文件名不是标准格式或无法读取时返回 false
+func summarizeLog(path string) (logSummary, bool) {
+	name := filepath.Base(path)
+	ts := strings.TrimSuffix(strings.TrimPrefix(name, logFilePrefix), logFileExt)
+	start, err := time.ParseInLocation(logTimestampLayout, ts, time.Local)
+	if err != nil {
+		return logSummary{}, false
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return logSummary{}, false
+	}
+	defer f.Close()
+
+	sum := logSummary{Start: start, LogPath: path}
+	if info, err := f.Stat(); err == nil && info.ModTime().After(start) {
+		sum.DurationMs = info.ModTime().Sub(start).Milliseconds()
+	}
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		// WinPSP 写的行形如 "[时间戳] 内容"
+		line := sc.Text()
+		if !strings.HasPrefix(line, "[") {
+			continue
+		}
+		_, msg, ok := strings.Cut(line, "] ")
+		if !ok {
+			continue
+		}
+		if strings.HasPrefix(msg, "[run:") {
+			if _, rest, ok := strings.Cut(msg, "] "); ok {
+				msg = rest
+			}
+		}
+		switch {
+		case strings.HasPrefix(msg, "Exit code: "):
+			if code, err := strconv.Atoi(strings.TrimPrefix(msg, "Exit code: ")); err == nil {
+				sum.ExitCode = &code
+			}
+		case strings.HasPrefix(msg, "Timeout after "):
+			sum.TimedOut = true
+		case strings.HasPrefix(msg, "Skipping: "):
+			sum.Skipped = true
+		}
+	}
+	return sum, true
+}
@@ -0,0 +1,125 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// logSummaryFixtures 是测试用的日志目录内容：文件名 → 内容
+var logSummaryFixtures = map[string]string{
+	"winpsp-20261012-083000.log": "[2026-10-12 08:30:00] Running: C:\\Tools\\backup.exe\n" +
+		"[2026-10-12 08:31:30] Exit code: 0\n",
+	"winpsp-20261013-083000.log": "[2026-10-13 08:30:00] [run:1a2b3c4d] Running: C:\\Tools\\backup.exe\n" +
+		"[2026-10-13 08:35:00] [run:1a2b3c4d] Timeout after 300 seconds\n",
+	// 重试：以最后一个 Exit code 为准；命令自己输出的 "Exit code:" 不算
+	"winpsp-20261014-083000.log": "[2026-10-14 08:30:00] Running: sync.bat\r\n" +
+		"Exit code: 7\r\n" +
+		"[2026-10-14 08:30:05] Exit code: 1\r\n" +
+		"[2026-10-14 08:30:10] Exit code: 0\r\n",
+	"winpsp-20261014-090000.log": "[2026-10-14 09:00:00] Skipping: battery below min_battery_percent\n",
+	// 不是关机日志，或者文件名不是标准格式
+	"winpsp-periodic-20261014-100000.log": "[2026-10-14 10:00:00] Exit code: 5\n",
+	"winpsp-backup.log":                   "[2026-10-14 11:00:00] Exit code: 6\n",
+	"winpsp.json":                         "{}",
+}
+
+func writeLogSummaryFixtures(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range logSummaryFixtures {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 最后修改时间决定 duration_ms
+	start, _ := time.ParseInLocation(logTimestampLayout, "20261012-083000", time.Local)
+	if err := os.Chtimes(filepath.Join(dir, "winpsp-20261012-083000.log"), start, start.Add(90*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestExportLogSummary(t *testing.T) {
+	dir := writeLogSummaryFixtures(t)
+	type want struct {
+		log      string
+		exitCode *int
+		timedOut bool
+		skipped  bool
+	}
+	all := []want{
+		{"winpsp-20261014-090000.log", nil, false, true},
+		{"winpsp-20261014-083000.log", intPtr(0), false, false},
+		{"winpsp-20261013-083000.log", nil, true, false},
+		{"winpsp-20261012-083000.log", intPtr(0), false, false},
+	}
+	tests := []struct {
+		limit int
+		want  []want
+	}{
+		{0, all},
+		{2, all[:2]},
+		{10, all},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if err := exportLogSummary(dir, tt.limit, &out); err != nil {
+			t.Fatalf("limit %d: %v", tt.limit, err)
+		}
+		var got []logSummary
+		if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+			t.Fatalf("limit %d: output is not a JSON array: %v\n%s", tt.limit, err, out.String())
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("limit %d: %d runs, want %d:\n%s", tt.limit, len(got), len(tt.want), out.String())
+		}
+		for i, w := range tt.want {
+			g := got[i]
+			if filepath.Base(g.LogPath) != w.log || g.TimedOut != w.timedOut || g.Skipped != w.skipped ||
+				(g.ExitCode == nil) != (w.exitCode == nil) || (g.ExitCode != nil && *g.ExitCode != *w.exitCode) {
+				t.Errorf("limit %d: run %d = %+v, want %+v", tt.limit, i+1, g, w)
+			}
+			if i > 0 && !g.Start.Before(got[i-1].Start) {
+				t.Errorf("limit %d: run %d starts at %s, not before the previous run", tt.limit, i+1, g.Start)
+			}
+		}
+	}
+}
+
+func TestSummarizeLogFields(t *testing.T) {
+	dir := writeLogSummaryFixtures(t)
+	sum, ok := summarizeLog(filepath.Join(dir, "winpsp-20261012-083000.log"))
+	if !ok {
+		t.Fatal("summarizeLog rejected a standard log name")
+	}
+	start := time.Date(2026, 10, 12, 8, 30, 0, 0, time.Local)
+	if !sum.Start.Equal(start) || sum.DurationMs != 90000 {
+		t.Errorf("start %s, duration %d ms, want %s and 90000 ms", sum.Start, sum.DurationMs, start)
+	}
+
+	for _, name := range []string{"winpsp-backup.log", "winpsp-20261014-083000.txt"} {
+		if _, ok := summarizeLog(filepath.Join(dir, name)); ok {
+			t.Errorf("summarizeLog(%s) succeeded", name)
+		}
+	}
+	if _, ok := summarizeLog(filepath.Join(dir, "winpsp-20261015-083000.log")); ok {
+		t.Error("summarizeLog succeeded for a missing file")
+	}
+}
+
+func TestExportLogSummaryEmpty(t *testing.T) {
+	var out bytes.Buffer
+	if err := exportLogSummary(t.TempDir(), 0, &out); err != nil {
+		t.Fatal(err)
+	}
+	if got := bytes.TrimSpace(out.Bytes()); string(got) != "[]" {
+		t.Errorf("summary of an empty directory = %s, want []", got)
+	}
+}
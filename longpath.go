@@ -0,0 +1,36 @@
+//go:build windows
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// -------------------- 长路径 --------------------
+
+// os 包会给超过 MAX_PATH 的绝对路径自动加 \\?\ 前缀，所以 os.OpenFile、
+// os.MkdirAll、os.Remove、filepath.Glob 等不需要处理；但相对路径不会被处理，
+// 直接调用的 Win32 API（如 GetDiskFreeSpaceEx）也不会。
+
+// longPathThreshold 与 os 包相同：目录路径还要给 8.3 文件名留出余量
+const longPathThreshold = 248
+
+// longPath 把 p 转成绝对路径，过长时加上 \\?\（UNC 路径为 \\?\UNC\）前缀，
+// 供直接调用 Win32 API 的地方使用。较短的路径原样返回（除了转成绝对路径）。
+func longPath(p string) string {
+	if strings.HasPrefix(p, `\\?\`) || strings.HasPrefix(p, `\\.\`) {
+		return p
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	if len(abs) < longPathThreshold {
+		return abs
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
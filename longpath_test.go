@@ -0,0 +1,81 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLongPath(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat(`folder\`, 40) + "winpsp.log"
+	tests := []struct {
+		in, want string
+	}{
+		{`C:\ProgramData\WinPSP\winpsp.json`, `C:\ProgramData\WinPSP\winpsp.json`},
+		{`C:\ProgramData\WinPSP\..\WinPSP-Oracle`, `C:\ProgramData\WinPSP-Oracle`},
+		{"winpsp.json", filepath.Join(cwd, "winpsp.json")},
+		{`\\?\C:\ProgramData`, `\\?\C:\ProgramData`},
+		{`\\.\pipe\winpsp`, `\\.\pipe\winpsp`},
+		{`C:\` + long, `\\?\C:\` + long},
+		{`\\backup\share\` + long, `\\?\UNC\backup\share\` + long},
+	}
+	for _, tt := range tests {
+		if got := longPath(tt.in); got != tt.want {
+			t.Errorf("longPath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// deepDir 在临时目录下创建总长超过 300 个字符的目录链
+func deepDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for len(dir) <= 300 {
+		dir = filepath.Join(dir, strings.Repeat("d", 50))
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestLongPathLogFile(t *testing.T) {
+	dir := deepDir(t)
+	path := filepath.Join(dir, "winpsp.json")
+	if err := os.WriteFile(path, []byte(`{"command": "cmd.exe /c echo deep", "log_count": 2}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &winpspService{configPath: path, interactive: true}
+	if err := s.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 运行三次，log_count 为 2：轮换要能列出并删除长路径下的日志
+	for i := 1; i <= 3; i++ {
+		if err := s.handleShutdownOnce(); err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+	}
+	logs, err := filepath.Glob(filepath.Join(dir, logFilePrefix+"*"+logFileExt))
+	if err != nil || len(logs) == 0 || len(logs) > 2 {
+		t.Fatalf("logs in %s: %q, %v, want 1 or 2", dir, logs, err)
+	}
+	data, err := os.ReadFile(logs[len(logs)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "] deep") {
+		t.Errorf("log does not contain the command output:\n%s", data)
+	}
+
+	if _, err := getDiskFreeSpace(dir); err != nil {
+		t.Errorf("getDiskFreeSpace on a %d character path: %v", len(dir), err)
+	}
+}
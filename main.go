@@ -10,34 +10,314 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"math/rand"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	"golang.org/x/sys/windows/svc"
 )
 
 const (
-	serviceName        = "WinPSP"
-	defaultConfigPath  = `C:\ProgramData\WinPSP\config.json`
+	defaultServiceName = "WinPSP"
+	programDataDir     = `C:\ProgramData`
 	defaultLogCount    = 7
 	defaultTimeoutSecs = 300 // 5 minutes
 	logFilePrefix      = "winpsp-"
+	periodicLogPrefix  = "winpsp-periodic-" // periodic_command 的日志，见 periodic.go
 	logFileExt         = ".log"
+	logTimestampLayout = "20060102-150405" // 日志文件名中的时间
+
+	defaultRetryDelaySecs    = 5
+	defaultRetryMaxDelaySecs = 60
+
+	defaultOnSuccessTimeoutSecs = 60
+	defaultHookTimeoutSecs      = 60
+	defaultStopGracePeriodSecs  = 30
+
+	exitCodeEnvVar = "WINPSP_EXIT_CODE" // post_hook_command 中主命令的退出码
+
+	startupWaitHintSlack = 10 * time.Second // 启动延迟期间报告给 SCM 的 WaitHint 余量
+
+	// pre_shutdown_notification_delay_ms 期间每隔这么久向 SCM 报告一次进度
+	preShutdownCheckpointInterval = 2 * time.Second
+
+	// 本地配置缺失或无效时，服务每隔这么久重新加载一次
+	configRetryInterval = 60 * time.Second
+
+	lastRunFileName = "winpsp-last-run.json"
+
+	// 命令超时被取消后，额外等待输出管道关闭的时间
+	outputDrainTimeout = 1 * time.Second
+
+	// 退出前等待异步事件日志写入的最长时间
+	eventFlushTimeout = 2 * time.Second
+)
+
+// 运行结果（写入运行记录的 result 字段）
+const (
+	resultSuccess            = "success"
+	resultSuccessByAllowlist = "success_by_allowlist"
+	resultFailure            = "failure"
+	resultTimeout            = "timeout"
+)
+
+// 重试间隔策略
+const (
+	retryStrategyFixed       = "fixed"
+	retryStrategyLinear      = "linear"
+	retryStrategyExponential = "exponential"
+)
+
+// 服务名和默认配置路径取决于 --service-name，见 install.go
+var (
+	serviceName       = defaultServiceName
+	defaultConfigPath = instanceConfigPath(defaultServiceName)
 )
 
 type Config struct {
-	Command  string `json:"command"`
-	LogCount *int   `json:"log_count"`
-	Timeout  *int   `json:"timeout"` // seconds
+	SchemaVersion int `json:"schema_version,omitempty"` // 配置格式版本，缺省 = 1，见 migrate.go
+
+	CommandEntry                          // 单命令配置：command / timeout（总预算）/ name
+	DeadlineWarningPercent *int           `json:"deadline_warning_percent"` // 用掉超时的这个比例时警告，0 = 不警告，见 deadlinewarn.go
+	Commands               []CommandEntry `json:"commands"`                 // 多条命令，按顺序执行，见 commands.go
+
+	CommandEnvVar string `json:"command_env_var"` // 从这个环境变量读取 command，变量为空时使用配置中的 command
+	RequireAdmin  bool   `json:"require_admin"`   // 检查 .exe 的清单是否要求管理员权限，见 uacmanifest.go
+
+	// 调用 WMI 方法代替命令，退出码取 ReturnValue，见 wmi.go
+	WMINamespace string            `json:"wmi_namespace"`
+	WMIClass     string            `json:"wmi_class"` // 类名或对象路径，如 Msvm_ComputerSystem.Name="..."
+	WMIMethod    string            `json:"wmi_method"`
+	WMIArgs      map[string]string `json:"wmi_args"` // 按参数的 CIM 类型转换
+
+	// commands 改为管道执行：上一条的 stdout 接到下一条的 stdin，见 pipeline.go
+	Pipeline         bool   `json:"pipeline"`
+	PipelineFailFast bool   `json:"pipeline_fail_fast"` // 退出码取第一条失败的命令，而不是最后一条
+	PerCommandLogs   bool   `json:"per_command_logs"`   // 每条命令的输出写入单独的日志，见 commandlog.go
+	LogCount         *int   `json:"log_count"`
+	LogWriteMode     string `json:"log_write_mode"` // buffered / direct，见 directlog.go
+
+	MaxLogDirSizeMB int `json:"max_log_dir_size_mb"` // 日志文件总大小上限，超出时删除最早的日志，0 = 不限制
+
+	// 代替 log_count / max_log_dir_size_mb 的保留策略，见 logretention.go
+	LogRetentionPolicy *LogRetentionPolicy `json:"log_retention_policy"`
+	logRetention       logRetention        // 生效的保留规则，由 readConfig 填写
+
+	LogSectionMarkers *bool  `json:"log_section_markers"` // 每条命令的输出前后加 START / END 标记
+	LogSectionFormat  string `json:"log_section_format"`  // plain / json
+
+	RetryCount         int    `json:"retry_count"`          // 失败后额外重试次数，0 = 不重试
+	RetryDelaySecs     *int   `json:"retry_delay_secs"`     // 基础重试间隔
+	RetryDelayStrategy string `json:"retry_delay_strategy"` // fixed / linear / exponential
+	RetryMaxDelaySecs  *int   `json:"retry_max_delay_secs"` // exponential 的间隔上限
+
+	SuccessExitCodes []int `json:"success_exit_codes"` // 视为成功的退出码，默认 [0]
+
+	GracePeriodSecs *int `json:"grace_period_secs"` // 超时后先发 Ctrl+Break，等待多久再强制终止
+
+	CommandBaseDir string `json:"command_base_dir"` // 命令中相对路径的基准目录，默认为配置文件所在目录
+	CommandHidden  *bool  `json:"command_hidden"`   // 不为命令创建控制台窗口；服务模式默认 true，交互模式默认 false
+	IntegrityLevel string `json:"integrity_level"`  // low / medium / high / system，见 integrity.go；空 = 与服务相同
+
+	// command 为脚本时的解释器参数，见 interpreter.go
+	PSExecutionPolicy string   `json:"ps_execution_policy"` // .ps1 的 PowerShell 执行策略
+	CmdExtraArgs      []string `json:"cmd_extra_args"`      // .bat / .cmd：加在 cmd.exe /C 之前，如 /V:ON
+
+	OutputEncoding        string `json:"output_encoding"`         // 命令输出编码：auto / utf8 / utf16le / utf16be / cp1252，见 encoding.go
+	OutputCodepage        int    `json:"output_codepage"`         // 执行命令时的控制台代码页，如 65001，0 = 不修改
+	OutputPrefixTimestamp *bool  `json:"output_prefix_timestamp"` // 命令输出每行加时间戳，默认 true
+	FailureOutputLines    *int   `json:"failure_output_lines"`    // 失败时在日志末尾重复输出的最后几行，0 = 不输出
+	MaxOutputLines        int    `json:"max_output_lines"`        // 每条命令只在日志中保留最后 N 行输出，0 = 不限，见 maxlines.go
+	LogTimestampFormat    string `json:"log_timestamp_format"`    // 日志时间戳格式（Go 时间布局）
+	LogDedupWindow        int    `json:"log_dedup_window"`        // seconds；合并此时间内连续重复的输出行，0 = 不合并
+
+	// 子进程的附加环境变量，见 envfile.go
+	Env             map[string]string `json:"env"`
+	EnvFile         string            `json:"env_file"`
+	EnvFileRequired bool              `json:"env_file_required"`
+	EnvInherit      *bool             `json:"env_inherit"` // 继承 WinPSP 的环境，默认 true；false 时只传入 env_file、env 和少数系统变量
+	envFileVars     map[string]string // 加载时从 env_file 读入
+
+	// 配置中 "secret:KEY" 形式的值在加载时从外部读取，见 secrets.go
+	SecretsBackend string   `json:"secrets_backend"` // env / file:<path> / vault:<url>，空 = 不替换
+	VaultToken     string   `json:"vault_token"`
+	secretRefs     []func() // 恢复被替换字段的原文，导出配置时使用
+
+	configHash string // 配置文件内容的哈希，见 runid.go
+
+	// 服务启动、停止时写入事件日志的消息模板，见 auditmessage.go
+	OnStartMessage string             `json:"on_start_message"`
+	OnStopMessage  string             `json:"on_stop_message"`
+	onStartMessage *template.Template // 加载时解析
+	onStopMessage  *template.Template
+
+	// 运行结束后把日志上传到 S3 兼容存储（s3_bucket 为空则不上传）
+	S3Endpoint          string `json:"s3_endpoint"`
+	S3Region            string `json:"s3_region"`
+	S3Bucket            string `json:"s3_bucket"`
+	S3KeyPrefix         string `json:"s3_key_prefix"`
+	S3AccessKeyID       string `json:"s3_access_key_id"`
+	S3SecretAccessKey   string `json:"s3_secret_access_key"`
+	S3SessionToken      string `json:"s3_session_token"` // STS 临时凭据的会话令牌
+	S3UploadTimeoutSecs *int   `json:"s3_upload_timeout_secs"`
+
+	// 运行结束后把日志复制到网络共享（archive_unc_path 为空则不复制），见 archive.go
+	ArchiveUNCPath     string `json:"archive_unc_path"`
+	ArchiveTimeoutSecs *int   `json:"archive_timeout_secs"`
+
+	// 把命令输出实时转发到命名管道（如 \\.\pipe\WinPSP-output），见 pipe.go
+	OutputPipeName             string `json:"output_pipe_name"`
+	OutputPipeConnectTimeoutMs *int   `json:"output_pipe_connect_timeout_ms"`
+
+	// 子进程资源限制（Job Object），0 = 不限制，见 jobobject.go
+	JobMemoryLimitMB  int `json:"job_memory_limit_mb"`
+	JobCPURatePercent int `json:"job_cpu_rate_percent"`
+
+	HistoryDBPath string `json:"history_db_path"` // SQLite 运行历史，见 history.go
+
+	// 追加到 gzip 压缩的 JSON Lines 运行历史，见 historygz.go
+	RunHistoryGzip bool `json:"run_history_gzip"`
+	HistoryMaxMB   *int `json:"history_max_mb"` // 超过后轮换
+
+	ConfigCheckIntervalSecs int `json:"config_check_interval_secs"` // 远程配置检查间隔，见 remoteconfig.go
+
+	ConfigReloadBackoff *ConfigReloadBackoff `json:"config_reload_backoff"` // --reload 失败后重试，见 reloadbackoff.go
+
+	// 执行命令前创建卷影副本，路径通过 %WINPSP_VSS_SHADOW% 传给命令，见 vss.go
+	VSSQuiesce bool   `json:"vss_quiesce"`
+	VSSVolume  string `json:"vss_volume"`
+
+	// 命令结束后删除的临时文件（glob），见 cleanup.go
+	CleanupPatterns      []string `json:"cleanup_patterns"`
+	CleanupOnSuccessOnly bool     `json:"cleanup_on_success_only"` // true：命令成功时不清理（临时文件是输出的一部分）
+
+	// 命令运行期间定期更新修改时间的文件，供外部监控判断是否卡住，见 heartbeat.go
+	HeartbeatFile         string `json:"heartbeat_file"`
+	HeartbeatIntervalSecs *int   `json:"heartbeat_interval_secs"`
+
+	// 安装时声明的服务依赖，运行时不使用，见 --install --depends-on
+	ServiceDependencies []string `json:"service_dependencies"`
+
+	StartupDelaySecs int `json:"startup_delay_secs"` // 服务启动后等待多久才开始接受 PreShutdown
+
+	// 收到 PreShutdown 后等待多久才开始执行，让其他服务先完成各自的关机
+	PreShutdownDelayMS int `json:"pre_shutdown_notification_delay_ms"`
+
+	// 服务进入 Running 后在后台执行一次的命令，见 startup.go
+	StartupCommand         string `json:"startup_command"`
+	StartupTimeout         *int   `json:"startup_timeout"`          // seconds
+	StartupCommandRequired bool   `json:"startup_command_required"` // true：失败时停止服务
+
+	// 服务运行期间定时执行的命令，见 periodic.go
+	PeriodicCommand      string `json:"periodic_command"`
+	PeriodicIntervalSecs *int   `json:"periodic_interval_secs"`
+	MaxPeriodicRuns      int    `json:"max_periodic_runs"`      // 服务每次启动后最多执行几次，0 = 不限
+	StopGracePeriodSecs  *int   `json:"stop_grace_period_secs"` // 关机处理进行中收到 Stop 时最多等待多久
+
+	// 全局互斥量被占用时的等待时间和超时后的处理，见 mutex.go
+	MutexWaitMs *int   `json:"mutex_wait_ms"`
+	MutexAction string `json:"mutex_action"` // proceed / abort
+
+	// 主命令成功后执行的附加命令，结果只记录不影响结论
+	OnSuccessCommand string `json:"on_success_command"`
+	OnSuccessTimeout *int   `json:"on_success_timeout"` // seconds
+
+	// 主命令前后执行的钩子命令，超时各自独立，不计入 timeout；
+	// post_hook_command 通过 WINPSP_EXIT_CODE 得到主命令的退出码
+	PreHookCommand  string `json:"pre_hook_command"`
+	PreHookTimeout  *int   `json:"pre_hook_timeout"`  // seconds
+	PreHookRequired bool   `json:"pre_hook_required"` // true：pre_hook_command 失败时不执行主命令
+	PostHookCommand string `json:"post_hook_command"`
+	PostHookTimeout *int   `json:"post_hook_timeout"` // seconds
+
+	MinUptimeSecs      int `json:"min_uptime_secs"`       // 开机不足这么久就关机时不执行命令，0 = 不检查
+	MinRunIntervalSecs int `json:"min_run_interval_secs"` // 距上次运行不足这么久时不再执行，0 = 不检查
+	IdleMinMinutes     int `json:"idle_min_minutes"`      // 关机前无键盘鼠标输入不足这么久时不执行，0 = 不检查，见 idle.go
+
+	FallbackCommand string `json:"fallback_command"` // 执行前检查不满足时改为执行的命令，见 preflight.go
+
+	// 目标盘可用空间不足时不执行 command，见 preflight.go
+	RequireFreeDiskBytes uint64 `json:"require_free_disk_bytes"`
+	RequireFreeDiskPath  string `json:"require_free_disk_path"`
+
+	// 网络目标不可达时跳过或改为执行 fallback_command，见 preflight.go
+	RequireNetwork       bool   `json:"require_network"`
+	RequireNetworkHost   string `json:"require_network_host"`
+	RequireNetworkPort   *int   `json:"require_network_port"`
+	RequireNetworkAction string `json:"require_network_action"` // skip / fallback
+
+	// 电池供电时跳过命令，见 power.go
+	SkipOnBattery             bool `json:"skip_on_battery"`
+	SkipOnBatteryBelowPercent int  `json:"skip_on_battery_below_percent"` // 只在电量低于此值时跳过
+
+	// 运行结束后发送 StatsD 指标，见 statsd.go
+	StatsDAddress string  `json:"statsd_address"` // host:port，空 = 不发送
+	StatsDPrefix  *string `json:"statsd_prefix"`
+
+	// 远程配置、webhook、S3 上传和 Vault 共用的 TLS 设置，见 tls.go
+	TLSCertFile           string `json:"tls_cert_file"`
+	TLSKeyFile            string `json:"tls_key_file"`
+	TLSCAFile             string `json:"tls_ca_file"`
+	TLSInsecureSkipVerify bool   `json:"tls_insecure_skip_verify"`
+
+	// 运行结束后把结果 POST 到 webhook，见 webhook.go
+	WebhookURL                 string `json:"webhook_url"`
+	WebhookToken               string `json:"webhook_token"`
+	WebhookTokenCredentialName string `json:"webhook_token_credential_name"` // 从凭据管理器读取 token，见 credential.go
+	WebhookTimeoutSecs         *int   `json:"webhook_timeout_secs"`
+
+	// --simulate 时代替命令的等待时间和退出码，见 simulate.go
+	SimulateDurationMs int `json:"simulate_duration_ms"`
+	SimulateExitCode   int `json:"simulate_exit_code"`
+
+	ValidationRules []ValidationRule `json:"validation_rules"` // 字段值必须匹配的正则表达式，见 validation.go
+
+	// 按主机名选用的部分配置，见 profiles.go
+	Profiles map[string]json.RawMessage `json:"profiles"`
 }
 
 type winpspService struct {
-	configPath string
-	config     *Config
+	configPath  string
+	config      atomic.Pointer[Config] // 当前配置，nil = 缺失或无效；重新加载时整体替换，读取方不会看到加载了一半的配置
+	configErr   error                  // 最近一次加载配置的错误，config 为 nil 时说明原因；通过 lastConfigErr 读取
+	configErrMu sync.Mutex             // 保护 configErr：重新加载与关机处理在不同的 goroutine 中
+	remote      *remoteConfig          // 远程配置源，nil 表示只用本地文件
+	simulate    bool                   // --simulate：不启动命令，其余流程照常
+	run         runContext             // 当前这次关机处理的标识，见 runid.go
+	interactive bool                   // 在控制台中运行（而不是服务），影响 command_hidden 的默认值
+
+	// periodic_command 的执行状态，见 periodic.go
+	periodicBusy atomic.Bool
+	periodicRuns int
+}
+
+// snapshot 返回固定使用 cfg 的副本，供与 Execute 并行的后台执行使用：
+// 执行途中重新加载配置（失败时 config 为 nil）不会影响它
+func (s *winpspService) snapshot(cfg *Config) *winpspService {
+	c := &winpspService{configPath: s.configPath, simulate: s.simulate, interactive: s.interactive}
+	c.config.Store(cfg)
+	return c
+}
+
+// devMode 由带构建标签的开发工具（如 stressreload.go）设置，正式版本中为 nil。
+// 返回 true 表示已处理，程序退出。
+var devMode func() bool
+
+// 空命令视为"有意不做任何事"，与配置缺失一样安静处理
+var errEmptyCommand = errors.New("empty command in config")
+
+// configAbsent 判断加载错误是否只是"还没有配置"，而不是配置写错了
+func configAbsent(err error) bool {
+	return errors.Is(err, os.ErrNotExist) || errors.Is(err, errEmptyCommand)
 }
 
 func main() {
@@ -49,16 +329,416 @@ func main() {
 	}
 	isInteractive := !isService
 
-	// 仅保留一个参数：--test-config（布尔开关）
+	// 命令行参数
+	serviceNameFlag := flag.String("service-name", defaultServiceName,
+		"Service instance name (config directory: C:\\ProgramData\\WinPSP-<NAME>\\)")
+	installMode := flag.Bool("install", false, "Install the service")
+	var installTriggers []serviceTriggerSpec
+	flag.Func("install-trigger", "With --install: start the service on NETWORK_AVAILABLE, USB_DEVICE[=HWID] or {GUID}[=HWID] (repeatable)",
+		func(v string) error {
+			t, err := parseServiceTrigger(v)
+			if err == nil {
+				installTriggers = append(installTriggers, t)
+			}
+			return err
+		})
+	registerSourceMode := flag.Bool("auto-register-event-source", false,
+		"With --install: register the Event Log source in the registry")
+	dependsOn := flag.String("depends-on", "",
+		"With --install: comma-separated services WinPSP depends on (stopped after WinPSP at shutdown)")
+	storeTokenMode := flag.Bool("store-webhook-token", false,
+		"Read the webhook token from the console and save it in Credential Manager as webhook_token_credential_name")
+	verifyMode := flag.Bool("verify", false, "With --install: check the config and that the command can be found")
+	uninstallMode := flag.Bool("uninstall", false, "Remove the service")
+	purgeMode := flag.Bool("purge", false,
+		"With --uninstall: also delete the config directory, logs, Event Log source and stored credential")
+	forceMode := flag.Bool("force", false, "With --uninstall --purge: do not ask for confirmation")
+	startMode := flag.Bool("start", false, "Start the service")
+	stopMode := flag.Bool("stop", false, "Stop the service")
+	statusMode := flag.Bool("status", false, "Show the service state")
+	reloadMode := flag.Bool("reload", false, "Tell the running service to reload its config")
+	simulateMode := flag.Bool("simulate", false, "Run the full shutdown flow once without executing the command")
+	configSchemaMode := flag.Bool("config-schema", false, "Print a JSON Schema for the config file")
+	exportConfigMode := flag.Bool("export-config", false, "Print the effective config as JSON (optionally to the file given as argument)")
+	listInstancesMode := flag.Bool("list-instances", false, "List all installed WinPSP service instances")
+	configFlag := flag.String("config", "",
+		"Path to the config file (default: search upwards from the current directory, then "+defaultConfigPath+")")
+	noSearch := flag.Bool("no-search", false,
+		"Do not search parent directories for winpsp.json / winpsp-config.json")
+	configURL := flag.String("config-url", "",
+		"Download the config from this HTTP(S) URL (cached next to the config file)")
+	configFromStdin := flag.Bool("config-from-stdin", false,
+		"Read the config JSON from standard input instead of a file (logs go to "+filepath.Dir(defaultConfigPath)+")")
+	configURLToken := flag.String("config-url-token", "",
+		"Bearer token for --config-url")
 	testMode := flag.Bool("test-config", false,
 		"Validate config file without executing commands")
+	listProfilesMode := flag.Bool("list-profiles", false,
+		"List the profiles defined in the config file")
+	profileFlag := flag.String("profile", "",
+		"Use this profile instead of the one matching the hostname")
+	showVersion := flag.Bool("version", false,
+		"Print version and build information")
+	historyMode := flag.Bool("history", false,
+		"Show the last N runs from history_db_path or run_history_gzip: --history [N]")
+	purgeHistoryMode := flag.Bool("purge-history", false,
+		"Delete history records older than --older-than-days")
+	olderThanDays := flag.Int("older-than-days", 0,
+		"Age in days used by --purge-history")
+	logSummaryMode := flag.Bool("export-log-summary", false,
+		"Print a JSON summary of the runs found in the log files, newest first")
+	summaryLimit := flag.Int("limit", 0, "With --export-log-summary: only the newest N runs")
+	tailLogMode := flag.Bool("tail-log", false,
+		"Print the newest log file and follow it as it grows")
+	selfTestMode := flag.Bool("self-test", false,
+		"Run a built-in command, check that its output reaches the log, print PASS or FAIL")
+	watchConfigMode := flag.Bool("watch-config", false,
+		"Print the config, then print a diff and validate it each time the file changes (until Ctrl+C)")
+	describeMode := flag.Bool("describe", false,
+		"Print a plain-English description of what the config will do at shutdown")
+	testWebhookMode := flag.Bool("test-webhook", false,
+		"POST a test payload to webhook_url and print the response (exit code 1 unless 2xx)")
+	healthMode := flag.Bool("check-service-health", false,
+		"Check that the service is installed, running and correctly configured, print PASS or FAIL per check")
+	benchmarkRuns := flag.Int("benchmark", 0,
+		"Run the command N times and print timing statistics")
+	migrateMode := flag.Bool("migrate-config", false,
+		"Convert a config file to a newer format: --migrate-config [input] [output]")
+	migrateFrom := flag.String("from", "v1", "Source format for --migrate-config")
+	migrateTo := flag.String("to", "v2", "Target format for --migrate-config")
+	selfUpdateMode := flag.Bool("self-update", false,
+		"Download the latest release (or the tag given as argument) from GitHub and replace this executable")
+	restartServiceMode := flag.Bool("restart-service", false, "With --self-update: stop the running service before replacing and start it afterwards")
+	importTaskFile := flag.String("import-task", "",
+		"Convert a Task Scheduler XML file (schtasks /query /xml) to a WinPSP config: --import-task <task.xml> [output]")
 	flag.Parse()
 
+	if err := validateServiceName(*serviceNameFlag); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	serviceName = *serviceNameFlag
+	defaultConfigPath = instanceConfigPath(serviceName)
+	if *profileFlag != "" {
+		chosenProfile, profileChosen = *profileFlag, true
+	}
+
+	configPath := resolveConfigPath(*configFlag, *noSearch, isInteractive)
+	// os 包只给绝对路径自动处理超过 MAX_PATH 的情况，见 longpath.go
+	if abs, err := filepath.Abs(configPath); err == nil {
+		configPath = abs
+	}
+
+	// 标准输入的配置：之后所有功能都读取内存中的副本，日志目录为实例的默认配置目录
+	if *configFromStdin {
+		if !isInteractive || *configFlag != "" || *configURL != "" || *installMode || *watchConfigMode {
+			fmt.Println("--config-from-stdin cannot be used with --config, --config-url, --install or --watch-config")
+			os.Exit(2)
+		}
+		p, err := readStdinConfig(os.Stdin)
+		if err != nil {
+			fmt.Printf("Config error: %v\n", err)
+			os.Exit(1)
+		}
+		configPath = p
+	}
+
+	// 远程配置：之后所有功能都读取本地缓存副本
+	var remote *remoteConfig
+	if *configURL != "" {
+		remote = newRemoteConfig(*configURL, *configURLToken, filepath.Dir(configPath))
+		configPath = remote.cachePath
+	}
+
 	// -----------------------------
 	// 服务模式
 	// -----------------------------
 	if !isInteractive {
-		svc.Run(serviceName, &winpspService{configPath: defaultConfigPath})
+		svc.Run(serviceName, &winpspService{configPath: configPath, remote: remote})
+		return
+	}
+
+	if remote != nil {
+		if _, err := remote.fetch(); err != nil {
+			fmt.Printf("Remote config unavailable, using cached copy: %v\n", err)
+		}
+	}
+
+	if *showVersion {
+		fmt.Println(versionString())
+		return
+	}
+
+	// -----------------------------
+	// 交互模式：自更新
+	// -----------------------------
+	if *selfUpdateMode {
+		if err := selfUpdate(flag.Arg(0), serviceName, *restartServiceMode); err != nil {
+			fmt.Printf("Update error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// -----------------------------
+	// 交互模式：服务管理
+	// -----------------------------
+	if *installMode || *uninstallMode || *startMode || *stopMode || *statusMode || *reloadMode || *listInstancesMode {
+		var err error
+		switch {
+		case *listInstancesMode:
+			err = printInstances()
+		case *installMode:
+			// 服务启动时需要与安装时相同的配置来源。
+			// 服务的当前目录是 System32，相对的 --config 要先换成绝对路径
+			var args []string
+			if *configFlag != "" {
+				abs, aerr := filepath.Abs(*configFlag)
+				if aerr != nil {
+					fmt.Printf("Error: %v\n", aerr)
+					os.Exit(1)
+				}
+				args = append(args, "--config", abs)
+			}
+			if *profileFlag != "" {
+				args = append(args, "--profile", *profileFlag)
+			}
+			if *configURL != "" {
+				args = append(args, "--config-url", *configURL)
+				if *configURLToken != "" {
+					args = append(args, "--config-url-token", *configURLToken)
+				}
+			}
+			// 服务启动后实际读取的配置；远程配置使用刚下载的缓存
+			svcConfigPath := resolveConfigPath(*configFlag, true, false)
+			if remote != nil {
+				svcConfigPath = configPath
+			}
+			deps := installDependencies(*dependsOn, svcConfigPath)
+			err = installAndVerify(serviceName, args, installTriggers, deps, *registerSourceMode,
+				*storeTokenMode, *verifyMode, svcConfigPath)
+		case *uninstallMode:
+			var items []purgeItem
+			if *purgeMode {
+				items = purgeItems(serviceName, resolveConfigPath(*configFlag, true, false))
+				err = confirmPurge(items, *forceMode, os.Stdin)
+			}
+			if err == nil {
+				err = uninstallService(serviceName)
+			}
+			if err == nil && *purgeMode {
+				err = purge(items)
+			}
+		case *startMode:
+			err = startService(serviceName)
+		case *stopMode:
+			err = stopService(serviceName)
+		case *reloadMode:
+			err = reloadService(serviceName)
+		default:
+			err = printServiceStatus(serviceName)
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// -----------------------------
+	// 交互模式：跟踪最新日志
+	// -----------------------------
+	if *tailLogMode {
+		if err := tailLog(filepath.Dir(configPath), os.Stdout); err != nil {
+			fmt.Printf("Tail error: %v\n", err)
+		}
+		return
+	}
+
+	// -----------------------------
+	// 交互模式：日志摘要
+	// -----------------------------
+	if *logSummaryMode {
+		if err := exportLogSummary(filepath.Dir(configPath), *summaryLimit, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Log summary error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// -----------------------------
+	// 交互模式：运行历史
+	// -----------------------------
+	if *historyMode || *purgeHistoryMode {
+		cfg, err := parseConfigFile(configPath)
+		if err != nil {
+			fmt.Printf("Config error: %v\n", err)
+			return
+		}
+		dbPath := historyDBPath(cfg, configPath)
+		if dbPath == "" && (*purgeHistoryMode || !cfg.RunHistoryGzip) {
+			fmt.Println("history_db_path is not set in the config file.")
+			return
+		}
+
+		if *purgeHistoryMode {
+			n, err := purgeHistory(dbPath, *olderThanDays)
+			if err != nil {
+				fmt.Printf("History error: %v\n", err)
+				return
+			}
+			fmt.Printf("Deleted %d history records.\n", n)
+			return
+		}
+
+		n := defaultHistoryRows
+		if flag.NArg() > 0 {
+			if v, err := strconv.Atoi(flag.Arg(0)); err == nil && v > 0 {
+				n = v
+			}
+		}
+		show := printHistory
+		if dbPath == "" {
+			// 没有数据库时读 run_history_gzip 的文件
+			dbPath, show = historyGzipPath(configPath), printHistoryGzip
+		}
+		if err := show(dbPath, n); err != nil {
+			fmt.Printf("History error: %v\n", err)
+		}
+		return
+	}
+
+	// -----------------------------
+	// 交互模式：开发工具（需要额外的构建标签）
+	// -----------------------------
+	if devMode != nil && devMode() {
+		return
+	}
+
+	// -----------------------------
+	// 交互模式：自检
+	// -----------------------------
+	if *selfTestMode {
+		if !runSelfTest(configPath) {
+			fmt.Println("FAIL")
+			os.Exit(1)
+		}
+		fmt.Println("PASS")
+		return
+	}
+
+	// -----------------------------
+	// 交互模式：保存 webhook token
+	// -----------------------------
+	if *storeTokenMode {
+		if err := storeWebhookToken(configPath); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// -----------------------------
+	// 交互模式：监视配置文件
+	// -----------------------------
+	if *watchConfigMode {
+		if err := watchConfig(configPath, os.Stdout); err != nil {
+			fmt.Printf("Watch error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// -----------------------------
+	// 交互模式：配置说明
+	// -----------------------------
+	if *describeMode {
+		if !runDescribe(configPath) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// -----------------------------
+	// 交互模式：测试 webhook
+	// -----------------------------
+	if *testWebhookMode {
+		if !testWebhook(configPath) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// -----------------------------
+	// 交互模式：服务健康检查
+	// -----------------------------
+	if *healthMode {
+		if !runHealthCheck(configPath) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// -----------------------------
+	// 交互模式：性能测试
+	// -----------------------------
+	if *benchmarkRuns != 0 {
+		if err := runBenchmark(configPath, *benchmarkRuns, *simulateMode); err != nil {
+			fmt.Printf("Benchmark error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// -----------------------------
+	// 交互模式：配置文件 JSON Schema
+	// -----------------------------
+	if *configSchemaMode {
+		if err := printConfigSchema(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// -----------------------------
+	// 交互模式：导出有效配置
+	// -----------------------------
+	if *exportConfigMode {
+		if err := exportConfig(configPath, flag.Arg(0)); err != nil {
+			fmt.Fprintf(os.Stderr, "Config error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// -----------------------------
+	// 交互模式：迁移配置格式
+	// -----------------------------
+	if *migrateMode {
+		if err := migrateConfig(*migrateFrom, *migrateTo, configPath, flag.Arg(0), flag.Arg(1)); err != nil {
+			fmt.Fprintf(os.Stderr, "Migration error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// -----------------------------
+	// 交互模式：导入任务计划程序的任务
+	// -----------------------------
+	if *importTaskFile != "" {
+		if err := importTask(*importTaskFile, flag.Arg(0)); err != nil {
+			fmt.Fprintf(os.Stderr, "Import error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// -----------------------------
+	// 交互模式：列出 profile
+	// -----------------------------
+	if *listProfilesMode {
+		listProfiles(configPath)
 		return
 	}
 
@@ -66,10 +746,11 @@ func main() {
 	// 交互模式：测试配置文件
 	// -----------------------------
 	if *testMode {
-		fmt.Println("WinPSP version 0.1.2")
+		fmt.Println(versionString())
 		fmt.Println("WinPSP: Testing config file...")
+		fmt.Printf("config: %s\n", configPath)
 
-		data, err := os.ReadFile(defaultConfigPath)
+		data, err := readConfigFile(configPath)
 		if err != nil {
 			fmt.Printf("Config error: %v\n", err)
 			return
@@ -81,10 +762,27 @@ func main() {
 			return
 		}
 
+		if name := profileName(&cfg); name != "" {
+			if err := applyProfile(&cfg, name); err != nil {
+				fmt.Printf("Config error: %v\n", err)
+				return
+			}
+			if profileChosen {
+				fmt.Printf("profile: %s (--profile)\n", name)
+			} else {
+				fmt.Printf("profile: %s (matches hostname)\n", name)
+			}
+		}
+
 		// 字段检查
-		if strings.TrimSpace(cfg.Command) == "" {
+		switch {
+		case len(cfg.Commands) > 0:
+			for i := range cfg.Commands {
+				fmt.Printf("commands[%d]: %s (%s)\n", i, cfg.Commands[i].Command, cfg.Commands[i].displayName())
+			}
+		case strings.TrimSpace(cfg.Command) == "":
 			fmt.Println("command: empty → do nothing")
-		} else {
+		default:
 			fmt.Printf("command: %s\n", cfg.Command)
 		}
 
@@ -94,12 +792,26 @@ func main() {
 			fmt.Printf("timeout: %d seconds\n", *cfg.Timeout)
 		}
 
-		if cfg.LogCount == nil {
+		if p := cfg.LogRetentionPolicy; p != nil && (cfg.LogCount == nil || *cfg.LogCount != 0) {
+			fmt.Printf("log_retention_policy: %s\n", p.Mode)
+		} else if cfg.LogCount == nil {
 			fmt.Printf("log_count: default (%d files)\n", defaultLogCount)
 		} else {
 			fmt.Printf("log_count: %d files\n", *cfg.LogCount)
 		}
 
+		if cfg.RetryCount > 0 {
+			strategy := cfg.RetryDelayStrategy
+			if strategy == "" {
+				strategy = retryStrategyFixed
+			}
+			fmt.Printf("retry_count: %d (strategy: %s)\n", cfg.RetryCount, strategy)
+		}
+
+		if len(cfg.SuccessExitCodes) > 0 {
+			fmt.Printf("success_exit_codes: %v\n", cfg.SuccessExitCodes)
+		}
+
 		fmt.Println("Config test completed.")
 		return
 	}
@@ -107,10 +819,14 @@ func main() {
 	// -----------------------------
 	// 交互模式：无参数 → 执行一次
 	// -----------------------------
-	fmt.Println("WinPSP version 0.1.2")
+	fmt.Println(versionString())
 	fmt.Println("Running in interactive mode (debug).")
+	if *simulateMode {
+		fmt.Println("Simulate mode: the command will not be executed.")
+	}
 
-	s := &winpspService{configPath: defaultConfigPath}
+	chooseProfile(configPath)
+	s := &winpspService{configPath: configPath, simulate: *simulateMode, interactive: true}
 	if err := s.loadConfig(); err != nil {
 		fmt.Printf("Config error: %v\n", err)
 		fmt.Println("Nothing will be executed. Exiting.")
@@ -120,6 +836,7 @@ func main() {
 	if err := s.handleShutdownOnce(); err != nil {
 		fmt.Printf("Shutdown handler error: %v\n", err)
 	}
+	flushEvents(eventFlushTimeout)
 }
 
 // -------------------- 服务实现 --------------------
@@ -131,69 +848,669 @@ func (s *winpspService) Execute(args []string, r <-chan svc.ChangeRequest, chang
 	}
 
 	// 尝试加载配置（失败则标记为无配置模式）
-	_ = s.loadConfig()
+	// 配置缺失是新装机器的正常状态，不写事件日志；配置损坏才报告
+	if s.remote != nil {
+		s.refreshRemoteConfig()
+	} else if err := s.loadConfig(); err != nil && !configAbsent(err) {
+		writeEvent(eventError, eventIDConfigError, fmt.Sprintf("WinPSP: config error: %v", err))
+	}
+
+	// 启动延迟：等依赖的服务就绪，期间不接受 PreShutdown
+	if s.waitStartupDelay(r, changes) {
+		return false, 0
+	}
+
+	// 远程配置定期检查，recheck 对本地配置保持为 nil（永不触发）；
+	// 本地配置缺失或无效时定期重新加载，修好配置后不必重启服务
+	var recheck, retry <-chan time.Time
+	if s.remote != nil {
+		recheck = time.After(s.configCheckInterval())
+	} else {
+		ticker := time.NewTicker(configRetryInterval)
+		defer ticker.Stop()
+		retry = ticker.C
+	}
 
 	changes <- svc.Status{
 		State:   svc.Running,
-		Accepts: svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPreShutdown,
+		Accepts: svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPreShutdown | svc.AcceptParamChange,
+	}
+	if err := s.lastConfigErr(); err != nil && !configAbsent(err) {
+		writeEvent(eventWarning, eventIDServiceStarted, fmt.Sprintf(
+			"%s started with an invalid config; nothing will run at shutdown until it is fixed (retrying every %s)",
+			versionString(), configRetryInterval))
+	} else {
+		writeEvent(eventInfo, eventIDServiceStarted, versionString()+" started")
+	}
+	s.writeStartMessage()
+	defer s.writeStopMessage()
+	checkServiceAccount()
+
+	// startup_command 在后台执行，startup_command_required 时失败会停止服务
+	startupFailed := s.startStartupCommand()
+
+	// periodic_command 的定时器，未配置时 periodic 为 nil（永不触发）
+	var periodic <-chan time.Time
+	if t := s.periodicTicker(); t != nil {
+		defer t.Stop()
+		periodic = t.C
+	}
+
+	// 关机处理在后台执行，期间仍然响应控制请求；done 在处理结束时关闭，未开始时为 nil
+	var done chan struct{}
+	startShutdown := func() {
+		done = make(chan struct{})
+		go func() {
+			defer close(done)
+			_ = s.handleShutdownOnce()
+		}()
 	}
 
-	for c := range r {
+	// pre_shutdown_notification_delay_ms：等待期间 delay 和 checkpoint 不为 nil
+	var delay, checkpoint <-chan time.Time
+	var stopCheckpoint func()
+	var checkpointN uint32
+
+	// config_reload_backoff：--reload 失败后等待重试时 retryReload 不为 nil
+	var reload *reloadRetry
+	var retryReload <-chan time.Time
+	defer func() {
+		if stopCheckpoint != nil {
+			stopCheckpoint()
+		}
+	}()
+
+	for {
+		var c svc.ChangeRequest
+		select {
+		case req, ok := <-r:
+			if !ok {
+				return false, 0
+			}
+			c = req
+		case <-done:
+			flushEvents(eventFlushTimeout)
+			return false, 0
+		case <-startupFailed:
+			if done != nil {
+				// 关机处理已经开始，让它正常结束
+				continue
+			}
+			changes <- svc.Status{State: svc.StopPending}
+			flushEvents(eventFlushTimeout)
+			return true, 1 // 服务特定错误码，SCM 记录为启动失败的原因
+		case <-recheck:
+			// 关机处理开始后不再替换配置，等它结束
+			if done == nil {
+				s.refreshRemoteConfig()
+				recheck = time.After(s.configCheckInterval())
+			}
+			continue
+		case <-retry:
+			if done == nil && s.config.Load() == nil {
+				s.retryConfigLoad()
+			}
+			continue
+		case <-periodic:
+			// 关机处理开始后不再启动新的定时执行
+			if done == nil && delay == nil {
+				s.startPeriodicRun()
+			}
+			continue
+		case <-retryReload:
+			// 与 periodic 相同：关机处理开始后不再重试，失败的结果不能替换正在使用的配置
+			if done == nil {
+				retryReload = s.tryReload(reload)
+			} else {
+				retryReload = nil
+			}
+			continue
+		case <-delay:
+			stopCheckpoint()
+			stopCheckpoint, delay, checkpoint = nil, nil, nil
+			startShutdown()
+			continue
+		case <-checkpoint:
+			// 定期更新 CheckPoint，SCM 据此判断服务没有卡住
+			checkpointN++
+			changes <- svc.Status{
+				State:      svc.StopPending,
+				Accepts:    svc.AcceptStop | svc.AcceptShutdown,
+				CheckPoint: checkpointN,
+				WaitHint:   uint32((2 * preShutdownCheckpointInterval).Milliseconds()),
+			}
+			continue
+		}
+
 		switch c.Cmd {
 		case svc.Interrogate:
 			changes <- c.CurrentStatus
+		case svc.ParamChange:
+			// winpsp --reload；正在重试时重新开始计数。关机处理开始后忽略
+			if done == nil {
+				reload = s.newReloadRetry()
+				retryReload = s.tryReload(reload)
+			} else {
+				debugf("WinPSP: reload ignored while handling shutdown")
+			}
+			changes <- c.CurrentStatus
 		case svc.Stop, svc.Shutdown:
 			changes <- svc.Status{State: svc.StopPending}
+			if done != nil {
+				s.waitShutdownDone(done)
+				flushEvents(eventFlushTimeout)
+			}
 			return false, 0
 		case svc.PreShutdown:
 			// 关机前执行
-			changes <- svc.Status{State: svc.StopPending}
-			_ = s.handleShutdownOnce()
-			return false, 0
+			changes <- svc.Status{State: svc.StopPending, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+			if done != nil || delay != nil {
+				break
+			}
+			if d := s.preShutdownDelay(); d > 0 {
+				debugf("WinPSP: pre-shutdown delay %s", d)
+				delay, checkpoint, stopCheckpoint = preShutdownTimers(d)
+			} else {
+				startShutdown()
+			}
 		default:
 			// ignore
 		}
 	}
+}
+
+// waitShutdownDone 在关机处理进行中收到 Stop 时调用，最多等待 stop_grace_period_secs
+// 让正在运行的命令结束，避免服务退出后命令成为孤儿进程
+func (s *winpspService) waitShutdownDone(done <-chan struct{}) {
+	grace := time.Duration(defaultStopGracePeriodSecs) * time.Second
+	if cfg := s.config.Load(); cfg != nil {
+		grace = time.Duration(*cfg.StopGracePeriodSecs) * time.Second
+	}
+
+	writeEvent(eventInfo, eventIDStopWaiting, "Waiting for running command to complete before stopping.")
+	select {
+	case <-done:
+	case <-time.After(grace):
+		writeEvent(eventWarning, eventIDStopWaiting,
+			fmt.Sprintf("Stopping after %s although the command is still running.", grace))
+	}
+}
+
+// preShutdownTimers 返回 d 之后到时的 delay、每隔 preShutdownCheckpointInterval
+// 报告一次进度的 checkpoint 和停止 checkpoint 的函数。可在调试时替换
+var preShutdownTimers = func(d time.Duration) (delay, checkpoint <-chan time.Time, stop func()) {
+	t := time.NewTicker(preShutdownCheckpointInterval)
+	return time.After(d), t.C, t.Stop
+}
+
+// preShutdownDelay 返回收到 PreShutdown 后开始执行前的等待时间，没有配置时为 0
+func (s *winpspService) preShutdownDelay() time.Duration {
+	cfg := s.config.Load()
+	if cfg == nil {
+		return 0
+	}
+	return time.Duration(cfg.PreShutdownDelayMS) * time.Millisecond
+}
+
+// waitStartupDelay 按 startup_delay_secs 等待，期间只响应 Stop 和 Interrogate。
+// 返回 true 表示等待期间收到了停止请求，服务应直接退出。
+func (s *winpspService) waitStartupDelay(r <-chan svc.ChangeRequest, changes chan<- svc.Status) bool {
+	cfg := s.config.Load()
+	if cfg == nil || cfg.StartupDelaySecs <= 0 {
+		return false
+	}
+
+	d := time.Duration(cfg.StartupDelaySecs) * time.Second
+	debugf("WinPSP: startup delay %s", d)
+	changes <- svc.Status{
+		State:    svc.StartPending,
+		Accepts:  svc.AcceptStop | svc.AcceptShutdown,
+		WaitHint: uint32((d + startupWaitHintSlack).Milliseconds()),
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return false
+		case c, ok := <-r:
+			if !ok {
+				return true
+			}
+			switch c.Cmd {
+			case svc.Interrogate:
+				changes <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				return true
+			}
+		}
+	}
+}
+
+// -------------------- 配置加载 --------------------
+
+// reloadConfig 重新加载配置（远程配置会先重新下载）
+func (s *winpspService) reloadConfig() {
+	if s.remote != nil {
+		s.refreshRemoteConfig()
+		return
+	}
+	if err := s.loadConfig(); err != nil && !configAbsent(err) {
+		writeEvent(eventError, eventIDConfigError, fmt.Sprintf("WinPSP: config error: %v", err))
+	}
+}
+
+// retryConfigLoad 重新加载缺失或无效的本地配置。
+// 同一个错误只报告一次，避免每分钟写一条事件日志。
+func (s *winpspService) retryConfigLoad() {
+	prev := s.lastConfigErr()
+	err := s.loadConfig()
+	switch {
+	case err == nil:
+		writeEvent(eventInfo, eventIDConfigLoaded, "WinPSP: config loaded: "+s.configPath)
+	case configAbsent(err):
+	case prev == nil || prev.Error() != err.Error():
+		writeEvent(eventError, eventIDConfigError, fmt.Sprintf("WinPSP: config error: %v", err))
+	}
+}
+
+func (s *winpspService) loadConfig() error {
+	cfg, err := s.readConfig()
+	s.storeConfig(cfg, err)
+	return err
+}
+
+// storeConfig 使 readConfig 的结果生效
+func (s *winpspService) storeConfig(cfg *Config, err error) {
+	s.configErrMu.Lock()
+	s.config.Store(cfg)
+	s.configErr = err
+	s.configErrMu.Unlock()
+	if err == nil && cfg.RequireAdmin {
+		s.checkAdminManifests()
+	}
+}
+
+// lastConfigErr 返回最近一次加载配置的错误
+func (s *winpspService) lastConfigErr() error {
+	s.configErrMu.Lock()
+	defer s.configErrMu.Unlock()
+	return s.configErr
+}
+
+// parseConfigFile 只做 JSON 解析和 profile 合并，不校验、不填默认值。
+// 供只需要读取个别字段的命令行功能使用。
+func parseConfigFile(path string) (*Config, error) {
+	data, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	cfg.configHash = configHash(data)
+
+	if name := profileName(&cfg); name != "" {
+		if err := applyProfile(&cfg, name); err != nil {
+			return nil, err
+		}
+	}
+	return &cfg, nil
+}
+
+// readConfig 读取并校验配置文件，填充默认值
+func (s *winpspService) readConfig() (*Config, error) {
+	// 配置不存在或无法读取 → 不执行任何命令，直接放行
+	// 配置损坏 → 不执行任何命令
+	cfg, err := parseConfigFile(s.configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.SchemaVersion > currentSchemaVersion {
+		return nil, fmt.Errorf("unsupported schema_version %d (this WinPSP supports up to %d)", cfg.SchemaVersion, currentSchemaVersion)
+	}
+
+	// 先替换密钥引用，后面的校验检查的是实际的值
+	if err := resolveSecrets(cfg, s.configPath); err != nil {
+		return nil, err
+	}
+
+	// command_env_var：部署工具通过环境变量指定要执行的命令，非空时代替 command
+	if cfg.CommandEnvVar != "" {
+		if v := strings.TrimSpace(os.Getenv(cfg.CommandEnvVar)); v != "" {
+			cfg.Command = v
+		} else if strings.TrimSpace(cfg.Command) == "" && len(cfg.Commands) == 0 {
+			return nil, fmt.Errorf("command_env_var: environment variable %s is not set and command is empty", cfg.CommandEnvVar)
+		}
+	}
+
+	cfg.Command = strings.TrimSpace(cfg.Command)
+	for i := range cfg.Commands {
+		cfg.Commands[i].Command = strings.TrimSpace(cfg.Commands[i].Command)
+		if cfg.Commands[i].Command == "" {
+			return nil, fmt.Errorf("commands[%d]: empty command", i)
+		}
+	}
+	cfg.WMIClass = strings.TrimSpace(cfg.WMIClass)
+	if cfg.WMIClass != "" {
+		if cfg.Command != "" || len(cfg.Commands) > 0 {
+			return nil, errors.New("use either wmi_class or command/commands, not both")
+		}
+		if strings.TrimSpace(cfg.WMIMethod) == "" {
+			return nil, errors.New("wmi_method is required with wmi_class")
+		}
+		if cfg.WMINamespace == "" {
+			cfg.WMINamespace = defaultWMINamespace
+		}
+	} else if cfg.Command == "" && len(cfg.Commands) == 0 {
+		// 空命令也视为无配置
+		return nil, errEmptyCommand
+	}
+	if cfg.Command != "" && len(cfg.Commands) > 0 {
+		return nil, errors.New("use either command or commands, not both")
+	}
+	for _, e := range cfg.commandEntries() {
+		if e.WorkingDirSDDL == "" {
+			continue
+		}
+		// 工作目录为空时继承的是 System32，不能修改它的权限
+		if e.WorkingDirectory == "" {
+			return nil, fmt.Errorf("%s: working_dir_sddl requires working_directory", e.displayName())
+		}
+		if _, _, err := sddlSecurityInfo(e.WorkingDirSDDL); err != nil {
+			return nil, fmt.Errorf("%s: invalid working_dir_sddl: %w", e.displayName(), err)
+		}
+	}
+
+	if cfg.LogSectionMarkers == nil {
+		v := true
+		cfg.LogSectionMarkers = &v
+	}
+
+	switch cfg.LogSectionFormat {
+	case "":
+		cfg.LogSectionFormat = sectionFormatPlain
+	case sectionFormatPlain, sectionFormatJSON:
+	default:
+		return nil, fmt.Errorf("invalid log_section_format: %q", cfg.LogSectionFormat)
+	}
+
+	// log_count: 0 仍然表示不写日志，可以与 log_retention_policy 同时使用
+	if cfg.LogRetentionPolicy != nil && ((cfg.LogCount != nil && *cfg.LogCount != 0) || cfg.MaxLogDirSizeMB != 0) {
+		return nil, errors.New("use either log_retention_policy or log_count/max_log_dir_size_mb, not both")
+	}
+	if cfg.LogCount == nil {
+		v := defaultLogCount
+		cfg.LogCount = &v
+	}
+
+	if cfg.Timeout == nil {
+		v := defaultTimeoutSecs
+		cfg.Timeout = &v
+	}
+	if cfg.DeadlineWarningPercent == nil {
+		v := defaultDeadlineWarningPercent
+		cfg.DeadlineWarningPercent = &v
+	} else if p := *cfg.DeadlineWarningPercent; p < 0 || p >= 100 {
+		return nil, fmt.Errorf("invalid deadline_warning_percent: %d", p)
+	}
+
+	if cfg.MaxLogDirSizeMB < 0 {
+		return nil, fmt.Errorf("invalid max_log_dir_size_mb: %d", cfg.MaxLogDirSizeMB)
+	}
+
+	// 超出范围时设置 Job Object 失败，命令会在没有限制的情况下运行；在加载时就拒绝
+	if p := cfg.JobCPURatePercent; p < 0 || p > 100 {
+		return nil, fmt.Errorf("invalid job_cpu_rate_percent: %d (1-100, or 0 for no limit)", p)
+	}
+
+	if cfg.HistoryMaxMB == nil {
+		v := defaultHistoryMaxMB
+		cfg.HistoryMaxMB = &v
+	} else if *cfg.HistoryMaxMB <= 0 {
+		return nil, fmt.Errorf("invalid history_max_mb: %d", *cfg.HistoryMaxMB)
+	}
+	if err := cfg.resolveLogRetention(); err != nil {
+		return nil, err
+	}
+
+	switch cfg.LogWriteMode {
+	case "":
+		cfg.LogWriteMode = logWriteBuffered
+	case logWriteBuffered, logWriteDirect:
+	default:
+		return nil, fmt.Errorf("invalid log_write_mode: %q", cfg.LogWriteMode)
+	}
+
+	if cfg.ArchiveTimeoutSecs != nil && *cfg.ArchiveTimeoutSecs <= 0 {
+		return nil, fmt.Errorf("invalid archive_timeout_secs: %d", *cfg.ArchiveTimeoutSecs)
+	}
+
+	if cfg.StopGracePeriodSecs == nil {
+		v := defaultStopGracePeriodSecs
+		cfg.StopGracePeriodSecs = &v
+	} else if *cfg.StopGracePeriodSecs < 0 {
+		return nil, fmt.Errorf("invalid stop_grace_period_secs: %d", *cfg.StopGracePeriodSecs)
+	}
+	if cfg.PreShutdownDelayMS < 0 {
+		return nil, fmt.Errorf("invalid pre_shutdown_notification_delay_ms: %d", cfg.PreShutdownDelayMS)
+	}
+	if cfg.ConfigReloadBackoff != nil {
+		if err := cfg.ConfigReloadBackoff.resolve(); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.RetryDelaySecs == nil {
+		v := defaultRetryDelaySecs
+		cfg.RetryDelaySecs = &v
+	}
+
+	if cfg.RetryMaxDelaySecs == nil {
+		v := defaultRetryMaxDelaySecs
+		cfg.RetryMaxDelaySecs = &v
+	}
+
+	if err := resolveEnvFile(cfg, s.configPath); err != nil {
+		return nil, err
+	}
+	if err := resolveCleanupPatterns(cfg, s.configPath); err != nil {
+		return nil, err
+	}
+	if err := parseServiceMessages(cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.GracePeriodSecs == nil {
+		v := defaultGracePeriodSecs
+		cfg.GracePeriodSecs = &v
+	}
+
+	if len(cfg.SuccessExitCodes) == 0 {
+		cfg.SuccessExitCodes = []int{0}
+	}
+
+	switch cfg.RetryDelayStrategy {
+	case "":
+		cfg.RetryDelayStrategy = retryStrategyFixed
+	case retryStrategyFixed, retryStrategyLinear, retryStrategyExponential:
+	default:
+		return nil, fmt.Errorf("invalid retry_delay_strategy: %q", cfg.RetryDelayStrategy)
+	}
+
+	if cfg.PSExecutionPolicy == "" {
+		cfg.PSExecutionPolicy = defaultPSExecutionPolicy
+	} else if p := canonicalPSExecutionPolicy(cfg.PSExecutionPolicy); p != "" {
+		cfg.PSExecutionPolicy = p
+	} else {
+		return nil, fmt.Errorf("invalid ps_execution_policy: %q", cfg.PSExecutionPolicy)
+	}
+
+	if cfg.HeartbeatIntervalSecs == nil || *cfg.HeartbeatIntervalSecs <= 0 {
+		v := defaultHeartbeatIntervalSecs
+		cfg.HeartbeatIntervalSecs = &v
+	}
+
+	cfg.FallbackCommand = strings.TrimSpace(cfg.FallbackCommand)
+	cfg.OnSuccessCommand = strings.TrimSpace(cfg.OnSuccessCommand)
+
+	if cfg.OnSuccessTimeout == nil {
+		v := defaultOnSuccessTimeoutSecs
+		cfg.OnSuccessTimeout = &v
+	}
+
+	cfg.StartupCommand = strings.TrimSpace(cfg.StartupCommand)
+	if cfg.StartupTimeout == nil {
+		v := defaultStartupTimeoutSecs
+		cfg.StartupTimeout = &v
+	}
+
+	cfg.PeriodicCommand = strings.TrimSpace(cfg.PeriodicCommand)
+	if cfg.PeriodicIntervalSecs == nil {
+		v := defaultPeriodicIntervalSecs
+		cfg.PeriodicIntervalSecs = &v
+	} else if *cfg.PeriodicIntervalSecs <= 0 {
+		return nil, fmt.Errorf("invalid periodic_interval_secs: %d", *cfg.PeriodicIntervalSecs)
+	}
+	if cfg.MaxPeriodicRuns < 0 {
+		return nil, fmt.Errorf("invalid max_periodic_runs: %d", cfg.MaxPeriodicRuns)
+	}
+
+	cfg.PreHookCommand = strings.TrimSpace(cfg.PreHookCommand)
+	cfg.PostHookCommand = strings.TrimSpace(cfg.PostHookCommand)
+	for _, p := range []**int{&cfg.PreHookTimeout, &cfg.PostHookTimeout} {
+		if *p == nil {
+			v := defaultHookTimeoutSecs
+			*p = &v
+		}
+	}
+
+	if cfg.RequireFreeDiskBytes > 0 && cfg.RequireFreeDiskPath == "" {
+		return nil, errors.New("require_free_disk_path is required with require_free_disk_bytes")
+	}
+
+	if cfg.RequireNetwork && cfg.RequireNetworkHost == "" {
+		return nil, errors.New("require_network_host is required with require_network")
+	}
+
+	if cfg.RequireNetworkPort == nil {
+		v := defaultRequireNetworkPort
+		cfg.RequireNetworkPort = &v
+	}
+	if cfg.RequireNetwork {
+		if _, err := hostPort(cfg.RequireNetworkHost, *cfg.RequireNetworkPort); err != nil {
+			return nil, fmt.Errorf("require_network_host: %w", err)
+		}
+	}
+
+	if cfg.StatsDAddress != "" {
+		if _, err := hostPort(cfg.StatsDAddress, defaultStatsDPort); err != nil {
+			return nil, fmt.Errorf("statsd_address: %w", err)
+		}
+	}
+
+	if cfg.WebhookURL != "" {
+		if u, err := url.Parse(cfg.WebhookURL); err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook_url: %q (IPv6 hosts need brackets, e.g. http://[::1]:8080/)", cfg.WebhookURL)
+		}
+	}
+
+	if cfg.S3Bucket != "" {
+		if err := resolveS3Endpoint(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	switch cfg.RequireNetworkAction {
+	case "":
+		cfg.RequireNetworkAction = networkActionSkip
+	case networkActionSkip, networkActionFallback:
+	default:
+		return nil, fmt.Errorf("invalid require_network_action: %q", cfg.RequireNetworkAction)
+	}
+
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return nil, errors.New("tls_cert_file and tls_key_file must be set together")
+	}
+
+	if cfg.VSSVolume == "" {
+		cfg.VSSVolume = defaultVSSVolume
+	} else if !strings.HasSuffix(cfg.VSSVolume, `\`) {
+		// VSS 要求卷名以反斜杠结尾
+		cfg.VSSVolume += `\`
+	}
+
+	if cfg.MutexWaitMs == nil {
+		v := defaultMutexWaitMs
+		cfg.MutexWaitMs = &v
+	}
 
-	return false, 0
-}
+	switch cfg.MutexAction {
+	case "":
+		cfg.MutexAction = mutexActionProceed
+	case mutexActionProceed, mutexActionAbort:
+	default:
+		return nil, fmt.Errorf("invalid mutex_action: %q", cfg.MutexAction)
+	}
 
-// -------------------- 配置加载 --------------------
+	if cfg.FailureOutputLines == nil {
+		v := defaultFailureOutputLines
+		cfg.FailureOutputLines = &v
+	}
+	if cfg.MaxOutputLines < 0 {
+		return nil, fmt.Errorf("invalid max_output_lines: %d", cfg.MaxOutputLines)
+	}
 
-func (s *winpspService) loadConfig() error {
-	data, err := os.ReadFile(s.configPath)
-	if err != nil {
-		// 配置不存在或无法读取 → 不执行任何命令，直接放行
-		s.config = nil
-		return err
+	if cfg.OutputPrefixTimestamp == nil {
+		v := true
+		cfg.OutputPrefixTimestamp = &v
 	}
 
-	var cfg Config
-	if err := json.Unmarshal(data, &cfg); err != nil {
-		// 配置损坏 → 不执行任何命令
-		s.config = nil
-		return err
+	if cfg.EnvInherit == nil {
+		v := true
+		cfg.EnvInherit = &v
 	}
 
-	cfg.Command = strings.TrimSpace(cfg.Command)
-	if cfg.Command == "" {
-		// 空命令也视为无配置
-		s.config = nil
-		return errors.New("empty command in config")
+	if cfg.LogTimestampFormat == "" {
+		cfg.LogTimestampFormat = defaultLogTimestampFormat
 	}
 
-	if cfg.LogCount == nil {
-		v := defaultLogCount
-		cfg.LogCount = &v
+	if cfg.OutputEncoding == "" {
+		cfg.OutputEncoding = outputEncodingAuto
+	} else if !validOutputEncoding(cfg.OutputEncoding) {
+		return nil, fmt.Errorf("invalid output_encoding: %q", cfg.OutputEncoding)
 	}
 
-	if cfg.Timeout == nil {
-		v := defaultTimeoutSecs
-		cfg.Timeout = &v
+	if cfg.OutputCodepage < 0 || cfg.OutputCodepage > 65535 {
+		return nil, fmt.Errorf("invalid output_codepage: %d", cfg.OutputCodepage)
 	}
 
-	s.config = &cfg
-	return nil
+	switch cfg.IntegrityLevel {
+	case "", integrityLow, integrityMedium, integrityHigh, integritySystem:
+	default:
+		return nil, fmt.Errorf("invalid integrity_level: %q", cfg.IntegrityLevel)
+	}
+
+	// 策略规则放在最后，检查的是填充默认值之后的实际值
+	if err := applyValidationRules(cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// commandName 返回命令行中可执行文件的文件名，用于事件和日志中标识命令
+func commandName(commandLine string) string {
+	parts, err := splitCommandLine(commandLine)
+	if err != nil || len(parts) == 0 {
+		return commandLine
+	}
+	return filepath.Base(parts[0])
 }
 
 // 解析指令
@@ -236,57 +1553,526 @@ func splitCommandLine(cmd string) ([]string, error) {
 // -------------------- 关机处理 --------------------
 
 func (s *winpspService) handleShutdownOnce() error {
+	cfg := s.config.Load()
 	// 无配置 → 什么也不做，直接放行
-	if s.config == nil {
+	if cfg == nil {
+		if err := s.lastConfigErr(); err == nil || configAbsent(err) {
+			debugf("WinPSP: No config file found, no action taken.")
+		} else {
+			writeEvent(eventError, eventIDConfigError,
+				fmt.Sprintf("WinPSP: config error, no action taken: %v", err))
+		}
 		return nil
 	}
 
-	logFile, logWriter, err := s.openLogFile()
+	// 整个关机处理固定使用 cfg：各个步骤再读取 s.config 时，
+	// 同时进行的重新加载（--stress-reload、远程配置检查）不会让一次运行混用两份配置
+	return s.snapshot(cfg).runShutdown(cfg)
+}
+
+// runShutdown 执行一次关机处理，s 是 snapshot 得到的副本
+func (s *winpspService) runShutdown(cfg *Config) error {
+
+	logFile, logWriter, err := s.openLogFile(logFilePrefix)
 	if err != nil {
 		// 日志失败不影响执行，只是没有日志
 		logFile = nil
 		logWriter = nil
-	} else {
-		defer logFile.Close()
 	}
 
+	s.run = newRunContext(cfg)
 	logLine := func(format string, args ...any) {
 		if logWriter == nil {
 			return
 		}
-		ts := time.Now().Format("2006-01-02 15:04:05")
+		ts := time.Now().Format(cfg.LogTimestampFormat)
 		line := fmt.Sprintf(format, args...)
-		fmt.Fprintf(logWriter, "[%s] %s\n", ts, line)
+		fmt.Fprintf(logWriter, "[%s] [run:%s] %s\n", ts, s.run.short(), line)
 	}
 
+	// running 记录当前执行的命令，panic 时写入崩溃文件
+	var running string
+	defer s.recoverShutdown(logFile, logLine, &running)
+
+	logLine("%s", versionString())
 	logLine("WinPSP: Shutdown triggered (PRESHUTDOWN)")
-	logLine("Running: %s", s.config.Command)
 
-	exitCode, timedOut, execErr := runCommandWithTimeout(
-		s.config.Command,
-		time.Duration(*s.config.Timeout)*time.Second,
-	)
+	release, err := acquireInstanceMutex(time.Duration(*cfg.MutexWaitMs) * time.Millisecond)
+	if err != nil {
+		if cfg.MutexAction == mutexActionAbort {
+			logLine("Aborting: %v", err)
+			if logFile != nil {
+				logFile.Close()
+			}
+			return nil
+		}
+		logLine("Warning: %v, proceeding anyway", err)
+	} else {
+		defer release()
+	}
+
+	entries, skip := s.preflight(logLine)
+	if skip != "" {
+		logLine("Skipping: %s", skip)
+		logLine("Shutdown released")
+		if logFile != nil {
+			logFile.Close()
+		}
+		return nil
+	}
+
+	// 命令的 stdout/stderr 写入日志，并可选地转发到命名管道
+	output := logWriter
+	var pipeOut io.Writer
+	if cfg.OutputPipeName != "" {
+		connectTimeout := time.Duration(defaultPipeConnectTimeoutMs) * time.Millisecond
+		if cfg.OutputPipeConnectTimeoutMs != nil {
+			connectTimeout = time.Duration(*cfg.OutputPipeConnectTimeoutMs) * time.Millisecond
+		}
+
+		pipe, err := openOutputPipe(cfg.OutputPipeName, connectTimeout)
+		if err != nil {
+			logLine("Output pipe not used: %v", err)
+		} else {
+			defer pipe.Close()
+			logLine("Output pipe client connected: %s", cfg.OutputPipeName)
+			pipeOut = pipe
+			if output != nil {
+				output = io.MultiWriter(output, pipe)
+			} else {
+				output = pipe
+			}
+		}
+	}
+
+	env := cfg.commandEnv()
+	if !*cfg.EnvInherit {
+		logLine("Environment not inherited: %d variables provided", len(env))
+	}
+
+	if cfg.PreHookCommand != "" {
+		ok := s.runExtraCommand("pre_hook_command", cfg.PreHookCommand, *cfg.PreHookTimeout, output, env, logLine)
+		if !ok && cfg.PreHookRequired {
+			logLine("Skipping: pre_hook_command failed (pre_hook_required)")
+			logLine("Shutdown released")
+			if logFile != nil {
+				logFile.Close()
+			}
+			return nil
+		}
+	}
+
+	var shadow shadowCopy
+	if cfg.VSSQuiesce {
+		shadow, err = createShadowCopy(cfg.VSSVolume)
+		if err != nil {
+			logLine("Warning: VSS snapshot of %s failed, running without it: %v", cfg.VSSVolume, err)
+			shadow = nil
+		} else {
+			logLine("VSS snapshot of %s created: %s", cfg.VSSVolume, shadow.Path())
+			env = appendEnv(env, vssEnvVar+"="+shadow.Path())
+		}
+	}
 
-	if execErr != nil && !timedOut {
-		logLine("Command error: %v", execErr)
+	if s.simulate {
+		logLine("Simulate mode: command will not be executed")
 	}
-	if timedOut {
-		logLine("Timeout after %d seconds", s.config.Timeout)
+
+	// 超时是整个关机阻塞的总预算（包括所有命令、重试及其间隔）
+	timeout := time.Duration(*cfg.Timeout) * time.Second
+	start := time.Now()
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = start.Add(timeout)
+	}
+
+	var stopHeartbeat func()
+	if cfg.HeartbeatFile != "" {
+		stopHeartbeat = startHeartbeat(s.heartbeatPath(), time.Duration(*cfg.HeartbeatIntervalSecs)*time.Second, logLine)
+	}
+
+	// 按顺序执行，某条命令失败（或超时）后不再执行后面的命令；pipeline 时整体作为一条命令
+	var results []commandResult
+	var cmdLogs []string // per_command_logs 创建的日志
+	if cfg.Pipeline && len(entries) > 1 {
+		command := pipelineName(entries, func(e *CommandEntry) string { return e.Command })
+		logLine("Running pipeline: %s", command)
+		reportCommandEvent(eventIDCommandStart, command, 0, 0)
+		running = command
+		commandStarting(command)
+
+		s.sectionStart(output, pipelineName(entries, (*CommandEntry).displayName), time.Now())
+		cmdOutput, flush := s.limitOutputLines(logWriter, pipeOut)
+		res := s.runPipelineEntries(entries, deadline, cmdOutput, env, logLine)
+		flush()
+		s.sectionEnd(output, &res)
+		results = append(results, res)
 	} else {
-		logLine("Exit code: %d", exitCode)
+		for i := range entries {
+			e := &entries[i]
+			logLine("Running: %s", e.Command)
+			reportCommandEvent(eventIDCommandStart, e.Command, 0, 0)
+			running = e.Command
+			commandStarting(e.Command)
+
+			// per_command_logs：这条命令的输出写入自己的日志，打不开时仍写入主日志
+			entryLog := logWriter
+			var cmdLog *os.File
+			if cfg.PerCommandLogs && logFile != nil {
+				f, w, err := s.openCommandLog(logFile.Name(), i+1, e.displayName())
+				if err != nil {
+					logLine("Command log not created, output goes to this log: %v", err)
+				} else {
+					cmdLog = f
+					cmdLogs = append(cmdLogs, f.Name())
+					logLine("Output: %s", f.Name())
+					entryLog = w
+				}
+			}
+
+			// 分节标记不计入 max_output_lines
+			entryOutput := joinWriters(entryLog, pipeOut)
+			cmdOutput, flush := s.limitOutputLines(entryLog, pipeOut)
+			s.sectionStart(entryOutput, e.displayName(), time.Now())
+			res := s.runEntry(e, deadline, cmdOutput, env, logLine)
+			flush()
+			s.sectionEnd(entryOutput, &res)
+			if cmdLog != nil {
+				cmdLog.Close()
+				res.LogPath = cmdLog.Name()
+			}
+			results = append(results, res)
+
+			if !s.succeeded(&res) {
+				if rest := len(entries) - i - 1; rest > 0 {
+					logLine("%s failed, skipping the remaining %d command(s)", res.Name, rest)
+				}
+				break
+			}
+		}
+	}
+	if stopHeartbeat != nil {
+		stopHeartbeat()
+	}
+	last := &results[len(results)-1]
+	exitCode, timedOut, execErr := last.ExitCode, last.TimedOut, last.Err
+
+	if last.tail != nil && !s.succeeded(last) {
+		if lines := last.tail.Lines(); len(lines) > 0 {
+			logLine("--- Last %d lines of output ---", len(lines))
+			for _, l := range lines {
+				logLine("%s", l)
+			}
+			logLine("--- End of output ---")
+		}
+	}
+
+	// on_success_command 只是附加动作，结果不影响本次运行的结论
+	if cfg.OnSuccessCommand != "" && !timedOut && s.isSuccess(exitCode, execErr) {
+		running = cfg.OnSuccessCommand
+		s.runExtraCommand("on_success_command", cfg.OnSuccessCommand, *cfg.OnSuccessTimeout, output, env, logLine)
+	}
+	if cfg.PostHookCommand != "" {
+		running = cfg.PostHookCommand
+		s.runExtraCommand("post_hook_command", cfg.PostHookCommand, *cfg.PostHookTimeout, output,
+			appendEnv(env, fmt.Sprintf("%s=%d", exitCodeEnvVar, exitCode)), logLine)
+	}
+	running = ""
+
+	if shadow != nil {
+		if err := shadow.Close(); err != nil {
+			logLine("Warning: VSS snapshot not deleted: %v", err)
+		} else {
+			logLine("VSS snapshot deleted")
+		}
+	}
+
+	if len(cfg.CleanupPatterns) > 0 {
+		if cfg.CleanupOnSuccessOnly && s.isSuccess(exitCode, execErr) && !timedOut {
+			logLine("Cleanup skipped: command succeeded (cleanup_on_success_only)")
+		} else {
+			n := cleanupFiles(cfg.CleanupPatterns, logLine)
+			logLine("Cleanup: deleted %d file(s)", n)
+		}
+	}
+
+	rec := runRecord{
+		LastRun:    start,
+		Command:    last.Command,
+		ExitCode:   exitCode,
+		TimedOut:   timedOut,
+		Attempts:   last.Attempts,
+		DurationMs: time.Since(start).Milliseconds(),
+		Result:     s.runResult(exitCode, timedOut, execErr),
+	}
+	if len(entries) > 1 {
+		for i := range results {
+			rec.Commands = append(rec.Commands, commandRecord{
+				Name:       results[i].Name,
+				ExitCode:   results[i].ExitCode,
+				TimedOut:   results[i].TimedOut,
+				Attempts:   results[i].Attempts,
+				DurationMs: results[i].Duration.Milliseconds(),
+				LogPath:    results[i].LogPath,
+			})
+		}
+	}
+	if logFile != nil {
+		rec.LogPath = logFile.Name()
+	}
+	switch rec.Result {
+	case resultSuccess, resultSuccessByAllowlist:
+		reportCommandEvent(eventIDCommandSuccess, rec.Command, rec.ExitCode, time.Since(start))
+	case resultTimeout:
+		reportCommandEvent(eventIDCommandTimeout, rec.Command, rec.ExitCode, time.Since(start))
+	default:
+		reportCommandEvent(eventIDCommandFailure, rec.Command, rec.ExitCode, time.Since(start))
+	}
+
+	if err := s.writeRunRecord(&rec); err != nil {
+		logLine("Failed to write run metadata: %v", err)
+	}
+	if dbPath := historyDBPath(cfg, s.configPath); dbPath != "" {
+		if err := recordHistory(dbPath, &rec); err != nil {
+			logLine("Failed to record run history: %v", err)
+		}
+	}
+	if cfg.RunHistoryGzip {
+		if err := appendHistoryGzip(historyGzipPath(s.configPath), &rec, int64(*cfg.HistoryMaxMB)<<20); err != nil {
+			logLine("Failed to append run history: %v", err)
+		}
+	}
+
+	if cfg.StatsDAddress != "" {
+		s.emitStatsD(&rec)
+	}
+	if cfg.WebhookURL != "" {
+		if err := s.sendWebhook(&rec); err != nil {
+			logLine("Webhook failed: %v", err)
+		}
 	}
 
 	logLine("Shutdown released")
+
+	if logFile != nil {
+		logFile.Close()
+
+		// 上传失败只记录到事件日志，不影响关机放行；
+		// 上传和归档都不超过 timeout 剩下的时间，命令用完的时间不会再被它们拉长
+		for _, path := range append([]string{logFile.Name()}, cmdLogs...) {
+			if cfg.S3Bucket != "" {
+				if err := s.uploadLogToS3(path, deadline); err != nil {
+					writeEvent(eventError, eventIDLogUploadFailed, fmt.Sprintf("WinPSP: log upload failed: %v", err))
+				}
+			}
+			if cfg.ArchiveUNCPath != "" {
+				if err := s.archiveLog(path, deadline); err != nil {
+					writeEvent(eventError, eventIDLogArchiveFailed, fmt.Sprintf("WinPSP: log archive failed: %v", err))
+				}
+			}
+		}
+	}
 	return nil
 }
 
+// timeoutBefore 返回 timeout 与 deadline 剩余时间中较短的一个，deadline 为零值时不限。
+// 返回值不大于 0 表示已经没有时间。
+func timeoutBefore(timeout time.Duration, deadline time.Time) time.Duration {
+	if deadline.IsZero() {
+		return timeout
+	}
+	return min(timeout, time.Until(deadline))
+}
+
+// execOptions 返回执行配置中命令时共用的选项
+func (s *winpspService) execOptions(output io.Writer, env []string, logf func(format string, args ...any)) execOptions {
+	cfg := s.config.Load()
+	return execOptions{
+		Output: output,
+		Limits: jobLimits{MemoryMB: cfg.JobMemoryLimitMB, CPURatePercent: cfg.JobCPURatePercent},
+		Grace:  time.Duration(*cfg.GracePeriodSecs) * time.Second,
+		Env:    env,
+		Logf:   logf,
+
+		BaseDir: s.commandBaseDir(),
+		Hidden:  s.commandHidden(),
+
+		IntegrityLevel: cfg.IntegrityLevel,
+
+		Encoding:        cfg.OutputEncoding,
+		Codepage:        uint32(cfg.OutputCodepage),
+		TimestampFormat: s.outputTimestampFormat(),
+		RunID:           s.run.short(),
+		DedupWindow:     time.Duration(cfg.LogDedupWindow) * time.Second,
+
+		PSExecutionPolicy: cfg.PSExecutionPolicy,
+		CmdExtraArgs:      cfg.CmdExtraArgs,
+	}
+}
+
+// commandHidden 返回 command_hidden，未设置时服务模式隐藏窗口、交互模式不隐藏
+func (s *winpspService) commandHidden() bool {
+	if cfg := s.config.Load(); cfg != nil && cfg.CommandHidden != nil {
+		return *cfg.CommandHidden
+	}
+	return !s.interactive
+}
+
+// runExtraCommand 执行主命令之外的附加命令（钩子、on_success_command），
+// 单独成节写入日志，不重试。返回是否成功。
+func (s *winpspService) runExtraCommand(name, command string, timeoutSecs int, output io.Writer, env []string, logf func(format string, args ...any)) bool {
+	timeout := time.Duration(timeoutSecs) * time.Second
+
+	logf("--- %s ---", name)
+	logf("Running: %s", command)
+	exitCode, timedOut, err := s.runCommand(command, timeout, s.execOptions(output, env, logf))
+	switch {
+	case timedOut:
+		logf("%s timed out after %d seconds", name, timeoutSecs)
+	case err != nil && !isExitError(err):
+		logf("%s error: %v", name, err)
+	default:
+		logf("%s exit code: %d", name, exitCode)
+	}
+	logf("--- end of %s ---", name)
+	return !timedOut && s.isSuccess(exitCode, err)
+}
+
+// commandBaseDir 返回命令中相对路径的基准目录：command_base_dir（相对路径同样
+// 基于配置文件目录），未设置时为配置文件所在目录
+func (s *winpspService) commandBaseDir() string {
+	dir := filepath.Dir(s.configPath)
+	if base := s.config.Load().CommandBaseDir; base != "" {
+		if filepath.IsAbs(base) {
+			return base
+		}
+		return filepath.Join(dir, base)
+	}
+	return dir
+}
+
+// outputTimestampFormat 返回命令输出行的时间戳格式，空表示不加时间戳
+func (s *winpspService) outputTimestampFormat() string {
+	cfg := s.config.Load()
+	if !*cfg.OutputPrefixTimestamp {
+		return ""
+	}
+	return cfg.LogTimestampFormat
+}
+
+// -------------------- 执行结果 --------------------
+
+// isSuccess 判断一次执行是否成功：命令必须已启动，且退出码在 success_exit_codes 中
+func (s *winpspService) isSuccess(exitCode int, execErr error) bool {
+	if execErr != nil && !isExitError(execErr) {
+		return false
+	}
+	for _, c := range s.config.Load().SuccessExitCodes {
+		if c == exitCode {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *winpspService) runResult(exitCode int, timedOut bool, execErr error) string {
+	switch {
+	case timedOut:
+		return resultTimeout
+	case !s.isSuccess(exitCode, execErr):
+		return resultFailure
+	case exitCode != 0:
+		return resultSuccessByAllowlist
+	default:
+		return resultSuccess
+	}
+}
+
+// -------------------- 运行记录 --------------------
+
+// runRecord 是每次运行后写入 winpsp-last-run.json 的元数据
+type runRecord struct {
+	LastRun    time.Time `json:"last_run"`
+	Command    string    `json:"command"`
+	Result     string    `json:"result"`
+	ExitCode   int       `json:"exit_code"`
+	TimedOut   bool      `json:"timed_out"`
+	Attempts   int       `json:"attempts"`
+	DurationMs int64     `json:"duration_ms"`
+	LogPath    string    `json:"log_path,omitempty"`
+
+	Commands []commandRecord `json:"commands,omitempty"` // 多条命令时每条的结果，执行顺序
+}
+
+type commandRecord struct {
+	Name       string `json:"name"`
+	ExitCode   int    `json:"exit_code"`
+	TimedOut   bool   `json:"timed_out"`
+	Attempts   int    `json:"attempts"`
+	DurationMs int64  `json:"duration_ms"`
+	LogPath    string `json:"log_path,omitempty"` // per_command_logs 时这条命令的日志
+}
+
+func (s *winpspService) writeRunRecord(rec *runRecord) error {
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(filepath.Dir(s.configPath), lastRunFileName)
+	return os.WriteFile(path, data, 0644)
+}
+
+// readRunRecord 读取上一次运行写入的 winpsp-last-run.json
+func (s *winpspService) readRunRecord() (*runRecord, error) {
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(s.configPath), lastRunFileName))
+	if err != nil {
+		return nil, err
+	}
+	var rec runRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// -------------------- 重试间隔 --------------------
+
+// retryDelay 计算第 attempt 次失败后的等待时间（attempt 从 1 开始）。
+// 结果带 ±10% 抖动，避免大量机器同时关机时同时重试。
+func retryDelay(cfg *Config, attempt int) time.Duration {
+	base := time.Duration(*cfg.RetryDelaySecs) * time.Second
+
+	var d time.Duration
+	switch cfg.RetryDelayStrategy {
+	case retryStrategyLinear:
+		d = base * time.Duration(attempt)
+	case retryStrategyExponential:
+		maxDelay := time.Duration(*cfg.RetryMaxDelaySecs) * time.Second
+		d = base
+		for i := 1; i < attempt && d < maxDelay; i++ {
+			d *= 2
+		}
+		if d > maxDelay {
+			d = maxDelay
+		}
+	default:
+		d = base
+	}
+
+	if jitter := int64(d / 10); jitter > 0 {
+		d += time.Duration(rand.Int63n(2*jitter+1) - jitter)
+	}
+	return d
+}
+
 // -------------------- 日志文件管理 --------------------
 
-func (s *winpspService) openLogFile() (*os.File, io.Writer, error) {
-	if s.config == nil || s.config.LogCount == nil {
+func (s *winpspService) openLogFile(prefix string) (*os.File, io.Writer, error) {
+	cfg := s.config.Load()
+	if cfg == nil || cfg.LogCount == nil {
 		// 不可能发生，因为 loadConfig 会填默认值
 		// 但为了未来维护安全，可以保留默认行为
-	} else if *s.config.LogCount == 0 {
+	} else if *cfg.LogCount == 0 {
 		return nil, nil, nil
 	}
 
@@ -295,19 +2081,31 @@ func (s *winpspService) openLogFile() (*os.File, io.Writer, error) {
 		return nil, nil, err
 	}
 
-	// 日志轮换
-	logCount := defaultLogCount
-	if s.config != nil && s.config.LogCount != nil {
-		logCount = *s.config.LogCount
+	// 日志轮换，规则见 logretention.go
+	retention := logRetention{count: defaultLogCount}
+	if cfg != nil {
+		retention = cfg.logRetention
 	}
 
-	if err := rotateLogs(cfgDir, logCount); err != nil {
+	if err := rotateLogs(cfgDir, prefix, retention); err != nil {
 		// 轮换失败不阻止继续写新日志
 	}
 
-	ts := time.Now().Format("20060102-150405")
-	logName := logFilePrefix + ts + logFileExt
-	logPath := filepath.Join(cfgDir, logName)
+	ts := time.Now().Format(logTimestampLayout)
+	logName := prefix + ts + logFileExt
+	return s.createLogFile(filepath.Join(cfgDir, logName))
+}
+
+// createLogFile 按 log_write_mode 创建日志文件
+func (s *winpspService) createLogFile(logPath string) (*os.File, io.Writer, error) {
+	cfg := s.config.Load()
+	if cfg != nil && cfg.LogWriteMode == logWriteDirect {
+		f, w, err := openDirectLog(logPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		return f, w, nil
+	}
 
 	f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
@@ -317,11 +2115,29 @@ func (s *winpspService) openLogFile() (*os.File, io.Writer, error) {
 	return f, f, nil
 }
 
-func rotateLogs(dir string, maxCount int) error {
-	entries, err := os.ReadDir(dir)
+// rotateLogs 按保留规则删除以 prefix 开头的最早的日志
+func rotateLogs(dir, prefix string, retention logRetention) error {
+	logs, err := listLogFilesWithPrefix(dir, prefix)
 	if err != nil {
 		return err
 	}
+	pruneLogs(dir, logs, retention)
+	return nil
+}
+
+// listLogFiles 返回目录中的 WinPSP 日志文件，按文件名（即时间）升序排列
+func listLogFiles(dir string) ([]fs.DirEntry, error) {
+	return listLogFilesWithPrefix(dir, logFilePrefix)
+}
+
+// listLogFilesWithPrefix 同 listLogFiles，只取以 prefix 开头的日志；
+// periodic_command 和 per_command_logs 的日志单独计数，不算在关机日志中。
+// 总大小限制和保留天数见 listAllLogFiles。
+func listLogFilesWithPrefix(dir, prefix string) ([]fs.DirEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
 
 	var logs []fs.DirEntry
 	for _, e := range entries {
@@ -329,46 +2145,94 @@ func rotateLogs(dir string, maxCount int) error {
 			continue
 		}
 		name := e.Name()
-		if strings.HasPrefix(name, logFilePrefix) && strings.HasSuffix(name, logFileExt) {
+		if prefix == logFilePrefix && (strings.HasPrefix(name, periodicLogPrefix) || isCommandLog(name)) {
+			continue
+		}
+		if strings.HasPrefix(name, prefix) && strings.HasSuffix(name, logFileExt) {
 			logs = append(logs, e)
 		}
 	}
 
-	if len(logs) <= maxCount {
-		return nil
-	}
-
 	sort.Slice(logs, func(i, j int) bool {
 		return logs[i].Name() < logs[j].Name()
 	})
+	return logs, nil
+}
 
-	toDelete := logs[0 : len(logs)-maxCount]
-	for _, e := range toDelete {
-		_ = os.Remove(filepath.Join(dir, e.Name()))
+// listAllLogFiles 返回目录中全部 winpsp-*.log（关机、周期和命令日志），
+// 按最后修改时间升序排列，用于按总大小和保留天数删除
+func listAllLogFiles(dir string) ([]fs.DirEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	var logs []fs.DirEntry
+	modTimes := make(map[string]time.Time)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, logFilePrefix) || !strings.HasSuffix(name, logFileExt) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		logs = append(logs, e)
+		modTimes[name] = info.ModTime()
+	}
+
+	// os.ReadDir 已按文件名排序，修改时间相同的保持文件名顺序
+	sort.SliceStable(logs, func(i, j int) bool {
+		return modTimes[logs[i].Name()].Before(modTimes[logs[j].Name()])
+	})
+	return logs, nil
 }
 
 // -------------------- 命令执行（带超时） --------------------
 
-func runCommandWithTimeout(commandLine string, timeout time.Duration) (exitCode int, timedOut bool, err error) {
-	// 解析命令行
-	parts, err := splitCommandLine(commandLine)
+// execOptions 是一次命令执行的附加选项，零值表示全部使用默认行为
+type execOptions struct {
+	Output  io.Writer     // 命令 stdout/stderr 的去向，nil 表示丢弃
+	Limits  jobLimits     // Job Object 资源限制
+	Grace   time.Duration // 超时后 Ctrl+Break 到强制终止之间的宽限期，0 = 直接终止
+	Env     []string      // 子进程环境变量，nil 表示继承
+	Dir     string        // 工作目录，空表示继承
+	BaseDir string        // 命令中相对路径的基准目录，空表示工作目录
+	Hidden  bool          // 不为命令创建控制台窗口（CREATE_NO_WINDOW）
+	Args    []string      // 追加在命令行参数之后的参数（args_file）
+	Logf    func(format string, args ...any)
+
+	IntegrityLevel string // 命令进程的完整性级别，空 = 与服务相同
+
+	Encoding        string        // 命令输出的编码，转换为 UTF-8 后写入 Output，空 = auto
+	Codepage        uint32        // 命令运行时的控制台代码页，0 = 不修改
+	TimestampFormat string        // 非空时命令输出逐行加此格式的时间戳
+	RunID           string        // 非空时命令输出逐行加 [run:<RunID>] 前缀
+	DedupWindow     time.Duration // 大于 0 时合并此时间内连续重复的输出行
+
+	PSExecutionPolicy string    // 执行 .ps1 时传给 powershell.exe 的 -ExecutionPolicy
+	CmdExtraArgs      []string  // 执行 .bat / .cmd 时加在 cmd.exe /C 之前的参数
+	Tee               io.Writer // 另外接收一份（解码后、不加时间戳的）输出，可为 nil
+}
+
+func (o *execOptions) logf(format string, args ...any) {
+	if o.Logf != nil {
+		o.Logf(format, args...)
+	}
+}
+
+func runCommandWithTimeout(commandLine string, timeout time.Duration, opts execOptions) (exitCode int, timedOut bool, err error) {
+	exe, args, cmdLine, err := commandArgv(commandLine, &opts)
 	if err != nil {
 		return 1, false, err
 	}
-	if len(parts) == 0 {
-		return 1, false, errors.New("empty command line")
-	}
-
-	exe := parts[0]
-	args := parts[1:]
 
 	// 禁用超时
 	if timeout == 0 {
 		cmd := exec.Command(exe, args...)
-		err = cmd.Run()
+		setCmdLine(cmd, cmdLine)
+		err = runCmd(cmd, &opts)
 		return exitCodeFromError(err), false, err
 	}
 
@@ -377,7 +2241,9 @@ func runCommandWithTimeout(commandLine string, timeout time.Duration) (exitCode
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, exe, args...)
-	err = cmd.Run()
+	setCmdLine(cmd, cmdLine)
+	setGracefulCancel(cmd, opts.Grace)
+	err = runCmd(cmd, &opts)
 
 	if ctx.Err() == context.DeadlineExceeded {
 		return exitCodeFromError(err), true, err
@@ -386,15 +2252,162 @@ func runCommandWithTimeout(commandLine string, timeout time.Duration) (exitCode
 	return exitCodeFromError(err), false, err
 }
 
+// commandArgv 解析命令行，返回要启动的程序、参数和交给 setCmdLine 的原样命令行
+func commandArgv(commandLine string, opts *execOptions) (exe string, args []string, cmdLine string, err error) {
+	parts, err := splitCommandLine(commandLine)
+	if err != nil {
+		return "", nil, "", err
+	}
+	if len(parts) == 0 {
+		return "", nil, "", errors.New("empty command line")
+	}
+
+	exe = parts[0]
+	// 含路径分隔符的相对路径基于 command_base_dir，而不是进程的工作目录
+	// （服务模式下是 System32）；不含分隔符的名字仍按 PATH 查找
+	if opts.BaseDir != "" && !filepath.IsAbs(exe) && strings.ContainsAny(exe, `\/`) {
+		exe = filepath.Join(opts.BaseDir, exe)
+	}
+	exe, args, cmdLine = resolveInterpreter(exe, append(parts[1:], opts.Args...), opts)
+	return exe, args, cmdLine, nil
+}
+
+// runCmd 启动命令，按需放入受限的 Job Object，然后等待其结束。
+// stdout 和 stderr 各用一个 goroutine 读取：两者共用一个同步写入者时，
+// 一个管道写满会让命令阻塞，而我们又在等另一个管道，可能互相卡死。
+func runCmd(cmd *exec.Cmd, opts *execOptions) error {
+	cmd.Env = opts.Env
+	cmd.Dir = opts.Dir
+	setHideWindow(cmd, opts.Hidden)
+	releaseToken, err := setIntegrityLevel(cmd, opts.IntegrityLevel)
+	if err != nil {
+		return fmt.Errorf("set integrity level %s: %w", opts.IntegrityLevel, err)
+	}
+	defer releaseToken()
+
+	var streams []io.Reader
+	if opts.Output != nil || opts.Tee != nil {
+		if !validOutputEncoding(opts.Encoding) {
+			return fmt.Errorf("invalid output encoding: %q", opts.Encoding)
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		stderr, err := cmd.StderrPipe()
+		if err != nil {
+			return err
+		}
+		streams = []io.Reader{stdout, stderr}
+	}
+
+	// 记录超时取消，等待输出时用到
+	cancelled := make(chan struct{})
+	if cmd.Cancel != nil {
+		cancel := cmd.Cancel
+		cmd.Cancel = func() error {
+			close(cancelled)
+			return cancel()
+		}
+	}
+
+	restoreCodepage := setConsoleCodepage(opts.Codepage)
+	defer restoreCodepage()
+
+	if opts.Limits.enabled() {
+		startSuspended(cmd)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	if opts.Limits.enabled() {
+		job, err := applyJobLimits(cmd.Process.Pid, opts.Limits)
+		if err != nil {
+			opts.logf("Warning: job limits not applied: %v", err)
+		} else {
+			defer closeHandle(job)
+			opts.logf("Warning: job limits applied (%s)", opts.Limits)
+		}
+		// 放入 Job Object 失败时命令仍然运行，只是不受限制；无法恢复时结束它
+		if err := resumeProcess(cmd.Process.Pid); err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return fmt.Errorf("resume process: %w", err)
+		}
+	}
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex // 两个流写入同一个日志，按行互斥
+	)
+	for _, r := range streams {
+		wg.Add(1)
+		go func(r io.Reader) {
+			defer wg.Done()
+			drainOutput(r, opts, &mu)
+		}(r)
+	}
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+
+	// 必须读完管道再调用 Wait，否则 Wait 关闭管道会丢掉末尾的输出。
+	// 超时取消后，命令启动的子进程可能仍持有管道，只再等一小段时间，
+	// 之后交给 Wait（WaitDelay）关闭管道，不让关机被卡住。
+	select {
+	case <-drained:
+	case <-cancelled:
+		select {
+		case <-drained:
+		case <-time.After(cmd.WaitDelay + outputDrainTimeout):
+		}
+	}
+
+	err = cmd.Wait()
+	<-drained
+	return err
+}
+
+// drainOutput 把一个输出流解码、加时间戳后写入 opts.Output，并复制一份到 opts.Tee
+func drainOutput(r io.Reader, opts *execOptions, mu *sync.Mutex) {
+	var ws []io.Writer
+	if opts.Output != nil {
+		var w io.Writer = &lockedWriter{mu: mu, w: opts.Output}
+		if opts.TimestampFormat != "" || opts.RunID != "" || opts.DedupWindow > 0 {
+			tw := newTimestampWriter(w, opts.TimestampFormat, opts.RunID, opts.DedupWindow)
+			defer tw.Close()
+			w = tw
+		}
+		ws = append(ws, w)
+	}
+	if opts.Tee != nil {
+		ws = append(ws, &lockedWriter{mu: mu, w: opts.Tee})
+	}
+
+	// 编码已在 runCmd 中校验过
+	out, _ := newOutputWriter(io.MultiWriter(ws...), opts.Encoding)
+	// defer 逆序执行：先刷出解码器，再刷出时间戳
+	defer out.Close()
+
+	io.Copy(out, r)
+}
+
 func exitCodeFromError(err error) int {
 	if err == nil {
 		return 0
 	}
 	var exitErr *exec.ExitError
 	if errors.As(err, &exitErr) {
-		// Windows 下 ExitError.Sys() 里有退出码，但标准库没直接暴露
-		// 简化处理：非 0 即视为 1
-		return 1
+		return exitErr.ExitCode()
 	}
 	return 1
 }
+
+// isExitError 区分"命令已运行但退出码非 0"与"命令根本没有启动"
+func isExitError(err error) bool {
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr)
+}
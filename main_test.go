@@ -0,0 +1,869 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+func intPtr(v int) *int { return &v }
+
+// -------------------- 测试用子进程 --------------------
+
+// 需要一个行为确定的子进程（捕获 Ctrl+Break、输出 UTF-16 等）的测试重新执行测试程序本身，
+// 用环境变量 WINPSP_TEST_HELPER 选择 testHelpers 中的一项，其返回值是退出码
+const testHelperEnv = "WINPSP_TEST_HELPER"
+
+var testHelpers = map[string]func(args []string) int{}
+
+func TestMain(m *testing.M) {
+	if name := os.Getenv(testHelperEnv); name != "" {
+		helper, ok := testHelpers[name]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "unknown test helper %q\n", name)
+			os.Exit(2)
+		}
+		os.Exit(helper(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+// helperCommand 返回运行测试子进程 name 的命令，ctx 结束时取消
+func helperCommand(ctx context.Context, t *testing.T, name string, args ...string) *exec.Cmd {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.CommandContext(ctx, exe, args...)
+	cmd.Env = append(os.Environ(), testHelperEnv+"="+name)
+	return cmd
+}
+
+func init() {
+	// 同时向 stdout 和 stderr 各写 floodBytes 字节：stdout 全是 o，stderr 全是 e
+	testHelpers["flood"] = func(args []string) int {
+		var wg sync.WaitGroup
+		for _, f := range []struct {
+			w *os.File
+			c string
+		}{{os.Stdout, "o"}, {os.Stderr, "e"}} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				line := []byte(strings.Repeat(f.c, floodLineBytes-1) + "\n")
+				for i := 0; i < floodBytes/floodLineBytes; i++ {
+					f.w.Write(line)
+				}
+			}()
+		}
+		wg.Wait()
+		return 0
+	}
+}
+
+const (
+	floodBytes     = 10 << 20
+	floodLineBytes = 1024
+)
+
+// countingWriter 统计写入的每种字节的个数
+type countingWriter struct {
+	counts [256]int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		c.counts[b]++
+	}
+	return len(p), nil
+}
+
+// helperCommandLine 返回交给 runCommandWithTimeout 执行测试子进程 name 的命令行和环境变量
+func helperCommandLine(t *testing.T, name string) (string, []string) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return `"` + exe + `"`, append(os.Environ(), testHelperEnv+"="+name)
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		strategy    string
+		delay, max  int
+		attempt     int
+		wantSeconds int
+	}{
+		{"", 5, 60, 1, 5},
+		{retryStrategyFixed, 5, 60, 1, 5},
+		{retryStrategyFixed, 5, 60, 4, 5},
+		{retryStrategyLinear, 5, 60, 1, 5},
+		{retryStrategyLinear, 5, 60, 3, 15},
+		{retryStrategyLinear, 5, 10, 30, 150}, // linear 不受 retry_max_delay_secs 限制
+		{retryStrategyExponential, 5, 60, 1, 5},
+		{retryStrategyExponential, 5, 60, 2, 10},
+		{retryStrategyExponential, 5, 60, 4, 40},
+		{retryStrategyExponential, 5, 60, 5, 60},
+		{retryStrategyExponential, 5, 60, 1000, 60},
+		{retryStrategyExponential, 50, 30, 1, 30},
+		{retryStrategyFixed, 0, 60, 3, 0},
+	}
+	for _, tt := range tests {
+		cfg := &Config{RetryDelayStrategy: tt.strategy, RetryDelaySecs: intPtr(tt.delay), RetryMaxDelaySecs: intPtr(tt.max)}
+		want := time.Duration(tt.wantSeconds) * time.Second
+		lo, hi := want-want/10, want+want/10
+
+		// 抖动是随机的：多取几次，每次都必须在 ±10% 之内
+		seen := map[time.Duration]bool{}
+		for i := 0; i < 200; i++ {
+			got := retryDelay(cfg, tt.attempt)
+			if got < lo || got > hi {
+				t.Fatalf("retryDelay(%s, delay=%d, max=%d, attempt=%d) = %s, want %s ±10%%",
+					tt.strategy, tt.delay, tt.max, tt.attempt, got, want)
+			}
+			seen[got] = true
+		}
+		if want > 0 && len(seen) < 2 {
+			t.Errorf("retryDelay(%s, attempt=%d) returned %s every time, want jitter", tt.strategy, tt.attempt, want)
+		}
+	}
+}
+
+// writeConfig 把 JSON 写到临时目录，返回使用它的服务
+func writeConfig(t *testing.T, data string) *winpspService {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "winpsp.json")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &winpspService{configPath: path}
+}
+
+func TestReadConfigJobCPURate(t *testing.T) {
+	tests := []struct {
+		percent int
+		wantErr bool
+	}{
+		{0, false},
+		{1, false},
+		{50, false},
+		{100, false},
+		{-1, true},
+		{101, true},
+		{1000, true},
+	}
+	for _, tt := range tests {
+		s := writeConfig(t, fmt.Sprintf(`{"command": "C:\\Windows\\System32\\whoami.exe", "job_cpu_rate_percent": %d}`, tt.percent))
+		_, err := s.readConfig()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("job_cpu_rate_percent %d: error = %v, wantErr %v", tt.percent, err, tt.wantErr)
+		}
+	}
+}
+
+func TestReadConfigDefaults(t *testing.T) {
+	s := writeConfig(t, `{"command": "C:\\Windows\\System32\\whoami.exe"}`)
+	cfg, err := s.readConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ints := []struct {
+		field string
+		got   *int
+		want  int
+	}{
+		{"timeout", cfg.Timeout, defaultTimeoutSecs},
+		{"log_count", cfg.LogCount, defaultLogCount},
+		{"retry_delay_secs", cfg.RetryDelaySecs, defaultRetryDelaySecs},
+		{"retry_max_delay_secs", cfg.RetryMaxDelaySecs, defaultRetryMaxDelaySecs},
+		{"grace_period_secs", cfg.GracePeriodSecs, defaultGracePeriodSecs},
+		{"stop_grace_period_secs", cfg.StopGracePeriodSecs, defaultStopGracePeriodSecs},
+		{"deadline_warning_percent", cfg.DeadlineWarningPercent, defaultDeadlineWarningPercent},
+		{"history_max_mb", cfg.HistoryMaxMB, defaultHistoryMaxMB},
+	}
+	for _, tt := range ints {
+		if tt.got == nil || *tt.got != tt.want {
+			t.Errorf("%s = %v, want %d", tt.field, tt.got, tt.want)
+		}
+	}
+
+	strs := []struct {
+		field, got, want string
+	}{
+		{"retry_delay_strategy", cfg.RetryDelayStrategy, retryStrategyFixed},
+		{"log_section_format", cfg.LogSectionFormat, sectionFormatPlain},
+		{"log_write_mode", cfg.LogWriteMode, logWriteBuffered},
+		{"require_network_action", cfg.RequireNetworkAction, networkActionSkip},
+	}
+	for _, tt := range strs {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.field, tt.got, tt.want)
+		}
+	}
+
+	if cfg.LogSectionMarkers == nil || !*cfg.LogSectionMarkers {
+		t.Error("log_section_markers not enabled by default")
+	}
+	if cfg.EnvInherit == nil || !*cfg.EnvInherit {
+		t.Error("env_inherit not enabled by default")
+	}
+	if len(cfg.SuccessExitCodes) != 1 || cfg.SuccessExitCodes[0] != 0 {
+		t.Errorf("success_exit_codes = %v, want [0]", cfg.SuccessExitCodes)
+	}
+}
+
+func TestReadConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name, json string
+	}{
+		{"empty command", `{"command": "  "}`},
+		{"command and commands", `{"command": "a.exe", "commands": [{"command": "b.exe"}]}`},
+		{"empty commands entry", `{"commands": [{"command": ""}]}`},
+		{"retry_delay_strategy", `{"command": "a.exe", "retry_delay_strategy": "random"}`},
+		{"log_write_mode", `{"command": "a.exe", "log_write_mode": "async"}`},
+		{"log_section_format", `{"command": "a.exe", "log_section_format": "xml"}`},
+		{"deadline_warning_percent", `{"command": "a.exe", "deadline_warning_percent": 100}`},
+		{"max_log_dir_size_mb", `{"command": "a.exe", "max_log_dir_size_mb": -1}`},
+		{"history_max_mb", `{"command": "a.exe", "history_max_mb": 0}`},
+		{"stop_grace_period_secs", `{"command": "a.exe", "stop_grace_period_secs": -1}`},
+		{"webhook_url", `{"command": "a.exe", "webhook_url": "/hook"}`},
+		{"s3_endpoint", `{"command": "a.exe", "s3_bucket": "logs", "s3_endpoint": "minio.local:9000"}`},
+		{"schema_version", `{"command": "a.exe", "schema_version": 999}`},
+		{"syntax", `{"command": "a.exe",}`},
+	}
+	for _, tt := range tests {
+		s := writeConfig(t, tt.json)
+		if cfg, err := s.readConfig(); err == nil {
+			t.Errorf("%s: readConfig accepted %s: %+v", tt.name, tt.json, cfg)
+		}
+	}
+}
+
+func TestRunResultAllowlist(t *testing.T) {
+	// robocopy：1 = 复制了文件，2 = 目标中有多余的文件，8 及以上才是失败
+	s := writeConfig(t, `{"command": "C:\\Windows\\System32\\robocopy.exe", "success_exit_codes": [0, 1, 2, 3]}`)
+	if err := s.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	exitErr := exec.Command("cmd.exe", "/c", "exit 1").Run()
+	if !isExitError(exitErr) {
+		t.Fatalf("cmd /c exit 1: %v", exitErr)
+	}
+	startErr := errors.New("executable file not found")
+
+	tests := []struct {
+		exitCode int
+		timedOut bool
+		err      error
+		want     string
+	}{
+		{0, false, nil, resultSuccess},
+		{1, false, exitErr, resultSuccessByAllowlist},
+		{3, false, exitErr, resultSuccessByAllowlist},
+		{8, false, exitErr, resultFailure},
+		{16, false, exitErr, resultFailure},
+		{1, true, exitErr, resultTimeout},
+		{0, false, startErr, resultFailure}, // 没有启动起来，不是退出码
+	}
+	for _, tt := range tests {
+		if got := s.runResult(tt.exitCode, tt.timedOut, tt.err); got != tt.want {
+			t.Errorf("runResult(%d, timedOut=%v, %v) = %q, want %q", tt.exitCode, tt.timedOut, tt.err, got, tt.want)
+		}
+	}
+
+	// 默认只有 0 是成功
+	s = writeConfig(t, `{"command": "C:\\Windows\\System32\\robocopy.exe"}`)
+	if err := s.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.runResult(1, false, exitErr); got != resultFailure {
+		t.Errorf("runResult(1) without success_exit_codes = %q, want %q", got, resultFailure)
+	}
+}
+
+// 两个输出流同时写满管道时不能死锁，也不能丢失或混入输出
+func TestRunCommandLargeOutput(t *testing.T) {
+	command, env := helperCommandLine(t, "flood")
+	for _, timeout := range []time.Duration{0, 2 * time.Minute} {
+		var out countingWriter
+		start := time.Now()
+		code, timedOut, err := runCommandWithTimeout(command, timeout, execOptions{Output: &out, Env: env})
+		if err != nil || code != 0 || timedOut {
+			t.Fatalf("timeout %s: exit code %d, timed out %v, %v after %s", timeout, code, timedOut, err, time.Since(start))
+		}
+		lines := floodBytes / floodLineBytes
+		if o, e, nl := out.counts['o'], out.counts['e'], out.counts['\n']; o != lines*(floodLineBytes-1) || e != o || nl != 2*lines {
+			t.Errorf("timeout %s: got %d o, %d e, %d newlines, want %d, %d, %d", timeout, o, e, nl,
+				lines*(floodLineBytes-1), lines*(floodLineBytes-1), 2*lines)
+		}
+	}
+}
+
+// 每个输出流的一行不会被另一个流的输出截断
+func TestRunCommandLargeOutputLines(t *testing.T) {
+	command, env := helperCommandLine(t, "flood")
+	var out bytes.Buffer
+	if _, _, err := runCommandWithTimeout(command, 0, execOptions{Output: &out, Env: env, TimestampFormat: "15:04:05"}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{strings.Repeat("o", floodLineBytes-1): true, strings.Repeat("e", floodLineBytes-1): true}
+	n := 0
+	for line := range strings.SplitSeq(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		n++
+		if _, text, _ := strings.Cut(line, "] "); !want[text] {
+			t.Fatalf("line %d mixes the two streams: %.40q...", n, line)
+		}
+	}
+	if n != 2*floodBytes/floodLineBytes {
+		t.Errorf("%d lines, want %d", n, 2*floodBytes/floodLineBytes)
+	}
+}
+
+func TestOnSuccessCommand(t *testing.T) {
+	tests := []struct {
+		exitCode     int
+		successCodes string
+		onSuccess    string
+		wantRun      bool
+		wantResult   string
+	}{
+		{0, "[0]", "cmd.exe /c echo confirmed", true, resultSuccess},
+		{1, "[0]", "cmd.exe /c echo confirmed", false, resultFailure},
+		{2, "[0, 2]", "cmd.exe /c echo confirmed", true, resultSuccessByAllowlist},
+		// on_success_command 失败不影响本次运行的结果
+		{0, "[0]", "cmd.exe /c echo confirmed& exit 5", true, resultSuccess},
+	}
+	for _, tt := range tests {
+		s := loadedService(t, fmt.Sprintf(`{
+  "command": "cmd.exe /c exit %d",
+  "success_exit_codes": %s,
+  "on_success_command": %q
+}`, tt.exitCode, tt.successCodes, tt.onSuccess))
+		s.interactive = true
+		log := shutdownLog(t, s)
+
+		ran := strings.Contains(log, "--- on_success_command ---") && strings.Contains(log, "confirmed")
+		if ran != tt.wantRun {
+			t.Errorf("exit code %d, success_exit_codes %s: on_success_command ran = %v, want %v:\n%s",
+				tt.exitCode, tt.successCodes, ran, tt.wantRun, log)
+		}
+		rec, err := s.readRunRecord()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Result != tt.wantResult || rec.Command != fmt.Sprintf("cmd.exe /c exit %d", tt.exitCode) {
+			t.Errorf("exit code %d: run record %+v, want result %s for the main command", tt.exitCode, rec, tt.wantResult)
+		}
+	}
+}
+
+// 用 go test -race 运行时检查重新加载配置与关机处理之间没有数据竞争
+func TestConcurrentReloadAndShutdown(t *testing.T) {
+	s := loadedService(t, `{"command": "cmd.exe /c echo run", "timeout": 30, "log_count": 0}`)
+	s.interactive = true
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				s.loadConfig()
+				s.configCheckInterval()
+			}
+		}()
+	}
+
+	for i := 1; i <= 5; i++ {
+		if err := s.handleShutdownOnce(); err != nil {
+			t.Errorf("run %d: %v", i, err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if cfg := s.config.Load(); cfg == nil || cfg.Command != "cmd.exe /c echo run" {
+		t.Errorf("config after reloading = %+v, err %v", cfg, s.lastConfigErr())
+	}
+}
+
+func init() {
+	// 钩子命令：输出 args[0] 和 WINPSP_EXIT_CODE，以 args[1] 退出
+	testHelpers["hook"] = func(args []string) int {
+		fmt.Printf("hook %s %s=%s\n", args[0], exitCodeEnvVar, os.Getenv(exitCodeEnvVar))
+		code, _ := strconv.Atoi(args[1])
+		return code
+	}
+}
+
+func TestHookCommands(t *testing.T) {
+	tests := []struct {
+		name        string
+		preExit     int
+		preRequired bool
+		mainExit    int
+		wantMain    bool
+		wantPost    string // post_hook_command 的输出，空表示没有执行
+	}{
+		{"success", 0, false, 0, true, "hook post WINPSP_EXIT_CODE=0"},
+		{"main failed", 0, false, 3, true, "hook post WINPSP_EXIT_CODE=3"},
+		{"pre failed", 1, false, 0, true, "hook post WINPSP_EXIT_CODE=0"},
+		{"pre failed and required", 1, true, 0, false, ""},
+		{"required pre succeeded", 0, true, 4, true, "hook post WINPSP_EXIT_CODE=4"},
+	}
+	exe, _ := helperCommandLine(t, "hook")
+	t.Setenv(testHelperEnv, "hook") // 钩子继承服务的环境
+	for _, tt := range tests {
+		data, err := json.Marshal(map[string]any{
+			"command":           fmt.Sprintf("cmd.exe /c exit %d", tt.mainExit),
+			"pre_hook_command":  fmt.Sprintf("%s pre %d", exe, tt.preExit),
+			"pre_hook_required": tt.preRequired,
+			"pre_hook_timeout":  30,
+			"post_hook_command": exe + " post 0",
+			"post_hook_timeout": 30,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := loadedService(t, string(data))
+		s.interactive = true
+		log := shutdownLog(t, s)
+
+		if !strings.Contains(log, "--- pre_hook_command ---") || !regexp.MustCompile(`hook pre WINPSP_EXIT_CODE=\r?\n`).MatchString(log) {
+			t.Errorf("%s: pre_hook_command did not run before the main command:\n%s", tt.name, log)
+		}
+		if ran := strings.Contains(log, "Running: cmd.exe /c exit"); ran != tt.wantMain {
+			t.Errorf("%s: main command ran = %v, want %v:\n%s", tt.name, ran, tt.wantMain, log)
+		}
+		ranPost := strings.Contains(log, "--- post_hook_command ---")
+		if ranPost != (tt.wantPost != "") || (ranPost && !strings.Contains(log, tt.wantPost)) {
+			t.Errorf("%s: post_hook_command output, want %q:\n%s", tt.name, tt.wantPost, log)
+		}
+		if tt.wantMain && strings.Index(log, "--- post_hook_command ---") < strings.Index(log, "Running: cmd.exe /c exit") {
+			t.Errorf("%s: post_hook_command ran before the main command:\n%s", tt.name, log)
+		}
+	}
+}
+
+func TestCommandBaseDir(t *testing.T) {
+	const configPath = `C:\ProgramData\WinPSP\winpsp.json`
+	tests := []struct {
+		baseDir, want string
+	}{
+		{"", `C:\ProgramData\WinPSP`},
+		{"scripts", `C:\ProgramData\WinPSP\scripts`},
+		{`..\Tools`, `C:\ProgramData\Tools`},
+		{`D:\Backup\bin`, `D:\Backup\bin`},
+	}
+	for _, tt := range tests {
+		s := &winpspService{configPath: configPath}
+		s.config.Store(&Config{CommandBaseDir: tt.baseDir})
+		if got := s.commandBaseDir(); got != tt.want {
+			t.Errorf("commandBaseDir(%q) = %q, want %q", tt.baseDir, got, tt.want)
+		}
+	}
+}
+
+func TestCommandArgvBaseDir(t *testing.T) {
+	tests := []struct {
+		command, baseDir string
+		wantExe          string
+	}{
+		{`scripts\backup.exe /full`, `C:\ProgramData\WinPSP`, `C:\ProgramData\WinPSP\scripts\backup.exe`},
+		{`./backup.exe`, `D:\Tools`, `D:\Tools\backup.exe`},
+		{`..\bin\backup.exe`, `D:\Tools\cfg`, `D:\Tools\bin\backup.exe`},
+		{`C:\Tools\backup.exe`, `D:\Tools`, `C:\Tools\backup.exe`},
+		{`whoami.exe`, `D:\Tools`, `whoami.exe`}, // 不含路径分隔符：按 PATH 查找
+		{`scripts\backup.exe`, "", `scripts\backup.exe`},
+	}
+	for _, tt := range tests {
+		exe, _, _, err := commandArgv(tt.command, &execOptions{BaseDir: tt.baseDir})
+		if err != nil || exe != tt.wantExe {
+			t.Errorf("commandArgv(%q, base %q) = %q, %v, want %q", tt.command, tt.baseDir, exe, err, tt.wantExe)
+		}
+	}
+}
+
+func TestShutdownRelativeCommand(t *testing.T) {
+	tests := []struct {
+		baseDir string // 空表示配置文件所在目录
+		script  string // 相对配置文件目录的脚本位置
+	}{
+		{"", `scripts\hello.bat`},
+		{"tools", `tools\scripts\hello.bat`},
+	}
+	for _, tt := range tests {
+		s := writeConfig(t, fmt.Sprintf(`{"command": "scripts\\hello.bat", "command_base_dir": %q}`, tt.baseDir))
+		script := filepath.Join(filepath.Dir(s.configPath), tt.script)
+		if err := os.MkdirAll(filepath.Dir(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(script, []byte("@echo ran "+tt.script+"\r\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.loadConfig(); err != nil {
+			t.Fatal(err)
+		}
+		s.interactive = true
+		log := shutdownLog(t, s)
+		if !strings.Contains(log, "] ran "+tt.script) {
+			t.Errorf("command_base_dir %q: log does not show %s ran:\n%s", tt.baseDir, script, log)
+		}
+	}
+}
+
+func TestWaitShutdownDone(t *testing.T) {
+	tests := []struct {
+		name        string
+		grace       int
+		finish      time.Duration // 关机处理在这之后结束，0 表示一直不结束，负数表示已经结束
+		wantWarning bool
+	}{
+		{"finishes within the grace period", 5, 200 * time.Millisecond, false},
+		{"already finished", 5, -1, false},
+		{"still running", 1, 0, true},
+		{"no grace period", 0, 0, true},
+	}
+	for _, tt := range tests {
+		events := mockEvents(t)
+		s := loadedService(t, fmt.Sprintf(`{"command": "cmd.exe /c echo run", "stop_grace_period_secs": %d}`, tt.grace))
+		done := make(chan struct{})
+		if tt.finish < 0 {
+			close(done)
+		} else if tt.finish > 0 {
+			time.AfterFunc(tt.finish, func() { close(done) })
+		}
+
+		start := time.Now()
+		s.waitShutdownDone(done)
+		d := time.Since(start)
+
+		got := events.all()
+		if len(got) == 0 || got[0] != (loggedEvent{eventInfo, eventIDStopWaiting, "Waiting for running command to complete before stopping."}) {
+			t.Errorf("%s: events %+v, want the waiting message first", tt.name, got)
+		}
+		warned := len(got) == 2 && got[1].kind == eventWarning && got[1].eid == eventIDStopWaiting
+		if warned != tt.wantWarning || len(got) > 2 {
+			t.Errorf("%s: events %+v, want warning %v", tt.name, got, tt.wantWarning)
+		}
+		if tt.wantWarning && (d < time.Duration(tt.grace)*time.Second || d > time.Duration(tt.grace)*time.Second+time.Second) {
+			t.Errorf("%s: gave up after %s, want %ds", tt.name, d, tt.grace)
+		}
+		if !tt.wantWarning && d > time.Second {
+			t.Errorf("%s: waited %s after the run finished", tt.name, d)
+		}
+	}
+}
+
+// PreShutdown 开始的关机处理在运行时收到 Stop：Execute 等命令结束后才返回
+func TestExecuteStopWaitsForShutdown(t *testing.T) {
+	events := mockEvents(t)
+	exe, _ := helperCommandLine(t, "sleep")
+	t.Setenv(testHelperEnv, "sleep")
+	data, err := json.Marshal(map[string]any{"command": exe + " 1500", "timeout": 30, "stop_grace_period_secs": 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := loadedService(t, string(data))
+	s.interactive = true
+
+	r := make(chan svc.ChangeRequest)
+	changes := make(chan svc.Status, 100)
+	done := make(chan struct{})
+	go func() {
+		s.Execute(nil, r, changes)
+		close(done)
+	}()
+	for st := range changes {
+		if st.State == svc.Running {
+			break
+		}
+	}
+
+	start := time.Now()
+	r <- svc.ChangeRequest{Cmd: svc.PreShutdown}
+	time.Sleep(300 * time.Millisecond)
+	r <- svc.ChangeRequest{Cmd: svc.Stop}
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Execute did not return after Stop")
+	}
+	if d := time.Since(start); d < 1500*time.Millisecond {
+		t.Errorf("Execute returned %s after PreShutdown, before the command finished", d)
+	}
+
+	var waiting, warned bool
+	for _, e := range events.all() {
+		if e.eid == eventIDStopWaiting {
+			waiting = waiting || e.kind == eventInfo
+			warned = warned || e.kind == eventWarning
+		}
+	}
+	if !waiting || warned {
+		t.Errorf("stop waiting events: info %v, warning %v, want info only", waiting, warned)
+	}
+	logs, _ := filepath.Glob(filepath.Join(filepath.Dir(s.configPath), logFilePrefix+"*"+logFileExt))
+	if len(logs) != 1 {
+		t.Errorf("log files: %q, want one from the completed run", logs)
+	}
+}
+
+func TestPreShutdownDelay(t *testing.T) {
+	tests := []struct {
+		data string
+		want time.Duration
+	}{
+		{`{"command": "backup.exe"}`, 0},
+		{`{"command": "backup.exe", "pre_shutdown_notification_delay_ms": 1500}`, 1500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := loadedService(t, tt.data).preShutdownDelay(); got != tt.want {
+			t.Errorf("%s: preShutdownDelay = %s, want %s", tt.data, got, tt.want)
+		}
+	}
+	if got := (&winpspService{}).preShutdownDelay(); got != 0 {
+		t.Errorf("preShutdownDelay without config = %s", got)
+	}
+	if _, err := writeConfig(t, `{"command": "backup.exe", "pre_shutdown_notification_delay_ms": -1}`).readConfig(); err == nil {
+		t.Error("negative pre_shutdown_notification_delay_ms accepted")
+	}
+}
+
+// 收到 PreShutdown 后先等待 delay，期间报告进度，到时后才执行命令
+func TestExecutePreShutdownDelay(t *testing.T) {
+	mockEvents(t)
+	delay, checkpoint := make(chan time.Time), make(chan time.Time)
+	var delays []time.Duration
+	stopped := make(chan struct{})
+	orig := preShutdownTimers
+	t.Cleanup(func() { preShutdownTimers = orig })
+	preShutdownTimers = func(d time.Duration) (<-chan time.Time, <-chan time.Time, func()) {
+		delays = append(delays, d)
+		return delay, checkpoint, func() { close(stopped) }
+	}
+
+	s := loadedService(t, `{"command": "cmd.exe /c echo ran", "pre_shutdown_notification_delay_ms": 30000}`)
+	s.interactive = true
+	logs := func() []string {
+		l, _ := filepath.Glob(filepath.Join(filepath.Dir(s.configPath), logFilePrefix+"*"+logFileExt))
+		return l
+	}
+
+	r := make(chan svc.ChangeRequest)
+	changes := make(chan svc.Status, 100)
+	done := make(chan struct{})
+	go func() {
+		s.Execute(nil, r, changes)
+		close(done)
+	}()
+	for st := range changes {
+		if st.State == svc.Running {
+			break
+		}
+	}
+	next := func() svc.Status {
+		t.Helper()
+		select {
+		case st := <-changes:
+			return st
+		case <-time.After(5 * time.Second):
+			t.Fatal("no status update")
+		}
+		return svc.Status{}
+	}
+
+	r <- svc.ChangeRequest{Cmd: svc.PreShutdown}
+	if st := next(); st.State != svc.StopPending {
+		t.Fatalf("status after PreShutdown = %+v", st)
+	}
+	// 重复的 PreShutdown 不重新开始等待
+	r <- svc.ChangeRequest{Cmd: svc.PreShutdown}
+	next()
+	if len(delays) != 1 || delays[0] != 30*time.Second {
+		t.Fatalf("pre-shutdown timers started with %v, want once with 30s", delays)
+	}
+
+	for i := uint32(1); i <= 2; i++ {
+		checkpoint <- time.Now()
+		st := next()
+		if st.State != svc.StopPending || st.CheckPoint != i || st.WaitHint != uint32((2*preShutdownCheckpointInterval).Milliseconds()) {
+			t.Errorf("checkpoint %d: status %+v", i, st)
+		}
+	}
+	if l := logs(); len(l) != 0 {
+		t.Fatalf("command ran before the delay ended: %q", l)
+	}
+
+	delay <- time.Now()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("Execute did not return after the shutdown handling")
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Error("checkpoint ticker not stopped")
+	}
+	if l := logs(); len(l) != 1 {
+		t.Errorf("log files: %q, want one from the run after the delay", l)
+	}
+}
+
+func TestRotateLogsDirSize(t *testing.T) {
+	const mb = 1 << 20
+	tests := []struct {
+		name     string
+		sizes    []int64 // 从早到晚的日志大小
+		count    int
+		maxMB    int
+		wantKept int  // 留下最新的几个
+		oldest   bool // 1 MB 的周期日志最早，否则最新
+	}{
+		{"under the cap", []int64{mb, mb, mb}, 0, 5, 3, false},
+		{"exactly at the cap", []int64{2 * mb, 2 * mb}, 0, 5, 2, false},
+		{"periodic log counts", []int64{2 * mb, 2 * mb}, 0, 4, 1, false},
+		{"oldest deleted first", []int64{3 * mb, mb, mb, mb}, 0, 3, 2, false},
+		{"one large old file", []int64{10 * mb, mb, mb}, 0, 5, 2, false},
+		{"latest alone over the cap", []int64{mb, 6 * mb}, 0, 5, 0, false},
+		{"after count pruning", []int64{5 * mb, 2 * mb, 2 * mb, 2 * mb}, 3, 5, 2, false},
+		{"older periodic log deleted first", []int64{mb, mb}, 0, 2, 2, true},
+		{"unlimited", []int64{10 * mb, 10 * mb}, 0, 0, 2, false},
+	}
+	base := time.Now().Add(-time.Hour)
+	for _, tt := range tests {
+		dir := t.TempDir()
+		var names []string
+		for i, size := range tt.sizes {
+			name := fmt.Sprintf("%s20261014-08%02d00%s", logFilePrefix, i, logFileExt)
+			path := filepath.Join(dir, name)
+			f, err := os.Create(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = f.Truncate(size)
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			mtime := base.Add(time.Duration(i+1) * time.Minute)
+			if err := os.Chtimes(path, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, name)
+		}
+		// periodic_command 的日志单独计数，但计入目录的总大小
+		periodic := filepath.Join(dir, periodicLogPrefix+"20261014-080000"+logFileExt)
+		if err := os.WriteFile(periodic, bytes.Repeat([]byte("x"), mb), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := base.Add(time.Duration(len(tt.sizes)+1) * time.Minute)
+		if tt.oldest {
+			mtime = base
+		}
+		if err := os.Chtimes(periodic, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+
+		retention := logRetention{count: tt.count, maxBytes: int64(tt.maxMB) << 20}
+		if err := rotateLogs(dir, logFilePrefix, retention); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		logs, err := listLogFiles(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range logs {
+			got = append(got, e.Name())
+		}
+		want := names[len(names)-tt.wantKept:]
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("%s: logs left %q, want %q", tt.name, got, want)
+		}
+		if _, err := os.Stat(periodic); (err == nil) == tt.oldest {
+			t.Errorf("%s: periodic log present = %v, want %v", tt.name, err == nil, !tt.oldest)
+		}
+	}
+}
+
+// 只有顶层的 timeout 键才算设置了超时，出现在字符串值或其它键中的 "timeout" 不算
+func TestReadConfigTimeoutKey(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want int
+	}{
+		{"absent", `{"command": "backup.exe"}`, defaultTimeoutSecs},
+		{"in the command string", `{"command": "run-with-timeout.exe --timeout 60 \"timeout\""}`, defaultTimeoutSecs},
+		{"in another key", `{"command": "backup.exe", "pre_hook_command": "x.exe", "pre_hook_timeout": 10}`, defaultTimeoutSecs},
+		{"in a commands entry", `{"commands": [{"command": "backup.exe", "timeout": 10}]}`, defaultTimeoutSecs},
+		{"in env", `{"command": "backup.exe", "env": {"timeout": "60"}}`, defaultTimeoutSecs},
+		{"set", `{"command": "backup.exe", "timeout": 60}`, 60},
+		{"zero means no timeout", `{"command": "backup.exe", "timeout": 0}`, 0},
+	}
+	for _, tt := range tests {
+		cfg, err := writeConfig(t, tt.data).readConfig()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if cfg.Timeout == nil || *cfg.Timeout != tt.want {
+			t.Errorf("%s: timeout = %v, want %d", tt.name, cfg.Timeout, tt.want)
+		}
+	}
+}
+
+func TestReadConfigCommandEnvVar(t *testing.T) {
+	const envVar = "WINPSP_TEST_COMMAND"
+	tests := []struct {
+		name    string
+		value   string // 环境变量的值
+		data    string
+		want    string
+		wantErr bool
+	}{
+		{"overrides command", `C:\Tools\deploy.exe`, `{"command": "backup.exe", "command_env_var": "WINPSP_TEST_COMMAND"}`, `C:\Tools\deploy.exe`, false},
+		{"trimmed", "  deploy.exe /quiet \r\n", `{"command_env_var": "WINPSP_TEST_COMMAND"}`, "deploy.exe /quiet", false},
+		{"empty falls back to command", "", `{"command": "backup.exe", "command_env_var": "WINPSP_TEST_COMMAND"}`, "backup.exe", false},
+		{"blank falls back to command", "  ", `{"command": "backup.exe", "command_env_var": "WINPSP_TEST_COMMAND"}`, "backup.exe", false},
+		{"not set without command", "", `{"command_env_var": "WINPSP_TEST_COMMAND"}`, "", true},
+		{"not used without command_env_var", "deploy.exe", `{"command": "backup.exe"}`, "backup.exe", false},
+	}
+	for _, tt := range tests {
+		t.Setenv(envVar, tt.value)
+		cfg, err := writeConfig(t, tt.data).readConfig()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: readConfig error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && cfg.Command != tt.want {
+			t.Errorf("%s: command = %q, want %q", tt.name, cfg.Command, tt.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// -------------------- max_output_lines --------------------
+
+// max_output_lines 限制每条命令写入日志的行数：输出先进入只保留最后 N 行的缓冲区，
+// 命令结束后再写入日志，有行被丢弃时先写一行说明。
+// 输出管道不受影响，仍然实时转发全部输出。
+
+// limitOutputLines 返回执行命令时使用的输出，以及命令结束后要调用的 flush。
+// log 是这条命令的日志，pipe 是输出管道，都可以为 nil。
+func (s *winpspService) limitOutputLines(log, pipe io.Writer) (io.Writer, func()) {
+	n := s.config.Load().MaxOutputLines
+	if n <= 0 || log == nil {
+		return joinWriters(log, pipe), func() {}
+	}
+
+	buf := newTailBuffer(n)
+	return joinWriters(buf, pipe), func() {
+		if dropped := buf.Dropped(); dropped > 0 {
+			fmt.Fprintf(log, "[WinPSP] Output truncated: %d earlier line(s) omitted (max_output_lines = %d)\n", dropped, n)
+		}
+		for _, line := range buf.Lines() {
+			fmt.Fprintln(log, line)
+		}
+	}
+}
+
+// joinWriters 同 io.MultiWriter，忽略为 nil 的一方；两者都为 nil 时返回 nil
+func joinWriters(a, b io.Writer) io.Writer {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	}
+	return io.MultiWriter(a, b)
+}
@@ -0,0 +1,57 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLimitOutputLines(t *testing.T) {
+	const output = "line 1\nline 2\nline 3\nline 4\nline 5\n"
+	tests := []struct {
+		n    int
+		want string // flush 之后日志的内容
+	}{
+		{0, output},
+		{5, output},
+		{10, output},
+		{3, "[WinPSP] Output truncated: 2 earlier line(s) omitted (max_output_lines = 3)\nline 3\nline 4\nline 5\n"},
+		{1, "[WinPSP] Output truncated: 4 earlier line(s) omitted (max_output_lines = 1)\nline 5\n"},
+	}
+	for _, tt := range tests {
+		s := &winpspService{}
+		s.config.Store(&Config{MaxOutputLines: tt.n})
+		var log, pipe bytes.Buffer
+		out, flush := s.limitOutputLines(&log, &pipe)
+
+		// 分多次写入，行可以跨越两次写入
+		for _, part := range []string{output[:10], output[10:23], output[23:]} {
+			out.Write([]byte(part))
+		}
+		if tt.n > 0 && log.Len() != 0 {
+			t.Errorf("max_output_lines %d: log written before flush: %q", tt.n, log.String())
+		}
+		flush()
+		if log.String() != tt.want {
+			t.Errorf("max_output_lines %d: log = %q, want %q", tt.n, log.String(), tt.want)
+		}
+		// 输出管道始终收到全部输出
+		if pipe.String() != output {
+			t.Errorf("max_output_lines %d: pipe = %q, want all output", tt.n, pipe.String())
+		}
+	}
+}
+
+func TestJoinWriters(t *testing.T) {
+	var a, b bytes.Buffer
+	if w := joinWriters(nil, nil); w != nil {
+		t.Errorf("joinWriters(nil, nil) = %v, want nil", w)
+	}
+	joinWriters(&a, nil).Write([]byte("a"))
+	joinWriters(nil, &b).Write([]byte("b"))
+	joinWriters(&a, &b).Write([]byte("c"))
+	if a.String() != "ac" || b.String() != "bc" {
+		t.Errorf("writers got %q and %q, want \"ac\" and \"bc\"", a.String(), b.String())
+	}
+}
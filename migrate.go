@@ -0,0 +1,223 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// -------------------- 配置迁移 --------------------
+
+// 配置格式版本：1 = 单条 command（schema_version 缺省），2 = commands 数组
+const currentSchemaVersion = 2
+
+// jsonField 是 JSON 对象中的一个键值对。迁移时按原顺序读写字段，
+// 未改动的字段原样保留，包括本版本不认识的字段。
+type jsonField struct {
+	Key   string
+	Value json.RawMessage
+}
+
+// migrateConfig 实现 --migrate-config：把 v1 配置的 command + timeout 改写为
+// commands 的第一项，加上 schema_version，其余字段不变。
+// in 为空时读取 configPath，out 为空时输出到标准输出。
+func migrateConfig(from, to, configPath, in, out string) error {
+	if from != "v1" || to != "v2" {
+		return fmt.Errorf("unsupported migration %s -> %s (only v1 -> v2)", from, to)
+	}
+	if in == "" {
+		in = configPath
+	}
+
+	data, err := readConfigFile(in)
+	if err != nil {
+		return err
+	}
+	fields, err := decodeObject(data)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", in, err)
+	}
+
+	migrated, err := migrateV1(fields)
+	if err != nil {
+		return fmt.Errorf("%s: %w", in, err)
+	}
+	result, err := encodeObject(migrated)
+	if err != nil {
+		return err
+	}
+
+	// 迁移结果必须仍是有效配置
+	var cfg Config
+	if err := json.Unmarshal(result, &cfg); err != nil {
+		return fmt.Errorf("migrated config is invalid: %w", err)
+	}
+
+	if out == "" {
+		_, err = os.Stdout.Write(result)
+		return err
+	}
+	return os.WriteFile(out, result, 0644)
+}
+
+func migrateV1(fields []jsonField) ([]jsonField, error) {
+	if i := fieldIndex(fields, "schema_version"); i >= 0 {
+		var v int
+		if err := json.Unmarshal(fields[i].Value, &v); err == nil && v >= currentSchemaVersion {
+			return nil, fmt.Errorf("config is already schema_version %d", v)
+		}
+		fields = append(fields[:i:i], fields[i+1:]...)
+	}
+	if fieldIndex(fields, "commands") >= 0 {
+		return nil, errors.New("config already uses commands, nothing to migrate")
+	}
+
+	command := fieldValue(fields, "command")
+	timeout := fieldValue(fields, "timeout")
+	fields, err := moveToCommands(fields, command, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// profile 会覆盖顶层字段：改写了 command 或 timeout 的 profile
+	// 也要换成 commands，否则合并后 command 和 commands 会同时存在
+	if i := fieldIndex(fields, "profiles"); i >= 0 {
+		profiles, err := decodeObject(fields[i].Value)
+		if err != nil {
+			return nil, fmt.Errorf("profiles: %w", err)
+		}
+		for j := range profiles {
+			p, err := decodeObject(profiles[j].Value)
+			if err != nil {
+				return nil, fmt.Errorf("profiles.%s: %w", profiles[j].Key, err)
+			}
+			if fieldIndex(p, "commands") >= 0 {
+				return nil, fmt.Errorf("profiles.%s already uses commands, nothing to migrate", profiles[j].Key)
+			}
+			pc, pt := fieldValue(p, "command"), fieldValue(p, "timeout")
+			if pc == nil && pt == nil {
+				continue
+			}
+			if pc == nil {
+				pc = command
+			}
+			if pt == nil {
+				pt = timeout
+			}
+			if p, err = moveToCommands(p, pc, pt); err != nil {
+				return nil, fmt.Errorf("profiles.%s: %w", profiles[j].Key, err)
+			}
+			if profiles[j].Value, err = encodeObject(p); err != nil {
+				return nil, err
+			}
+		}
+		if fields[i].Value, err = encodeObject(profiles); err != nil {
+			return nil, err
+		}
+	}
+
+	version, _ := json.Marshal(currentSchemaVersion)
+	return append([]jsonField{{Key: "schema_version", Value: version}}, fields...), nil
+}
+
+// moveToCommands 用 commands: [{command, timeout}] 替换 fields 中的 command。
+// 顶层 timeout 保留，它仍是全部命令的总预算。
+func moveToCommands(fields []jsonField, command, timeout json.RawMessage) ([]jsonField, error) {
+	if command == nil {
+		return fields, nil
+	}
+
+	entry := []jsonField{{Key: "command", Value: command}}
+	if timeout != nil {
+		entry = append(entry, jsonField{Key: "timeout", Value: timeout})
+	}
+	obj, err := encodeObject(entry)
+	if err != nil {
+		return nil, err
+	}
+	commands := append(append([]byte{'['}, bytes.TrimSpace(obj)...), ']')
+
+	if i := fieldIndex(fields, "command"); i >= 0 {
+		fields[i] = jsonField{Key: "commands", Value: commands}
+	} else {
+		fields = append(fields, jsonField{Key: "commands", Value: commands})
+	}
+	return fields, nil
+}
+
+func fieldIndex(fields []jsonField, key string) int {
+	for i := range fields {
+		if fields[i].Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func fieldValue(fields []jsonField, key string) json.RawMessage {
+	if i := fieldIndex(fields, key); i >= 0 {
+		return fields[i].Value
+	}
+	return nil
+}
+
+// decodeObject 按原顺序读出 JSON 对象的字段
+func decodeObject(data []byte) ([]jsonField, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil {
+		return nil, err
+	} else if tok != json.Delim('{') {
+		return nil, errors.New("not a JSON object")
+	}
+
+	var fields []jsonField
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var f jsonField
+		f.Key, _ = tok.(string)
+		if err := dec.Decode(&f.Value); err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err == nil {
+		return nil, errors.New("unexpected data after the JSON object")
+	}
+	return fields, nil
+}
+
+// encodeObject 按 fields 的顺序写出 JSON 对象，两个空格缩进
+func encodeObject(fields []jsonField) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(f.Key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(f.Value)
+	}
+	buf.WriteByte('}')
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, buf.Bytes(), "", "  "); err != nil {
+		return nil, err
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
@@ -0,0 +1,166 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		in, want string
+	}{
+		{
+			"command and timeout",
+			`{
+  "command": "C:\\Tools\\backup.exe /full",
+  "timeout": 300,
+  "log_count": 5
+}`,
+			`{
+  "schema_version": 2,
+  "commands": [
+    {
+      "command": "C:\\Tools\\backup.exe /full",
+      "timeout": 300
+    }
+  ],
+  "timeout": 300,
+  "log_count": 5
+}
+`,
+		},
+		{
+			"no timeout, unknown field kept",
+			`{"log_count": 3, "command": "shutdown-hook.bat", "x_custom": {"a": [1, 2]}}`,
+			`{
+  "schema_version": 2,
+  "log_count": 3,
+  "commands": [
+    {
+      "command": "shutdown-hook.bat"
+    }
+  ],
+  "x_custom": {
+    "a": [
+      1,
+      2
+    ]
+  }
+}
+`,
+		},
+		{
+			"schema_version 1",
+			`{"schema_version": 1, "command": "a.exe", "timeout": 60}`,
+			`{
+  "schema_version": 2,
+  "commands": [
+    {
+      "command": "a.exe",
+      "timeout": 60
+    }
+  ],
+  "timeout": 60
+}
+`,
+		},
+		{
+			"profiles",
+			`{
+  "command": "a.exe",
+  "timeout": 60,
+  "profiles": {
+    "office": {"timeout": 120},
+    "lab": {"command": "b.exe"},
+    "quiet": {"log_count": 1}
+  }
+}`,
+			`{
+  "schema_version": 2,
+  "commands": [
+    {
+      "command": "a.exe",
+      "timeout": 60
+    }
+  ],
+  "timeout": 60,
+  "profiles": {
+    "office": {
+      "timeout": 120,
+      "commands": [
+        {
+          "command": "a.exe",
+          "timeout": 120
+        }
+      ]
+    },
+    "lab": {
+      "commands": [
+        {
+          "command": "b.exe",
+          "timeout": 60
+        }
+      ]
+    },
+    "quiet": {
+      "log_count": 1
+    }
+  }
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		in := filepath.Join(dir, "v1.json")
+		out := filepath.Join(dir, "v2.json")
+		if err := os.WriteFile(in, []byte(tt.in), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := migrateConfig("v1", "v2", "", in, out); err != nil {
+			t.Errorf("%s: migrateConfig: %v", tt.name, err)
+			continue
+		}
+		got, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: migrated config:\n%s\nwant:\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMigrateConfigErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		in       string
+	}{
+		{"already commands", "v1", "v2", `{"commands": [{"command": "a.exe"}]}`},
+		{"already v2", "v1", "v2", `{"schema_version": 2, "command": "a.exe"}`},
+		{"profile uses commands", "v1", "v2", `{"command": "a.exe", "profiles": {"lab": {"commands": []}}}`},
+		{"not an object", "v1", "v2", `["a.exe"]`},
+		{"trailing data", "v1", "v2", `{"command": "a.exe"} {}`},
+		{"invalid result", "v1", "v2", `{"command": "a.exe", "log_count": "five"}`},
+		{"unsupported versions", "v2", "v3", `{"command": "a.exe"}`},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		in := filepath.Join(dir, "v1.json")
+		out := filepath.Join(dir, "v2.json")
+		if err := os.WriteFile(in, []byte(tt.in), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := migrateConfig(tt.from, tt.to, "", in, out); err == nil {
+			t.Errorf("%s: migrateConfig succeeded", tt.name)
+		}
+		if _, err := os.Stat(out); err == nil {
+			t.Errorf("%s: output written although the migration failed", tt.name)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+const defaultMutexWaitMs = 5000
+
+const (
+	mutexActionProceed = "proceed"
+	mutexActionAbort   = "abort"
+)
+
+var errMutexBusy = errors.New("another WinPSP process is handling shutdown")
+
+// -------------------- 全局互斥 --------------------
+
+// acquireInstanceMutex 获取系统范围的命名互斥量 Global\WinPSP-{service-name}，
+// 防止不同进程（不同会话里的交互模式、服务本身）同时执行关机命令。
+// 返回的函数释放互斥量；等待超时返回 errMutexBusy。
+func acquireInstanceMutex(wait time.Duration) (release func(), err error) {
+	name, err := windows.UTF16PtrFromString(`Global\WinPSP-` + serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := windows.CreateMutex(nil, false, name)
+	if h == 0 {
+		return nil, err
+	}
+
+	ev, err := windows.WaitForSingleObject(h, uint32(wait.Milliseconds()))
+	switch ev {
+	case windows.WAIT_OBJECT_0, windows.WAIT_ABANDONED:
+		// WAIT_ABANDONED：上一个持有者异常退出，互斥量已归我们所有
+		return func() {
+			windows.ReleaseMutex(h)
+			windows.CloseHandle(h)
+		}, nil
+	case uint32(windows.WAIT_TIMEOUT):
+		windows.CloseHandle(h)
+		return nil, errMutexBusy
+	default:
+		windows.CloseHandle(h)
+		return nil, err
+	}
+}
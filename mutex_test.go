@@ -0,0 +1,97 @@
+//go:build windows
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func init() {
+	// 以 args[0] 为服务名获取实例互斥量，输出 held 后：
+	// args[1] 为 exit 时不释放直接退出（互斥量被遗弃），否则等到标准输入关闭再释放
+	testHelpers["holdmutex"] = func(args []string) int {
+		serviceName = args[0]
+		release, err := acquireInstanceMutex(0)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		fmt.Println("held")
+		if args[1] == "exit" {
+			os.Exit(0)
+		}
+		bufio.NewReader(os.Stdin).ReadString('\n')
+		release()
+		return 0
+	}
+}
+
+// useServiceName 让测试使用自己的互斥量，不影响本机已安装的 WinPSP
+func useServiceName(t *testing.T, name string) {
+	t.Helper()
+	orig := serviceName
+	serviceName = name
+	t.Cleanup(func() { serviceName = orig })
+}
+
+func TestInstanceMutex(t *testing.T) {
+	tests := []struct {
+		name     string
+		holder   string // 子进程持有互斥量的方式，空表示没有其它持有者
+		wait     time.Duration
+		wantBusy bool
+	}{
+		{"free", "", 0, false},
+		{"held by a running process", "wait", 200 * time.Millisecond, true},
+		// 持有者崩溃后互斥量被遗弃，不会像残留的锁文件那样挡住之后的运行
+		{"abandoned by a dead process", "exit", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useServiceName(t, fmt.Sprintf("WinPSPTest-Mutex-%d", os.Getpid()))
+			if tt.holder != "" {
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+				cmd := helperCommand(ctx, t, "holdmutex", serviceName, tt.holder)
+				stdin, err := cmd.StdinPipe()
+				if err != nil {
+					t.Fatal(err)
+				}
+				stdout, err := cmd.StdoutPipe()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := cmd.Start(); err != nil {
+					t.Fatal(err)
+				}
+				defer func() {
+					stdin.Close()
+					cmd.Wait()
+				}()
+				if line, _ := bufio.NewReader(stdout).ReadString('\n'); line != "held\n" && line != "held\r\n" {
+					t.Fatalf("helper: %q", line)
+				}
+				if tt.holder == "exit" {
+					cmd.Wait()
+				}
+			}
+
+			release, err := acquireInstanceMutex(tt.wait)
+			if tt.wantBusy {
+				if err != errMutexBusy {
+					t.Fatalf("acquireInstanceMutex = %v, want errMutexBusy", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("acquireInstanceMutex: %v", err)
+			}
+			release()
+		})
+	}
+}
@@ -0,0 +1,45 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+const defaultStatsDPort = 8125
+
+// -------------------- 网络地址 --------------------
+
+// hostPort 把配置中的地址规范成 net.Dial 可用的 host:port。
+// 接受 "host"、"host:port"、"1.2.3.4"、"::1"、"[::1]" 和 "[::1]:port"；
+// 没有端口时使用 defaultPort。IPv6 地址必须用方括号才能带端口。
+func hostPort(addr string, defaultPort int) (string, error) {
+	addr = strings.TrimSpace(addr)
+	if addr == "" {
+		return "", fmt.Errorf("empty address")
+	}
+
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		if host == "" {
+			return "", fmt.Errorf("missing host in address %q", addr)
+		}
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return "", fmt.Errorf("invalid port in address %q", addr)
+		}
+		return net.JoinHostPort(host, port), nil
+	}
+
+	// 没有端口：去掉可能的方括号，裸 IPv6 地址也在这里处理
+	if strings.HasPrefix(addr, "[") != strings.HasSuffix(addr, "]") {
+		return "", fmt.Errorf("invalid address %q", addr)
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	if _, err := netip.ParseAddr(host); strings.ContainsAny(host, "[]") || (strings.Contains(host, ":") && err != nil) {
+		return "", fmt.Errorf("invalid address %q", addr)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(defaultPort)), nil
+}
@@ -0,0 +1,71 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHostPort(t *testing.T) {
+	tests := []struct {
+		addr    string
+		want    string
+		wantErr bool
+	}{
+		{"127.0.0.1", "127.0.0.1:8125", false},
+		{"127.0.0.1:9125", "127.0.0.1:9125", false},
+		{"::1", "[::1]:8125", false},
+		{"[::1]", "[::1]:8125", false},
+		{"[::1]:514", "[::1]:514", false},
+		{"fe80::1%eth0", "[fe80::1%eth0]:8125", false},
+		{"metrics.example.com", "metrics.example.com:8125", false},
+		{"metrics.example.com:9125", "metrics.example.com:9125", false},
+		{"  localhost  ", "localhost:8125", false},
+		{"", "", true},
+		{":8125", "", true},
+		{"localhost:http", "", true},
+		{"localhost:70000", "", true},
+		{"[::1", "", true},
+		{"::1]", "", true},
+		{"::1:514", "[::1:514]:8125", false}, // 没有方括号时整个都是 IPv6 地址
+		{"[[::1]]", "", true},
+		{"not::an::address", "", true},
+	}
+	for _, tt := range tests {
+		got, err := hostPort(tt.addr, defaultStatsDPort)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("hostPort(%q) = %q, %v, want %q, wantErr %v", tt.addr, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestReadConfigAddresses(t *testing.T) {
+	tests := []struct {
+		field, value string
+		wantErr      bool
+	}{
+		{"webhook_url", "http://127.0.0.1:8080/hook", false},
+		{"webhook_url", "http://[::1]:8080/hook", false},
+		{"webhook_url", "https://hooks.example.com/winpsp", false},
+		{"webhook_url", "http://::1:8080/hook", true},
+		{"statsd_address", "127.0.0.1", false},
+		{"statsd_address", "::1", false},
+		{"statsd_address", "[::1]:8125", false},
+		{"statsd_address", "statsd.example.com:8125", false},
+		{"statsd_address", "[::1", true},
+		{"require_network_host", "127.0.0.1", false},
+		{"require_network_host", "::1", false},
+		{"require_network_host", "[::1]:445", false},
+		{"require_network_host", "nas01.example.com", false},
+		{"require_network_host", "nas01:port", true},
+	}
+	for _, tt := range tests {
+		data := fmt.Sprintf(`{"command": "C:\\Windows\\System32\\whoami.exe", "require_network": true, "require_network_host": "nas01", %q: %q}`,
+			tt.field, tt.value)
+		err := writeConfig(t, data).loadConfig()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s %q: loadConfig error = %v, wantErr %v", tt.field, tt.value, err, tt.wantErr)
+		}
+	}
+}
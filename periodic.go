@@ -0,0 +1,81 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const defaultPeriodicIntervalSecs = 3600
+
+// -------------------- periodic_command --------------------
+
+// periodic_command 在服务运行期间每隔 periodic_interval_secs 执行一次（例如增量检查点），
+// 每次写一个 winpsp-periodic-<时间>.log，与关机日志分开按 log_count 轮换。
+// 定时执行在后台进行，不影响关机处理；关机处理开始后不再启动新的定时执行。
+// 上一次还没结束时跳过这一次。定时器在服务启动时按当时的配置创建，
+// 修改 periodic_command / periodic_interval_secs 后需要重启服务。
+
+// periodicTicker 返回 periodic_command 的定时器，未配置时返回 nil
+func (s *winpspService) periodicTicker() *time.Ticker {
+	cfg := s.config.Load()
+	if cfg == nil || cfg.PeriodicCommand == "" {
+		return nil
+	}
+	return time.NewTicker(time.Duration(*cfg.PeriodicIntervalSecs) * time.Second)
+}
+
+// startPeriodicRun 在后台执行一次 periodic_command，返回是否真正启动了。
+// 只在 Execute 的循环中调用，periodicRuns 不需要加锁。
+func (s *winpspService) startPeriodicRun() bool {
+	cfg := s.config.Load()
+	if cfg == nil || cfg.PeriodicCommand == "" {
+		return false
+	}
+	if cfg.MaxPeriodicRuns > 0 && s.periodicRuns >= cfg.MaxPeriodicRuns {
+		return false
+	}
+	if !s.periodicBusy.CompareAndSwap(false, true) {
+		debugf("WinPSP: previous periodic run still in progress, skipped")
+		return false
+	}
+
+	s.periodicRuns++
+	n := s.periodicRuns
+	go func() {
+		defer s.periodicBusy.Store(false)
+		s.snapshot(cfg).runPeriodic(cfg, n)
+	}()
+	return true
+}
+
+// runPeriodic 执行 periodic_command，输出写入单独的日志文件。
+// s 是快照，这里设置的 s.run 不影响可能同时开始的关机处理。
+func (s *winpspService) runPeriodic(cfg *Config, n int) {
+	logFile, logWriter, err := s.openLogFile(periodicLogPrefix)
+	if err != nil {
+		debugf("WinPSP: periodic log not written: %v", err)
+		logFile, logWriter = nil, nil
+	}
+	if logFile != nil {
+		defer logFile.Close()
+	}
+
+	s.run = newRunContext(cfg)
+	logLine := func(format string, args ...any) {
+		if logWriter == nil {
+			return
+		}
+		ts := time.Now().Format(cfg.LogTimestampFormat)
+		fmt.Fprintf(logWriter, "[%s] [run:%s] %s\n", ts, s.run.short(), fmt.Sprintf(format, args...))
+	}
+
+	logLine("%s", versionString())
+	if cfg.MaxPeriodicRuns > 0 {
+		logLine("WinPSP: Periodic run %d of %d", n, cfg.MaxPeriodicRuns)
+	} else {
+		logLine("WinPSP: Periodic run %d", n)
+	}
+	s.runExtraCommand("periodic_command", cfg.PeriodicCommand, *cfg.Timeout, logWriter, cfg.commandEnv(), logLine)
+}
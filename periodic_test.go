@@ -0,0 +1,152 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// periodicLogs 返回配置目录中 periodic_command 日志的全部内容
+func periodicLogs(t *testing.T, s *winpspService) (files int, content string) {
+	t.Helper()
+	logs, _ := filepath.Glob(filepath.Join(filepath.Dir(s.configPath), periodicLogPrefix+"*"+logFileExt))
+	var b strings.Builder
+	for _, l := range logs {
+		data, err := os.ReadFile(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Write(data)
+	}
+	return len(logs), b.String()
+}
+
+// checkpoints 统计命令输出的 checkpoint 行（不含 "Running: ..." 行）
+func checkpoints(log string) int {
+	return len(regexp.MustCompile(`(?m)^checkpoint\r?$`).FindAllString(log, -1))
+}
+
+// waitPeriodicIdle 等待正在进行的定时执行结束
+func waitPeriodicIdle(t *testing.T, s *winpspService) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for s.periodicBusy.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("periodic run did not finish")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestPeriodicTicker(t *testing.T) {
+	if tk := loadedService(t, `{"command": "cmd.exe /c echo shutdown"}`).periodicTicker(); tk != nil {
+		tk.Stop()
+		t.Error("periodicTicker without periodic_command returned a ticker")
+	}
+
+	s := loadedService(t, `{"command": "cmd.exe /c echo shutdown", "periodic_command": "cmd.exe /c echo tick", "periodic_interval_secs": 1}`)
+	tk := s.periodicTicker()
+	if tk == nil {
+		t.Fatal("periodicTicker returned nil with periodic_command set")
+	}
+	defer tk.Stop()
+	start := time.Now()
+	for i := 1; i <= 2; i++ {
+		select {
+		case <-tk.C:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("ticker did not fire #%d", i)
+		}
+	}
+	if d := time.Since(start); d < 1900*time.Millisecond {
+		t.Errorf("ticker fired twice in %s, want every second", d)
+	}
+}
+
+func TestStartPeriodicRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		max      int
+		starts   int
+		wantRuns int
+	}{
+		{"unlimited", 0, 3, 3},
+		{"max_periodic_runs", 2, 4, 2},
+	}
+	for _, tt := range tests {
+		s := loadedService(t, fmt.Sprintf(`{"command": "cmd.exe /c echo shutdown", "periodic_command": "cmd.exe /c echo checkpoint", "max_periodic_runs": %d}`, tt.max))
+		s.interactive = true
+		started := 0
+		for range tt.starts {
+			if s.startPeriodicRun() {
+				started++
+			}
+			waitPeriodicIdle(t, s)
+		}
+		if started != tt.wantRuns {
+			t.Errorf("%s: %d runs started, want %d", tt.name, started, tt.wantRuns)
+		}
+		files, log := periodicLogs(t, s)
+		if files == 0 || checkpoints(log) != tt.wantRuns {
+			t.Errorf("%s: %d periodic logs with %d outputs, want %d:\n%s", tt.name, files, checkpoints(log), tt.wantRuns, log)
+		}
+		// 关机日志不受影响
+		if logs, _ := listLogFiles(filepath.Dir(s.configPath)); len(logs) != 0 {
+			t.Errorf("%s: shutdown logs %v created by periodic runs", tt.name, logs)
+		}
+	}
+}
+
+// 上一次还没结束时跳过
+func TestStartPeriodicRunBusy(t *testing.T) {
+	s := loadedService(t, `{"command": "cmd.exe /c echo shutdown", "periodic_command": "cmd.exe /c ping -n 2 127.0.0.1 >NUL"}`)
+	s.interactive = true
+	if !s.startPeriodicRun() {
+		t.Fatal("first periodic run not started")
+	}
+	if s.startPeriodicRun() {
+		t.Error("second periodic run started while the first is still running")
+	}
+	waitPeriodicIdle(t, s)
+	if s.periodicRuns != 1 {
+		t.Errorf("periodicRuns = %d, want 1", s.periodicRuns)
+	}
+}
+
+// Execute 按 periodic_interval_secs 触发定时执行，并写入单独的日志
+func TestExecutePeriodic(t *testing.T) {
+	mockEvents(t)
+	s := loadedService(t, `{
+  "command": "cmd.exe /c echo shutdown",
+  "periodic_command": "cmd.exe /c echo checkpoint",
+  "periodic_interval_secs": 1,
+  "max_periodic_runs": 2
+}`)
+	s.interactive = true
+	r := make(chan svc.ChangeRequest)
+	changes := make(chan svc.Status, 10)
+	done := make(chan struct{})
+	go func() {
+		s.Execute(nil, r, changes)
+		close(done)
+	}()
+
+	time.Sleep(3500 * time.Millisecond)
+	r <- svc.ChangeRequest{Cmd: svc.Stop}
+	<-done
+	waitPeriodicIdle(t, s)
+
+	files, log := periodicLogs(t, s)
+	if files == 0 || !strings.Contains(log, "Periodic run 1 of 2") || !strings.Contains(log, "Periodic run 2 of 2") ||
+		checkpoints(log) != 2 {
+		t.Errorf("%d periodic logs, want runs 1 and 2 of 2:\n%s", files, log)
+	}
+}
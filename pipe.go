@@ -0,0 +1,129 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	defaultPipeConnectTimeoutMs = 5000
+	pipeBufferSize              = 64 * 1024
+	pipeWriteTimeoutMs          = 1000
+
+	// 只允许 SYSTEM 和管理员连接，命令输出可能包含敏感信息
+	pipeSDDL = "D:P(A;;GA;;;SY)(A;;GA;;;BA)"
+)
+
+var errNoPipeClient = errors.New("no client connected to output pipe")
+
+// -------------------- 输出命名管道 --------------------
+
+// outputPipe 是命名管道服务端，把命令输出实时转发给一个监控客户端。
+// 管道写入失败（客户端断开、长时间不读）只会停止转发，
+// 绝不阻塞或中断命令本身。
+type outputPipe struct {
+	handle windows.Handle
+	event  windows.Handle
+	broken bool
+}
+
+// openOutputPipe 创建管道并等待客户端连接，超时返回 errNoPipeClient
+func openOutputPipe(name string, timeout time.Duration) (*outputPipe, error) {
+	namePtr, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+
+	sd, err := windows.SecurityDescriptorFromString(pipeSDDL)
+	if err != nil {
+		return nil, err
+	}
+	sa := &windows.SecurityAttributes{
+		Length:             uint32(unsafe.Sizeof(windows.SecurityAttributes{})),
+		SecurityDescriptor: sd,
+	}
+
+	h, err := windows.CreateNamedPipe(namePtr,
+		windows.PIPE_ACCESS_OUTBOUND|windows.FILE_FLAG_OVERLAPPED|windows.FILE_FLAG_FIRST_PIPE_INSTANCE,
+		windows.PIPE_TYPE_BYTE|windows.PIPE_WAIT|windows.PIPE_REJECT_REMOTE_CLIENTS,
+		1, pipeBufferSize, 0, 0, sa)
+	if err != nil {
+		return nil, err
+	}
+
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		windows.CloseHandle(h)
+		return nil, err
+	}
+
+	p := &outputPipe{handle: h, event: event}
+
+	ov := windows.Overlapped{HEvent: event}
+	err = windows.ConnectNamedPipe(h, &ov)
+	switch err {
+	case nil, windows.ERROR_PIPE_CONNECTED:
+		return p, nil
+	case windows.ERROR_IO_PENDING:
+	default:
+		p.Close()
+		return nil, err
+	}
+
+	ev, _ := windows.WaitForSingleObject(event, uint32(timeout.Milliseconds()))
+	if ev != windows.WAIT_OBJECT_0 {
+		p.cancel(&ov)
+		p.Close()
+		return nil, errNoPipeClient
+	}
+
+	var n uint32
+	if err := windows.GetOverlappedResult(h, &ov, &n, false); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Write 实现 io.Writer。总是报告写入成功，以免影响同一输出的其它目标。
+func (p *outputPipe) Write(b []byte) (int, error) {
+	if p.broken || len(b) == 0 {
+		return len(b), nil
+	}
+
+	ov := windows.Overlapped{HEvent: p.event}
+	var n uint32
+	err := windows.WriteFile(p.handle, b, &n, &ov)
+	if err == windows.ERROR_IO_PENDING {
+		ev, _ := windows.WaitForSingleObject(p.event, pipeWriteTimeoutMs)
+		if ev != windows.WAIT_OBJECT_0 {
+			// 客户端不读数据 → 放弃转发，避免拖住命令
+			p.cancel(&ov)
+			p.broken = true
+			return len(b), nil
+		}
+		err = windows.GetOverlappedResult(p.handle, &ov, &n, false)
+	}
+	if err != nil {
+		p.broken = true
+	}
+	return len(b), nil
+}
+
+// cancel 取消挂起的重叠 I/O，并等待其真正结束后才能复用 ov
+func (p *outputPipe) cancel(ov *windows.Overlapped) {
+	var n uint32
+	_ = windows.CancelIoEx(p.handle, ov)
+	_ = windows.GetOverlappedResult(p.handle, ov, &n, true)
+}
+
+func (p *outputPipe) Close() error {
+	_ = windows.DisconnectNamedPipe(p.handle)
+	windows.CloseHandle(p.event)
+	return windows.CloseHandle(p.handle)
+}
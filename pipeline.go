@@ -0,0 +1,260 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// -------------------- 管道 --------------------
+
+// pipeline 为 true 时 commands 不再依次执行，而是像 cmd.exe 的 a | b | c 一样
+// 同时启动：每条命令的 stdout 直接接到下一条的 stdin（操作系统管道，不经过 WinPSP），
+// 各命令的 stderr 和最后一条的 stdout 写入日志。
+// 整个管道共用顶层 timeout，不重试（stdin 的数据无法重放），各条的 timeout 不使用。
+
+// pipelineStage 是管道中的一条命令
+type pipelineStage struct {
+	command string
+	opts    execOptions
+}
+
+// pipelineName 返回形如 "a | b | c" 的名字
+func pipelineName(entries []CommandEntry, name func(e *CommandEntry) string) string {
+	parts := make([]string, len(entries))
+	for i := range entries {
+		parts[i] = name(&entries[i])
+	}
+	return strings.Join(parts, " | ")
+}
+
+// runPipelineEntries 把 entries 作为一个管道执行，结果按一条命令记录。
+// 退出码取最后一条命令的；pipeline_fail_fast 时取第一条失败的命令的。
+func (s *winpspService) runPipelineEntries(entries []CommandEntry, deadline time.Time, output io.Writer, env []string, logf func(format string, args ...any)) commandResult {
+	cfg := s.config.Load()
+	res := commandResult{
+		Name:     pipelineName(entries, (*CommandEntry).displayName),
+		Command:  pipelineName(entries, func(e *CommandEntry) string { return e.Command }),
+		Attempts: 1,
+	}
+	start := time.Now()
+	defer func() { res.Duration = time.Since(start) }()
+
+	if n := *cfg.FailureOutputLines; n > 0 {
+		res.tail = newTailBuffer(n)
+	}
+
+	stages := make([]pipelineStage, len(entries))
+	for i := range entries {
+		extraArgs, err := s.prepareEntry(&entries[i], logf)
+		if err != nil {
+			logf("Command error: %v", err)
+			res.ExitCode, res.Err = 1, err
+			return res
+		}
+		opts := s.execOptions(output, env, logf)
+		opts.Dir, opts.Args = entries[i].WorkingDirectory, extraArgs
+		if res.tail != nil {
+			opts.Tee = res.tail
+		}
+		stages[i] = pipelineStage{command: entries[i].Command, opts: opts}
+	}
+
+	var timeout time.Duration // 0 = 不限
+	if !deadline.IsZero() {
+		if timeout = time.Until(deadline); timeout <= 0 {
+			res.TimedOut = true
+			logf("Timeout after %d seconds", int(time.Since(start).Round(time.Second).Seconds()))
+			return res
+		}
+	}
+
+	var (
+		codes []int
+		errs  []error
+	)
+	stopWarning := s.startDeadlineWarning(res.Command, timeout, logf)
+	if s.simulate {
+		// 模拟模式：整个管道当作一条命令
+		code, timedOut, err := simulateCommand(cfg, res.Command, timeout, stages[len(stages)-1].opts)
+		codes, errs, res.TimedOut = []int{code}, []error{err}, timedOut
+		stopWarning()
+	} else {
+		var err error
+		codes, errs, res.TimedOut, err = runPipeline(stages, timeout)
+		stopWarning()
+		if err != nil {
+			logf("Command error: %v", err)
+			res.ExitCode, res.Err = 1, err
+			return res
+		}
+	}
+
+	if res.TimedOut {
+		logf("Timeout after %d seconds", int(time.Since(start).Round(time.Second).Seconds()))
+	}
+
+	pick := len(codes) - 1
+	for i := range codes {
+		if len(codes) > 1 {
+			logf("%s exit code: %d", entries[i].displayName(), codes[i])
+		}
+		if errs[i] != nil && !isExitError(errs[i]) {
+			logf("Command error: %v", errs[i])
+		}
+		if cfg.PipelineFailFast && pick == len(codes)-1 && !s.isSuccess(codes[i], errs[i]) {
+			pick = i
+		}
+	}
+	res.ExitCode, res.Err = codes[pick], errs[pick]
+	if !res.TimedOut {
+		logf("Exit code: %d", res.ExitCode)
+	}
+	return res
+}
+
+// runPipeline 同时启动所有命令并用管道串联，等待全部结束。
+// 返回每条命令的退出码和错误；err 只表示管道没能启动。
+func runPipeline(stages []pipelineStage, timeout time.Duration) (exitCodes []int, errs []error, timedOut bool, err error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmds := make([]*exec.Cmd, len(stages))
+	for i := range stages {
+		opts := &stages[i].opts
+		exe, args, cmdLine, err := commandArgv(stages[i].command, opts)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		cmd := exec.CommandContext(ctx, exe, args...)
+		setCmdLine(cmd, cmdLine)
+		setGracefulCancel(cmd, opts.Grace)
+		cmd.Env, cmd.Dir = opts.Env, opts.Dir
+		setHideWindow(cmd, opts.Hidden)
+		releaseToken, err := setIntegrityLevel(cmd, opts.IntegrityLevel)
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("set integrity level %s: %w", opts.IntegrityLevel, err)
+		}
+		defer releaseToken()
+		cmds[i] = cmd
+	}
+
+	// 相邻命令之间的管道；两端都被子进程继承后，WinPSP 必须关闭自己的副本，
+	// 否则上游退出后下游读不到 EOF
+	var ends []*os.File
+	closeEnds := func() {
+		for _, f := range ends {
+			f.Close()
+		}
+		ends = nil
+	}
+	defer closeEnds()
+	for i := 0; i+1 < len(cmds); i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, nil, false, err
+		}
+		ends = append(ends, r, w)
+		cmds[i].Stdout = w
+		cmds[i+1].Stdin = r
+	}
+
+	type stream struct {
+		r    io.Reader
+		opts *execOptions
+	}
+	var streams []stream
+	for i, cmd := range cmds {
+		opts := &stages[i].opts
+		if opts.Output == nil && opts.Tee == nil {
+			continue
+		}
+		if !validOutputEncoding(opts.Encoding) {
+			return nil, nil, false, fmt.Errorf("invalid output encoding: %q", opts.Encoding)
+		}
+		stderr, err := cmd.StderrPipe()
+		if err != nil {
+			return nil, nil, false, err
+		}
+		streams = append(streams, stream{stderr, opts})
+		if i == len(cmds)-1 {
+			stdout, err := cmd.StdoutPipe()
+			if err != nil {
+				return nil, nil, false, err
+			}
+			streams = append(streams, stream{stdout, opts})
+		}
+	}
+
+	restoreCodepage := setConsoleCodepage(stages[0].opts.Codepage)
+	defer restoreCodepage()
+
+	for i, cmd := range cmds {
+		if err := cmd.Start(); err != nil {
+			for _, started := range cmds[:i] {
+				started.Process.Kill()
+				started.Wait()
+			}
+			return nil, nil, false, fmt.Errorf("%s: %w", stages[i].command, err)
+		}
+		if limits := stages[i].opts.Limits; limits.enabled() {
+			job, err := applyJobLimits(cmd.Process.Pid, limits)
+			if err != nil {
+				stages[i].opts.logf("Warning: job limits not applied: %v", err)
+			} else {
+				defer windows.CloseHandle(job)
+			}
+		}
+	}
+	closeEnds()
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex // 所有流写入同一个日志，按行互斥
+	)
+	for _, st := range streams {
+		wg.Add(1)
+		go func(st stream) {
+			defer wg.Done()
+			drainOutput(st.r, st.opts, &mu)
+		}(st)
+	}
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+
+	// 与 runCmd 相同：先读完输出再 Wait，超时后最多再等一小段时间
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		select {
+		case <-drained:
+		case <-time.After(stages[0].opts.Grace + outputDrainTimeout):
+		}
+	}
+
+	exitCodes = make([]int, len(cmds))
+	errs = make([]error, len(cmds))
+	for i, cmd := range cmds {
+		errs[i] = cmd.Wait()
+		exitCodes[i] = exitCodeFromError(errs[i])
+	}
+	<-drained
+
+	return exitCodes, errs, ctx.Err() == context.DeadlineExceeded, nil
+}
@@ -0,0 +1,168 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// pipeData 返回 n 字节的测试数据：覆盖所有字节值，包括 \0、\r、\n 和 Ctrl+Z
+func pipeData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i*7 + i/256)
+	}
+	return data
+}
+
+func init() {
+	// 管道中的一条命令，args[0] 是角色：
+	//   gen N [code]  向 stdout 写 pipeData(N)
+	//   copy [code]   stdin 原样复制到 stdout
+	//   sleep MS      不读 stdin，等待 MS 毫秒
+	testHelpers["pipe"] = func(args []string) int {
+		exit := func(i int) int {
+			if len(args) > i {
+				code, _ := strconv.Atoi(args[i])
+				return code
+			}
+			return 0
+		}
+		switch args[0] {
+		case "gen":
+			n, _ := strconv.Atoi(args[1])
+			if _, err := os.Stdout.Write(pipeData(n)); err != nil {
+				return 1
+			}
+			return exit(2)
+		case "copy":
+			if _, err := io.Copy(os.Stdout, os.Stdin); err != nil {
+				return 1
+			}
+			return exit(1)
+		case "sleep":
+			ms, _ := strconv.Atoi(args[1])
+			time.Sleep(time.Duration(ms) * time.Millisecond)
+			return 0
+		}
+		return 2
+	}
+}
+
+// pipeStages 把 "gen 10" 这样的角色参数变成管道的各条命令，只有最后一条的输出写入 out
+func pipeStages(t *testing.T, out io.Writer, stages ...string) []pipelineStage {
+	t.Helper()
+	exe, env := helperCommandLine(t, "pipe")
+	p := make([]pipelineStage, len(stages))
+	for i, args := range stages {
+		p[i] = pipelineStage{command: exe + " " + args, opts: execOptions{Env: env}}
+	}
+	p[len(p)-1].opts.Output = out
+	p[len(p)-1].opts.Encoding = outputEncodingUTF8 // 原样写入，不做编码转换
+	return p
+}
+
+func TestRunPipelineDataFlow(t *testing.T) {
+	const n = 4 << 20 // 远大于管道缓冲区，上下游必须同时运行
+	tests := []struct {
+		name      string
+		stages    []string
+		wantCodes []int
+	}{
+		{"two commands", []string{fmt.Sprintf("gen %d", n), "copy"}, []int{0, 0}},
+		{"four commands", []string{fmt.Sprintf("gen %d", n), "copy", "copy", "copy"}, []int{0, 0, 0, 0}},
+		{"exit codes per command", []string{fmt.Sprintf("gen %d 3", n), "copy 5", "copy"}, []int{3, 5, 0}},
+		{"empty input", []string{"gen 0", "copy"}, []int{0, 0}},
+	}
+	want := pipeData(n)
+	for _, tt := range tests {
+		var out bytes.Buffer
+		codes, errs, timedOut, err := runPipeline(pipeStages(t, &out, tt.stages...), 30*time.Second)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if timedOut {
+			t.Errorf("%s: timed out", tt.name)
+		}
+		if fmt.Sprint(codes) != fmt.Sprint(tt.wantCodes) {
+			t.Errorf("%s: exit codes %v (errors %v), want %v", tt.name, codes, errs, tt.wantCodes)
+		}
+		w := want
+		if tt.stages[0] == "gen 0" {
+			w = nil
+		}
+		if !bytes.Equal(out.Bytes(), w) {
+			i := 0
+			for i < len(w) && i < out.Len() && out.Bytes()[i] == w[i] {
+				i++
+			}
+			t.Errorf("%s: got %d bytes, want %d; first difference at byte %d", tt.name, out.Len(), len(w), i)
+		}
+	}
+}
+
+func TestRunPipelineTimeout(t *testing.T) {
+	start := time.Now()
+	_, _, timedOut, err := runPipeline(pipeStages(t, io.Discard, "gen 10", "sleep 10000", "copy"), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !timedOut {
+		t.Error("pipeline did not time out")
+	}
+	if d := time.Since(start); d > 8*time.Second {
+		t.Errorf("pipeline returned after %s, want shortly after the 1s timeout", d)
+	}
+}
+
+func TestRunPipelineStartError(t *testing.T) {
+	stages := pipeStages(t, io.Discard, "gen 10", "copy")
+	stages[1].command = `C:\does\not\exist.exe`
+	if _, _, _, err := runPipeline(stages, 10*time.Second); err == nil {
+		t.Error("runPipeline succeeded although a command could not be started")
+	}
+}
+
+func TestPipelineExitCode(t *testing.T) {
+	tests := []struct {
+		codes    [3]int
+		failFast bool
+		want     int
+	}{
+		{[3]int{0, 0, 0}, false, 0},
+		{[3]int{0, 2, 0}, false, 0},
+		{[3]int{0, 2, 0}, true, 2},
+		{[3]int{3, 2, 0}, true, 3},
+		{[3]int{0, 0, 4}, false, 4},
+		{[3]int{0, 0, 4}, true, 4},
+	}
+	for _, tt := range tests {
+		var commands []map[string]any
+		for _, c := range tt.codes {
+			commands = append(commands, map[string]any{"command": fmt.Sprintf("cmd.exe /c exit %d", c)})
+		}
+		data, err := json.Marshal(map[string]any{
+			"commands":           commands,
+			"pipeline":           true,
+			"pipeline_fail_fast": tt.failFast,
+			"timeout":            30,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := loadedService(t, string(data))
+		s.interactive = true
+		log := shutdownLog(t, s)
+		if !regexp.MustCompile(fmt.Sprintf(`\] Exit code: %d\r?\n`, tt.want)).MatchString(log) {
+			t.Errorf("codes %v, fail fast %v: want exit code %d:\n%s", tt.codes, tt.failFast, tt.want, log)
+		}
+	}
+}
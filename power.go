@@ -0,0 +1,55 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+var procGetSystemPowerStatus = modkernel32.NewProc("GetSystemPowerStatus")
+
+// -------------------- 电池状态 --------------------
+
+// systemPowerStatus 对应 SYSTEM_POWER_STATUS
+type systemPowerStatus struct {
+	ACLineStatus        byte // 0 = 电池，1 = 交流电，255 = 未知
+	BatteryFlag         byte
+	BatteryLifePercent  byte // 0-100，255 = 未知
+	SystemStatusFlag    byte
+	BatteryLifeTime     uint32
+	BatteryFullLifeTime uint32
+}
+
+// getPowerStatus 可在调试时替换
+var getPowerStatus = func() (systemPowerStatus, error) {
+	var st systemPowerStatus
+	r, _, err := procGetSystemPowerStatus.Call(uintptr(unsafe.Pointer(&st)))
+	if r == 0 {
+		return st, err
+	}
+	return st, nil
+}
+
+// batterySkipReason 判断是否因电池供电跳过命令，返回空字符串表示照常执行。
+// 查询失败或状态未知时照常执行：宁可多跑一次，也不要漏掉关机任务。
+func batterySkipReason(cfg *Config) string {
+	if !cfg.SkipOnBattery && cfg.SkipOnBatteryBelowPercent <= 0 {
+		return ""
+	}
+
+	st, err := getPowerStatus()
+	if err != nil || st.ACLineStatus != 0 {
+		return ""
+	}
+
+	if cfg.SkipOnBatteryBelowPercent > 0 {
+		if st.BatteryLifePercent != 255 && int(st.BatteryLifePercent) < cfg.SkipOnBatteryBelowPercent {
+			return fmt.Sprintf("system is on battery power (%d%% < %d%%)", st.BatteryLifePercent, cfg.SkipOnBatteryBelowPercent)
+		}
+		if !cfg.SkipOnBattery {
+			return ""
+		}
+	}
+	return "system is on battery power"
+}
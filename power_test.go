@@ -0,0 +1,65 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// mockPowerStatus 让 batterySkipReason 看到 st（err 非 nil 时查询失败）
+func mockPowerStatus(t *testing.T, st systemPowerStatus, err error) {
+	t.Helper()
+	orig := getPowerStatus
+	getPowerStatus = func() (systemPowerStatus, error) { return st, err }
+	t.Cleanup(func() { getPowerStatus = orig })
+}
+
+func TestBatterySkipReason(t *testing.T) {
+	const (
+		battery = 0
+		ac      = 1
+		unknown = 255
+	)
+	queryFailed := errors.New("GetSystemPowerStatus failed")
+	tests := []struct {
+		skip         bool
+		belowPercent int
+		line         byte
+		percent      byte
+		err          error
+		want         string
+	}{
+		{false, 0, battery, 10, nil, ""},
+		{true, 0, ac, 100, nil, ""},
+		{true, 0, battery, 80, nil, "system is on battery power"},
+		{true, 0, unknown, 80, nil, ""},
+		{true, 0, battery, 80, queryFailed, ""},
+		{false, 30, battery, 20, nil, "system is on battery power (20% < 30%)"},
+		{false, 30, battery, 30, nil, ""},
+		{false, 30, battery, unknown, nil, ""},
+		{false, 30, ac, 20, nil, ""},
+		{true, 30, battery, 20, nil, "system is on battery power (20% < 30%)"},
+		{true, 30, battery, 90, nil, "system is on battery power"},
+	}
+	for _, tt := range tests {
+		mockPowerStatus(t, systemPowerStatus{ACLineStatus: tt.line, BatteryLifePercent: tt.percent}, tt.err)
+		cfg := &Config{SkipOnBattery: tt.skip, SkipOnBatteryBelowPercent: tt.belowPercent}
+		if got := batterySkipReason(cfg); got != tt.want {
+			t.Errorf("%+v: batterySkipReason = %q, want %q", tt, got, tt.want)
+		}
+	}
+}
+
+func TestPreflightBattery(t *testing.T) {
+	mockPowerStatus(t, systemPowerStatus{ACLineStatus: 0, BatteryLifePercent: 50}, nil)
+	s := loadedService(t, `{"command": "C:\\Tools\\backup.exe", "skip_on_battery": true}`)
+	if entries, skip := s.preflight(t.Logf); skip != "system is on battery power" || entries != nil {
+		t.Errorf("preflight on battery = %v, %q", entries, skip)
+	}
+
+	mockPowerStatus(t, systemPowerStatus{ACLineStatus: 1, BatteryLifePercent: 50}, nil)
+	if entries, skip := s.preflight(t.Logf); skip != "" || len(entries) != 1 {
+		t.Errorf("preflight on AC power = %v, %q", entries, skip)
+	}
+}
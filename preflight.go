@@ -0,0 +1,154 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	defaultRequireNetworkPort = 80
+	networkCheckTimeout       = 5 * time.Second
+)
+
+const (
+	networkActionSkip     = "skip"
+	networkActionFallback = "fallback"
+)
+
+// -------------------- 执行前检查 --------------------
+
+// getUptime 返回系统自上次启动以来的时间（GetTickCount64），可在调试时替换
+var getUptime = windows.DurationSinceBoot
+
+// shortUptime 检查 min_uptime_secs，返回空字符串表示照常执行。
+// 更新导致的重启循环中，刚开机又关机时不必再跑一遍备份。
+func shortUptime(cfg *Config) string {
+	if cfg.MinUptimeSecs <= 0 {
+		return ""
+	}
+	if up := getUptime(); up < time.Duration(cfg.MinUptimeSecs)*time.Second {
+		return fmt.Sprintf("system uptime is only %d seconds", int(up.Seconds()))
+	}
+	return ""
+}
+
+// recentRun 检查 min_run_interval_secs，返回空字符串表示照常执行。
+// 部分 Windows 版本会连续发送多次 PreShutdown，互斥量只能挡住并发，挡不住紧接着的第二次。
+// 元数据不存在或无法解析时照常执行。
+func (s *winpspService) recentRun(cfg *Config) string {
+	if cfg.MinRunIntervalSecs <= 0 {
+		return ""
+	}
+	rec, err := s.readRunRecord()
+	if err != nil || rec.LastRun.IsZero() {
+		return ""
+	}
+	if ago := time.Since(rec.LastRun); ago >= 0 && ago < time.Duration(cfg.MinRunIntervalSecs)*time.Second {
+		return fmt.Sprintf("last run was only %d seconds ago", int(ago.Seconds()))
+	}
+	return ""
+}
+
+// getDiskFreeSpace 返回 path 所在卷上调用者可用的字节数，可在调试时替换
+var getDiskFreeSpace = func(path string) (uint64, error) {
+	p, err := windows.UTF16PtrFromString(longPath(path))
+	if err != nil {
+		return 0, err
+	}
+	var free uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &free, nil, nil); err != nil {
+		return 0, err
+	}
+	return free, nil
+}
+
+// diskSpaceShortage 检查 require_free_disk_path 的可用空间，返回空字符串表示足够。
+// 查询失败也视为不满足：目标盘不可用时备份同样注定失败。
+func diskSpaceShortage(cfg *Config) string {
+	if cfg.RequireFreeDiskBytes == 0 {
+		return ""
+	}
+
+	free, err := getDiskFreeSpace(cfg.RequireFreeDiskPath)
+	if err != nil {
+		return fmt.Sprintf("cannot query free space on %s: %v", cfg.RequireFreeDiskPath, err)
+	}
+	if free < cfg.RequireFreeDiskBytes {
+		return fmt.Sprintf("insufficient disk space on %s (%d bytes free, %d required)",
+			cfg.RequireFreeDiskPath, free, cfg.RequireFreeDiskBytes)
+	}
+	return ""
+}
+
+// dialNetwork 尝试建立一次 TCP 连接，可在调试时替换
+var dialNetwork = func(address string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// networkUnavailable 检查 require_network_host 是否可达，返回空字符串表示可达
+func networkUnavailable(cfg *Config) string {
+	if !cfg.RequireNetwork {
+		return ""
+	}
+
+	// require_network_host 可以自带端口（host:port / [::1]:port），否则用 require_network_port
+	address, err := hostPort(cfg.RequireNetworkHost, *cfg.RequireNetworkPort)
+	if err != nil {
+		return err.Error()
+	}
+	if err := dialNetwork(address, networkCheckTimeout); err != nil {
+		return fmt.Sprintf("network target %s unreachable: %v", address, err)
+	}
+	return ""
+}
+
+// preflight 在执行前检查运行条件，返回实际要执行的命令。
+// skip 非空表示本次不执行任何命令，内容为原因。
+// 条件不满足时，如果配置了 fallback_command 就改为只执行它，否则跳过。
+func (s *winpspService) preflight(logf func(format string, args ...any)) (entries []CommandEntry, skip string) {
+	cfg := s.config.Load()
+
+	if reason := shortUptime(cfg); reason != "" {
+		return nil, reason
+	}
+
+	if reason := s.recentRun(cfg); reason != "" {
+		return nil, reason
+	}
+
+	if reason := idleSkipReason(cfg, s.interactive, logf); reason != "" {
+		return nil, reason
+	}
+
+	if reason := batterySkipReason(cfg); reason != "" {
+		return nil, reason
+	}
+
+	if reason := diskSpaceShortage(cfg); reason != "" {
+		if cfg.FallbackCommand == "" {
+			return nil, reason
+		}
+		logf("Precondition failed: %s, running fallback_command", reason)
+		return cfg.fallbackEntries(), ""
+	}
+
+	if reason := networkUnavailable(cfg); reason != "" {
+		logf("Warning: %s", reason)
+		if cfg.RequireNetworkAction != networkActionFallback || cfg.FallbackCommand == "" {
+			return nil, reason
+		}
+		logf("Running fallback_command")
+		return cfg.fallbackEntries(), ""
+	}
+
+	return cfg.commandEntries(), ""
+}
@@ -0,0 +1,272 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockUptime 让 shortUptime 看到系统已运行 up
+func mockUptime(t *testing.T, up time.Duration) {
+	t.Helper()
+	orig := getUptime
+	getUptime = func() time.Duration { return up }
+	t.Cleanup(func() { getUptime = orig })
+}
+
+func TestShortUptime(t *testing.T) {
+	tests := []struct {
+		minUptime int
+		uptime    time.Duration
+		want      string
+	}{
+		{0, time.Second, ""},
+		{300, 299*time.Second + 900*time.Millisecond, "system uptime is only 299 seconds"},
+		{300, 300 * time.Second, ""},
+		{300, 48 * time.Hour, ""},
+		{300, 0, "system uptime is only 0 seconds"},
+	}
+	for _, tt := range tests {
+		mockUptime(t, tt.uptime)
+		if got := shortUptime(&Config{MinUptimeSecs: tt.minUptime}); got != tt.want {
+			t.Errorf("min_uptime_secs %d, uptime %s: shortUptime = %q, want %q", tt.minUptime, tt.uptime, got, tt.want)
+		}
+	}
+}
+
+func TestShutdownShortUptime(t *testing.T) {
+	mockUptime(t, 2*time.Minute)
+	s := loadedService(t, `{"command": "cmd.exe /c echo backup", "min_uptime_secs": 300}`)
+	s.interactive = true
+	log := shutdownLog(t, s)
+	if !strings.Contains(log, "Skipping: system uptime is only 120 seconds") || strings.Contains(log, "Running:") {
+		t.Errorf("log:\n%s", log)
+	}
+}
+
+// writeLastRun 在配置目录写一个 last_run 为 lastRun 的运行记录
+func writeLastRun(t *testing.T, s *winpspService, lastRun time.Time) {
+	t.Helper()
+	data, err := json.Marshal(runRecord{LastRun: lastRun, Command: "backup.exe", Result: resultSuccess})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(filepath.Dir(s.configPath), lastRunFileName), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecentRun(t *testing.T) {
+	tests := []struct {
+		name        string
+		interval    int
+		record      string // 非空时原样写入运行记录
+		ago         time.Duration
+		wantSkipped bool
+	}{
+		{"disabled", 0, "", time.Second, false},
+		{"recent", 300, "", 30 * time.Second, true},
+		{"old enough", 300, "", 301 * time.Second, false},
+		{"clock moved back", 300, "", -time.Minute, false},
+		{"no record", 300, "-", 0, false},
+		{"invalid record", 300, "{", 0, false},
+		{"no last_run", 300, `{"command": "backup.exe"}`, 0, false},
+	}
+	for _, tt := range tests {
+		s := writeConfig(t, `{}`)
+		s.config.Store(&Config{MinRunIntervalSecs: tt.interval})
+		switch tt.record {
+		case "":
+			writeLastRun(t, s, time.Now().Add(-tt.ago))
+		case "-":
+		default:
+			os.WriteFile(filepath.Join(filepath.Dir(s.configPath), lastRunFileName), []byte(tt.record), 0644)
+		}
+		got := s.recentRun(s.config.Load())
+		if (got != "") != tt.wantSkipped || (got != "" && !strings.HasPrefix(got, "last run was only ")) {
+			t.Errorf("%s: recentRun = %q, want skipped %v", tt.name, got, tt.wantSkipped)
+		}
+	}
+}
+
+func TestShutdownMinRunInterval(t *testing.T) {
+	s := loadedService(t, `{"command": "cmd.exe /c echo backup", "min_run_interval_secs": 600}`)
+	s.interactive = true
+	writeLastRun(t, s, time.Now().Add(-90*time.Second))
+	log := shutdownLog(t, s)
+	if !regexp.MustCompile(`Skipping: last run was only 9\d seconds ago`).MatchString(log) || strings.Contains(log, "Running:") {
+		t.Errorf("log:\n%s", log)
+	}
+
+	// 跳过的运行不更新运行记录，之后的关机不会被一直跳过
+	if rec, err := s.readRunRecord(); err != nil || time.Since(rec.LastRun) < 90*time.Second {
+		t.Errorf("run record after the skipped run = %+v, %v", rec, err)
+	}
+}
+
+// mockDiskFreeSpace 让 diskSpaceShortage 看到 free 字节可用（err 非 nil 时查询失败），返回查询的路径
+func mockDiskFreeSpace(t *testing.T, free uint64, err error) *string {
+	t.Helper()
+	var queried string
+	orig := getDiskFreeSpace
+	getDiskFreeSpace = func(path string) (uint64, error) {
+		queried = path
+		return free, err
+	}
+	t.Cleanup(func() { getDiskFreeSpace = orig })
+	return &queried
+}
+
+func TestDiskSpaceShortage(t *testing.T) {
+	const gb = 1 << 30
+	tests := []struct {
+		required, free uint64
+		err            error
+		want           string
+	}{
+		{0, 0, nil, ""},
+		{10 * gb, 20 * gb, nil, ""},
+		{10 * gb, 10 * gb, nil, ""},
+		{10 * gb, 10*gb - 1, nil, `insufficient disk space on E:\Backups (10737418239 bytes free, 10737418240 required)`},
+		{10 * gb, 0, errors.New("The device is not ready."), `cannot query free space on E:\Backups: The device is not ready.`},
+	}
+	for _, tt := range tests {
+		queried := mockDiskFreeSpace(t, tt.free, tt.err)
+		cfg := &Config{RequireFreeDiskBytes: tt.required, RequireFreeDiskPath: `E:\Backups`}
+		if got := diskSpaceShortage(cfg); got != tt.want {
+			t.Errorf("required %d, free %d, err %v: diskSpaceShortage = %q, want %q", tt.required, tt.free, tt.err, got, tt.want)
+		}
+		if tt.required > 0 && *queried != `E:\Backups` {
+			t.Errorf("free space queried on %q", *queried)
+		}
+	}
+}
+
+func TestPreflightDiskSpace(t *testing.T) {
+	mockDiskFreeSpace(t, 100, nil)
+	tests := []struct {
+		config      string
+		wantCommand string // 空表示跳过
+	}{
+		{`{"command": "backup.exe", "require_free_disk_bytes": 100, "require_free_disk_path": "E:\\"}`, "backup.exe"},
+		{`{"command": "backup.exe", "require_free_disk_bytes": 101, "require_free_disk_path": "E:\\"}`, ""},
+		{`{"command": "backup.exe", "require_free_disk_bytes": 101, "require_free_disk_path": "E:\\",
+		   "fallback_command": "notify.exe"}`, "notify.exe"},
+	}
+	for _, tt := range tests {
+		s := loadedService(t, tt.config)
+		entries, skip := s.preflight(t.Logf)
+		if tt.wantCommand == "" {
+			if skip == "" || entries != nil {
+				t.Errorf("%s: preflight = %v, %q, want skip", tt.config, entries, skip)
+			}
+			continue
+		}
+		if skip != "" || len(entries) != 1 || entries[0].Command != tt.wantCommand {
+			t.Errorf("%s: preflight = %v, %q, want %s", tt.config, entries, skip, tt.wantCommand)
+		}
+	}
+}
+
+func TestReadConfigRequiresDiskPath(t *testing.T) {
+	s := writeConfig(t, `{"command": "backup.exe", "require_free_disk_bytes": 1024}`)
+	if err := s.loadConfig(); err == nil {
+		t.Error("require_free_disk_bytes accepted without require_free_disk_path")
+	}
+}
+
+// mockDialNetwork 让 networkUnavailable 的连接得到 err，返回尝试连接的地址
+func mockDialNetwork(t *testing.T, err error) *string {
+	t.Helper()
+	var dialed string
+	orig := dialNetwork
+	dialNetwork = func(address string, timeout time.Duration) error {
+		dialed = address
+		return err
+	}
+	t.Cleanup(func() { dialNetwork = orig })
+	return &dialed
+}
+
+func TestNetworkUnavailable(t *testing.T) {
+	refused := errors.New("connection refused")
+	tests := []struct {
+		host       string
+		port       int
+		err        error
+		wantDialed string
+		want       string
+	}{
+		{"nas01", 80, nil, "nas01:80", ""},
+		{"nas01", 445, nil, "nas01:445", ""},
+		{"nas01:8443", 80, nil, "nas01:8443", ""},
+		{"::1", 80, nil, "[::1]:80", ""},
+		{"[fe80::1]:445", 80, nil, "[fe80::1]:445", ""},
+		{"nas01", 445, refused, "nas01:445", "network target nas01:445 unreachable: connection refused"},
+	}
+	for _, tt := range tests {
+		dialed := mockDialNetwork(t, tt.err)
+		cfg := &Config{RequireNetwork: true, RequireNetworkHost: tt.host, RequireNetworkPort: intPtr(tt.port)}
+		if got := networkUnavailable(cfg); got != tt.want || *dialed != tt.wantDialed {
+			t.Errorf("%s (port %d): networkUnavailable = %q after dialing %q, want %q after dialing %q",
+				tt.host, tt.port, got, *dialed, tt.want, tt.wantDialed)
+		}
+	}
+
+	dialed := mockDialNetwork(t, refused)
+	if got := networkUnavailable(&Config{RequireNetworkHost: "nas01"}); got != "" || *dialed != "" {
+		t.Errorf("networkUnavailable without require_network = %q, dialed %q", got, *dialed)
+	}
+}
+
+func TestPreflightNetwork(t *testing.T) {
+	mockDialNetwork(t, errors.New("i/o timeout"))
+	tests := []struct {
+		config      string
+		wantCommand string // 空表示跳过
+	}{
+		{`{"command": "sync.exe", "require_network": true, "require_network_host": "nas01"}`, ""},
+		{`{"command": "sync.exe", "require_network": true, "require_network_host": "nas01",
+		   "require_network_action": "skip", "fallback_command": "local.exe"}`, ""},
+		{`{"command": "sync.exe", "require_network": true, "require_network_host": "nas01",
+		   "require_network_action": "fallback", "fallback_command": "local.exe"}`, "local.exe"},
+		{`{"command": "sync.exe", "require_network": true, "require_network_host": "nas01",
+		   "require_network_action": "fallback"}`, ""},
+	}
+	for _, tt := range tests {
+		s := loadedService(t, tt.config)
+		entries, skip := s.preflight(t.Logf)
+		if tt.wantCommand == "" {
+			if !strings.Contains(skip, "unreachable") || entries != nil {
+				t.Errorf("%s: preflight = %v, %q, want skip", tt.config, entries, skip)
+			}
+			continue
+		}
+		if skip != "" || len(entries) != 1 || entries[0].Command != tt.wantCommand {
+			t.Errorf("%s: preflight = %v, %q, want %s", tt.config, entries, skip, tt.wantCommand)
+		}
+	}
+}
+
+func TestDialNetwork(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	if err := dialNetwork(addr, networkCheckTimeout); err != nil {
+		t.Errorf("dialNetwork(%s): %v", addr, err)
+	}
+	l.Close()
+	if err := dialNetwork(addr, networkCheckTimeout); err == nil {
+		t.Errorf("dialNetwork(%s) succeeded after the listener was closed", addr)
+	}
+}
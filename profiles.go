@@ -0,0 +1,182 @@
+//go:build windows
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// -------------------- 配置 Profile --------------------
+
+// profiles 中的每一项都是一段部分配置，覆盖在顶层配置之上。
+// 名称与本机主机名相同（不区分大小写）的 profile 会被自动选用，
+// 这样同一份配置文件可以分发给多台机器。
+
+// --profile 或交互菜单选定的 profile，空串表示只用顶层配置；
+// profileChosen 为 false 时按主机名自动选用
+var (
+	chosenProfile string
+	profileChosen bool
+)
+
+// profileName 返回要合并的 profile 名称，空串表示只用顶层配置
+func profileName(cfg *Config) string {
+	if profileChosen {
+		return chosenProfile
+	}
+	return hostProfileName(cfg)
+}
+
+// hostProfileName 返回与本机主机名匹配的 profile 名称，没有则返回空串
+func hostProfileName(cfg *Config) string {
+	host, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	for name := range cfg.Profiles {
+		if strings.EqualFold(name, host) {
+			return name
+		}
+	}
+	return ""
+}
+
+// applyProfile 把指定 profile 的字段覆盖到 cfg 上（未出现的字段保持不变）
+func applyProfile(cfg *Config, name string) error {
+	raw, ok := cfg.Profiles[name]
+	if !ok {
+		return fmt.Errorf("profile %q not found", name)
+	}
+	profiles := cfg.Profiles
+	if err := json.Unmarshal(raw, cfg); err != nil {
+		return fmt.Errorf("profile %q: %w", name, err)
+	}
+	// profile 内部不允许再嵌套 profiles
+	cfg.Profiles = profiles
+	return nil
+}
+
+// listProfiles 实现 --list-profiles：只读操作，总是以 0 退出
+func listProfiles(configPath string) {
+	data, err := readConfigFile(configPath)
+	if err != nil {
+		fmt.Printf("Config error: %v\n", err)
+		return
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		fmt.Printf("JSON parse error: %v\n", err)
+		return
+	}
+
+	if len(cfg.Profiles) == 0 {
+		fmt.Println("No profiles defined.")
+		return
+	}
+
+	names := sortedProfileNames(&cfg)
+	matched := hostProfileName(&cfg)
+	for _, name := range names {
+		var p Config
+		command := "(invalid profile)"
+		if err := json.Unmarshal(cfg.Profiles[name], &p); err == nil {
+			command = p.Command
+			if command == "" {
+				command = "(inherits top-level command)"
+			}
+		}
+		command = truncateRunes(command, 60)
+
+		mark := ""
+		if name == matched {
+			mark = "  [matches hostname]"
+		}
+		fmt.Printf("%-20s %s%s\n", name, command, mark)
+	}
+}
+
+// truncateRunes 把 s 截短到最多 n 个字符（按 rune 计算，不会截断多字节字符），
+// 截短时以 "..." 结尾
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-3]) + "..."
+}
+
+// sortedProfileNames 返回按名称排序的 profile 列表
+func sortedProfileNames(cfg *Config) []string {
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// stdinIsConsole 判断标准输入是否为控制台（而不是管道或文件），可在调试时替换
+var stdinIsConsole = func() bool {
+	var mode uint32
+	return windows.GetConsoleMode(windows.Handle(os.Stdin.Fd()), &mode) == nil
+}
+
+// chooseProfile 在交互模式下有多个 profile 且未指定 --profile 时显示选择菜单。
+// 标准输入不是控制台（脚本、计划任务）时不询问，只用顶层配置，
+// 避免同一脚本在不同主机上因主机名匹配而执行不同的命令。
+func chooseProfile(configPath string) {
+	if profileChosen {
+		return
+	}
+	if !stdinIsConsole() {
+		chosenProfile, profileChosen = "", true
+		return
+	}
+	cfg, err := parseConfigFile(configPath)
+	if err != nil || len(cfg.Profiles) < 2 {
+		return
+	}
+
+	names := sortedProfileNames(cfg)
+	matched := hostProfileName(cfg)
+	def := len(names) + 1 // [top-level]
+	for i, name := range names {
+		mark := ""
+		if name == matched {
+			mark = " (matches hostname)"
+			def = i + 1
+		}
+		fmt.Printf("%d) %s%s\n", i+1, name, mark)
+	}
+	fmt.Printf("%d) [top-level]\n", len(names)+1)
+
+	in := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Printf("Select profile [%d]: ", def)
+		line, err := in.ReadString('\n')
+		n := def
+		if line = strings.TrimSpace(line); line != "" {
+			n, _ = strconv.Atoi(line)
+		}
+		if n >= 1 && n <= len(names)+1 {
+			profileChosen = true
+			if n <= len(names) {
+				chosenProfile = names[n-1]
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+		fmt.Println("Invalid choice.")
+	}
+}
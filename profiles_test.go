@@ -0,0 +1,203 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureStdout 返回 f 执行期间写到 os.Stdout 的内容
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	f()
+	w.Close()
+	return <-out
+}
+
+// writeProfilesFixture 写出有两个 profile 的配置：office 和与本机主机名同名的一个
+func writeProfilesFixture(t *testing.T) (path, host string) {
+	t.Helper()
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skip("no hostname")
+	}
+	host = strings.ToLower(host)
+	data := fmt.Sprintf(`{
+  "command": "C:\\Tools\\backup.exe",
+  "timeout": 60,
+  "log_count": 3,
+  "profiles": {
+    "office": {"command": "C:\\工具\\关机前清理临时文件并同步所有用户的桌面和文档目录到文件服务器.exe --full --目标 D:\\备份\\桌面与文档"},
+    %q: {"timeout": 300}
+  }
+}`, host)
+	path = filepath.Join(t.TempDir(), "winpsp.json")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, host
+}
+
+func TestTruncateRunes(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"", 10, ""},
+		{"shutdown.exe", 12, "shutdown.exe"},
+		{"shutdown.exe /s", 12, "shutdown...."},
+		{"关机前清理", 5, "关机前清理"},
+		{"关机前清理临时文件", 5, "关机..."},
+		{"a关机前清理临时文件", 6, "a关机..."},
+	}
+	for _, tt := range tests {
+		if got := truncateRunes(tt.in, tt.n); got != tt.want {
+			t.Errorf("truncateRunes(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	path, host := writeProfilesFixture(t)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		profile     string
+		wantCommand string
+		wantTimeout int
+	}{
+		{"office", `C:\工具\关机前清理临时文件并同步所有用户的桌面和文档目录到文件服务器.exe --full --目标 D:\备份\桌面与文档`, 60},
+		{host, `C:\Tools\backup.exe`, 300},
+	}
+	for _, tt := range tests {
+		var cfg Config
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			t.Fatal(err)
+		}
+		if err := applyProfile(&cfg, tt.profile); err != nil {
+			t.Fatalf("applyProfile(%q): %v", tt.profile, err)
+		}
+		if cfg.Command != tt.wantCommand || cfg.Timeout == nil || *cfg.Timeout != tt.wantTimeout {
+			t.Errorf("applyProfile(%q): command %q timeout %v, want %q %d",
+				tt.profile, cfg.Command, cfg.Timeout, tt.wantCommand, tt.wantTimeout)
+		}
+		if cfg.LogCount == nil || *cfg.LogCount != 3 {
+			t.Errorf("applyProfile(%q): log_count not inherited from the top level", tt.profile)
+		}
+		if len(cfg.Profiles) != 2 {
+			t.Errorf("applyProfile(%q): %d profiles left, want 2", tt.profile, len(cfg.Profiles))
+		}
+	}
+
+	var cfg Config
+	json.Unmarshal(data, &cfg)
+	if err := applyProfile(&cfg, "missing"); err == nil {
+		t.Error(`applyProfile("missing") succeeded`)
+	}
+	if got := hostProfileName(&cfg); got != host {
+		t.Errorf("hostProfileName = %q, want %q", got, host)
+	}
+}
+
+func TestListProfiles(t *testing.T) {
+	path, host := writeProfilesFixture(t)
+	out := captureStdout(t, func() { listProfiles(path) })
+
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("listProfiles printed %d lines, want 2:\n%s", len(lines), out)
+	}
+	// 主机名 profile 没有自己的 command；office 的 command 超过 60 个字符
+	byName := map[string]string{}
+	for _, l := range lines {
+		name, rest, _ := strings.Cut(l, " ")
+		byName[name] = strings.TrimSpace(rest)
+	}
+	if got, want := byName[host], "(inherits top-level command)  [matches hostname]"; got != want {
+		t.Errorf("%s: %q, want %q", host, got, want)
+	}
+	office := byName["office"]
+	if n := len([]rune(office)); n != 60 || !strings.HasSuffix(office, "...") {
+		t.Errorf("office: %q (%d characters), want 60 characters ending in ...", office, n)
+	}
+	if !strings.HasPrefix(office, `C:\工具\关机前清理`) {
+		t.Errorf("office: %q lost its prefix", office)
+	}
+}
+
+func TestListProfilesNone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "winpsp.json")
+	if err := os.WriteFile(path, []byte(`{"command": "shutdown.exe"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out := captureStdout(t, func() { listProfiles(path) }); out != "No profiles defined.\n" {
+		t.Errorf("listProfiles = %q", out)
+	}
+}
+
+// 标准输入不是控制台时不显示菜单：没有 --profile 就只用顶层配置，即使有 profile 与主机名匹配
+func TestChooseProfileNonConsole(t *testing.T) {
+	origConsole := stdinIsConsole
+	stdinIsConsole = func() bool { return false }
+	origName, origChosen := chosenProfile, profileChosen
+	t.Cleanup(func() {
+		stdinIsConsole = origConsole
+		chosenProfile, profileChosen = origName, origChosen
+	})
+
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := writeConfig(t, fmt.Sprintf(`{
+  "command": "top.exe",
+  "profiles": {
+    %q: {"command": "host.exe"},
+    "OTHER": {"command": "other.exe"}
+  }
+}`, host))
+
+	tests := []struct {
+		profile     string // --profile，空串表示未指定
+		wantCommand string
+	}{
+		{"", "top.exe"},
+		{"OTHER", "other.exe"},
+	}
+	for _, tt := range tests {
+		chosenProfile, profileChosen = tt.profile, tt.profile != ""
+		if out := captureStdout(t, func() { chooseProfile(s.configPath) }); out != "" {
+			t.Errorf("--profile %q: menu shown without a console:\n%s", tt.profile, out)
+		}
+		cfg, err := s.readConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Command != tt.wantCommand {
+			t.Errorf("--profile %q: command %q, want %q", tt.profile, cfg.Command, tt.wantCommand)
+		}
+	}
+}
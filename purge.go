@@ -0,0 +1,88 @@
+//go:build windows
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// -------------------- --uninstall --purge --------------------
+
+// --purge 在删除服务之后继续删除 WinPSP 留在机器上的东西：
+// 实例的配置目录（配置、日志、运行记录等全部内容）、事件日志来源的注册表项、
+// webhook_token_credential_name 对应的凭据。
+// 只删除 C:\ProgramData\WinPSP[-<NAME>]\；--config 指向别处的文件不会被删除。
+
+// 删除操作，可在调试时替换
+var (
+	removeAll         = os.RemoveAll
+	removeEventSource = unregisterEventSource
+)
+
+// purgeItem 是 --purge 要删除的一项
+type purgeItem struct {
+	desc   string
+	remove func() error
+}
+
+// purgeItems 列出实例要删除的内容。凭据名需要在删除配置目录之前读出来。
+func purgeItems(name, configPath string) []purgeItem {
+	dir := filepath.Dir(instanceConfigPath(name))
+	items := []purgeItem{
+		{"Event Log source " + name, func() error { return removeEventSource(name) }},
+	}
+	if cfg, err := parseConfigFile(configPath); err == nil && cfg.WebhookTokenCredentialName != "" {
+		target := cfg.WebhookTokenCredentialName
+		items = append(items, purgeItem{"credential " + target, func() error { return deleteCredential(target) }})
+	}
+	items = append(items, purgeItem{"config directory " + dir, func() error {
+		if _, err := os.Stat(dir); err != nil {
+			return err
+		}
+		return removeAll(dir)
+	}})
+	return items
+}
+
+// confirmPurge 列出将要删除的内容并等待输入 y，force 时直接返回
+func confirmPurge(items []purgeItem, force bool, in io.Reader) error {
+	if force {
+		return nil
+	}
+	fmt.Println("--purge will delete:")
+	for _, it := range items {
+		fmt.Printf("  %s\n", it.desc)
+	}
+	fmt.Print("Continue? [y/N] ")
+	line, _ := bufio.NewReader(in).ReadString('\n')
+	if answer := strings.ToLower(strings.TrimSpace(line)); answer != "y" && answer != "yes" {
+		return errors.New("purge cancelled")
+	}
+	return nil
+}
+
+// purge 依次删除各项，每删除一项在 stderr 输出一行；某项失败不影响其他项，
+// 最后返回第一个错误。本来就不存在的项不算错误。
+func purge(items []purgeItem) error {
+	var first error
+	for _, it := range items {
+		err := it.remove()
+		switch {
+		case err == nil:
+			fmt.Fprintf(os.Stderr, "Deleted %s\n", it.desc)
+		case errors.Is(err, os.ErrNotExist):
+		default:
+			fmt.Fprintf(os.Stderr, "Failed to delete %s: %v\n", it.desc, err)
+			if first == nil {
+				first = fmt.Errorf("purge %s: %w", it.desc, err)
+			}
+		}
+	}
+	return first
+}
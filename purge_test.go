@@ -0,0 +1,127 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// purgeCalls 记录模拟的删除操作：操作名 → 参数
+type purgeCalls struct {
+	calls []string
+	errs  map[string]error // 按操作名返回的错误
+}
+
+// mockPurge 替换 removeAll / removeEventSource / deleteCredential
+func mockPurge(t *testing.T) *purgeCalls {
+	t.Helper()
+	p := &purgeCalls{errs: map[string]error{}}
+	record := func(op, arg string) error {
+		p.calls = append(p.calls, op+" "+arg)
+		return p.errs[op]
+	}
+	origAll, origSource, origCred := removeAll, removeEventSource, deleteCredential
+	removeAll = func(path string) error { return record("removeAll", path) }
+	removeEventSource = func(name string) error { return record("removeEventSource", name) }
+	deleteCredential = func(target string) error { return record("deleteCredential", target) }
+	t.Cleanup(func() { removeAll, removeEventSource, deleteCredential = origAll, origSource, origCred })
+	return p
+}
+
+// purgeInstance 在 C:\ProgramData 下创建一个临时实例的配置目录，返回实例名和目录
+func purgeInstance(t *testing.T, config string) (string, string) {
+	t.Helper()
+	name := fmt.Sprintf("PurgeTest%d", time.Now().UnixNano())
+	dir := filepath.Dir(instanceConfigPath(name))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Skipf("cannot create %s: %v", dir, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	if config != "" {
+		if err := os.WriteFile(instanceConfigPath(name), []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return name, dir
+}
+
+func TestPurge(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    string
+		errs      map[string]error
+		wantCalls []string // {name} 和 {dir} 替换为实例名和配置目录
+		wantErr   string
+	}{
+		{"no config", "", nil,
+			[]string{"removeEventSource {name}", "removeAll {dir}"}, ""},
+		{"with credential", `{"command": "x.exe", "webhook_token_credential_name": "WinPSP/webhook"}`, nil,
+			[]string{"removeEventSource {name}", "deleteCredential WinPSP/webhook", "removeAll {dir}"}, ""},
+		{"already removed", `{"command": "x.exe", "webhook_token_credential_name": "WinPSP/webhook"}`,
+			map[string]error{"removeEventSource": nil, "deleteCredential": os.ErrNotExist},
+			[]string{"removeEventSource {name}", "deleteCredential WinPSP/webhook", "removeAll {dir}"}, ""},
+		{"failure does not stop the rest", `{"command": "x.exe", "webhook_token_credential_name": "WinPSP/webhook"}`,
+			map[string]error{"removeEventSource": errors.New("Access is denied."), "removeAll": errors.New("file in use")},
+			[]string{"removeEventSource {name}", "deleteCredential WinPSP/webhook", "removeAll {dir}"}, "Access is denied."},
+	}
+	for _, tt := range tests {
+		p := mockPurge(t)
+		for op, err := range tt.errs {
+			p.errs[op] = err
+		}
+		name, dir := purgeInstance(t, tt.config)
+
+		err := purge(purgeItems(name, instanceConfigPath(name)))
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: purge error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+		want := strings.NewReplacer("{name}", name, "{dir}", dir).Replace(strings.Join(tt.wantCalls, "\n"))
+		if got := strings.Join(p.calls, "\n"); got != want {
+			t.Errorf("%s: calls\n%s\nwant\n%s", tt.name, got, want)
+		}
+	}
+}
+
+// 配置目录不存在时不调用 removeAll，也不算错误
+func TestPurgeMissingDir(t *testing.T) {
+	p := mockPurge(t)
+	name := fmt.Sprintf("PurgeTest%d", time.Now().UnixNano())
+	if err := purge(purgeItems(name, instanceConfigPath(name))); err != nil {
+		t.Errorf("purge: %v", err)
+	}
+	if got := strings.Join(p.calls, "\n"); got != "removeEventSource "+name {
+		t.Errorf("calls %q, want only the event source", got)
+	}
+}
+
+func TestConfirmPurge(t *testing.T) {
+	tests := []struct {
+		force   bool
+		input   string
+		wantErr bool
+	}{
+		{true, "", false},
+		{false, "y\r\n", false},
+		{false, "YES\n", false},
+		{false, "n\r\n", true},
+		{false, "\r\n", true},
+		{false, "", true},
+	}
+	items := []purgeItem{{"Event Log source WinPSP", nil}, {`config directory C:\ProgramData\WinPSP`, nil}}
+	for _, tt := range tests {
+		var err error
+		out := captureStdout(t, func() { err = confirmPurge(items, tt.force, strings.NewReader(tt.input)) })
+		if (err != nil) != tt.wantErr {
+			t.Errorf("force %v, input %q: error = %v, wantErr %v", tt.force, tt.input, err, tt.wantErr)
+		}
+		if listed := strings.Contains(out, `config directory C:\ProgramData\WinPSP`); listed == tt.force {
+			t.Errorf("force %v: items listed %v:\n%s", tt.force, listed, out)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultReloadBackoffInitialMS   = 500
+	defaultReloadBackoffMaxMS       = 10000
+	defaultReloadBackoffMultiplier  = 2.0
+	defaultReloadBackoffMaxAttempts = 5
+)
+
+// -------------------- config_reload_backoff --------------------
+
+// 配置管理工具常常分几步改写配置文件（删除、写入、关闭），--reload 正好落在中间时
+// 读到的是空文件或半个文件。配置了 config_reload_backoff 时，重新加载失败后按指数退避
+// 重试，直到成功或者用完 max_attempts 次；重试期间继续使用原来的配置。
+// 重试由服务的控制循环中的定时器驱动，不阻塞控制请求。
+
+// ConfigReloadBackoff 是 --reload 失败后的重试设置，0 表示使用默认值
+type ConfigReloadBackoff struct {
+	InitialMS   int     `json:"initial_ms"`   // 第一次重试前的等待
+	MaxMS       int     `json:"max_ms"`       // 每次等待的上限
+	Multiplier  float64 `json:"multiplier"`   // 每次等待是上一次的几倍
+	MaxAttempts int     `json:"max_attempts"` // 包括第一次加载在内的总次数
+}
+
+// resolve 校验设置并填入默认值
+func (b *ConfigReloadBackoff) resolve() error {
+	if b.InitialMS < 0 || b.MaxMS < 0 || b.Multiplier < 0 || b.MaxAttempts < 0 {
+		return errors.New("config_reload_backoff: values must not be negative")
+	}
+	if b.InitialMS == 0 {
+		b.InitialMS = defaultReloadBackoffInitialMS
+	}
+	if b.MaxMS == 0 {
+		b.MaxMS = defaultReloadBackoffMaxMS
+	}
+	if b.Multiplier == 0 {
+		b.Multiplier = defaultReloadBackoffMultiplier
+	}
+	if b.MaxAttempts == 0 {
+		b.MaxAttempts = defaultReloadBackoffMaxAttempts
+	}
+	if b.Multiplier < 1 {
+		return fmt.Errorf("invalid config_reload_backoff multiplier: %g", b.Multiplier)
+	}
+	if b.MaxMS < b.InitialMS {
+		return fmt.Errorf("config_reload_backoff: max_ms (%d) is less than initial_ms (%d)", b.MaxMS, b.InitialMS)
+	}
+	return nil
+}
+
+// reloadRetry 是一次 --reload 的重试状态
+type reloadRetry struct {
+	policy  ConfigReloadBackoff
+	attempt int
+	delay   time.Duration
+}
+
+// newReloadRetry 按当前配置的 config_reload_backoff 开始一次重新加载，
+// 未配置（或使用远程配置）时返回 nil，即只加载一次
+func (s *winpspService) newReloadRetry() *reloadRetry {
+	cfg := s.config.Load()
+	if s.remote != nil || cfg == nil || cfg.ConfigReloadBackoff == nil {
+		return nil
+	}
+	p := *cfg.ConfigReloadBackoff
+	return &reloadRetry{policy: p, delay: time.Duration(p.InitialMS) * time.Millisecond}
+}
+
+// tryReload 加载一次配置。需要再次重试时返回下一次的定时器，否则返回 nil。
+// r 为 nil 时与 reloadConfig 相同。只能在关机处理开始之前调用（见 Execute）。
+func (s *winpspService) tryReload(r *reloadRetry) <-chan time.Time {
+	if r == nil {
+		s.reloadConfig()
+		return nil
+	}
+
+	r.attempt++
+	cfg, err := s.readConfig()
+	if err == nil {
+		if r.attempt > 1 {
+			debugf("WinPSP: config reloaded after %d attempts", r.attempt)
+		}
+		s.storeConfig(cfg, nil)
+		return nil
+	}
+	if r.attempt >= r.policy.MaxAttempts {
+		// 用完重试次数，与只加载一次时的结果相同
+		s.storeConfig(cfg, err)
+		if !configAbsent(err) {
+			writeEvent(eventError, eventIDConfigError, fmt.Sprintf("WinPSP: config error after %d reload attempts: %v", r.attempt, err))
+		}
+		return nil
+	}
+
+	wait := r.delay
+	debugf("WinPSP: config reload attempt %d failed, retrying in %s: %v", r.attempt, wait, err)
+	r.delay = time.Duration(float64(r.delay) * r.policy.Multiplier)
+	if limit := time.Duration(r.policy.MaxMS) * time.Millisecond; r.delay > limit {
+		r.delay = limit
+	}
+	return time.After(wait)
+}
@@ -0,0 +1,118 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestConfigReloadBackoffResolve(t *testing.T) {
+	tests := []struct {
+		in      ConfigReloadBackoff
+		want    ConfigReloadBackoff
+		wantErr bool
+	}{
+		{ConfigReloadBackoff{}, ConfigReloadBackoff{500, 10000, 2, 5}, false},
+		{ConfigReloadBackoff{InitialMS: 100}, ConfigReloadBackoff{100, 10000, 2, 5}, false},
+		{ConfigReloadBackoff{200, 1000, 1.5, 3}, ConfigReloadBackoff{200, 1000, 1.5, 3}, false},
+		{ConfigReloadBackoff{Multiplier: 1}, ConfigReloadBackoff{500, 10000, 1, 5}, false},
+		{ConfigReloadBackoff{InitialMS: 1000, MaxMS: 1000}, ConfigReloadBackoff{1000, 1000, 2, 5}, false},
+		{ConfigReloadBackoff{InitialMS: -1}, ConfigReloadBackoff{}, true},
+		{ConfigReloadBackoff{MaxAttempts: -1}, ConfigReloadBackoff{}, true},
+		{ConfigReloadBackoff{Multiplier: 0.5}, ConfigReloadBackoff{}, true},
+		{ConfigReloadBackoff{InitialMS: 200, MaxMS: 100}, ConfigReloadBackoff{}, true},
+		{ConfigReloadBackoff{MaxMS: 100}, ConfigReloadBackoff{}, true}, // 小于默认的 initial_ms
+	}
+	for _, tt := range tests {
+		got := tt.in
+		err := got.resolve()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%+v.resolve() error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("%+v.resolve() = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+const reloadBackoffConfig = `{
+  "command": "C:\\Windows\\System32\\whoami.exe",
+  "config_reload_backoff": {"initial_ms": 100, "max_ms": 250, "max_attempts": 4}
+}`
+
+// loadedService 返回已按 data 加载好配置的服务
+func loadedService(t *testing.T, data string) *winpspService {
+	t.Helper()
+	s := writeConfig(t, data)
+	if err := s.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestTryReloadBackoff(t *testing.T) {
+	s := loadedService(t, reloadBackoffConfig)
+	orig := s.config.Load()
+
+	// 配置管理工具写了一半的文件
+	if err := os.WriteFile(s.configPath, []byte(`{"command": "C:\\Win`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := s.newReloadRetry()
+	if r == nil {
+		t.Fatal("newReloadRetry returned nil with config_reload_backoff set")
+	}
+
+	// 等待 100 ms、200 ms、250 ms（max_ms），第 4 次失败后放弃
+	for i, want := range []time.Duration{200, 250, 250} {
+		if next := s.tryReload(r); next == nil {
+			t.Fatalf("attempt %d: no retry scheduled", i+1)
+		}
+		if r.delay != want*time.Millisecond {
+			t.Errorf("attempt %d: next delay %s, want %s", i+1, r.delay, want*time.Millisecond)
+		}
+		if s.config.Load() != orig {
+			t.Fatalf("attempt %d: config replaced while retrying", i+1)
+		}
+	}
+	if next := s.tryReload(r); next != nil {
+		t.Fatal("retry scheduled after max_attempts")
+	}
+	if s.config.Load() != nil || s.lastConfigErr() == nil {
+		t.Error("config still loaded after the last attempt failed")
+	}
+}
+
+func TestTryReloadRecovers(t *testing.T) {
+	s := loadedService(t, reloadBackoffConfig)
+	if err := os.WriteFile(s.configPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := s.newReloadRetry()
+	if next := s.tryReload(r); next == nil {
+		t.Fatal("no retry scheduled for an empty config file")
+	}
+
+	// 写完之后的下一次重试成功
+	updated := `{"command": "C:\\Windows\\System32\\hostname.exe", "config_reload_backoff": {}}`
+	if err := os.WriteFile(s.configPath, []byte(updated), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if next := s.tryReload(r); next != nil {
+		t.Fatal("retry scheduled after a successful reload")
+	}
+	cfg := s.config.Load()
+	if cfg == nil || cfg.Command != `C:\Windows\System32\hostname.exe` || s.lastConfigErr() != nil {
+		t.Fatalf("config after recovery = %+v, err %v", cfg, s.lastConfigErr())
+	}
+}
+
+func TestNewReloadRetryDisabled(t *testing.T) {
+	s := loadedService(t, `{"command": "C:\\Windows\\System32\\whoami.exe"}`)
+	if r := s.newReloadRetry(); r != nil {
+		t.Errorf("newReloadRetry = %+v, want nil without config_reload_backoff", r)
+	}
+}
@@ -0,0 +1,134 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	remoteConfigCacheName     = "config.remote.json"
+	remoteConfigETagName      = "config.remote.etag"
+	remoteConfigHTTPTimeout   = 30 * time.Second
+	defaultConfigCheckSeconds = 300
+)
+
+// -------------------- 远程配置 --------------------
+
+// remoteConfig 从 HTTP(S) 下载配置并缓存在本地。
+// 服务器不可达时继续使用上次缓存的版本。
+type remoteConfig struct {
+	url       string
+	token     string // Bearer token，可为空
+	cachePath string
+	etagPath  string
+}
+
+// newRemoteConfig 创建远程配置源，缓存文件放在 dir 中（日志也写在这里）
+func newRemoteConfig(url, token, dir string) *remoteConfig {
+	return &remoteConfig{
+		url:       url,
+		token:     token,
+		cachePath: filepath.Join(dir, remoteConfigCacheName),
+		etagPath:  filepath.Join(dir, remoteConfigETagName),
+	}
+}
+
+// fetch 下载配置；内容未变（304）时返回 changed = false
+func (rc *remoteConfig) fetch() (changed bool, err error) {
+	req, err := http.NewRequest(http.MethodGet, rc.url, nil)
+	if err != nil {
+		return false, err
+	}
+	if rc.token != "" {
+		req.Header.Set("Authorization", "Bearer "+rc.token)
+	}
+	// 只有缓存还在时 ETag 才有意义
+	if _, err := os.Stat(rc.cachePath); err == nil {
+		if etag, err := os.ReadFile(rc.etagPath); err == nil {
+			req.Header.Set("If-None-Match", strings.TrimSpace(string(etag)))
+		}
+	}
+
+	// TLS 客户端证书等设置取自上次缓存的配置：
+	// 第一次下载时还没有缓存，只能使用默认设置（或预先部署的缓存文件）
+	var cached *Config
+	if cfg, err := parseConfigFile(rc.cachePath); err == nil {
+		cached = cfg
+	}
+	client, err := newHTTPClient(cached, remoteConfigHTTPTimeout)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return false, nil
+	case resp.StatusCode != http.StatusOK:
+		return false, fmt.Errorf("GET %s: %s", rc.url, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	if !json.Valid(data) {
+		return false, errors.New("remote config is not valid JSON")
+	}
+
+	// 先写临时文件再改名，避免留下半个配置
+	tmp := rc.cachePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, rc.cachePath); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		_ = os.WriteFile(rc.etagPath, []byte(etag), 0600)
+	} else {
+		_ = os.Remove(rc.etagPath)
+	}
+	return true, nil
+}
+
+// refreshRemoteConfig 下载远程配置并在内容变化时重新加载。
+// 下载失败时保留当前（缓存的）配置。
+func (s *winpspService) refreshRemoteConfig() {
+	changed, err := s.remote.fetch()
+	if err != nil {
+		writeEvent(eventWarning, eventIDRemoteConfigError,
+			fmt.Sprintf("WinPSP: remote config unavailable, using cached copy: %v", err))
+	}
+	if changed || s.config.Load() == nil {
+		if err := s.loadConfig(); err != nil && !configAbsent(err) {
+			writeEvent(eventError, eventIDConfigError, fmt.Sprintf("WinPSP: config error: %v", err))
+		}
+	}
+}
+
+// configCheckInterval 返回远程配置的检查间隔（来自当前缓存的配置）
+func (s *winpspService) configCheckInterval() time.Duration {
+	cfg := s.config.Load()
+	secs := defaultConfigCheckSeconds
+	if cfg != nil && cfg.ConfigCheckIntervalSecs > 0 {
+		secs = cfg.ConfigCheckIntervalSecs
+	}
+	return time.Duration(secs) * time.Second
+}
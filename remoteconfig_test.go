@@ -0,0 +1,137 @@
+//go:build windows
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// configServer 是模拟的配置服务器：按 ETag 返回 304，记录收到的请求头
+type configServer struct {
+	mu      sync.Mutex
+	body    string
+	etag    string
+	status  int // 非 0 时直接返回这个状态码
+	headers []http.Header
+}
+
+func (cs *configServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.headers = append(cs.headers, r.Header.Clone())
+	if cs.status != 0 {
+		w.WriteHeader(cs.status)
+		return
+	}
+	if cs.etag != "" && r.Header.Get("If-None-Match") == cs.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if cs.etag != "" {
+		w.Header().Set("ETag", cs.etag)
+	}
+	w.Write([]byte(cs.body))
+}
+
+func (cs *configServer) set(f func()) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	f()
+}
+
+func (cs *configServer) lastHeader(key string) string {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.headers[len(cs.headers)-1].Get(key)
+}
+
+func TestRemoteConfigFetch(t *testing.T) {
+	const v1 = `{"command": "C:\\Windows\\System32\\whoami.exe"}`
+	const v2 = `{"command": "C:\\Windows\\System32\\hostname.exe"}`
+	cs := &configServer{body: v1, etag: `"v1"`}
+	srv := httptest.NewServer(cs)
+	defer srv.Close()
+
+	rc := newRemoteConfig(srv.URL+"/winpsp.json", "s3cr3t", t.TempDir())
+	cached := func() string {
+		data, _ := os.ReadFile(rc.cachePath)
+		return string(data)
+	}
+
+	steps := []struct {
+		name        string
+		server      func()
+		wantChanged bool
+		wantErr     bool
+		wantIfNone  string
+		wantCache   string
+	}{
+		{"first download", func() {}, true, false, "", v1},
+		{"not modified", func() {}, false, false, `"v1"`, v1},
+		{"changed", func() { cs.body, cs.etag = v2, `"v2"` }, true, false, `"v1"`, v2},
+		{"server error keeps cache", func() { cs.status = http.StatusInternalServerError }, false, true, `"v2"`, v2},
+		{"invalid JSON keeps cache", func() { cs.status, cs.body, cs.etag = 0, `{"command": `, "" }, false, true, `"v2"`, v2},
+		{"no ETag", func() { cs.body = v1 }, true, false, `"v2"`, v1},
+		{"no ETag sent after a response without one", func() {}, true, false, "", v1},
+	}
+	for _, st := range steps {
+		cs.set(st.server)
+		changed, err := rc.fetch()
+		if changed != st.wantChanged || (err != nil) != st.wantErr {
+			t.Fatalf("%s: fetch = %v, %v, want changed %v, error %v", st.name, changed, err, st.wantChanged, st.wantErr)
+		}
+		if got := cs.lastHeader("If-None-Match"); got != st.wantIfNone {
+			t.Errorf("%s: If-None-Match %q, want %q", st.name, got, st.wantIfNone)
+		}
+		if got := cs.lastHeader("Authorization"); got != "Bearer s3cr3t" {
+			t.Errorf("%s: Authorization %q", st.name, got)
+		}
+		if got := cached(); got != st.wantCache {
+			t.Errorf("%s: cache = %q, want %q", st.name, got, st.wantCache)
+		}
+	}
+}
+
+func TestRefreshRemoteConfig(t *testing.T) {
+	cs := &configServer{body: `{"command": "C:\\Windows\\System32\\whoami.exe"}`, etag: `"v1"`}
+	srv := httptest.NewServer(cs)
+	defer srv.Close()
+
+	rc := newRemoteConfig(srv.URL, "", t.TempDir())
+	s := &winpspService{configPath: rc.cachePath, remote: rc}
+	s.refreshRemoteConfig()
+	if cfg := s.config.Load(); cfg == nil || cfg.Command != `C:\Windows\System32\whoami.exe` {
+		t.Fatalf("config after first refresh = %+v, err %v", cfg, s.lastConfigErr())
+	}
+
+	// 服务器不可达：继续使用缓存的配置
+	srv.Close()
+	before := s.config.Load()
+	s.refreshRemoteConfig()
+	if s.config.Load() != before {
+		t.Error("config replaced although the server was unreachable")
+	}
+
+	// 启动时服务器不可达，但有缓存：使用缓存
+	s2 := &winpspService{configPath: rc.cachePath, remote: rc}
+	s2.refreshRemoteConfig()
+	if cfg := s2.config.Load(); cfg == nil || cfg.Command != `C:\Windows\System32\whoami.exe` {
+		t.Errorf("cached config not loaded when the server is unreachable: %v", s2.lastConfigErr())
+	}
+}
+
+func TestConfigCheckInterval(t *testing.T) {
+	s := &winpspService{}
+	if got := s.configCheckInterval(); got != defaultConfigCheckSeconds*time.Second {
+		t.Errorf("configCheckInterval without config = %s", got)
+	}
+	s.config.Store(&Config{ConfigCheckIntervalSecs: 60})
+	if got := s.configCheckInterval(); got != time.Minute {
+		t.Errorf("configCheckInterval = %s, want 1m", got)
+	}
+}
@@ -0,0 +1,56 @@
+//go:build windows
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// -------------------- 运行标识 --------------------
+
+// 多台机器的日志汇总到一处时，每一行都要能看出来自哪台机器、哪次运行、哪份配置。
+// 文本日志中 WinPSP 写的行带 [run:<run_id 前 8 位>] 前缀；
+// JSON 格式的分节标记带完整的 hostname / run_id / config_hash 字段。
+
+// runContext 是一次关机处理的标识，handleShutdownOnce 开始时生成
+type runContext struct {
+	Hostname   string
+	RunID      string // 随机 UUID（v4）
+	ConfigHash string // 配置文件内容 SHA256 的前 8 个十六进制字符
+}
+
+func newRunContext(cfg *Config) runContext {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown-host"
+	}
+	return runContext{Hostname: host, RunID: newRunID(), ConfigHash: cfg.configHash}
+}
+
+// short 返回文本日志前缀中使用的 run_id 前 8 位
+func (rc runContext) short() string {
+	if len(rc.RunID) < 8 {
+		return rc.RunID
+	}
+	return rc.RunID[:8]
+}
+
+// newRunID 用 crypto/rand 生成 UUID v4
+func newRunID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "00000000-0000-4000-8000-000000000000"
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func configHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:4])
+}
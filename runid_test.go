@@ -0,0 +1,117 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var uuidV4 = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestNewRunID(t *testing.T) {
+	seen := map[string]bool{}
+	for range 1000 {
+		id := newRunID()
+		if !uuidV4.MatchString(id) {
+			t.Fatalf("newRunID() = %q, not a UUID v4", id)
+		}
+		if seen[id] {
+			t.Fatalf("newRunID() returned %q twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	tests := []struct {
+		data, want string
+	}{
+		{"", "e3b0c442"},
+		{"abc", "ba7816bf"},
+	}
+	for _, tt := range tests {
+		if got := configHash([]byte(tt.data)); got != tt.want {
+			t.Errorf("configHash(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+	if configHash([]byte(`{"timeout": 30}`)) == configHash([]byte(`{"timeout": 31}`)) {
+		t.Error("different configs have the same hash")
+	}
+}
+
+func TestRunContextShort(t *testing.T) {
+	tests := []struct {
+		id, want string
+	}{
+		{"1a2b3c4d-5e6f-4a7b-8c9d-0e1f2a3b4c5d", "1a2b3c4d"},
+		{"1a2b3c4d", "1a2b3c4d"},
+		{"1a2b", "1a2b"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := (runContext{RunID: tt.id}).short(); got != tt.want {
+			t.Errorf("short(%q) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
+
+const runIDConfig = `{
+  "commands": [
+    {"name": "first", "command": "cmd.exe /c echo output of first"},
+    {"name": "second", "command": "cmd.exe /c exit 0"}
+  ],
+  "log_section_format": "json"
+}`
+
+// 一次运行的每一行 WinPSP 日志都带同一个 run_id：文本行的前缀和 JSON 分节标记
+func TestRunIDOnEveryLine(t *testing.T) {
+	var runIDs []string
+	for range 2 {
+		s := loadedService(t, runIDConfig)
+		s.interactive = true
+		log := shutdownLog(t, s)
+		data, err := os.ReadFile(s.configPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		host, _ := os.Hostname()
+
+		id := s.run.RunID
+		if !uuidV4.MatchString(id) {
+			t.Fatalf("run_id %q is not a UUID v4", id)
+		}
+		runIDs = append(runIDs, id)
+
+		var textLines, markers int
+		for _, l := range strings.Split(strings.TrimRight(log, "\r\n"), "\n") {
+			l = strings.TrimSuffix(l, "\r")
+			switch {
+			case strings.HasPrefix(l, "["):
+				textLines++
+				_, msg, _ := strings.Cut(l, "] ")
+				if !strings.HasPrefix(msg, "[run:"+id[:8]+"] ") {
+					t.Errorf("line without [run:%s]: %q", id[:8], l)
+				}
+			case strings.HasPrefix(l, "{"):
+				markers++
+				var m map[string]any
+				if err := json.Unmarshal([]byte(l), &m); err != nil {
+					t.Fatalf("section marker %q: %v", l, err)
+				}
+				if m["run_id"] != id || m["hostname"] != host || m["config_hash"] != configHash(data) {
+					t.Errorf("section marker %v, want run_id %s, hostname %s, config_hash %s", m, id, host, configHash(data))
+				}
+			}
+		}
+		if textLines == 0 || markers != 4 {
+			t.Errorf("%d text lines and %d section markers, want some and 4:\n%s", textLines, markers, log)
+		}
+	}
+	if runIDs[0] == runIDs[1] {
+		t.Errorf("two runs have the same run_id %s", runIDs[0])
+	}
+}
@@ -0,0 +1,117 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	defaultS3UploadTimeoutSecs = 30
+	defaultS3Region            = "us-east-1"
+)
+
+// -------------------- S3 日志上传 --------------------
+
+// resolveS3Endpoint 在加载配置时填入 s3_region 和 s3_endpoint 的默认值并校验地址。
+// 未配置 s3_endpoint 时使用该区域的 AWS 地址 https://s3.<region>.amazonaws.com。
+func resolveS3Endpoint(cfg *Config) error {
+	if cfg.S3Region == "" {
+		cfg.S3Region = defaultS3Region
+	}
+	if cfg.S3Endpoint == "" {
+		cfg.S3Endpoint = "https://s3." + cfg.S3Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(cfg.S3Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid s3_endpoint: %q (expected http:// or https:// URL)", cfg.S3Endpoint)
+	}
+	return nil
+}
+
+// uploadLogToS3 把已关闭的日志文件上传到 S3 兼容存储。
+// 对象键为 {prefix}/{hostname}/{logfilename}，使用 path-style 地址，
+// 以兼容 MinIO、Backblaze B2 等非 AWS 实现。
+// 上传时间不超过 s3_upload_timeout_secs，也不超过关机截止时间 deadline（零值表示不限）。
+func (s *winpspService) uploadLogToS3(logPath string, deadline time.Time) error {
+	cfg := s.config.Load()
+
+	body, err := os.ReadFile(logPath)
+	if err != nil {
+		return err
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown-host"
+	}
+
+	key := path.Join(strings.Trim(cfg.S3KeyPrefix, "/"), host, filepath.Base(logPath))
+
+	timeout := time.Duration(defaultS3UploadTimeoutSecs) * time.Second
+	if cfg.S3UploadTimeoutSecs != nil {
+		timeout = time.Duration(*cfg.S3UploadTimeoutSecs) * time.Second
+	}
+	if timeout = timeoutBefore(timeout, deadline); timeout <= 0 {
+		return fmt.Errorf("s3 upload %s: skipped, shutdown timeout reached", key)
+	}
+
+	client, err := newS3Client(cfg, timeout)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if _, err := client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(cfg.S3Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("text/plain; charset=utf-8"),
+	}); err != nil {
+		return fmt.Errorf("s3 upload %s: %w", key, err)
+	}
+	return nil
+}
+
+// newS3Client 按配置创建 S3 客户端。签名（SigV4，含 STS 临时凭据的会话令牌）交给 SDK；
+// 没有配置访问密钥时发送匿名请求。
+func newS3Client(cfg *Config, timeout time.Duration) (*s3.Client, error) {
+	httpClient, err := newHTTPClient(cfg, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds aws.CredentialsProvider = aws.AnonymousCredentials{}
+	if cfg.S3AccessKeyID != "" {
+		creds = aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{
+				AccessKeyID:     cfg.S3AccessKeyID,
+				SecretAccessKey: cfg.S3SecretAccessKey,
+				SessionToken:    cfg.S3SessionToken,
+			}, nil
+		})
+	}
+
+	return s3.New(s3.Options{
+		Region:       cfg.S3Region,
+		BaseEndpoint: aws.String(strings.TrimRight(cfg.S3Endpoint, "/")),
+		UsePathStyle: true,
+		Credentials:  creds,
+		HTTPClient:   httpClient,
+		// 只在 S3 要求时计算校验和：部分兼容实现不支持 SDK 默认附加的 CRC 校验
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+		ResponseChecksumValidation: aws.ResponseChecksumValidationWhenRequired,
+	}), nil
+}
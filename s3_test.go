@@ -0,0 +1,214 @@
+//go:build windows
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResolveS3Endpoint(t *testing.T) {
+	tests := []struct {
+		region, endpoint string
+		wantRegion       string
+		wantEndpoint     string
+		wantErr          bool
+	}{
+		{"", "", "us-east-1", "https://s3.us-east-1.amazonaws.com", false},
+		{"eu-west-1", "", "eu-west-1", "https://s3.eu-west-1.amazonaws.com", false},
+		{"", "http://minio.local:9000", "us-east-1", "http://minio.local:9000", false},
+		{"auto", "https://example.r2.cloudflarestorage.com/", "auto", "https://example.r2.cloudflarestorage.com/", false},
+		{"", "minio.local:9000", "", "", true},
+		{"", "ftp://minio.local", "", "", true},
+		{"", "https://", "", "", true},
+	}
+	for _, tt := range tests {
+		cfg := &Config{S3Region: tt.region, S3Endpoint: tt.endpoint}
+		err := resolveS3Endpoint(cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("resolveS3Endpoint(%q, %q) error = %v, wantErr %v", tt.region, tt.endpoint, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (cfg.S3Region != tt.wantRegion || cfg.S3Endpoint != tt.wantEndpoint) {
+			t.Errorf("resolveS3Endpoint(%q, %q) = %q, %q, want %q, %q",
+				tt.region, tt.endpoint, cfg.S3Region, cfg.S3Endpoint, tt.wantRegion, tt.wantEndpoint)
+		}
+	}
+}
+
+// sigV4AuthRe 解析 Authorization 头；签名本身由 SDK 计算，这里只检查凭据和签名的请求头
+var sigV4AuthRe = regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=([^/]+)/\d{8}/([^/]+)/s3/aws4_request, SignedHeaders=([a-z0-9;-]+), Signature=[0-9a-f]{64}$`)
+
+func TestUploadLogToS3(t *testing.T) {
+	const (
+		region  = "eu-west-1"
+		content = "WinPSP: shutdown command finished\r\n"
+	)
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown-host"
+	}
+
+	tests := []struct {
+		name                string
+		accessKey, token    string
+		wantSigned          bool
+		wantSignedHeaderSub string // SignedHeaders 必须包含的头
+	}{
+		{"signed", "AKIDEXAMPLE", "", true, "host;x-amz-content-sha256;x-amz-date"},
+		{"temporary credentials", "ASIAEXAMPLE", "FwoGZXIvYXdzEBYaDH", true, "x-amz-security-token"},
+		{"anonymous", "", "", false, ""},
+	}
+	for _, tt := range tests {
+		type received struct {
+			method, path, contentType, auth, token, payloadHash string
+			body                                                []byte
+		}
+		got := make(chan received, 1)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			got <- received{
+				method:      r.Method,
+				path:        r.URL.Path,
+				contentType: r.Header.Get("Content-Type"),
+				auth:        r.Header.Get("Authorization"),
+				token:       r.Header.Get("X-Amz-Security-Token"),
+				payloadHash: r.Header.Get("X-Amz-Content-Sha256"),
+				body:        body,
+			}
+		}))
+
+		logPath := filepath.Join(t.TempDir(), "winpsp 2026-10-14.log")
+		if err := os.WriteFile(logPath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg := &Config{
+			S3Endpoint:        srv.URL + "/",
+			S3Region:          region,
+			S3Bucket:          "logs",
+			S3KeyPrefix:       "/winpsp/",
+			S3AccessKeyID:     tt.accessKey,
+			S3SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+			S3SessionToken:    tt.token,
+		}
+		if err := resolveS3Endpoint(cfg); err != nil {
+			t.Fatal(err)
+		}
+		s := &winpspService{}
+		s.config.Store(cfg)
+
+		err := s.uploadLogToS3(logPath, time.Time{})
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%s: uploadLogToS3: %v", tt.name, err)
+		}
+		rec := <-got
+
+		if want := "/logs/winpsp/" + host + "/winpsp 2026-10-14.log"; rec.method != http.MethodPut || rec.path != want {
+			t.Errorf("%s: request %s %q, want PUT %q", tt.name, rec.method, rec.path, want)
+		}
+		if string(rec.body) != content || rec.contentType != "text/plain; charset=utf-8" {
+			t.Errorf("%s: body %q (%s), want %q", tt.name, rec.body, rec.contentType, content)
+		}
+		if rec.token != tt.token {
+			t.Errorf("%s: X-Amz-Security-Token = %q, want %q", tt.name, rec.token, tt.token)
+		}
+
+		m := sigV4AuthRe.FindStringSubmatch(rec.auth)
+		if !tt.wantSigned {
+			if rec.auth != "" {
+				t.Errorf("%s: anonymous upload sent Authorization %q", tt.name, rec.auth)
+			}
+			continue
+		}
+		if m == nil {
+			t.Errorf("%s: malformed Authorization %q", tt.name, rec.auth)
+			continue
+		}
+		if m[1] != tt.accessKey || m[2] != region || !strings.Contains(m[3], tt.wantSignedHeaderSub) {
+			t.Errorf("%s: Authorization %q, want key %s, region %s, signed headers with %s", tt.name, rec.auth, tt.accessKey, region, tt.wantSignedHeaderSub)
+		}
+		if rec.payloadHash != sha256Hex([]byte(content)) {
+			t.Errorf("%s: X-Amz-Content-Sha256 = %q, want the hash of the body", tt.name, rec.payloadHash)
+		}
+	}
+}
+
+func TestUploadLogToS3Rejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	logPath := filepath.Join(t.TempDir(), "winpsp.log")
+	if err := os.WriteFile(logPath, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{S3Endpoint: srv.URL, S3Bucket: "logs"}
+	if err := resolveS3Endpoint(cfg); err != nil {
+		t.Fatal(err)
+	}
+	s := &winpspService{}
+	s.config.Store(cfg)
+
+	err := s.uploadLogToS3(logPath, time.Time{})
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("uploadLogToS3 error = %v, want 403", err)
+	}
+}
+
+// 上传不超过关机截止时间剩下的时间；已经没有时间时不发送请求
+func TestUploadLogToS3Deadline(t *testing.T) {
+	release := make(chan struct{})
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	logPath := filepath.Join(t.TempDir(), "winpsp.log")
+	if err := os.WriteFile(logPath, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	timeout := 30
+	cfg := &Config{S3Endpoint: srv.URL, S3Bucket: "logs", S3UploadTimeoutSecs: &timeout}
+	if err := resolveS3Endpoint(cfg); err != nil {
+		t.Fatal(err)
+	}
+	s := &winpspService{}
+	s.config.Store(cfg)
+
+	tests := []struct {
+		name         string
+		deadline     time.Duration
+		wantErr      string
+		wantRequests int32
+	}{
+		{"deadline passed", -time.Second, "skipped, shutdown timeout reached", 0},
+		{"deadline before the upload timeout", time.Second, "deadline exceeded", 1},
+	}
+	for _, tt := range tests {
+		requests.Store(0)
+		start := time.Now()
+		err := s.uploadLogToS3(logPath, start.Add(tt.deadline))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+		}
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf("%s: returned after %s", tt.name, d)
+		}
+		if got := requests.Load(); got != tt.wantRequests {
+			t.Errorf("%s: %d requests, want %d", tt.name, got, tt.wantRequests)
+		}
+	}
+}
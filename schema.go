@@ -0,0 +1,232 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// -------------------- JSON Schema --------------------
+
+// 字段说明和取值范围；类型由 Config 结构体反射得到，新增字段时在这里补上说明
+var schemaDescriptions = map[string]string{
+	"schema_version":                     "Config format version. Missing = 1; 2 = commands array (see --migrate-config).",
+	"command":                            "Command to run in the PRESHUTDOWN phase. Empty = do nothing.",
+	"command_hidden":                     "Start the command without a console window (CREATE_NO_WINDOW). Default: true for the service, false in interactive mode.",
+	"integrity_level":                    "Integrity level of the command process: low or medium lowers it below the service's; high and system change nothing. Empty = same as the service.",
+	"command_base_dir":                   "Base directory for relative paths in commands (containing \\ or /). Default: the config file's directory.",
+	"name":                               "Name of the command in log section markers and run records.",
+	"commands":                           "Commands run one after another instead of command. A failure stops the sequence.",
+	"command_env_var":                    "Environment variable holding the command line. When set and not empty, it replaces command.",
+	"require_admin":                      "Warn when a command's .exe has no manifest requesting requireAdministrator.",
+	"wmi_namespace":                      "WMI namespace for wmi_class. Default: root\\cimv2.",
+	"wmi_class":                          "WMI class or object path whose wmi_method is called instead of running command, e.g. Msvm_ComputerSystem.Name=\"<GUID>\".",
+	"wmi_method":                         "WMI method to call. Its ReturnValue is used as the exit code.",
+	"wmi_args":                           "Input parameters of wmi_method, converted to the parameter's CIM type.",
+	"working_directory":                  "Working directory of the command. Empty = inherit.",
+	"working_dir_sddl":                   "SDDL security descriptor set on working_directory before the command runs, so files it creates there inherit the ACL.",
+	"args_file":                          "File with extra arguments, one per line (# comments and blank lines ignored). Relative to working_directory.",
+	"args_file_required":                 "Fail the command when args_file does not exist.",
+	"log_section_markers":                "Mark the start and end of each command's output in the log.",
+	"log_section_format":                 "Format of the section markers.",
+	"log_retention_policy":               "How old logs are pruned: mode count, size_mb or age_days with value, or all with count, size_mb and age_days. Replaces log_count and max_log_dir_size_mb.",
+	"max_log_dir_size_mb":                "Total size cap for all log files; the oldest logs are deleted first. 0 = unlimited.",
+	"log_write_mode":                     "buffered (default) or direct: write the log with FILE_FLAG_WRITE_THROUGH | FILE_FLAG_NO_BUFFERING so nothing is lost on a crash.",
+	"pipeline":                           "Run commands as a pipeline: each command's stdout feeds the next command's stdin.",
+	"per_command_logs":                   "Write each command's output to its own winpsp-<time>-<N>-<name>.log instead of the shutdown log.",
+	"pipeline_fail_fast":                 "With pipeline: use the exit code of the first failing command instead of the last command.",
+	"log_count":                          "Number of log files to keep. 0 disables logging.",
+	"deadline_warning_percent":           "Log a warning (and Event ID 1004) when a command has used this percentage of its timeout. 0 = no warning.",
+	"timeout":                            "Maximum seconds to block shutdown, including retries. 0 = wait indefinitely. In commands: limit for that command.",
+	"retry_count":                        "Extra attempts after a failure. Timeouts are never retried.",
+	"retry_delay_secs":                   "Base delay between attempts in seconds.",
+	"retry_delay_strategy":               "How the delay grows between attempts.",
+	"retry_max_delay_secs":               "Upper bound for the exponential strategy.",
+	"success_exit_codes":                 "Exit codes treated as success.",
+	"grace_period_secs":                  "Seconds between Ctrl+Break and forced termination on timeout.",
+	"ps_execution_policy":                "PowerShell execution policy used when the command is a .ps1 script.",
+	"cmd_extra_args":                     "Extra cmd.exe arguments placed before /C when the command is a .bat or .cmd file, e.g. [\"/V:ON\"].",
+	"output_codepage":                    "Console code page for the command, e.g. 65001 for UTF-8. 0 = leave unchanged.",
+	"output_encoding":                    "Encoding of the command output, converted to UTF-8 in the log.",
+	"output_prefix_timestamp":            "Prefix each output line with a timestamp.",
+	"max_output_lines":                   "Keep only the last N output lines of each command in the log. 0 = unlimited.",
+	"failure_output_lines":               "Output lines repeated at the end of the log when the command fails.",
+	"log_timestamp_format":               "Go time layout for log timestamps.",
+	"log_dedup_window":                   "Collapse identical consecutive output lines within this many seconds. 0 = disabled.",
+	"env":                                "Extra environment variables for the command.",
+	"env_file":                           "File with KEY=VALUE lines added to the command environment.",
+	"secrets_backend":                    "Where \"secret:KEY\" values are read from: env, file:<path> or vault:<url>. Empty = no substitution.",
+	"vault_token":                        "Token sent as X-Vault-Token with secrets_backend vault:<url>.",
+	"env_file_required":                  "Treat a missing env_file as a config error.",
+	"env_inherit":                        "Pass WinPSP's environment to the command. false = only env_file, env and SystemRoot, windir, TEMP, TMP.",
+	"s3_endpoint":                        "S3-compatible endpoint URL for log upload. Defaults to https://s3.<region>.amazonaws.com.",
+	"s3_region":                          "S3 region used for signing.",
+	"s3_bucket":                          "Bucket for log upload. Empty disables upload.",
+	"s3_key_prefix":                      "Object key prefix.",
+	"s3_access_key_id":                   "S3 access key ID.",
+	"s3_secret_access_key":               "S3 secret access key.",
+	"s3_session_token":                   "Session token for temporary (STS) S3 credentials.",
+	"archive_unc_path":                   "Network share the log is copied to after each run, as <path>\\<hostname>\\<logfilename>. Empty disables archiving.",
+	"archive_timeout_secs":               "Timeout in seconds for archiving the log, all attempts included. Default: 60.",
+	"s3_upload_timeout_secs":             "Upload timeout in seconds.",
+	"output_pipe_name":                   "Named pipe that receives a live copy of the command output.",
+	"output_pipe_connect_timeout_ms":     "How long to wait for a pipe client.",
+	"job_memory_limit_mb":                "Job Object memory limit for the command. 0 = unlimited.",
+	"job_cpu_rate_percent":               "Job Object CPU rate limit for the command. 0 = unlimited.",
+	"history_db_path":                    "SQLite database recording every run.",
+	"run_history_gzip":                   "Append every run to winpsp-history.jsonl.gz (gzip-compressed JSON Lines) next to the config.",
+	"history_max_mb":                     "Size in MB after which winpsp-history.jsonl.gz is rotated.",
+	"config_check_interval_secs":         "How often a --config-url config is checked for changes.",
+	"config_reload_backoff":              "Retry a failed --reload with exponential backoff: initial_ms, max_ms, multiplier, max_attempts.",
+	"vss_quiesce":                        "Create a VSS snapshot before running the command.",
+	"vss_volume":                         "Volume to snapshot.",
+	"cleanup_patterns":                   "Glob patterns of files deleted after the command finishes.",
+	"cleanup_on_success_only":            "Skip cleanup when the command succeeded.",
+	"heartbeat_file":                     "File whose modification time is updated while the command runs, for external watchdogs. Deleted afterwards.",
+	"heartbeat_interval_secs":            "How often heartbeat_file is touched.",
+	"service_dependencies":               "Services WinPSP depends on, applied by --install when --depends-on is not given. Not used at runtime.",
+	"stop_grace_period_secs":             "How long a Stop request waits for a running shutdown command before the service exits.",
+	"startup_command":                    "Command run once in the background when the service has started, e.g. to register with a CMDB.",
+	"startup_timeout":                    "Timeout for startup_command in seconds (0 = no limit).",
+	"startup_command_required":           "Stop the service when startup_command fails.",
+	"periodic_command":                   "Command run every periodic_interval_secs while the service is running, logged to winpsp-periodic-<time>.log.",
+	"periodic_interval_secs":             "Interval for periodic_command in seconds. Default: 3600.",
+	"max_periodic_runs":                  "Maximum number of periodic_command runs per service start. 0 = unlimited.",
+	"on_start_message":                   "Message written to the Event Log (ID 10) when the service starts. Supports {{.Hostname}}, {{.Timestamp}}, {{.ConfigHash}}.",
+	"on_stop_message":                    "Message written to the Event Log (ID 11) when the service stops. Same template variables as on_start_message.",
+	"startup_delay_secs":                 "Seconds after service start before shutdown is handled.",
+	"pre_shutdown_notification_delay_ms": "Milliseconds to wait after the PRESHUTDOWN notification before running, so other services can finish shutting down.",
+	"mutex_wait_ms":                      "How long to wait for the global instance mutex.",
+	"mutex_action":                       "What to do when the mutex wait times out.",
+	"on_success_command":                 "Command run after the main command succeeded. Its result is only logged.",
+	"on_success_timeout":                 "Timeout for on_success_command in seconds.",
+	"pre_hook_command":                   "Command run before the main command, e.g. to acquire a token.",
+	"pre_hook_timeout":                   "Timeout for pre_hook_command in seconds (0 = no limit), separate from timeout.",
+	"pre_hook_required":                  "Skip the main command when pre_hook_command fails.",
+	"post_hook_command":                  "Command run after the main command, with its exit code in WINPSP_EXIT_CODE.",
+	"post_hook_timeout":                  "Timeout for post_hook_command in seconds (0 = no limit), separate from timeout.",
+	"idle_min_minutes":                   "Skip the command unless there was no keyboard or mouse input for this many minutes before shutdown. 0 = disabled.",
+	"min_run_interval_secs":              "Skip the command when the last run recorded in winpsp-last-run.json started less than this many seconds ago. 0 = disabled.",
+	"min_uptime_secs":                    "Skip the command when the system has been up for less than this many seconds. 0 = disabled.",
+	"fallback_command":                   "Command to run instead when a precondition fails.",
+	"require_free_disk_bytes":            "Minimum free bytes on require_free_disk_path.",
+	"require_free_disk_path":             "Path on the volume checked for free space.",
+	"require_network":                    "Require a TCP connection to require_network_host before running.",
+	"require_network_host":               "Host checked by require_network.",
+	"require_network_port":               "Port checked by require_network.",
+	"require_network_action":             "What to do when the network check fails.",
+	"skip_on_battery":                    "Skip the command on battery power.",
+	"skip_on_battery_below_percent":      "Skip the command on battery power below this charge.",
+	"statsd_address":                     "StatsD server host:port.",
+	"statsd_prefix":                      "Prefix for StatsD metric names.",
+	"tls_cert_file":                      "PEM client certificate for HTTPS requests.",
+	"tls_key_file":                       "PEM private key for tls_cert_file.",
+	"tls_ca_file":                        "PEM root CAs for HTTPS requests.",
+	"tls_insecure_skip_verify":           "Do not verify server certificates.",
+	"webhook_url":                        "URL that receives the run result as JSON.",
+	"webhook_token":                      "Bearer token for webhook_url.",
+	"webhook_token_credential_name":      "Read the webhook bearer token from this Windows Credential Manager entry (generic credential) instead of webhook_token.",
+	"webhook_timeout_secs":               "Webhook request timeout in seconds.",
+	"simulate_duration_ms":               "Duration of the simulated command in --simulate mode.",
+	"simulate_exit_code":                 "Exit code of the simulated command in --simulate mode.",
+	"validation_rules":                   "Regular expressions that field values must match.",
+	"profiles":                           "Partial configs merged over the top level when the hostname matches.",
+}
+
+var schemaEnums = map[string][]string{
+	"retry_delay_strategy":   {retryStrategyFixed, retryStrategyLinear, retryStrategyExponential},
+	"output_encoding":        {outputEncodingAuto, outputEncodingUTF8, outputEncodingUTF16LE, outputEncodingUTF16BE, outputEncodingCP1252},
+	"mutex_action":           {mutexActionProceed, mutexActionAbort},
+	"integrity_level":        {integrityLow, integrityMedium, integrityHigh, integritySystem},
+	"log_write_mode":         {logWriteBuffered, logWriteDirect},
+	"log_section_format":     {sectionFormatPlain, sectionFormatJSON},
+	"ps_execution_policy":    psExecutionPolicies,
+	"require_network_action": {networkActionSkip, networkActionFallback},
+}
+
+// configSchema 由 Config 结构体生成 JSON Schema (draft-07)
+func configSchema() map[string]any {
+	props := map[string]any{}
+	for _, f := range reflect.VisibleFields(reflect.TypeOf(Config{})) {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if f.Anonymous || !f.IsExported() || name == "" || name == "-" {
+			continue
+		}
+
+		p := schemaType(f.Type)
+		if d, ok := schemaDescriptions[name]; ok {
+			p["description"] = d
+		}
+		if e, ok := schemaEnums[name]; ok {
+			p["enum"] = e
+		}
+		props[name] = p
+	}
+
+	// 允许配置文件用 "$schema" 指向本 schema，方便编辑器识别
+	props["$schema"] = map[string]any{"type": "string"}
+
+	// profile 是部分配置，结构与顶层相同
+	props["profiles"] = map[string]any{
+		"type":                 "object",
+		"description":          schemaDescriptions["profiles"],
+		"additionalProperties": map[string]any{"$ref": "#"},
+	}
+
+	return map[string]any{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"title":                "WinPSP config",
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+}
+
+func schemaType(t reflect.Type) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaType(t.Elem())}
+	case reflect.Struct:
+		props := map[string]any{}
+		for _, f := range reflect.VisibleFields(t) {
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.Anonymous && name != "" && name != "-" {
+				p := schemaType(f.Type)
+				if d, ok := schemaDescriptions[name]; ok {
+					p["description"] = d
+				}
+				props[name] = p
+			}
+		}
+		return map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaType(t.Elem())}
+	default:
+		return map[string]any{}
+	}
+}
+
+func printConfigSchema() error {
+	data, err := json.MarshalIndent(configSchema(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(append(data, '\n'))
+	return err
+}
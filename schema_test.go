@@ -0,0 +1,232 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// printedSchema 返回 --config-schema 的输出解析后的结果
+func printedSchema(t *testing.T) map[string]any {
+	t.Helper()
+	var err error
+	out := captureStdout(t, func() { err = printConfigSchema() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal([]byte(out), &schema); err != nil {
+		t.Fatalf("--config-schema output is not valid JSON: %v", err)
+	}
+	return schema
+}
+
+// schemaKeywords 是 configSchema 用到的 draft-07 关键字
+var schemaKeywords = []string{"$schema", "$ref", "title", "description", "type", "enum", "minimum",
+	"properties", "additionalProperties", "items"}
+
+// checkSchema 检查 s 是结构正确的 draft-07 schema，返回发现的问题
+func checkSchema(s map[string]any, path string) []string {
+	var problems []string
+	for k, v := range s {
+		if !slices.Contains(schemaKeywords, k) {
+			problems = append(problems, fmt.Sprintf("%s: unknown keyword %q", path, k))
+			continue
+		}
+		switch k {
+		case "type":
+			if !slices.Contains([]string{"string", "boolean", "integer", "number", "array", "object"}, fmt.Sprint(v)) {
+				problems = append(problems, fmt.Sprintf("%s: invalid type %v", path, v))
+			}
+		case "$ref":
+			if v != "#" {
+				problems = append(problems, fmt.Sprintf("%s: unresolvable $ref %v", path, v))
+			}
+		case "enum":
+			if e, ok := v.([]any); !ok || len(e) == 0 {
+				problems = append(problems, fmt.Sprintf("%s: enum must be a non-empty array", path))
+			}
+		case "properties":
+			props, ok := v.(map[string]any)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: properties must be an object", path))
+				continue
+			}
+			for name, p := range props {
+				sub, ok := p.(map[string]any)
+				if !ok {
+					problems = append(problems, fmt.Sprintf("%s.%s: not a schema", path, name))
+					continue
+				}
+				problems = append(problems, checkSchema(sub, path+"."+name)...)
+			}
+		case "items", "additionalProperties":
+			switch sub := v.(type) {
+			case bool:
+			case map[string]any:
+				problems = append(problems, checkSchema(sub, path+"/"+k)...)
+			default:
+				problems = append(problems, fmt.Sprintf("%s: %s must be a schema or a boolean", path, k))
+			}
+		}
+	}
+	return problems
+}
+
+// validate 按 schema 校验 JSON 值 v（draft-07，只实现 configSchema 用到的关键字），返回第一个错误
+func validate(root, s map[string]any, v any, path string) error {
+	if s["$ref"] == "#" {
+		s = root
+	}
+	switch s["type"] {
+	case "string":
+		if _, ok := v.(string); !ok {
+			return fmt.Errorf("%s: %v is not a string", path, v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: %v is not a boolean", path, v)
+		}
+	case "integer", "number":
+		n, ok := v.(float64)
+		if !ok || (s["type"] == "integer" && n != math.Trunc(n)) {
+			return fmt.Errorf("%s: %v is not of type %s", path, v, s["type"])
+		}
+		if lo, ok := s["minimum"].(float64); ok && n < lo {
+			return fmt.Errorf("%s: %v is less than %v", path, n, lo)
+		}
+	case "array":
+		a, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s: %v is not an array", path, v)
+		}
+		if items, ok := s["items"].(map[string]any); ok {
+			for i, e := range a {
+				if err := validate(root, items, e, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case "object":
+		o, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: %v is not an object", path, v)
+		}
+		props, _ := s["properties"].(map[string]any)
+		for k, e := range o {
+			if p, ok := props[k].(map[string]any); ok {
+				if err := validate(root, p, e, path+"."+k); err != nil {
+					return err
+				}
+				continue
+			}
+			switch ap := s["additionalProperties"].(type) {
+			case bool:
+				if !ap {
+					return fmt.Errorf("%s: unknown property %q", path, k)
+				}
+			case map[string]any:
+				if err := validate(root, ap, e, path+"."+k); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if enum, ok := s["enum"].([]any); ok && !slices.Contains(enum, v) {
+		return fmt.Errorf("%s: %v is not one of %v", path, v, enum)
+	}
+	return nil
+}
+
+func TestConfigSchemaIsValid(t *testing.T) {
+	schema := printedSchema(t)
+	if schema["$schema"] != "http://json-schema.org/draft-07/schema#" || schema["type"] != "object" {
+		t.Errorf("schema header = %v, %v", schema["$schema"], schema["type"])
+	}
+	problems := checkSchema(schema, "#")
+	sort.Strings(problems)
+	for _, p := range problems {
+		t.Error(p)
+	}
+}
+
+// 每个配置字段都要有说明，新增字段时不要忘了 schemaDescriptions
+func TestConfigSchemaDescriptions(t *testing.T) {
+	props := printedSchema(t)["properties"].(map[string]any)
+	for name, p := range props {
+		if d, _ := p.(map[string]any)["description"].(string); d == "" && name != "$schema" {
+			t.Errorf("%s has no description", name)
+		}
+	}
+	for _, name := range []string{"command", "timeout", "commands", "profiles", "config_reload_backoff"} {
+		if props[name] == nil {
+			t.Errorf("schema lacks %s", name)
+		}
+	}
+}
+
+func TestConfigSchemaValidates(t *testing.T) {
+	schema := printedSchema(t)
+	valid := []string{
+		`{"command": "C:\\Tools\\backup.exe"}`,
+		`{
+  "$schema": "https://example.com/winpsp.schema.json",
+  "command": "C:\\Tools\\backup.exe",
+  "timeout": 300,
+  "log_count": 5,
+  "retry_delay_strategy": "exponential",
+  "output_encoding": "utf16le",
+  "success_exit_codes": [0, 1],
+  "env": {"BACKUP_TARGET": "E:\\"},
+  "require_free_disk_bytes": 1073741824,
+  "require_free_disk_path": "E:\\",
+  "config_reload_backoff": {"initial_ms": 100, "multiplier": 1.5},
+  "commands": [{"name": "flush", "command": "flush.exe", "timeout": 30}],
+  "profiles": {"db01": {"timeout": 600, "output_encoding": "cp1252"}}
+}`,
+	}
+	for _, data := range valid {
+		var v any
+		if err := json.Unmarshal([]byte(data), &v); err != nil {
+			t.Fatal(err)
+		}
+		if err := validate(schema, schema, v, "#"); err != nil {
+			t.Errorf("valid config rejected: %v\n%s", err, data)
+		}
+		// schema 接受的配置 WinPSP 也必须能加载
+		if err := writeConfig(t, data).loadConfig(); err != nil {
+			t.Errorf("config accepted by the schema does not load: %v\n%s", err, data)
+		}
+	}
+
+	invalid := []struct {
+		data, wantErr string
+	}{
+		{`{"comand": "backup.exe"}`, `unknown property "comand"`},
+		{`{"timeout": "300"}`, "#.timeout: 300 is not of type integer"},
+		{`{"timeout": 1.5}`, "#.timeout: 1.5 is not of type integer"},
+		{`{"retry_delay_strategy": "random"}`, "#.retry_delay_strategy: random is not one of"},
+		{`{"require_free_disk_bytes": -1}`, "#.require_free_disk_bytes: -1 is less than 0"},
+		{`{"success_exit_codes": [0, "1"]}`, "#.success_exit_codes[1]"},
+		{`{"env": {"A": 1}}`, "#.env.A: 1 is not a string"},
+		{`{"commands": [{"command": "a.exe", "timout": 5}]}`, `#.commands[0]: unknown property "timout"`},
+		{`{"config_reload_backoff": {"multiplier": "2"}}`, "#.config_reload_backoff.multiplier: 2 is not of type number"},
+		{`{"profiles": {"db01": {"comand": "x"}}}`, `#.profiles.db01: unknown property "comand"`},
+	}
+	for _, tt := range invalid {
+		var v any
+		if err := json.Unmarshal([]byte(tt.data), &v); err != nil {
+			t.Fatal(err)
+		}
+		err := validate(schema, schema, v, "#")
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: validate = %v, want %q", tt.data, err, tt.wantErr)
+		}
+	}
+}
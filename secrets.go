@@ -0,0 +1,229 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	secretRefPrefix = "secret:"
+
+	secretsBackendEnv   = "env"
+	secretsBackendFile  = "file:"
+	secretsBackendVault = "vault:"
+
+	vaultHTTPTimeout = 10 * time.Second
+)
+
+// -------------------- 外部密钥 --------------------
+
+// secrets_backend 设置后，配置中值为 "secret:KEY" 的字符串在加载时替换为密钥的值：
+//   env            WinPSP 进程的环境变量 KEY
+//   file:<path>    KEY=VALUE 文件（格式同 env_file，相对路径以配置文件目录为基准）
+//   vault:<url>    HashiCorp Vault KV 密钥（GET url，X-Vault-Token 为 vault_token），
+//                  KEY 是其中的字段名；KV v1 和 v2 的响应格式都支持
+// secrets_backend 为空时不做替换，"secret:" 开头的值原样使用。
+
+// secretLookup 按键名取密钥的值
+type secretLookup func(key string) (string, error)
+
+// 各后端读取密钥，可在调试时替换
+var (
+	lookupEnvSecret   = os.LookupEnv
+	loadSecretsFile   = loadEnvFile
+	fetchVaultSecrets = vaultGet
+)
+
+// resolveSecrets 把 cfg 中所有 "secret:KEY" 字符串替换为密钥的值。
+// 被替换的字段记录在 cfg.secretRefs 中，导出配置时恢复为引用原文。
+func resolveSecrets(cfg *Config, configPath string) error {
+	if cfg.SecretsBackend == "" {
+		return nil
+	}
+	lookup, err := newSecretLookup(cfg, configPath)
+	if err != nil {
+		return err
+	}
+
+	return walkConfigStrings(reflect.ValueOf(cfg).Elem(), func(set func(string), value string) error {
+		key, ok := strings.CutPrefix(value, secretRefPrefix)
+		if !ok {
+			return nil
+		}
+		secret, err := lookup(key)
+		if err != nil {
+			return fmt.Errorf("%s%s: %w", secretRefPrefix, key, err)
+		}
+		set(secret)
+		cfg.secretRefs = append(cfg.secretRefs, func() { set(value) })
+		return nil
+	})
+}
+
+// restoreSecretRefs 把 resolveSecrets 替换过的字段恢复为 "secret:KEY"
+func (cfg *Config) restoreSecretRefs() {
+	for _, restore := range cfg.secretRefs {
+		restore()
+	}
+	cfg.secretRefs = nil
+}
+
+func newSecretLookup(cfg *Config, configPath string) (secretLookup, error) {
+	backend := cfg.SecretsBackend
+	switch {
+	case backend == secretsBackendEnv:
+		return func(key string) (string, error) {
+			if v, ok := lookupEnvSecret(key); ok {
+				return v, nil
+			}
+			return "", fmt.Errorf("environment variable %s is not set", key)
+		}, nil
+
+	case strings.HasPrefix(backend, secretsBackendFile):
+		path := strings.TrimPrefix(backend, secretsBackendFile)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(configPath), path)
+		}
+		vars, err := loadSecretsFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("secrets_backend: %w", err)
+		}
+		return mapLookup(vars, "secrets file"), nil
+
+	case strings.HasPrefix(backend, secretsBackendVault):
+		vars, err := fetchVaultSecrets(cfg, strings.TrimPrefix(backend, secretsBackendVault))
+		if err != nil {
+			return nil, fmt.Errorf("secrets_backend: %w", err)
+		}
+		return mapLookup(vars, "vault secret"), nil
+	}
+	return nil, fmt.Errorf("invalid secrets_backend: %q", backend)
+}
+
+func mapLookup(vars map[string]string, source string) secretLookup {
+	return func(key string) (string, error) {
+		if v, ok := vars[key]; ok {
+			return v, nil
+		}
+		return "", fmt.Errorf("not found in %s", source)
+	}
+}
+
+// vaultGet 读取一个 Vault KV 密钥的全部字段（只取字符串值）
+func vaultGet(cfg *Config, url string) (map[string]string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.VaultToken != "" {
+		req.Header.Set("X-Vault-Token", cfg.VaultToken)
+	}
+
+	client, err := newHTTPClient(cfg, vaultHTTPTimeout)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault: %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	// KV v1：{"data": {...}}；KV v2：{"data": {"data": {...}, "metadata": {...}}}
+	var doc struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+	fields := doc.Data
+	if inner, ok := fields["data"]; ok {
+		if _, isV2 := fields["metadata"]; isV2 {
+			fields = nil
+			if err := json.Unmarshal(inner, &fields); err != nil {
+				return nil, fmt.Errorf("vault: %w", err)
+			}
+		}
+	}
+
+	vars := make(map[string]string, len(fields))
+	for k, raw := range fields {
+		var v string
+		if json.Unmarshal(raw, &v) == nil {
+			vars[k] = v
+		}
+	}
+	return vars, nil
+}
+
+// walkConfigStrings 对 v 中所有可写的字符串调用 fn（包括切片元素和 map 的值），
+// 跳过未导出字段以及 secrets 后端自身的设置
+func walkConfigStrings(v reflect.Value, fn func(set func(string), value string) error) error {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			return walkConfigStrings(v.Elem(), fn)
+		}
+
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			switch f.Tag.Get("json") {
+			case "secrets_backend", "vault_token":
+				continue
+			}
+			if err := walkConfigStrings(v.Field(i), fn); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Slice:
+		switch v.Type().Elem().Kind() {
+		case reflect.String, reflect.Struct, reflect.Pointer:
+			for i := 0; i < v.Len(); i++ {
+				if err := walkConfigStrings(v.Index(i), fn); err != nil {
+					return err
+				}
+			}
+		}
+
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		for _, k := range v.MapKeys() {
+			m, k := v, k
+			set := func(s string) { m.SetMapIndex(k, reflect.ValueOf(s).Convert(m.Type().Elem())) }
+			if err := fn(set, v.MapIndex(k).String()); err != nil {
+				return err
+			}
+		}
+
+	case reflect.String:
+		if v.CanSet() {
+			return fn(v.SetString, v.String())
+		}
+	}
+	return nil
+}
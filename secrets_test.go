@@ -0,0 +1,188 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// secretBackends 是模拟的 env / file / vault 后端，记录读取的文件和 URL
+type secretBackends struct {
+	env, file, vault  map[string]string
+	fileErr, vaultErr error
+	filePaths         []string
+	vaultURLs         []string
+}
+
+func mockSecretBackends(t *testing.T) *secretBackends {
+	t.Helper()
+	b := &secretBackends{
+		env:   map[string]string{"BACKUP_CMD": `C:\Tools\backup.exe /env`, "TOKEN": "env-token"},
+		file:  map[string]string{"BACKUP_CMD": `C:\Tools\backup.exe /file`, "TOKEN": "file-token"},
+		vault: map[string]string{"BACKUP_CMD": `C:\Tools\backup.exe /vault`, "TOKEN": "vault-token"},
+	}
+	origEnv, origFile, origVault := lookupEnvSecret, loadSecretsFile, fetchVaultSecrets
+	lookupEnvSecret = func(key string) (string, bool) {
+		v, ok := b.env[key]
+		return v, ok
+	}
+	loadSecretsFile = func(path string) (map[string]string, error) {
+		b.filePaths = append(b.filePaths, path)
+		return b.file, b.fileErr
+	}
+	fetchVaultSecrets = func(cfg *Config, url string) (map[string]string, error) {
+		b.vaultURLs = append(b.vaultURLs, url)
+		return b.vault, b.vaultErr
+	}
+	t.Cleanup(func() { lookupEnvSecret, loadSecretsFile, fetchVaultSecrets = origEnv, origFile, origVault })
+	return b
+}
+
+func TestResolveSecrets(t *testing.T) {
+	tests := []struct {
+		name         string
+		backend      string
+		fileErr      error
+		vaultErr     error
+		wantSuffix   string // command 解析后的结尾，空表示应当出错
+		wantToken    string
+		wantFilePath string
+		wantVaultURL string
+	}{
+		{"no backend", "", nil, nil, "secret:BACKUP_CMD", "secret:TOKEN", "", ""},
+		{"env", "env", nil, nil, "/env", "env-token", "", ""},
+		{"file, relative path", "file:secrets.env", nil, nil, "/file", "file-token", "secrets.env", ""},
+		{"file, absolute path", `file:D:\secrets\winpsp.env`, nil, nil, "/file", "file-token", `D:\secrets\winpsp.env`, ""},
+		{"file missing", "file:secrets.env", errors.New("The system cannot find the file specified."), nil, "", "", "secrets.env", ""},
+		{"vault", "vault:https://vault.example.com/v1/secret/data/winpsp", nil, nil, "/vault", "vault-token", "", "https://vault.example.com/v1/secret/data/winpsp"},
+		{"vault unreachable", "vault:https://vault.example.com/v1/secret/winpsp", nil, errors.New("connection refused"), "", "", "", "https://vault.example.com/v1/secret/winpsp"},
+		{"unknown backend", "keyvault:winpsp", nil, nil, "", "", "", ""},
+	}
+	const configPath = `C:\ProgramData\WinPSP\winpsp.json`
+	for _, tt := range tests {
+		b := mockSecretBackends(t)
+		b.fileErr, b.vaultErr = tt.fileErr, tt.vaultErr
+		cfg := &Config{
+			SecretsBackend: tt.backend,
+			WebhookToken:   "secret:TOKEN",
+			VaultToken:     "secret:TOKEN", // 后端自身的设置不替换
+			Commands:       []CommandEntry{{Name: "upload", Command: "secret:BACKUP_CMD"}},
+			Env:            map[string]string{"API_TOKEN": "secret:TOKEN", "PLAIN": "value"},
+			CmdExtraArgs:   []string{"/V:ON", "secret:TOKEN"},
+		}
+		cfg.Command = "secret:BACKUP_CMD"
+
+		err := resolveSecrets(cfg, configPath)
+		if tt.wantSuffix == "" {
+			if err == nil {
+				t.Errorf("%s: resolveSecrets succeeded", tt.name)
+			}
+		} else if err != nil {
+			t.Errorf("%s: resolveSecrets: %v", tt.name, err)
+		} else {
+			if !strings.HasSuffix(cfg.Command, tt.wantSuffix) || !strings.HasSuffix(cfg.Commands[0].Command, tt.wantSuffix) {
+				t.Errorf("%s: command %q, commands[0] %q, want ...%s", tt.name, cfg.Command, cfg.Commands[0].Command, tt.wantSuffix)
+			}
+			if cfg.WebhookToken != tt.wantToken || cfg.Env["API_TOKEN"] != tt.wantToken || cfg.CmdExtraArgs[1] != tt.wantToken {
+				t.Errorf("%s: webhook_token %q, env %q, cmd_extra_args %q, want %q",
+					tt.name, cfg.WebhookToken, cfg.Env["API_TOKEN"], cfg.CmdExtraArgs, tt.wantToken)
+			}
+			if cfg.VaultToken != "secret:TOKEN" || cfg.Env["PLAIN"] != "value" || cfg.CmdExtraArgs[0] != "/V:ON" {
+				t.Errorf("%s: values without a reference changed: %+v", tt.name, cfg)
+			}
+		}
+
+		wantPath := tt.wantFilePath
+		if wantPath != "" && !filepath.IsAbs(wantPath) {
+			wantPath = filepath.Join(filepath.Dir(configPath), wantPath)
+		}
+		if got := strings.Join(b.filePaths, " "); got != wantPath {
+			t.Errorf("%s: secrets file read %q, want %q", tt.name, got, wantPath)
+		}
+		if got := strings.Join(b.vaultURLs, " "); got != tt.wantVaultURL {
+			t.Errorf("%s: vault fetched %q, want %q", tt.name, got, tt.wantVaultURL)
+		}
+	}
+}
+
+func TestResolveSecretsMissingKey(t *testing.T) {
+	for _, backend := range []string{"env", "file:secrets.env", "vault:https://vault.example.com/v1/secret/winpsp"} {
+		mockSecretBackends(t)
+		cfg := &Config{SecretsBackend: backend}
+		cfg.Command = "secret:NO_SUCH_KEY"
+		err := resolveSecrets(cfg, `C:\ProgramData\WinPSP\winpsp.json`)
+		if err == nil || !strings.Contains(err.Error(), "secret:NO_SUCH_KEY") {
+			t.Errorf("%s: resolveSecrets error = %v, want one naming the key", backend, err)
+		}
+	}
+}
+
+func TestRestoreSecretRefs(t *testing.T) {
+	mockSecretBackends(t)
+	cfg := &Config{SecretsBackend: "env", Env: map[string]string{"API_TOKEN": "secret:TOKEN"}}
+	cfg.Command = "secret:BACKUP_CMD"
+	if err := resolveSecrets(cfg, `C:\ProgramData\WinPSP\winpsp.json`); err != nil {
+		t.Fatal(err)
+	}
+	cfg.restoreSecretRefs()
+	if cfg.Command != "secret:BACKUP_CMD" || cfg.Env["API_TOKEN"] != "secret:TOKEN" {
+		t.Errorf("after restoreSecretRefs: command %q, env %v", cfg.Command, cfg.Env)
+	}
+}
+
+func TestVaultGet(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"KV v1", http.StatusOK, `{"data": {"TOKEN": "v1-token", "PORT": 8200}}`, map[string]string{"TOKEN": "v1-token"}, false},
+		{"KV v2", http.StatusOK, `{"data": {"data": {"TOKEN": "v2-token"}, "metadata": {"version": 3}}}`, map[string]string{"TOKEN": "v2-token"}, false},
+		{"KV v1 field named data", http.StatusOK, `{"data": {"data": "plain"}}`, map[string]string{"data": "plain"}, false},
+		{"forbidden", http.StatusForbidden, `{"errors": ["permission denied"]}`, nil, true},
+		{"not JSON", http.StatusOK, `<html>`, nil, true},
+	}
+	for _, tt := range tests {
+		var gotToken string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotToken = r.Header.Get("X-Vault-Token")
+			w.WriteHeader(tt.status)
+			w.Write([]byte(tt.body))
+		}))
+		got, err := vaultGet(&Config{VaultToken: "hvs.test"}, srv.URL+"/v1/secret/winpsp")
+		srv.Close()
+
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: vaultGet error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if gotToken != "hvs.test" {
+			t.Errorf("%s: X-Vault-Token %q", tt.name, gotToken)
+		}
+		if !tt.wantErr && (len(got) != len(tt.want) || got["TOKEN"] != tt.want["TOKEN"] || got["data"] != tt.want["data"]) {
+			t.Errorf("%s: vaultGet = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigSecrets(t *testing.T) {
+	b := mockSecretBackends(t)
+	b.env["BACKUP_CMD"] = "cmd.exe /c echo from env"
+	s := loadedService(t, `{"command": "secret:BACKUP_CMD", "secrets_backend": "env"}`)
+	if cfg := s.config.Load(); cfg.Command != "cmd.exe /c echo from env" {
+		t.Errorf("command after loading = %q", cfg.Command)
+	}
+
+	// 导出时恢复为引用
+	m := exportedConfig(t, s.configPath)
+	if m["command"] != "secret:BACKUP_CMD" {
+		t.Errorf("exported command %v, want the secret reference", m["command"])
+	}
+}
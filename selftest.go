@@ -0,0 +1,85 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// -------------------- 自检 --------------------
+
+const (
+	selfTestCommand = "cmd.exe /c echo WinPSP self-test OK"
+	selfTestMarker  = "WinPSP self-test OK"
+	selfTestTimeout = 30 * time.Second
+)
+
+// runSelfTest 实现 --self-test：在日志目录写一个临时日志，执行内置的
+// echo 命令，检查输出确实进了日志，然后删除临时日志。
+// 不读取也不修改配置文件，只用到配置文件所在的目录。
+func runSelfTest(configPath string) bool {
+	fmt.Println(versionString())
+	dir := filepath.Dir(configPath)
+	fmt.Printf("Log directory: %s\n", dir)
+
+	logPath := filepath.Join(dir, fmt.Sprintf("winpsp-selftest-%d.log", os.Getpid()))
+	var f *os.File
+	ok := selfTestStep("create log file", func() (err error) {
+		if err = os.MkdirAll(dir, 0755); err == nil {
+			f, err = os.Create(logPath)
+		}
+		return err
+	})
+	if !ok {
+		return false
+	}
+	defer os.Remove(logPath)
+
+	ok = selfTestStep("run "+selfTestCommand, func() error {
+		fmt.Fprintf(f, "[%s] Running: %s\n", time.Now().Format(defaultLogTimestampFormat), selfTestCommand)
+		exitCode, timedOut, err := runCommandWithTimeout(selfTestCommand, selfTestTimeout, execOptions{
+			Output:          f,
+			TimestampFormat: defaultLogTimestampFormat,
+		})
+		switch {
+		case timedOut:
+			return fmt.Errorf("timed out after %s", selfTestTimeout)
+		case err != nil && !isExitError(err):
+			return err
+		case exitCode != 0:
+			return fmt.Errorf("exit code %d", exitCode)
+		}
+		return nil
+	})
+	if cerr := f.Close(); ok && cerr != nil {
+		selfTestStep("write log file", func() error { return cerr })
+		ok = false
+	}
+	if !ok {
+		return false
+	}
+
+	return selfTestStep("check log output", func() error {
+		data, err := os.ReadFile(logPath)
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(string(data), selfTestMarker) {
+			return fmt.Errorf("%q not found in %s", selfTestMarker, logPath)
+		}
+		return nil
+	})
+}
+
+func selfTestStep(name string, fn func() error) bool {
+	if err := fn(); err != nil {
+		fmt.Printf("  FAIL  %s: %v\n", name, err)
+		return false
+	}
+	fmt.Printf("  OK    %s\n", name)
+	return true
+}
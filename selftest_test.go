@@ -0,0 +1,69 @@
+//go:build windows
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// denyWrites 让 dir 只能读：受保护的 DACL 只允许读取和遍历，测试结束时恢复
+func denyWrites(t *testing.T, dir string) {
+	t.Helper()
+	set := func(sddl string) error {
+		sd, info, err := sddlSecurityInfo(sddl)
+		if err != nil {
+			return err
+		}
+		return setDirSecurity(dir, info, sd)
+	}
+	if err := set("D:P(A;OICI;GRGX;;;WD)"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { set("D:P(A;OICI;GA;;;WD)") })
+}
+
+func TestRunSelfTest(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(t *testing.T, dir string) string // 返回配置文件路径
+		wantPass bool
+		wantFail string
+	}{
+		{"clean directory", func(t *testing.T, dir string) string {
+			return filepath.Join(dir, "winpsp.json")
+		}, true, ""},
+		{"missing directory is created", func(t *testing.T, dir string) string {
+			return filepath.Join(dir, "WinPSP", "winpsp.json")
+		}, true, ""},
+		{"read-only directory", func(t *testing.T, dir string) string {
+			denyWrites(t, dir)
+			return filepath.Join(dir, "winpsp.json")
+		}, false, "FAIL  create log file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			configPath := tt.setup(t, dir)
+
+			var pass bool
+			out := captureStdout(t, func() { pass = runSelfTest(configPath) })
+			if pass != tt.wantPass {
+				t.Fatalf("runSelfTest = %v, want %v:\n%s", pass, tt.wantPass, out)
+			}
+			if tt.wantPass && !strings.Contains(out, "OK    check log output") {
+				t.Errorf("output does not report the log check:\n%s", out)
+			}
+			if tt.wantFail != "" && !strings.Contains(out, tt.wantFail) {
+				t.Errorf("output does not contain %q:\n%s", tt.wantFail, out)
+			}
+
+			// 临时日志已删除，也没有创建配置文件
+			left, _ := filepath.Glob(filepath.Join(filepath.Dir(configPath), "*"))
+			if len(left) != 0 {
+				t.Errorf("files left behind: %q", left)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+//go:build windows
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"debug/pe"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const (
+	updateHTTPTimeout = 5 * time.Minute
+	checksumsAsset    = "SHA256SUMS"
+)
+
+// -------------------- --self-update --------------------
+
+// --self-update 从 GitHub Releases 下载最新（或指定）版本的 winpsp-<架构>.exe，
+// 用发布中的 SHA256 校验和核对、确认是本机架构的 PE 文件之后替换当前的可执行文件。
+// 新文件先下载到同一目录的临时文件，再通过两次重命名替换，旧版本保留为 .old。
+// 服务正在运行时需要先停止，或者用 --restart-service 由 WinPSP 停止、更新后再启动。
+
+// 更新用到的外部资源，可在调试时替换
+var (
+	releasesAPI      = "https://api.github.com/repos/PtrBreak/WinPSP/releases"
+	updateHTTPClient = &http.Client{Timeout: updateHTTPTimeout}
+	currentExe       = os.Executable
+)
+
+type githubRelease struct {
+	TagName string        `json:"tag_name"`
+	Assets  []githubAsset `json:"assets"`
+}
+
+type githubAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// releaseAssetName 返回本机架构对应的发布文件名，与 Makefile 一致
+func releaseAssetName() (string, uint16, error) {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "winpsp-x64.exe", pe.IMAGE_FILE_MACHINE_AMD64, nil
+	case "386":
+		return "winpsp-x86.exe", pe.IMAGE_FILE_MACHINE_I386, nil
+	case "arm64":
+		return "winpsp-arm64.exe", pe.IMAGE_FILE_MACHINE_ARM64, nil
+	}
+	return "", 0, fmt.Errorf("no release binary for %s", runtime.GOARCH)
+}
+
+// selfUpdate 实现 --self-update。tag 为空时更新到最新版本。
+func selfUpdate(tag, name string, restart bool) error {
+	assetName, machine, err := releaseAssetName()
+	if err != nil {
+		return err
+	}
+
+	rel, err := fetchRelease(tag)
+	if err != nil {
+		return err
+	}
+	if tag == "" && strings.TrimPrefix(rel.TagName, "v") == strings.TrimPrefix(version, "v") {
+		fmt.Printf("WinPSP %s is already the latest version.\n", version)
+		return nil
+	}
+	asset := rel.asset(assetName)
+	if asset == nil {
+		return fmt.Errorf("release %s has no %s", rel.TagName, assetName)
+	}
+	want, err := rel.checksum(assetName)
+	if err != nil {
+		return err
+	}
+
+	exe, err := currentExe()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+
+	// 先下载并校验，服务只在真正替换时停止
+	fmt.Printf("Downloading %s %s...\n", assetName, rel.TagName)
+	tmp, err := downloadVerified(asset.URL, filepath.Dir(exe), want, machine)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	running, err := serviceRunning(name)
+	if err != nil {
+		return err
+	}
+	if running {
+		if !restart {
+			return fmt.Errorf("service %s is running; stop it first or use --restart-service", name)
+		}
+		fmt.Printf("Stopping service %s...\n", name)
+		if err := stopService(name); err != nil {
+			return err
+		}
+	}
+
+	if err := replaceExecutable(exe, tmp); err != nil {
+		return err
+	}
+	fmt.Printf("Updated %s to %s (previous version kept as %s.old).\n", exe, rel.TagName, filepath.Base(exe))
+
+	if running {
+		fmt.Printf("Starting service %s...\n", name)
+		return startService(name)
+	}
+	return nil
+}
+
+// fetchRelease 查询 GitHub Releases API，tag 为空时取最新的正式版本
+func fetchRelease(tag string) (*githubRelease, error) {
+	url := releasesAPI + "/latest"
+	if tag != "" {
+		url = releasesAPI + "/tags/" + tag
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", "WinPSP/"+version)
+
+	resp, err := updateHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && tag != "" {
+		return nil, fmt.Errorf("release %s not found", tag)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GitHub API: %s", resp.Status)
+	}
+
+	var rel githubRelease
+	if err := json.NewDecoder(resp.Body).Decode(&rel); err != nil {
+		return nil, fmt.Errorf("GitHub API: %w", err)
+	}
+	return &rel, nil
+}
+
+func (rel *githubRelease) asset(name string) *githubAsset {
+	for i := range rel.Assets {
+		if rel.Assets[i].Name == name {
+			return &rel.Assets[i]
+		}
+	}
+	return nil
+}
+
+// checksum 从发布的校验和文件中读出 name 的 SHA-256：优先 <name>.sha256，
+// 其次 SHA256SUMS（sha256sum 的输出格式："<hex>  <文件名>"）
+func (rel *githubRelease) checksum(name string) ([]byte, error) {
+	a := rel.asset(name + ".sha256")
+	if a == nil {
+		a = rel.asset(checksumsAsset)
+	}
+	if a == nil {
+		return nil, fmt.Errorf("release %s has no checksum file (%s.sha256 or %s)", rel.TagName, name, checksumsAsset)
+	}
+
+	resp, err := updateHTTPClient.Get(a.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download %s: %s", a.Name, resp.Status)
+	}
+
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		// <name>.sha256 可能只有一个十六进制值
+		if len(fields) == 0 || (len(fields) > 1 && strings.TrimPrefix(fields[1], "*") != name) {
+			continue
+		}
+		sum, err := hex.DecodeString(fields[0])
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("%s: invalid checksum for %s", a.Name, name)
+		}
+		return sum, nil
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%s has no checksum for %s", a.Name, name)
+}
+
+// downloadVerified 把 url 下载到 dir 中的临时文件，核对 SHA-256 和 PE 头，返回临时文件路径
+func downloadVerified(url, dir string, want []byte, machine uint16) (string, error) {
+	resp, err := updateHTTPClient.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download: %s", resp.Status)
+	}
+
+	f, err := os.CreateTemp(dir, "winpsp-update-*.exe")
+	if err != nil {
+		return "", err
+	}
+	path := f.Name()
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && !bytes.Equal(h.Sum(nil), want) {
+		err = fmt.Errorf("checksum mismatch: got %x, want %x", h.Sum(nil), want)
+	}
+	if err == nil {
+		err = checkPE(path, machine)
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// checkPE 确认文件是本机架构的 PE 可执行文件
+func checkPE(path string, machine uint16) error {
+	f, err := pe.Open(path)
+	if err != nil {
+		return fmt.Errorf("downloaded file is not a valid executable: %w", err)
+	}
+	defer f.Close()
+	if f.Machine != machine {
+		return fmt.Errorf("downloaded executable is for machine type 0x%X, want 0x%X", f.Machine, machine)
+	}
+	if f.Characteristics&pe.IMAGE_FILE_EXECUTABLE_IMAGE == 0 {
+		return errors.New("downloaded file is not an executable image")
+	}
+	return nil
+}
+
+// replaceExecutable 把 exe 改名为 exe.old，再把 newPath 改名为 exe，失败时恢复原文件
+func replaceExecutable(exe, newPath string) error {
+	old := exe + ".old"
+	if err := os.Remove(old); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Rename(exe, old); err != nil {
+		return err
+	}
+	if err := os.Rename(newPath, exe); err != nil {
+		if rerr := os.Rename(old, exe); rerr != nil {
+			return fmt.Errorf("%w (and restoring %s failed: %v)", err, exe, rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// serviceRunning 判断服务是否在运行，服务未安装时返回 false。可在调试时替换
+var serviceRunning = func(name string) (bool, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return false, err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		if errors.Is(err, windows.ERROR_SERVICE_DOES_NOT_EXIST) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer s.Close()
+
+	st, err := s.Query()
+	if err != nil {
+		return false, err
+	}
+	return st.State != svc.Stopped, nil
+}
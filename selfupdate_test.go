@@ -0,0 +1,243 @@
+//go:build windows
+
+package main
+
+import (
+	"crypto/sha256"
+	"debug/pe"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mockReleases 用 httptest 服务器代替 GitHub：/releases/... 返回 releases 中的发布，
+// 其他路径返回 files 中的文件
+func mockReleases(t *testing.T, releases map[string]githubRelease, files map[string][]byte) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rel, ok := releases[r.URL.Path]; ok {
+			json.NewEncoder(w).Encode(rel)
+			return
+		}
+		if data, ok := files[r.URL.Path]; ok {
+			w.Write(data)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	origAPI := releasesAPI
+	releasesAPI = srv.URL + "/releases"
+	t.Cleanup(func() { releasesAPI = origAPI })
+	return srv
+}
+
+func TestReleaseChecksum(t *testing.T) {
+	const name = "winpsp-x64.exe"
+	sum := strings.Repeat("ab", sha256.Size)
+	tests := []struct {
+		name    string
+		file    string // 校验和文件名
+		content string
+		want    string
+	}{
+		{"single value", name + ".sha256", sum + "\n", sum},
+		{"sha256sum line", name + ".sha256", sum + "  " + name + "\n", sum},
+		{"SHA256SUMS", checksumsAsset, strings.Repeat("cd", sha256.Size) + "  winpsp-x86.exe\n" + sum + " *" + name + "\n", sum},
+		{"SHA256SUMS without the binary", checksumsAsset, sum + "  winpsp-arm64.exe\n", ""},
+		{"invalid hex", name + ".sha256", "not-a-checksum\n", ""},
+		{"short checksum", name + ".sha256", "abcd\n", ""},
+		{"empty file", name + ".sha256", "", ""},
+		{"no checksum file", "notes.txt", sum, ""},
+	}
+	for _, tt := range tests {
+		srv := mockReleases(t, nil, map[string][]byte{"/" + tt.file: []byte(tt.content)})
+		rel := &githubRelease{TagName: "v0.2", Assets: []githubAsset{
+			{Name: name, URL: srv.URL + "/" + name},
+			{Name: tt.file, URL: srv.URL + "/" + tt.file},
+		}}
+		got, err := rel.checksum(name)
+		if hex.EncodeToString(got) != tt.want || (err != nil) != (tt.want == "") {
+			t.Errorf("%s: checksum = %x, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestDownloadVerified(t *testing.T) {
+	binary, err := os.ReadFile(peFixture(t, 0, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	notPE := []byte("MZ but nothing else")
+	srv := mockReleases(t, nil, map[string][]byte{"/good.exe": binary, "/bad.exe": notPE})
+
+	tests := []struct {
+		name    string
+		path    string
+		sum     string
+		machine uint16
+		wantErr string
+	}{
+		{"valid", "/good.exe", sha256Hex(binary), pe.IMAGE_FILE_MACHINE_AMD64, ""},
+		{"checksum mismatch", "/good.exe", sha256Hex(notPE), pe.IMAGE_FILE_MACHINE_AMD64, "checksum mismatch"},
+		{"not a PE file", "/bad.exe", sha256Hex(notPE), pe.IMAGE_FILE_MACHINE_AMD64, "not a valid executable"},
+		{"other architecture", "/good.exe", sha256Hex(binary), pe.IMAGE_FILE_MACHINE_ARM64, "machine type 0x8664"},
+		{"not found", "/missing.exe", sha256Hex(binary), pe.IMAGE_FILE_MACHINE_AMD64, "404"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		want, _ := hex.DecodeString(tt.sum)
+		path, err := downloadVerified(srv.URL+tt.path, dir, want, tt.machine)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			} else if data, _ := os.ReadFile(path); string(data) != string(binary) || filepath.Dir(path) != dir {
+				t.Errorf("%s: downloaded %s does not hold the release binary", tt.name, path)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error %v, want it to contain %q", tt.name, err, tt.wantErr)
+		}
+		// 校验失败时不留下临时文件
+		if left, _ := os.ReadDir(dir); len(left) != 0 {
+			t.Errorf("%s: %d file(s) left behind", tt.name, len(left))
+		}
+	}
+}
+
+func TestReplaceExecutable(t *testing.T) {
+	for _, oldExists := range []bool{false, true} {
+		dir := t.TempDir()
+		exe := filepath.Join(dir, "winpsp.exe")
+		update := filepath.Join(dir, "winpsp-update-1.exe")
+		os.WriteFile(exe, []byte("current"), 0644)
+		os.WriteFile(update, []byte("new"), 0644)
+		if oldExists {
+			os.WriteFile(exe+".old", []byte("previous update"), 0644)
+		}
+
+		if err := replaceExecutable(exe, update); err != nil {
+			t.Fatalf(".old exists %v: %v", oldExists, err)
+		}
+		got, _ := os.ReadFile(exe)
+		old, _ := os.ReadFile(exe + ".old")
+		if string(got) != "new" || string(old) != "current" {
+			t.Errorf(".old exists %v: exe %q, .old %q", oldExists, got, old)
+		}
+		if _, err := os.Stat(update); !os.IsNotExist(err) {
+			t.Errorf(".old exists %v: temporary file still present", oldExists)
+		}
+	}
+
+	// 新文件不存在：原文件恢复
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "winpsp.exe")
+	os.WriteFile(exe, []byte("current"), 0644)
+	if err := replaceExecutable(exe, filepath.Join(dir, "missing.exe")); err == nil {
+		t.Error("replaceExecutable succeeded without the new file")
+	}
+	if got, _ := os.ReadFile(exe); string(got) != "current" {
+		t.Errorf("exe after a failed replace = %q, want the original", got)
+	}
+}
+
+func TestSelfUpdate(t *testing.T) {
+	assetName, _, err := releaseAssetName()
+	if err != nil {
+		t.Skip(err)
+	}
+	// peFixture 是 AMD64 的 PE 文件
+	if assetName != "winpsp-x64.exe" {
+		t.Skipf("no fixture for %s", assetName)
+	}
+	binary, err := os.ReadFile(peFixture(t, 0, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var srv *httptest.Server
+	release := func(tag string) githubRelease {
+		return githubRelease{TagName: tag, Assets: []githubAsset{
+			{Name: assetName, URL: srv.URL + "/" + tag + "/" + assetName},
+			{Name: checksumsAsset, URL: srv.URL + "/" + tag + "/" + checksumsAsset},
+		}}
+	}
+	files := map[string][]byte{
+		"/v9.9.9/" + assetName:      binary,
+		"/v9.9.9/" + checksumsAsset: []byte(sha256Hex(binary) + "  " + assetName + "\n"),
+		"/v0.2/" + assetName:        binary,
+		"/v0.2/" + checksumsAsset:   []byte(strings.Repeat("00", sha256.Size) + "  " + assetName + "\n"),
+	}
+	releases := map[string]githubRelease{}
+	srv = mockReleases(t, releases, files)
+	releases["/releases/latest"] = release("v9.9.9")
+	releases["/releases/tags/v9.9.9"] = release("v9.9.9")
+	releases["/releases/tags/v0.2"] = release("v0.2")
+
+	origExe, origRunning := currentExe, serviceRunning
+	t.Cleanup(func() { currentExe, serviceRunning = origExe, origRunning })
+	running := false
+	serviceRunning = func(string) (bool, error) { return running, nil }
+
+	tests := []struct {
+		name       string
+		tag        string
+		running    bool
+		want       string // 更新后 exe 的内容
+		wantErr    string
+		wantOutput string
+	}{
+		{"latest", "", false, string(binary), "", "Updated "},
+		{"tag", "v9.9.9", false, string(binary), "", "to v9.9.9"},
+		{"service running", "", true, "current", "stop it first or use --restart-service", ""},
+		{"checksum mismatch", "v0.2", false, "current", "checksum mismatch", ""},
+		{"unknown tag", "v0.0.1", false, "current", "release v0.0.1 not found", ""},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		exe := filepath.Join(dir, "winpsp.exe")
+		os.WriteFile(exe, []byte("current"), 0644)
+		currentExe = func() (string, error) { return exe, nil }
+		running = tt.running
+
+		var err error
+		out := captureStdout(t, func() { err = selfUpdate(tt.tag, "WinPSP", false) })
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: selfUpdate error %v, want %q", tt.name, err, tt.wantErr)
+		}
+		if !strings.Contains(out, tt.wantOutput) {
+			t.Errorf("%s: output %q, want it to contain %q", tt.name, out, tt.wantOutput)
+		}
+		if got, _ := os.ReadFile(exe); string(got) != tt.want {
+			t.Errorf("%s: exe holds %d bytes after the update, want %d", tt.name, len(got), len(tt.want))
+		}
+		// 目录中只留下 exe 和成功时的 .old
+		want := 1
+		if tt.want != "current" {
+			want = 2
+		}
+		if left, _ := os.ReadDir(dir); len(left) != want {
+			t.Errorf("%s: %d file(s) in the directory, want %d", tt.name, len(left), want)
+		}
+	}
+
+	// 最新版本与当前版本相同：不下载
+	releases["/releases/latest"] = release("v" + version)
+	out := captureStdout(t, func() { err = selfUpdate("", "WinPSP", false) })
+	if err != nil || !strings.Contains(out, fmt.Sprintf("WinPSP %s is already the latest version", version)) {
+		t.Errorf("selfUpdate at the latest version: %v, output %q", err, out)
+	}
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,38 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// -------------------- 模拟模式 --------------------
+
+// --simulate 走完整的关机流程（日志、事件日志、运行记录、历史、webhook、上传），
+// 只是不启动命令：用 simulate_duration_ms 的等待和 simulate_exit_code 代替。
+// 用来端到端检查通知和日志链路。
+
+// simulateCommand 代替 runCommandWithTimeout，返回值语义相同
+func simulateCommand(cfg *Config, command string, timeout time.Duration, opts execOptions) (int, bool, error) {
+	d := time.Duration(cfg.SimulateDurationMs) * time.Millisecond
+	opts.logf("Simulate: not executing command, sleeping %s and returning exit code %d", d, cfg.SimulateExitCode)
+	if opts.Output != nil {
+		fmt.Fprintf(opts.Output, "[simulate] %s\n", command)
+	}
+
+	if timeout > 0 && d > timeout {
+		time.Sleep(timeout)
+		return 1, true, nil
+	}
+	time.Sleep(d)
+	return cfg.SimulateExitCode, false, nil
+}
+
+// runCommand 执行命令，模拟模式下不真正执行
+func (s *winpspService) runCommand(command string, timeout time.Duration, opts execOptions) (int, bool, error) {
+	if s.simulate {
+		return simulateCommand(s.config.Load(), command, timeout, opts)
+	}
+	return runCommandWithTimeout(command, timeout, opts)
+}
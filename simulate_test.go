@@ -0,0 +1,121 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulateCommand(t *testing.T) {
+	tests := []struct {
+		durationMs, exitCode int
+		timeout              time.Duration
+		wantCode             int
+		wantTimedOut         bool
+	}{
+		{0, 0, 0, 0, false},
+		{50, 7, 0, 7, false},
+		{50, 7, time.Second, 7, false},
+		{500, 0, 100 * time.Millisecond, 1, true},
+	}
+	for _, tt := range tests {
+		cfg := &Config{SimulateDurationMs: tt.durationMs, SimulateExitCode: tt.exitCode}
+		var out bytes.Buffer
+		start := time.Now()
+		code, timedOut, err := simulateCommand(cfg, "backup.exe /full", tt.timeout, execOptions{Output: &out})
+		d := time.Since(start)
+		if err != nil || code != tt.wantCode || timedOut != tt.wantTimedOut {
+			t.Errorf("%+v: simulateCommand = %d, %v, %v, want %d, %v", tt, code, timedOut, err, tt.wantCode, tt.wantTimedOut)
+		}
+		want := time.Duration(tt.durationMs) * time.Millisecond
+		if tt.wantTimedOut {
+			want = tt.timeout
+		}
+		if d < want {
+			t.Errorf("%+v: returned after %s, want at least %s", tt, d, want)
+		}
+		if out.String() != "[simulate] backup.exe /full\n" {
+			t.Errorf("%+v: output %q", tt, out.String())
+		}
+	}
+}
+
+// TestSimulateShutdown 走一遍完整的关机流程，检查日志、运行记录和 webhook 都照常产生
+func TestSimulateShutdown(t *testing.T) {
+	payloads := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		payloads <- body
+	}))
+	defer srv.Close()
+
+	// 命令不存在：真的执行就会失败，而不是返回 simulate_exit_code
+	s := writeConfig(t, fmt.Sprintf(`{
+  "command": "C:\\WinPSP\\does-not-exist.exe --flush",
+  "simulate_duration_ms": 300,
+  "simulate_exit_code": 4,
+  "webhook_url": %q
+}`, srv.URL))
+	s.simulate, s.interactive = true, true
+	if err := s.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.handleShutdownOnce(); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Dir(s.configPath)
+
+	logs, _ := filepath.Glob(filepath.Join(dir, logFilePrefix+"*"+logFileExt))
+	if len(logs) != 1 {
+		t.Fatalf("log files: %q, want one", logs)
+	}
+	data, err := os.ReadFile(logs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Simulate mode: command will not be executed",
+		"Simulate: not executing command, sleeping 300ms and returning exit code 4",
+		`[simulate] C:\WinPSP\does-not-exist.exe --flush`,
+		"Shutdown released",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("log lacks %q:\n%s", want, data)
+		}
+	}
+
+	data, err = os.ReadFile(filepath.Join(dir, lastRunFileName))
+	if err != nil {
+		t.Fatalf("run metadata not written: %v", err)
+	}
+	var rec runRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.ExitCode != 4 || rec.Result != resultFailure || rec.TimedOut || rec.DurationMs < 300 || rec.LogPath != logs[0] {
+		t.Errorf("run metadata = %+v", rec)
+	}
+
+	select {
+	case body := <-payloads:
+		var p webhookPayload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Fatalf("webhook payload %q: %v", body, err)
+		}
+		if p.Host != webhookHost() || p.ExitCode != 4 || p.Result != resultFailure || p.Command != rec.Command {
+			t.Errorf("webhook payload = %s", body)
+		}
+	default:
+		t.Error("webhook not called")
+	}
+}
@@ -0,0 +1,64 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const defaultStartupTimeoutSecs = 60
+
+// -------------------- startup_command --------------------
+
+// startup_command 在服务进入 Running 后执行一次（例如向 CMDB 登记、检查前提条件）。
+// 服务启动时还没有日志文件，执行结果和失败时的最后几行输出写入事件日志。
+// 命令在后台执行，期间服务照常响应控制请求，包括 PreShutdown。
+
+// startStartupCommand 在后台执行 startup_command。返回的通道在
+// startup_command_required 且命令失败时收到一个值，否则永远不会有值。
+func (s *winpspService) startStartupCommand() <-chan struct{} {
+	failed := make(chan struct{}, 1)
+	cfg := s.config.Load()
+	if cfg == nil || cfg.StartupCommand == "" {
+		return failed
+	}
+
+	go func() {
+		if !s.snapshot(cfg).runStartupCommand(cfg) && cfg.StartupCommandRequired {
+			failed <- struct{}{}
+		}
+	}()
+	return failed
+}
+
+// runStartupCommand 执行 startup_command 并把结果写入事件日志，返回是否成功
+func (s *winpspService) runStartupCommand(cfg *Config) bool {
+	var lines []string
+	logf := func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+
+	var output io.Writer
+	var tail *tailBuffer
+	if n := *cfg.FailureOutputLines; n > 0 {
+		tail = newTailBuffer(n)
+		output = tail
+	}
+
+	ok := s.runExtraCommand("startup_command", cfg.StartupCommand, *cfg.StartupTimeout, output, cfg.commandEnv(), logf)
+	if ok {
+		writeEvent(eventInfo, eventIDStartupCommand, "WinPSP: "+strings.Join(lines, "\n"))
+		return true
+	}
+
+	if tail != nil {
+		if out := tail.Lines(); len(out) > 0 {
+			lines = append(lines, "Last output:")
+			lines = append(lines, out...)
+		}
+	}
+	writeEvent(eventError, eventIDStartupCommand, "WinPSP: "+strings.Join(lines, "\n"))
+	return false
+}
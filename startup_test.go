@@ -0,0 +1,255 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// startupDelayService 返回 startup_delay_secs 为 secs 的服务
+func startupDelayService(t *testing.T, secs int) *winpspService {
+	t.Helper()
+	return loadedService(t, fmt.Sprintf(`{"command": "C:\\Windows\\System32\\whoami.exe", "startup_delay_secs": %d}`, secs))
+}
+
+func TestWaitStartupDelay(t *testing.T) {
+	r := make(chan svc.ChangeRequest)
+	changes := make(chan svc.Status, 10)
+
+	// 未配置时不等待，也不报告状态
+	if startupDelayService(t, 0).waitStartupDelay(r, changes) {
+		t.Fatal("waitStartupDelay without startup_delay_secs reported a stop")
+	}
+	if len(changes) != 0 {
+		t.Fatalf("status reported without startup_delay_secs: %+v", <-changes)
+	}
+
+	s := startupDelayService(t, 1)
+	start := time.Now()
+	stopped := make(chan bool)
+	go func() { stopped <- s.waitStartupDelay(r, changes) }()
+
+	st := <-changes
+	if st.State != svc.StartPending || st.Accepts&svc.AcceptPreShutdown != 0 || st.Accepts&svc.AcceptStop == 0 {
+		t.Errorf("status during the delay = %+v, want StartPending accepting Stop but not PreShutdown", st)
+	}
+	if st.WaitHint < 1000 {
+		t.Errorf("wait hint %d ms is shorter than the delay", st.WaitHint)
+	}
+
+	// 等待期间回应 Interrogate，忽略 PreShutdown
+	r <- svc.ChangeRequest{Cmd: svc.Interrogate, CurrentStatus: st}
+	if got := <-changes; got != st {
+		t.Errorf("Interrogate answered with %+v, want %+v", got, st)
+	}
+	r <- svc.ChangeRequest{Cmd: svc.PreShutdown}
+
+	if <-stopped {
+		t.Fatal("waitStartupDelay reported a stop")
+	}
+	if d := time.Since(start); d < time.Second {
+		t.Errorf("waitStartupDelay returned after %s, want at least 1s", d)
+	}
+	if len(changes) != 0 {
+		t.Errorf("unexpected status after PreShutdown during the delay: %+v", <-changes)
+	}
+}
+
+func TestWaitStartupDelayStop(t *testing.T) {
+	for _, cmd := range []svc.Cmd{svc.Stop, svc.Shutdown} {
+		s := startupDelayService(t, 30)
+		r := make(chan svc.ChangeRequest)
+		changes := make(chan svc.Status, 10)
+		stopped := make(chan bool)
+		go func() { stopped <- s.waitStartupDelay(r, changes) }()
+		<-changes
+
+		start := time.Now()
+		r <- svc.ChangeRequest{Cmd: cmd}
+		if !<-stopped {
+			t.Errorf("cmd %d: waitStartupDelay did not report the stop", cmd)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("cmd %d: took %s to stop during the delay", cmd, d)
+		}
+		if st := <-changes; st.State != svc.StopPending {
+			t.Errorf("cmd %d: status %+v, want StopPending", cmd, st)
+		}
+	}
+}
+
+// 启动延迟期间收到 Stop 时 Execute 直接退出，不进入 Running，也不报告错误
+func TestExecuteStopDuringStartupDelay(t *testing.T) {
+	s := startupDelayService(t, 30)
+	r := make(chan svc.ChangeRequest)
+	changes := make(chan svc.Status, 10)
+	type result struct {
+		specific bool
+		code     uint32
+	}
+	done := make(chan result)
+	go func() {
+		specific, code := s.Execute(nil, r, changes)
+		done <- result{specific, code}
+	}()
+
+	for st := range changes {
+		if st.State == svc.Running {
+			t.Fatal("service reported Running before the startup delay ended")
+		}
+		// 第二个 StartPending 是等待期间的状态
+		if st.State == svc.StartPending && st.WaitHint > 0 {
+			break
+		}
+	}
+	r <- svc.ChangeRequest{Cmd: svc.Stop}
+	select {
+	case res := <-done:
+		if res.specific || res.code != 0 {
+			t.Errorf("Execute = %v, %d, want a clean exit", res.specific, res.code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Execute did not return after Stop during the startup delay")
+	}
+}
+
+// startupEvents 返回事件 ID 为 eventIDStartupCommand 的事件，等待最多 10 秒直到至少有 n 个
+func startupEvents(t *testing.T, events *eventRecorder, n int) []loggedEvent {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var got []loggedEvent
+		for _, e := range events.all() {
+			if e.eid == eventIDStartupCommand {
+				got = append(got, e)
+			}
+		}
+		if len(got) >= n || time.Now().After(deadline) {
+			return got
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestStartStartupCommand(t *testing.T) {
+	tests := []struct {
+		name       string
+		command    string
+		required   bool
+		wantKind   int
+		wantMsg    []string
+		wantFailed bool
+	}{
+		{"success", "cmd.exe /c echo registered", false, eventInfo,
+			[]string{"Running: cmd.exe /c echo registered", "startup_command exit code: 0"}, false},
+		{"failure", "cmd.exe /c echo no CMDB & exit 3", false, eventError,
+			[]string{"startup_command exit code: 3", "Last output:", "no CMDB"}, false},
+		{"required failure", "cmd.exe /c exit 3", true, eventError,
+			[]string{"startup_command exit code: 3"}, true},
+		{"required success", "cmd.exe /c exit 0", true, eventInfo,
+			[]string{"startup_command exit code: 0"}, false},
+	}
+	for _, tt := range tests {
+		events := mockEvents(t)
+		data, err := json.Marshal(map[string]any{
+			"command":                  "cmd.exe /c echo shutdown",
+			"startup_command":          tt.command,
+			"startup_timeout":          30,
+			"startup_command_required": tt.required,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := loadedService(t, string(data))
+
+		failed := s.startStartupCommand()
+		got := startupEvents(t, events, 1)
+		if len(got) != 1 {
+			t.Fatalf("%s: startup events %+v, want one", tt.name, got)
+		}
+		if got[0].kind != tt.wantKind {
+			t.Errorf("%s: event kind %d, want %d", tt.name, got[0].kind, tt.wantKind)
+		}
+		for _, want := range tt.wantMsg {
+			if !strings.Contains(got[0].msg, want) {
+				t.Errorf("%s: event message %q does not contain %q", tt.name, got[0].msg, want)
+			}
+		}
+
+		select {
+		case <-failed:
+			if !tt.wantFailed {
+				t.Errorf("%s: failure reported although startup_command_required is not set or it succeeded", tt.name)
+			}
+		case <-time.After(500 * time.Millisecond):
+			if tt.wantFailed {
+				t.Errorf("%s: failure not reported", tt.name)
+			}
+		}
+	}
+}
+
+// 没有 startup_command 时不启动任何东西
+func TestStartStartupCommandNotConfigured(t *testing.T) {
+	events := mockEvents(t)
+	s := loadedService(t, `{"command": "cmd.exe /c echo shutdown"}`)
+	failed := s.startStartupCommand()
+	select {
+	case <-failed:
+		t.Error("failure reported without startup_command")
+	case <-time.After(300 * time.Millisecond):
+	}
+	if got := startupEvents(t, events, 0); len(got) != 0 {
+		t.Errorf("startup events %+v without startup_command", got)
+	}
+}
+
+// Execute 不等 startup_command 结束就进入 Running；startup_command_required 时失败会停止服务
+func TestExecuteStartupCommandRequired(t *testing.T) {
+	mockEvents(t)
+	s := loadedService(t, `{
+  "command": "cmd.exe /c echo shutdown",
+  "startup_command": "cmd.exe /c ping -n 2 127.0.0.1 >NUL & exit 4",
+  "startup_timeout": 30,
+  "startup_command_required": true
+}`)
+	r := make(chan svc.ChangeRequest)
+	changes := make(chan svc.Status, 10)
+	type result struct {
+		specific bool
+		code     uint32
+	}
+	done := make(chan result, 1)
+	start := time.Now()
+	go func() {
+		specific, code := s.Execute(nil, r, changes)
+		done <- result{specific, code}
+	}()
+
+	for st := range changes {
+		if st.State == svc.Running {
+			break
+		}
+	}
+	if d := time.Since(start); d > 900*time.Millisecond {
+		t.Errorf("Running reported after %s, startup_command blocked the start", d)
+	}
+
+	select {
+	case res := <-done:
+		if !res.specific || res.code != 1 {
+			t.Errorf("Execute = %v, %d, want a service-specific exit code 1", res.specific, res.code)
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatal("Execute did not return after the required startup_command failed")
+	}
+	if st := <-changes; st.State != svc.StopPending {
+		t.Errorf("status %+v, want StopPending", st)
+	}
+}
@@ -0,0 +1,63 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	defaultStatsDPrefix = "winpsp."
+	statsDDialTimeout   = 2 * time.Second
+)
+
+// -------------------- StatsD 指标 --------------------
+
+// statsDMetrics 把一次运行的结果转换成 StatsD 行协议（name:value|type）
+func statsDMetrics(prefix string, rec *runRecord) []string {
+	lines := []string{
+		fmt.Sprintf("%srun.duration_ms:%d|ms", prefix, rec.DurationMs),
+		fmt.Sprintf("%srun.exit_code:%d|g", prefix, rec.ExitCode),
+	}
+	if rec.TimedOut {
+		lines = append(lines, fmt.Sprintf("%srun.timeout:1|c", prefix))
+	}
+	return lines
+}
+
+// sendStatsD 通过 UDP 把指标发到 address，一个数据报发送全部指标
+var sendStatsD = func(address string, lines []string) error {
+	conn, err := net.DialTimeout("udp", address, statsDDialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(strings.Join(lines, "\n")))
+	return err
+}
+
+// emitStatsD 在后台发送本次运行的指标，不阻塞关机流程。
+// UDP 本身不保证送达，发送失败只输出调试信息。
+func (s *winpspService) emitStatsD(rec *runRecord) {
+	cfg := s.config.Load()
+	prefix := defaultStatsDPrefix
+	if cfg.StatsDPrefix != nil {
+		prefix = *cfg.StatsDPrefix
+	}
+	lines := statsDMetrics(prefix, rec)
+	address, err := hostPort(cfg.StatsDAddress, defaultStatsDPort)
+	if err != nil {
+		debugf("WinPSP: statsd_address: %v", err)
+		return
+	}
+
+	go func() {
+		if err := sendStatsD(address, lines); err != nil {
+			debugf("WinPSP: statsd send to %s failed: %v", address, err)
+		}
+	}()
+}
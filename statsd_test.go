@@ -0,0 +1,98 @@
+//go:build windows
+
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsDMetrics(t *testing.T) {
+	tests := []struct {
+		prefix string
+		rec    runRecord
+		want   []string
+	}{
+		{"winpsp.", runRecord{DurationMs: 1500, ExitCode: 0},
+			[]string{"winpsp.run.duration_ms:1500|ms", "winpsp.run.exit_code:0|g"}},
+		{"prod.db01.", runRecord{DurationMs: 300000, ExitCode: 1, TimedOut: true},
+			[]string{"prod.db01.run.duration_ms:300000|ms", "prod.db01.run.exit_code:1|g", "prod.db01.run.timeout:1|c"}},
+		{"", runRecord{DurationMs: 7, ExitCode: -1},
+			[]string{"run.duration_ms:7|ms", "run.exit_code:-1|g"}},
+	}
+	for _, tt := range tests {
+		got := statsDMetrics(tt.prefix, &tt.rec)
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("statsDMetrics(%q, %+v) = %q, want %q", tt.prefix, tt.rec, got, tt.want)
+		}
+	}
+}
+
+// statsDPacket 是 emitStatsD 交给 sendStatsD 的一次发送
+type statsDPacket struct {
+	address string
+	lines   []string
+}
+
+// mockStatsD 让 sendStatsD 把发送的内容交给返回的通道，而不是 UDP 套接字
+func mockStatsD(t *testing.T) <-chan statsDPacket {
+	t.Helper()
+	sent := make(chan statsDPacket, 10)
+	orig := sendStatsD
+	sendStatsD = func(address string, lines []string) error {
+		sent <- statsDPacket{address, lines}
+		return nil
+	}
+	t.Cleanup(func() { sendStatsD = orig })
+	return sent
+}
+
+func TestEmitStatsD(t *testing.T) {
+	tests := []struct {
+		config      string
+		wantAddress string
+		wantFirst   string
+	}{
+		{`{"command": "a.exe", "statsd_address": "localhost"}`, "localhost:8125", "winpsp.run.duration_ms:2500|ms"},
+		{`{"command": "a.exe", "statsd_address": "10.0.0.5:9125", "statsd_prefix": "prod."}`, "10.0.0.5:9125", "prod.run.duration_ms:2500|ms"},
+		{`{"command": "a.exe", "statsd_address": "::1", "statsd_prefix": ""}`, "[::1]:8125", "run.duration_ms:2500|ms"},
+	}
+	for _, tt := range tests {
+		sent := mockStatsD(t)
+		s := loadedService(t, tt.config)
+		s.emitStatsD(&runRecord{DurationMs: 2500, ExitCode: 3, TimedOut: true})
+		select {
+		case p := <-sent:
+			if p.address != tt.wantAddress || len(p.lines) != 3 || p.lines[0] != tt.wantFirst {
+				t.Errorf("%s: sent %+v, want %s to %s", tt.config, p, tt.wantFirst, tt.wantAddress)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: nothing sent", tt.config)
+		}
+	}
+}
+
+func TestSendStatsD(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	lines := []string{"winpsp.run.duration_ms:1500|ms", "winpsp.run.exit_code:0|g"}
+	if err := sendStatsD(conn.LocalAddr().String(), lines); err != nil {
+		t.Fatal(err)
+	}
+	// 全部指标在同一个数据报中
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1500)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(buf[:n]), strings.Join(lines, "\n"); got != want {
+		t.Errorf("datagram %q, want %q", got, want)
+	}
+}
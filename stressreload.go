@@ -0,0 +1,96 @@
+//go:build windows && stress
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// -------------------- 重新加载压力测试 --------------------
+
+// 开发和测试用，只有 go build -race -tags stress 构建的版本才有 --stress-reload：
+// 一个 goroutine 反复改写并重新加载配置，同时另一个 goroutine 反复执行关机处理，
+// 由 race detector 报告两者之间的数据竞争。
+// 使用临时目录中生成的配置和模拟模式，不会执行真实命令，也不会触碰已有的配置。
+
+var stressReloadRuns = flag.Int("stress-reload", 0,
+	"Reload a generated config N times while running the shutdown handler (stress builds only)")
+
+func init() {
+	devMode = func() bool {
+		if *stressReloadRuns <= 0 {
+			return false
+		}
+		if err := runStressReload(*stressReloadRuns); err != nil {
+			fmt.Printf("Stress error: %v\n", err)
+			os.Exit(1)
+		}
+		return true
+	}
+}
+
+func runStressReload(n int) error {
+	dir, err := os.MkdirTemp("", "winpsp-stress-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+	writeConfig := func(i int) error {
+		cfg := map[string]any{
+			"command":              "cmd.exe /c echo stress",
+			"log_count":            2 + i%3,
+			"timeout":              10 + i%5,
+			"simulate_duration_ms": i % 5,
+			"simulate_exit_code":   i % 2,
+			"success_exit_codes":   []int{0, i % 2},
+		}
+		data, err := json.Marshal(cfg)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path, data, 0644)
+	}
+
+	if err := writeConfig(0); err != nil {
+		return err
+	}
+	s := &winpspService{configPath: path, simulate: true}
+	if err := s.loadConfig(); err != nil {
+		return err
+	}
+
+	// 配置文件只由重新加载的 goroutine 读写，关机处理只读取已加载的配置
+	done := make(chan error, 1)
+	go func() {
+		for i := 1; i <= n; i++ {
+			if err := writeConfig(i); err != nil {
+				done <- err
+				return
+			}
+			s.reloadConfig()
+		}
+		done <- nil
+	}()
+
+	runs := 0
+	for {
+		select {
+		case err := <-done:
+			flushEvents(eventFlushTimeout)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Stress reload: %d reloads, %d shutdown runs, no errors.\n", n, runs)
+			return nil
+		default:
+			s.handleShutdownOnce()
+			runs++
+		}
+	}
+}
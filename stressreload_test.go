@@ -0,0 +1,17 @@
+//go:build windows && stress
+
+package main
+
+import "testing"
+
+// 用 go test -race -tags stress 运行，见 stressreload.go
+func TestStressReload(t *testing.T) {
+	tests := []int{1, 100}
+	for _, n := range tests {
+		var err error
+		captureStdout(t, func() { err = runStressReload(n) })
+		if err != nil {
+			t.Errorf("runStressReload(%d): %v", n, err)
+		}
+	}
+}
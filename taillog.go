@@ -0,0 +1,99 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+)
+
+const (
+	tailPollInterval = 200 * time.Millisecond
+	tailIdleTimeout  = 5 * time.Second
+)
+
+// -------------------- --tail-log --------------------
+
+// tailLog 打印目录中最新的日志文件，然后每 200 ms 检查一次新内容，
+// 直到文件 5 秒没有增长或收到 Ctrl+C。相当于简化版的 tail -f。
+func tailLog(dir string, w io.Writer) error {
+	logs, err := listLogFiles(dir)
+	if err != nil {
+		return err
+	}
+	if len(logs) == 0 {
+		return errors.New("no log files found in " + dir)
+	}
+	path := filepath.Join(dir, logs[len(logs)-1].Name())
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Fprintf(w, "==> %s <==\n", path)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	ticker := time.NewTicker(tailPollInterval)
+	defer ticker.Stop()
+
+	buf := make([]byte, 32*1024)
+	lastGrowth := time.Now()
+	for {
+		grew, err := copyNewContent(f, buf, w)
+		if err != nil {
+			return err
+		}
+		if grew {
+			lastGrowth = time.Now()
+		} else if time.Since(lastGrowth) >= tailIdleTimeout {
+			return nil
+		}
+
+		select {
+		case <-interrupt:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// copyNewContent 从当前位置读到 EOF 并写出，返回是否读到了新内容。
+// 文件被截断时从头开始读。
+func copyNewContent(f *os.File, buf []byte, w io.Writer) (bool, error) {
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false, err
+	}
+	if fi, err := f.Stat(); err == nil && fi.Size() < offset {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+	}
+
+	grew := false
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			grew = true
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return grew, werr
+			}
+		}
+		if err == io.EOF {
+			return grew, nil
+		}
+		if err != nil {
+			return grew, err
+		}
+	}
+}
@@ -0,0 +1,132 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer 是可以在 tailLog 写入时读取的 bytes.Buffer
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestCopyNewContent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "winpsp-20261014-083000.log")
+	if err := os.WriteFile(path, []byte("line 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, 4) // 小于内容，需要读多次
+	steps := []struct {
+		write    string // 在读之前追加（或以 ! 开头时覆盖）的内容
+		want     string
+		wantGrew bool
+	}{
+		{"", "line 1\n", true},
+		{"", "", false},
+		{"line 2\n", "line 2\n", true},
+		{"!new\n", "new\n", true}, // 轮换后同名文件更短：从头读
+	}
+	for i, st := range steps {
+		if strings.HasPrefix(st.write, "!") {
+			if err := os.WriteFile(path, []byte(st.write[1:]), 0644); err != nil {
+				t.Fatal(err)
+			}
+		} else if st.write != "" {
+			a, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a.WriteString(st.write)
+			a.Close()
+		}
+
+		var out bytes.Buffer
+		grew, err := copyNewContent(f, buf, &out)
+		if err != nil {
+			t.Fatalf("step %d: %v", i+1, err)
+		}
+		if grew != st.wantGrew || out.String() != st.want {
+			t.Errorf("step %d: copyNewContent = %v, %q, want %v, %q", i+1, grew, out.String(), st.wantGrew, st.want)
+		}
+	}
+}
+
+func TestTailLog(t *testing.T) {
+	dir := t.TempDir()
+	older := filepath.Join(dir, "winpsp-20261013-083000.log")
+	latest := filepath.Join(dir, "winpsp-20261014-083000.log")
+	os.WriteFile(older, []byte("old run\n"), 0644)
+	if err := os.WriteFile(latest, []byte("START\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out syncBuffer
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() { done <- tailLog(dir, &out) }()
+
+	// 命令仍在写日志
+	time.Sleep(3 * tailPollInterval)
+	f, err := os.OpenFile(latest, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("still running\n")
+	f.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(out.String(), "still running") && time.Now().Before(deadline) {
+		time.Sleep(tailPollInterval / 2)
+	}
+
+	// 没有新内容后 tailIdleTimeout 内退出
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(tailIdleTimeout + 5*time.Second):
+		t.Fatal("tailLog did not exit after the log stopped growing")
+	}
+	if d := time.Since(start); d < tailIdleTimeout {
+		t.Errorf("tailLog exited after %s, before the idle timeout", d)
+	}
+
+	got := out.String()
+	want := "==> " + latest + " <==\nSTART\nstill running\n"
+	if got != want {
+		t.Errorf("tailLog output = %q, want %q", got, want)
+	}
+}
+
+func TestTailLogNoLogs(t *testing.T) {
+	if err := tailLog(t.TempDir(), &bytes.Buffer{}); err == nil {
+		t.Error("tailLog succeeded in an empty directory")
+	}
+}
@@ -0,0 +1,257 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -------------------- --import-task --------------------
+
+// --import-task 把任务计划程序导出的 XML（schtasks /query /xml /tn <名称>）转换为
+// WinPSP 配置：每个 <Exec> 操作成为一条命令，能对应上的设置一并转换，
+// WinPSP 没有的功能（触发器、运行账户、其他类型的操作等）在 stderr 输出警告。
+// WinPSP 只在关机时执行，任务的触发器全部不转换。
+
+// taskXML 是任务 XML 中用到的部分
+type taskXML struct {
+	RegistrationInfo struct {
+		URI string `xml:"URI"`
+	} `xml:"RegistrationInfo"`
+	Triggers struct {
+		Items []taskElement `xml:",any"`
+	} `xml:"Triggers"`
+	Principals struct {
+		Principal []struct {
+			UserID  string `xml:"UserId"`
+			GroupID string `xml:"GroupId"`
+		} `xml:"Principal"`
+	} `xml:"Principals"`
+	Settings struct {
+		ExecutionTimeLimit         string `xml:"ExecutionTimeLimit"`
+		DisallowStartIfOnBatteries string `xml:"DisallowStartIfOnBatteries"`
+		RunOnlyIfNetworkAvailable  string `xml:"RunOnlyIfNetworkAvailable"`
+		RunOnlyIfIdle              string `xml:"RunOnlyIfIdle"`
+		IdleSettings               struct {
+			Duration string `xml:"Duration"`
+		} `xml:"IdleSettings"`
+		WakeToRun string `xml:"WakeToRun"`
+	} `xml:"Settings"`
+	Actions struct {
+		Exec []struct {
+			Command          string `xml:"Command"`
+			Arguments        string `xml:"Arguments"`
+			WorkingDirectory string `xml:"WorkingDirectory"`
+		} `xml:"Exec"`
+		Other []taskElement `xml:",any"`
+	} `xml:"Actions"`
+}
+
+type taskElement struct {
+	XMLName xml.Name
+}
+
+// importTask 实现 --import-task：读取任务 XML，生成配置写到 out（为空时写到标准输出）
+func importTask(in, out string) error {
+	data, err := os.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	// schtasks 导出的是带 BOM 的 UTF-16，与配置文件一样先转成 UTF-8
+	if data, err = decodeConfig(data); err != nil {
+		return err
+	}
+
+	result, warnings, err := convertTask(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", in, err)
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+
+	// 转换结果必须是有效配置
+	var cfg Config
+	if err := json.Unmarshal(result, &cfg); err != nil {
+		return fmt.Errorf("imported config is invalid: %w", err)
+	}
+
+	if out == "" {
+		_, err = os.Stdout.Write(result)
+		return err
+	}
+	return os.WriteFile(out, result, 0644)
+}
+
+// convertTask 把 UTF-8 的任务 XML 转换为配置，同时返回不支持的功能的警告
+func convertTask(data []byte) ([]byte, []string, error) {
+	var task taskXML
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	// XML 声明中仍写着 UTF-16，内容其实已经是 UTF-8
+	dec.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) { return r, nil }
+	if err := dec.Decode(&task); err != nil {
+		return nil, nil, fmt.Errorf("parse task XML: %w", err)
+	}
+	if len(task.Actions.Exec) == 0 {
+		return nil, nil, errors.New("task has no <Exec> action")
+	}
+
+	var warnings []string
+	warnf := func(format string, args ...any) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	for _, t := range task.Triggers.Items {
+		warnf("trigger %s not imported: WinPSP runs the command only at shutdown", t.XMLName.Local)
+	}
+	for _, a := range task.Actions.Other {
+		warnf("%s action not supported, skipped", a.XMLName.Local)
+	}
+	for _, p := range task.Principals.Principal {
+		if isSystemAccount(p.UserID) && p.GroupID == "" {
+			continue
+		}
+		if p.UserID != "" || p.GroupID != "" {
+			warnf("task principal %s not imported: the service runs the command as LocalSystem", p.UserID+p.GroupID)
+		}
+	}
+	if task.Settings.WakeToRun == "true" {
+		warnf("WakeToRun not supported")
+	}
+
+	name := task.RegistrationInfo.URI
+	if i := strings.LastIndex(name, `\`); i >= 0 {
+		name = name[i+1:]
+	}
+
+	entries := make([]CommandEntry, len(task.Actions.Exec))
+	for i, a := range task.Actions.Exec {
+		command := strings.TrimSpace(a.Command)
+		if strings.ContainsAny(command, " \t") && !strings.HasPrefix(command, `"`) {
+			command = `"` + command + `"`
+		}
+		if args := strings.TrimSpace(a.Arguments); args != "" {
+			command += " " + args
+		}
+		if strings.Contains(command, "%") {
+			warnf("%s: environment variables are not expanded by WinPSP", command)
+		}
+		entries[i] = CommandEntry{Command: command, WorkingDirectory: a.WorkingDirectory}
+	}
+
+	var fields []jsonField
+	add := func(key string, v any) error {
+		raw, err := json.Marshal(v)
+		if err == nil {
+			fields = append(fields, jsonField{Key: key, Value: raw})
+		}
+		return err
+	}
+
+	var err error
+	if len(entries) == 1 {
+		e := entries[0]
+		if name != "" {
+			err = add("name", name)
+		}
+		if err == nil {
+			err = add("command", e.Command)
+		}
+		if err == nil && e.WorkingDirectory != "" {
+			err = add("working_directory", e.WorkingDirectory)
+		}
+	} else {
+		// 多个操作按顺序执行，与任务计划程序相同
+		if err = add("schema_version", currentSchemaVersion); err == nil {
+			err = add("commands", entries)
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if limit := task.Settings.ExecutionTimeLimit; limit != "" {
+		d, err := parseTaskDuration(limit)
+		switch {
+		case err != nil:
+			warnf("ExecutionTimeLimit %s not imported: %v", limit, err)
+		default:
+			// PT0S 表示不限，对应 timeout 0
+			secs := int(d / time.Second)
+			if secs > defaultTimeoutSecs {
+				warnf("ExecutionTimeLimit %s imported as timeout %d: shutdown is blocked for up to that long", limit, secs)
+			}
+			if err := add("timeout", secs); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if task.Settings.DisallowStartIfOnBatteries == "true" {
+		if err := add("skip_on_battery", true); err != nil {
+			return nil, nil, err
+		}
+	}
+	if task.Settings.RunOnlyIfNetworkAvailable == "true" {
+		if err := add("require_network", true); err != nil {
+			return nil, nil, err
+		}
+	}
+	if task.Settings.RunOnlyIfIdle == "true" {
+		// 任务计划程序未写 Duration 时默认 10 分钟
+		minutes := 10
+		if s := task.Settings.IdleSettings.Duration; s != "" {
+			if d, err := parseTaskDuration(s); err == nil {
+				minutes = int(d / time.Minute)
+			} else {
+				warnf("IdleSettings Duration %s not imported: %v", s, err)
+			}
+		}
+		if err := add("idle_min_minutes", minutes); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	result, err := encodeObject(fields)
+	return result, warnings, err
+}
+
+// isSystemAccount 判断任务的 UserId 是否为 LocalSystem，与服务的运行账户相同
+func isSystemAccount(user string) bool {
+	switch strings.ToUpper(user) {
+	case "S-1-5-18", "SYSTEM", `NT AUTHORITY\SYSTEM`:
+		return true
+	}
+	return false
+}
+
+var taskDurationRe = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseTaskDuration 解析任务计划程序使用的 ISO 8601 时长，如 PT72H、P1DT30M
+func parseTaskDuration(s string) (time.Duration, error) {
+	m := taskDurationRe.FindStringSubmatch(s)
+	if m == nil || s == "P" || s == "PT" {
+		return 0, fmt.Errorf("unsupported duration %q", s)
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if m[i+1] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return 0, err
+		}
+		d += time.Duration(n) * unit
+	}
+	return d, nil
+}
@@ -0,0 +1,215 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// taskFixture 是 schtasks /query /xml 导出的任务，只有一个 <Exec> 操作
+const taskFixture = `<?xml version="1.0" encoding="UTF-16"?>
+<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <RegistrationInfo>
+    <Date>2026-10-01T08:30:00</Date>
+    <Author>CONTOSO\admin</Author>
+    <URI>\Backup\Nightly backup</URI>
+  </RegistrationInfo>
+  <Triggers>
+    <CalendarTrigger>
+      <StartBoundary>2026-10-01T02:00:00</StartBoundary>
+      <ScheduleByDay><DaysInterval>1</DaysInterval></ScheduleByDay>
+    </CalendarTrigger>
+    <BootTrigger/>
+  </Triggers>
+  <Principals>
+    <Principal id="Author">
+      <UserId>S-1-5-18</UserId>
+      <RunLevel>HighestAvailable</RunLevel>
+    </Principal>
+  </Principals>
+  <Settings>
+    <DisallowStartIfOnBatteries>true</DisallowStartIfOnBatteries>
+    <RunOnlyIfNetworkAvailable>true</RunOnlyIfNetworkAvailable>
+    <RunOnlyIfIdle>true</RunOnlyIfIdle>
+    <IdleSettings><Duration>PT5M</Duration></IdleSettings>
+    <ExecutionTimeLimit>PT2M</ExecutionTimeLimit>
+  </Settings>
+  <Actions Context="Author">
+    <Exec>
+      <Command>C:\Program Files\Backup\backup.exe</Command>
+      <Arguments>/full /log "C:\logs\backup.log"</Arguments>
+      <WorkingDirectory>C:\Program Files\Backup</WorkingDirectory>
+    </Exec>
+  </Actions>
+</Task>`
+
+func TestParseTaskDuration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"PT0S", 0, false},
+		{"PT30S", 30 * time.Second, false},
+		{"PT72H", 72 * time.Hour, false},
+		{"P1DT30M", 24*time.Hour + 30*time.Minute, false},
+		{"P3D", 72 * time.Hour, false},
+		{"PT1H2M3S", time.Hour + 2*time.Minute + 3*time.Second, false},
+		{"P", 0, true},
+		{"PT", 0, true},
+		{"P1Y", 0, true},
+		{"PT1.5H", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTaskDuration(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseTaskDuration(%q) = %s, %v, want %s, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestConvertTask(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         string
+		want         map[string]any // 生成的配置，JSON 数字为 float64
+		wantWarnings []string       // 每条警告应包含的内容
+	}{
+		{
+			"fixture", taskFixture,
+			map[string]any{
+				"name":              "Nightly backup",
+				"command":           `"C:\Program Files\Backup\backup.exe" /full /log "C:\logs\backup.log"`,
+				"working_directory": `C:\Program Files\Backup`,
+				"timeout":           float64(120),
+				"skip_on_battery":   true,
+				"require_network":   true,
+				"idle_min_minutes":  float64(5),
+			},
+			[]string{"trigger CalendarTrigger not imported", "trigger BootTrigger not imported"},
+		},
+		{
+			"unsupported features",
+			`<Task><Principals><Principal><UserId>CONTOSO\svc-backup</UserId></Principal></Principals>
+			<Settings><RunOnlyIfIdle>true</RunOnlyIfIdle><WakeToRun>true</WakeToRun><ExecutionTimeLimit>PT72H</ExecutionTimeLimit></Settings>
+			<Actions><Exec><Command>%SystemRoot%\System32\cmd.exe</Command></Exec><SendEmail/></Actions></Task>`,
+			map[string]any{
+				"command":          `%SystemRoot%\System32\cmd.exe`,
+				"timeout":          float64(72 * 3600),
+				"idle_min_minutes": float64(10), // 没有 Duration 时为任务计划程序的默认值
+			},
+			[]string{
+				"SendEmail action not supported",
+				`task principal CONTOSO\svc-backup not imported`,
+				"WakeToRun not supported",
+				"environment variables are not expanded",
+				"ExecutionTimeLimit PT72H imported as timeout 259200",
+			},
+		},
+		{
+			"no time limit",
+			`<Task><Settings><ExecutionTimeLimit>PT0S</ExecutionTimeLimit></Settings>
+			<Actions><Exec><Command>backup.exe</Command></Exec></Actions></Task>`,
+			map[string]any{"command": "backup.exe", "timeout": float64(0)},
+			nil,
+		},
+		{
+			"invalid time limit",
+			`<Task><Settings><ExecutionTimeLimit>P1M</ExecutionTimeLimit></Settings>
+			<Actions><Exec><Command>backup.exe</Command></Exec></Actions></Task>`,
+			map[string]any{"command": "backup.exe"},
+			[]string{"ExecutionTimeLimit P1M not imported"},
+		},
+	}
+	for _, tt := range tests {
+		data, warnings, err := convertTask([]byte(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var got map[string]any
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Errorf("%s: output is not JSON: %v\n%s", tt.name, err, data)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: config = %v, want %v", tt.name, got, tt.want)
+		}
+		if len(warnings) != len(tt.wantWarnings) {
+			t.Errorf("%s: warnings %q, want %d", tt.name, warnings, len(tt.wantWarnings))
+			continue
+		}
+		for i, w := range tt.wantWarnings {
+			if !strings.Contains(warnings[i], w) {
+				t.Errorf("%s: warning %q, want it to contain %q", tt.name, warnings[i], w)
+			}
+		}
+	}
+}
+
+func TestConvertTaskMultipleActions(t *testing.T) {
+	data, _, err := convertTask([]byte(`<Task><RegistrationInfo><URI>\Cleanup</URI></RegistrationInfo><Actions>
+	<Exec><Command>C:\Tools\stop-app.exe</Command><WorkingDirectory>C:\Tools</WorkingDirectory></Exec>
+	<Exec><Command>C:\Tools\clean temp.bat</Command><Arguments>/q</Arguments></Exec>
+	</Actions></Task>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	want := []CommandEntry{
+		{Command: `C:\Tools\stop-app.exe`, WorkingDirectory: `C:\Tools`},
+		{Command: `"C:\Tools\clean temp.bat" /q`},
+	}
+	if len(cfg.Commands) != len(want) || cfg.Name != "" || cfg.SchemaVersion != currentSchemaVersion {
+		t.Fatalf("imported config:\n%s", data)
+	}
+	for i, e := range want {
+		if got := cfg.Commands[i]; got.Command != e.Command || got.WorkingDirectory != e.WorkingDirectory {
+			t.Errorf("commands[%d] = %q in %q, want %q in %q", i, got.Command, got.WorkingDirectory, e.Command, e.WorkingDirectory)
+		}
+	}
+}
+
+func TestConvertTaskInvalid(t *testing.T) {
+	for _, data := range []string{
+		`<Task><Actions><SendEmail/></Actions></Task>`,
+		`<Task><Actions><Exec><Command>backup.exe</Command>`,
+		`not XML`,
+	} {
+		if _, _, err := convertTask([]byte(data)); err == nil {
+			t.Errorf("convertTask(%q) succeeded", data)
+		}
+	}
+}
+
+// schtasks 导出的是带 BOM 的 UTF-16LE，生成的文件可以直接作为配置使用
+func TestImportTask(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "task.xml")
+	out := filepath.Join(dir, "winpsp.json")
+	if err := os.WriteFile(in, utf16le(t, strings.ReplaceAll(taskFixture, "\n", "\r\n"), true), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := importTask(in, out); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &winpspService{configPath: out}
+	cfg, err := s.readConfig()
+	if err != nil {
+		t.Fatalf("imported config does not load: %v", err)
+	}
+	if cfg.Command != `"C:\Program Files\Backup\backup.exe" /full /log "C:\logs\backup.log"` || *cfg.Timeout != 120 {
+		t.Errorf("imported command %q, timeout %d", cfg.Command, *cfg.Timeout)
+	}
+}
@@ -0,0 +1,68 @@
+//go:build windows
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// -------------------- TLS --------------------
+
+// buildTLSConfig 根据 tls_* 字段构造所有 HTTP 客户端共用的 TLS 配置。
+// 没有设置任何 tls_* 字段时返回 nil，即使用 Go 的默认行为（系统根证书）。
+func buildTLSConfig(cfg *Config) (*tls.Config, error) {
+	if cfg == nil || (cfg.TLSCertFile == "" && cfg.TLSKeyFile == "" && cfg.TLSCAFile == "" && !cfg.TLSInsecureSkipVerify) {
+		return nil, nil
+	}
+
+	tc := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.TLSInsecureSkipVerify,
+	}
+
+	if cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" {
+		if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+			return nil, errors.New("tls_cert_file and tls_key_file must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	if cfg.TLSCAFile != "" {
+		pem, err := os.ReadFile(cfg.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read tls_ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls_ca_file %s contains no PEM certificates", cfg.TLSCAFile)
+		}
+		tc.RootCAs = pool
+	}
+
+	return tc, nil
+}
+
+// newHTTPClient 返回使用 cfg 中 TLS 设置的 HTTP 客户端，cfg 可为 nil
+func newHTTPClient(cfg *Config, timeout time.Duration) (*http.Client, error) {
+	tc, err := buildTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: timeout}
+	if tc != nil {
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.TLSClientConfig = tc
+		client.Transport = tr
+	}
+	return client, nil
+}
@@ -0,0 +1,249 @@
+//go:build windows
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA 是测试用的证书颁发机构，签发客户端证书
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// writeClientCert 签发一张客户端证书，写到 dir 中，返回证书和私钥的路径
+func (ca *testCA) writeClientCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "winpsp-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, "client.crt")
+	keyFile = filepath.Join(dir, "client.key")
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certFile, keyFile
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// mtlsServer 启动一个要求 clientCA 签发的客户端证书的 HTTPS 服务器，
+// 返回它和写有服务器证书的 CA 文件
+func mtlsServer(t *testing.T, clientCA *testCA, handler http.Handler) (*httptest.Server, string) {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(handler)
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCA.cert)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0) // 失败的握手是预期的
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	caFile := filepath.Join(t.TempDir(), "server-ca.pem")
+	writeFile(t, caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+	return srv, caFile
+}
+
+func TestBuildTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "WinPSP Test CA")
+	certFile, keyFile := ca.writeClientCert(t, dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	writeFile(t, caFile, ca.pem)
+	notPEM := filepath.Join(dir, "ca.txt")
+	writeFile(t, notPEM, []byte("not a certificate"))
+
+	tests := []struct {
+		name      string
+		cfg       *Config
+		wantNil   bool
+		wantErr   bool
+		wantCerts int
+		wantRoots bool
+	}{
+		{"nil config", nil, true, false, 0, false},
+		{"no tls fields", &Config{}, true, false, 0, false},
+		{"insecure only", &Config{TLSInsecureSkipVerify: true}, false, false, 0, false},
+		{"client certificate", &Config{TLSCertFile: certFile, TLSKeyFile: keyFile}, false, false, 1, false},
+		{"custom CA", &Config{TLSCAFile: caFile}, false, false, 0, true},
+		{"everything", &Config{TLSCertFile: certFile, TLSKeyFile: keyFile, TLSCAFile: caFile}, false, false, 1, true},
+		{"certificate without key", &Config{TLSCertFile: certFile}, false, true, 0, false},
+		{"key without certificate", &Config{TLSKeyFile: keyFile}, false, true, 0, false},
+		{"key does not match", &Config{TLSCertFile: caFile, TLSKeyFile: keyFile}, false, true, 0, false},
+		{"missing CA file", &Config{TLSCAFile: filepath.Join(dir, "missing.pem")}, false, true, 0, false},
+		{"CA file without PEM", &Config{TLSCAFile: notPEM}, false, true, 0, false},
+	}
+	for _, tt := range tests {
+		tc, err := buildTLSConfig(tt.cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if (tc == nil) != tt.wantNil {
+			t.Errorf("%s: config = %v, want nil %v", tt.name, tc, tt.wantNil)
+			continue
+		}
+		if tc == nil {
+			continue
+		}
+		if tc.MinVersion != tls.VersionTLS12 || tc.InsecureSkipVerify != tt.cfg.TLSInsecureSkipVerify ||
+			len(tc.Certificates) != tt.wantCerts || (tc.RootCAs != nil) != tt.wantRoots {
+			t.Errorf("%s: config = %+v", tt.name, tc)
+		}
+	}
+}
+
+func TestNewHTTPClientMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	clientCA := newTestCA(t, "WinPSP Client CA")
+	certFile, keyFile := clientCA.writeClientCert(t, dir)
+	otherCert, otherKey := newTestCA(t, "Other CA").writeClientCert(t, t.TempDir())
+	srv, serverCA := mtlsServer(t, clientCA, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+
+	tests := []struct {
+		name   string
+		cfg    *Config
+		wantOK bool
+	}{
+		{"default roots", nil, false},
+		{"server CA but no client certificate", &Config{TLSCAFile: serverCA}, false},
+		{"client certificate but default roots", &Config{TLSCertFile: certFile, TLSKeyFile: keyFile}, false},
+		{"server CA and client certificate", &Config{TLSCertFile: certFile, TLSKeyFile: keyFile, TLSCAFile: serverCA}, true},
+		{"insecure and client certificate", &Config{TLSCertFile: certFile, TLSKeyFile: keyFile, TLSInsecureSkipVerify: true}, true},
+		{"client certificate from another CA", &Config{TLSCertFile: otherCert, TLSKeyFile: otherKey, TLSCAFile: serverCA}, false},
+	}
+	for _, tt := range tests {
+		client, err := newHTTPClient(tt.cfg, 5*time.Second)
+		if err != nil {
+			t.Fatalf("%s: newHTTPClient: %v", tt.name, err)
+		}
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			if tt.wantOK {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !tt.wantOK {
+			t.Errorf("%s: request succeeded with status %s", tt.name, resp.Status)
+		} else if string(body) != "winpsp-client" {
+			t.Errorf("%s: server saw client certificate %q", tt.name, body)
+		}
+	}
+}
+
+func TestWebhookMutualTLS(t *testing.T) {
+	clientCA := newTestCA(t, "WinPSP Client CA")
+	certFile, keyFile := clientCA.writeClientCert(t, t.TempDir())
+	got := make(chan string, 1)
+	srv, serverCA := mtlsServer(t, clientCA, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.TLS.PeerCertificates[0].Subject.CommonName
+	}))
+
+	cfg := &Config{WebhookURL: srv.URL, TLSCertFile: certFile, TLSKeyFile: keyFile, TLSCAFile: serverCA}
+	resp, err := postWebhook(cfg, webhookPayload{Host: "test"})
+	if err != nil {
+		t.Fatalf("postWebhook: %v", err)
+	}
+	resp.Body.Close()
+	if cn := <-got; cn != "winpsp-client" {
+		t.Errorf("webhook client certificate %q", cn)
+	}
+}
+
+func TestRemoteConfigMutualTLS(t *testing.T) {
+	clientCA := newTestCA(t, "WinPSP Client CA")
+	certFile, keyFile := clientCA.writeClientCert(t, t.TempDir())
+	cs := &configServer{body: `{"command": "C:\\Windows\\System32\\hostname.exe"}`}
+	srv, serverCA := mtlsServer(t, clientCA, cs)
+
+	// 第一次下载时还没有缓存，使用默认设置：服务器证书不受信任
+	rc := newRemoteConfig(srv.URL, "", t.TempDir())
+	if _, err := rc.fetch(); err == nil {
+		t.Fatal("fetch succeeded without the server CA or a client certificate")
+	}
+
+	// 预先部署的缓存中的 tls_* 设置用于下载
+	cached, err := json.Marshal(map[string]any{
+		"command":       `C:\Windows\System32\whoami.exe`,
+		"tls_cert_file": certFile,
+		"tls_key_file":  keyFile,
+		"tls_ca_file":   serverCA,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, rc.cachePath, cached)
+	if changed, err := rc.fetch(); err != nil || !changed {
+		t.Fatalf("fetch with the cached TLS settings = %v, %v", changed, err)
+	}
+}
@@ -0,0 +1,130 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// SERVICE_TRIGGER 相关常量（winsvc.h），x/sys/windows 中没有
+const (
+	serviceTriggerTypeDeviceInterfaceArrival = 1
+	serviceTriggerTypeIPAddressAvailability  = 2
+	serviceTriggerActionServiceStart         = 1
+	serviceTriggerDataTypeString             = 2
+)
+
+const (
+	triggerNetworkAvailable = "NETWORK_AVAILABLE"
+	triggerUSBDevice        = "USB_DEVICE"
+)
+
+var (
+	// NETWORK_MANAGER_FIRST_IP_ADDRESS_ARRIVAL_GUID
+	guidFirstIPAddressArrival = windows.GUID{Data1: 0x4f27f2de, Data2: 0x14e2, Data3: 0x430b,
+		Data4: [8]byte{0xa5, 0x49, 0x7c, 0xd4, 0x8c, 0xbc, 0x82, 0x45}}
+	// GUID_DEVINTERFACE_USB_DEVICE
+	guidDevInterfaceUSBDevice = windows.GUID{Data1: 0xa5dcbf10, Data2: 0x6530, Data3: 0x11d2,
+		Data4: [8]byte{0x90, 0x1f, 0x00, 0xc0, 0x4f, 0xb9, 0x51, 0xed}}
+)
+
+type serviceTriggerSpecificDataItem struct {
+	DataType uint32
+	Size     uint32
+	Data     *byte
+}
+
+type serviceTrigger struct {
+	TriggerType    uint32
+	Action         uint32
+	TriggerSubtype *windows.GUID
+	DataItemCount  uint32
+	DataItems      *serviceTriggerSpecificDataItem
+}
+
+type serviceTriggerInfo struct {
+	TriggerCount uint32
+	Triggers     *serviceTrigger
+	Reserved     *byte
+}
+
+// -------------------- 服务触发器 --------------------
+
+// serviceTriggerSpec 是 --install-trigger 的一项：
+//
+//	NETWORK_AVAILABLE            第一个 IP 地址可用时启动
+//	USB_DEVICE[=硬件ID]          USB 设备接入时启动
+//	{接口类 GUID}[=硬件ID]       指定设备接口类的设备接入时启动
+type serviceTriggerSpec struct {
+	Type       uint32
+	Subtype    windows.GUID
+	HardwareID string
+}
+
+func parseServiceTrigger(s string) (serviceTriggerSpec, error) {
+	name, hwid, _ := strings.Cut(strings.TrimSpace(s), "=")
+
+	switch {
+	case strings.EqualFold(name, triggerNetworkAvailable):
+		if hwid != "" {
+			return serviceTriggerSpec{}, fmt.Errorf("%s does not take a hardware ID", triggerNetworkAvailable)
+		}
+		return serviceTriggerSpec{Type: serviceTriggerTypeIPAddressAvailability, Subtype: guidFirstIPAddressArrival}, nil
+	case strings.EqualFold(name, triggerUSBDevice):
+		return serviceTriggerSpec{Type: serviceTriggerTypeDeviceInterfaceArrival, Subtype: guidDevInterfaceUSBDevice, HardwareID: hwid}, nil
+	case strings.HasPrefix(name, "{"):
+		guid, err := windows.GUIDFromString(name)
+		if err != nil {
+			return serviceTriggerSpec{}, fmt.Errorf("invalid device interface GUID %q: %w", name, err)
+		}
+		return serviceTriggerSpec{Type: serviceTriggerTypeDeviceInterfaceArrival, Subtype: guid, HardwareID: hwid}, nil
+	default:
+		return serviceTriggerSpec{}, fmt.Errorf("unknown trigger %q (use %s, %s or a device interface GUID)",
+			s, triggerNetworkAvailable, triggerUSBDevice)
+	}
+}
+
+func (t serviceTriggerSpec) String() string {
+	switch {
+	case t.Type == serviceTriggerTypeIPAddressAvailability:
+		return "network available"
+	case t.HardwareID != "":
+		return fmt.Sprintf("device arrival %s (%s)", t.Subtype, t.HardwareID)
+	default:
+		return fmt.Sprintf("device arrival %s", t.Subtype)
+	}
+}
+
+// setServiceTriggers 用 ChangeServiceConfig2(SERVICE_CONFIG_TRIGGER_INFO) 注册触发启动
+func setServiceTriggers(h windows.Handle, specs []serviceTriggerSpec) error {
+	triggers := make([]serviceTrigger, len(specs))
+	for i := range specs {
+		triggers[i] = serviceTrigger{
+			TriggerType:    specs[i].Type,
+			Action:         serviceTriggerActionServiceStart,
+			TriggerSubtype: &specs[i].Subtype,
+		}
+
+		if specs[i].HardwareID != "" {
+			// 数据项为 REG_MULTI_SZ 格式的 UTF-16 字符串（以两个 NUL 结尾）
+			u := windows.StringToUTF16(specs[i].HardwareID)
+			u = append(u, 0)
+			triggers[i].DataItemCount = 1
+			triggers[i].DataItems = &serviceTriggerSpecificDataItem{
+				DataType: serviceTriggerDataTypeString,
+				Size:     uint32(len(u) * 2),
+				Data:     (*byte)(unsafe.Pointer(&u[0])),
+			}
+		}
+	}
+
+	info := serviceTriggerInfo{TriggerCount: uint32(len(triggers))}
+	if len(triggers) > 0 {
+		info.Triggers = &triggers[0]
+	}
+	return windows.ChangeServiceConfig2(h, windows.SERVICE_CONFIG_TRIGGER_INFO, (*byte)(unsafe.Pointer(&info)))
+}
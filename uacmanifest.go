@@ -0,0 +1,170 @@
+//go:build windows
+
+package main
+
+import (
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	rtManifest           = 24 // RT_MANIFEST
+	resourceSubdirectory = 0x80000000
+	levelRequireAdmin    = "requireAdministrator"
+)
+
+var executionLevelRe = regexp.MustCompile(`requestedExecutionLevel[^>]*\blevel\s*=\s*["']([A-Za-z]+)["']`)
+
+// -------------------- require_admin --------------------
+
+// require_admin 时在加载配置后检查每条命令的 .exe：清单缺失或没有要求
+// requireAdministrator 时写一条警告（交互模式下输出到控制台）。
+// 只是提示，不影响执行；.bat / .ps1 等脚本没有清单，不检查。
+
+// checkAdminManifests 检查配置中所有命令的可执行文件
+func (s *winpspService) checkAdminManifests() {
+	cfg := s.config.Load()
+	for _, e := range cfg.commandEntries() {
+		if e.wmi {
+			continue
+		}
+		exe, err := commandExecutable(e.Command, s.commandBaseDir())
+		if err != nil || !strings.EqualFold(filepath.Ext(exe), ".exe") {
+			continue
+		}
+
+		var msg string
+		switch level, err := exeExecutionLevel(exe); {
+		case err != nil:
+			msg = fmt.Sprintf("cannot read the manifest of %s: %v", exe, err)
+		case level == "":
+			msg = fmt.Sprintf("%s has no UAC manifest; it will not request elevation", exe)
+		case level != levelRequireAdmin:
+			msg = fmt.Sprintf("%s requests %s, not %s", exe, level, levelRequireAdmin)
+		default:
+			continue
+		}
+		if s.interactive {
+			fmt.Println("Warning (require_admin): " + msg)
+		} else {
+			writeEvent(eventWarning, eventIDNoAdminManifest, "WinPSP: require_admin: "+msg)
+		}
+	}
+}
+
+// exeExecutionLevel 从 PE 文件的 RT_MANIFEST 资源中读出 requestedExecutionLevel，
+// 没有清单或清单中没有这一项时返回 ""
+func exeExecutionLevel(path string) (string, error) {
+	f, err := pe.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	manifest, err := peManifest(f)
+	if err != nil || manifest == nil {
+		return "", err
+	}
+	if m := executionLevelRe.FindSubmatch(manifest); m != nil {
+		return string(m[1]), nil
+	}
+	return "", nil
+}
+
+// peManifest 返回第一个 RT_MANIFEST 资源的内容，没有时返回 nil
+func peManifest(f *pe.File) ([]byte, error) {
+	var dir pe.DataDirectory
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		if oh.NumberOfRvaAndSizes > pe.IMAGE_DIRECTORY_ENTRY_RESOURCE {
+			dir = oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_RESOURCE]
+		}
+	case *pe.OptionalHeader64:
+		if oh.NumberOfRvaAndSizes > pe.IMAGE_DIRECTORY_ENTRY_RESOURCE {
+			dir = oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_RESOURCE]
+		}
+	}
+	if dir.VirtualAddress == 0 || dir.Size == 0 {
+		return nil, nil
+	}
+
+	rsrc, err := peData(f, dir.VirtualAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	// 资源目录有三层：类型 → 名称 → 语言，名称和语言取第一项
+	var off uint32
+	for level, id := range []int{rtManifest, -1, -1} {
+		next, ok := peResourceEntry(rsrc, off, id)
+		if !ok {
+			return nil, nil
+		}
+		// 第三层的项直接指向 IMAGE_RESOURCE_DATA_ENTRY，没有子目录标志
+		if (next&resourceSubdirectory != 0) != (level < 2) {
+			return nil, fmt.Errorf("malformed resource directory at level %d", level)
+		}
+		off = next &^ resourceSubdirectory
+	}
+	if int(off)+8 > len(rsrc) {
+		return nil, errors.New("malformed resource data entry")
+	}
+
+	rva := binary.LittleEndian.Uint32(rsrc[off:])
+	size := binary.LittleEndian.Uint32(rsrc[off+4:])
+	data, err := peData(f, rva)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(size) > uint64(len(data)) {
+		return nil, errors.New("manifest extends past the end of its section")
+	}
+	return data[:size], nil
+}
+
+// peResourceEntry 在 off 处的资源目录中查找 ID 为 id 的项（id < 0 时取第一项），
+// 返回该项的 OffsetToData
+func peResourceEntry(rsrc []byte, off uint32, id int) (uint32, bool) {
+	if int(off)+16 > len(rsrc) {
+		return 0, false
+	}
+	n := int(binary.LittleEndian.Uint16(rsrc[off+12:])) + int(binary.LittleEndian.Uint16(rsrc[off+14:]))
+	for i := 0; i < n; i++ {
+		e := int(off) + 16 + i*8
+		if e+8 > len(rsrc) {
+			return 0, false
+		}
+		name := binary.LittleEndian.Uint32(rsrc[e:])
+		if id < 0 || name == uint32(id) {
+			return binary.LittleEndian.Uint32(rsrc[e+4:]), true
+		}
+	}
+	return 0, false
+}
+
+// peData 返回从 rva 开始到所在节末尾的数据
+func peData(f *pe.File, rva uint32) ([]byte, error) {
+	for _, sec := range f.Sections {
+		size := sec.VirtualSize
+		if sec.Size > size {
+			size = sec.Size
+		}
+		if rva < sec.VirtualAddress || rva >= sec.VirtualAddress+size {
+			continue
+		}
+		data, err := sec.Data()
+		if err != nil {
+			return nil, err
+		}
+		if start := rva - sec.VirtualAddress; int(start) < len(data) {
+			return data[start:], nil
+		}
+		return nil, errors.New("RVA points into uninitialized data")
+	}
+	return nil, fmt.Errorf("no section contains RVA 0x%X", rva)
+}
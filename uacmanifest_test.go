@@ -0,0 +1,156 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// uacManifest 返回请求 level 的应用程序清单
+func uacManifest(level string) string {
+	return `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<assembly xmlns="urn:schemas-microsoft-com:asm.v1" manifestVersion="1.0">
+  <trustInfo xmlns="urn:schemas-microsoft-com:asm.v3">
+    <security>
+      <requestedPrivileges>
+        <requestedExecutionLevel level="` + level + `" uiAccess="false"/>
+      </requestedPrivileges>
+    </security>
+  </trustInfo>
+</assembly>`
+}
+
+// peFixture 在临时目录中写一个最小的 PE32+ 文件。resType 不为 0 时带一个 .rsrc 节，
+// 其中只有一个类型为 resType 的资源，内容是 data；为 0 时没有资源目录。
+func peFixture(t *testing.T, resType uint32, data string) string {
+	t.Helper()
+	const (
+		rsrcRVA  = 0x1000
+		rsrcFile = 0x200
+	)
+
+	// 资源目录：类型 → 名称 1 → 语言 0x409 → IMAGE_RESOURCE_DATA_ENTRY → 数据
+	var rsrc bytes.Buffer
+	dir := func(id, offset uint32) {
+		binary.Write(&rsrc, binary.LittleEndian, [4]uint32{0, 0, 0, 1 << 16}) // 一个 ID 项
+		binary.Write(&rsrc, binary.LittleEndian, [2]uint32{id, offset})
+	}
+	dir(resType, resourceSubdirectory|0x18)
+	dir(1, resourceSubdirectory|0x30)
+	dir(0x409, 0x48)
+	binary.Write(&rsrc, binary.LittleEndian, [4]uint32{rsrcRVA + 0x58, uint32(len(data)), 0, 0})
+	rsrc.WriteString(data)
+	for rsrc.Len()%0x200 != 0 {
+		rsrc.WriteByte(0)
+	}
+
+	var oh pe.OptionalHeader64
+	oh.Magic = 0x20b
+	oh.SectionAlignment = 0x1000
+	oh.FileAlignment = 0x200
+	oh.SizeOfHeaders = rsrcFile
+	oh.SizeOfImage = rsrcRVA + 0x1000
+	oh.Subsystem = pe.IMAGE_SUBSYSTEM_WINDOWS_CUI
+	oh.NumberOfRvaAndSizes = 16
+	fh := pe.FileHeader{
+		Machine:              pe.IMAGE_FILE_MACHINE_AMD64,
+		SizeOfOptionalHeader: uint16(binary.Size(oh)),
+		Characteristics:      pe.IMAGE_FILE_EXECUTABLE_IMAGE | pe.IMAGE_FILE_LARGE_ADDRESS_AWARE,
+	}
+	sh := pe.SectionHeader32{
+		VirtualSize:      uint32(rsrc.Len()),
+		VirtualAddress:   rsrcRVA,
+		SizeOfRawData:    uint32(rsrc.Len()),
+		PointerToRawData: rsrcFile,
+		Characteristics:  pe.IMAGE_SCN_CNT_INITIALIZED_DATA | pe.IMAGE_SCN_MEM_READ,
+	}
+	copy(sh.Name[:], ".rsrc")
+	if resType != 0 {
+		fh.NumberOfSections = 1
+		oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_RESOURCE] = pe.DataDirectory{VirtualAddress: rsrcRVA, Size: uint32(rsrc.Len())}
+	}
+
+	var img bytes.Buffer
+	img.WriteString("MZ")
+	img.Write(make([]byte, 0x3a))
+	binary.Write(&img, binary.LittleEndian, uint32(0x40)) // e_lfanew
+	img.WriteString("PE\x00\x00")
+	binary.Write(&img, binary.LittleEndian, fh)
+	binary.Write(&img, binary.LittleEndian, oh)
+	if resType != 0 {
+		binary.Write(&img, binary.LittleEndian, sh)
+		img.Write(make([]byte, rsrcFile-img.Len()))
+		img.Write(rsrc.Bytes())
+	}
+
+	path := filepath.Join(t.TempDir(), "fixture.exe")
+	if err := os.WriteFile(path, img.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExeExecutionLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		resType uint32
+		data    string
+		want    string
+	}{
+		{"requireAdministrator", rtManifest, uacManifest(levelRequireAdmin), levelRequireAdmin},
+		{"asInvoker", rtManifest, uacManifest("asInvoker"), "asInvoker"},
+		{"highestAvailable", rtManifest, uacManifest("highestAvailable"), "highestAvailable"},
+		{"single quotes", rtManifest, `<requestedExecutionLevel level = 'requireAdministrator'/>`, levelRequireAdmin},
+		{"manifest without execution level", rtManifest, `<assembly xmlns="urn:schemas-microsoft-com:asm.v1" manifestVersion="1.0"/>`, ""},
+		{"no manifest resource", 16, "VS_VERSION_INFO", ""}, // 只有 RT_VERSION
+		{"no resources", 0, "", ""},
+	}
+	for _, tt := range tests {
+		got, err := exeExecutionLevel(peFixture(t, tt.resType, tt.data))
+		if err != nil || got != tt.want {
+			t.Errorf("%s: exeExecutionLevel = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	notPE := filepath.Join(t.TempDir(), "script.exe")
+	os.WriteFile(notPE, []byte("@echo off\r\n"), 0644)
+	if _, err := exeExecutionLevel(notPE); err == nil {
+		t.Error("exeExecutionLevel succeeded for a file that is not a PE image")
+	}
+}
+
+func TestCheckAdminManifests(t *testing.T) {
+	tests := []struct {
+		name string
+		exe  string
+		want string // 警告中应包含的内容，空表示没有警告
+	}{
+		{"requests elevation", peFixture(t, rtManifest, uacManifest(levelRequireAdmin)), ""},
+		{"asInvoker", peFixture(t, rtManifest, uacManifest("asInvoker")), "requests asInvoker, not requireAdministrator"},
+		{"no manifest", peFixture(t, 0, ""), "has no UAC manifest"},
+	}
+	for _, tt := range tests {
+		command, _ := json.Marshal(`"` + tt.exe + `" /quiet`)
+		s := writeConfig(t, fmt.Sprintf(`{"command": %s, "require_admin": true}`, command))
+		s.interactive = true
+		var err error
+		out := captureStdout(t, func() { err = s.loadConfig() })
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		switch {
+		case tt.want == "" && out != "":
+			t.Errorf("%s: unexpected warning %q", tt.name, out)
+		case tt.want != "" && (!strings.HasPrefix(out, "Warning (require_admin): ") || !strings.Contains(out, tt.want)):
+			t.Errorf("%s: output %q, want a warning containing %q", tt.name, out, tt.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// -------------------- 配置校验规则 --------------------
+
+// ValidationRule 要求某个字段的值匹配正则表达式，
+// 例如限定 command 只能运行 C:\Program Files\ 下的程序
+type ValidationRule struct {
+	Field   string `json:"field"`   // 字段的 JSON 名，如 "command"
+	Pattern string `json:"pattern"` // Go regexp 语法；需要整体匹配时自己加 ^ 和 $
+	Message string `json:"message"` // 不匹配时的错误信息
+}
+
+// applyValidationRules 依次检查 validation_rules，第一个不满足的规则作为错误返回。
+// 正则表达式无效、字段不存在或不是字符串同样视为配置错误。
+// 空字段不检查：是否必填由各字段自己的规则决定。
+func applyValidationRules(cfg *Config) error {
+	for i, rule := range cfg.ValidationRules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("validation_rules[%d]: invalid pattern: %w", i, err)
+		}
+
+		values, err := configStringValues(cfg, rule.Field)
+		if err != nil {
+			return fmt.Errorf("validation_rules[%d]: %w", i, err)
+		}
+
+		for _, v := range values {
+			if v == "" || re.MatchString(v) {
+				continue
+			}
+			msg := rule.Message
+			if msg == "" {
+				msg = fmt.Sprintf("does not match %q", rule.Pattern)
+			}
+			return fmt.Errorf("%s: %s", rule.Field, msg)
+		}
+	}
+	return nil
+}
+
+// configStringValues 按 JSON 名取字段的字符串值；字符串数组返回全部元素。
+// command 等 CommandEntry 的字段同时检查 commands 中的每一条，
+// 否则把命令写进 commands 就能绕过规则。
+func configStringValues(cfg *Config, field string) ([]string, error) {
+	values, found, err := structStringValues(reflect.ValueOf(cfg).Elem(), field)
+	if err != nil {
+		return nil, err
+	}
+	for i := range cfg.Commands {
+		v, ok, err := structStringValues(reflect.ValueOf(&cfg.Commands[i]).Elem(), field)
+		if err != nil {
+			return nil, err
+		}
+		found = found || ok
+		values = append(values, v...)
+	}
+	if !found {
+		return nil, fmt.Errorf("unknown field %q", field)
+	}
+	return values, nil
+}
+
+func structStringValues(v reflect.Value, field string) (values []string, found bool, err error) {
+	for _, sf := range reflect.VisibleFields(v.Type()) {
+		name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if sf.Anonymous || name != field {
+			continue
+		}
+
+		f := v.FieldByIndex(sf.Index)
+		if f.Kind() == reflect.Pointer {
+			if f.IsNil() {
+				return nil, true, nil
+			}
+			f = f.Elem()
+		}
+		switch {
+		case f.Kind() == reflect.String:
+			return []string{f.String()}, true, nil
+		case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.String:
+			values := make([]string, f.Len())
+			for j := range values {
+				values[j] = f.Index(j).String()
+			}
+			return values, true, nil
+		default:
+			return nil, true, fmt.Errorf("field %q is not a string", field)
+		}
+	}
+	return nil, false, nil
+}
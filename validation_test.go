@@ -0,0 +1,47 @@
+//go:build windows
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidationRules(t *testing.T) {
+	const programFiles = `{"field": "command", "pattern": "^(?i)C:\\\\Program Files\\\\", "message": "command must be under C:\\Program Files\\"}`
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string // 空表示加载成功
+	}{
+		{"matching", `{"command": "C:\\Program Files\\Backup\\backup.exe", "validation_rules": [` + programFiles + `]}`, ""},
+		{"matching ignores case", `{"command": "c:\\program files\\Backup\\backup.exe", "validation_rules": [` + programFiles + `]}`, ""},
+		{"not matching", `{"command": "C:\\Temp\\backup.exe", "validation_rules": [` + programFiles + `]}`,
+			`command: command must be under C:\Program Files\`},
+		{"default message", `{"command": "C:\\Temp\\backup.exe", "validation_rules": [{"field": "command", "pattern": "^D:"}]}`,
+			`command: does not match "^D:"`},
+		{"invalid pattern", `{"command": "backup.exe", "validation_rules": [{"field": "command", "pattern": "("}]}`,
+			"validation_rules[0]: invalid pattern: error parsing regexp"},
+		{"second rule", `{"command": "backup.exe", "validation_rules": [{"field": "command", "pattern": "backup"}, {"field": "command", "pattern": "["}]}`,
+			"validation_rules[1]: invalid pattern"},
+		{"unknown field", `{"command": "backup.exe", "validation_rules": [{"field": "comand", "pattern": "x"}]}`,
+			`validation_rules[0]: unknown field "comand"`},
+		{"not a string", `{"command": "backup.exe", "timeout": 60, "validation_rules": [{"field": "timeout", "pattern": "6"}]}`,
+			`validation_rules[0]: field "timeout" is not a string`},
+		{"empty field not checked", `{"command": "backup.exe", "validation_rules": [{"field": "fallback_command", "pattern": "^never$"}]}`, ""},
+		{"commands checked too", `{"commands": [{"command": "C:\\Program Files\\a.exe"}, {"command": "C:\\Temp\\b.exe"}],
+		   "validation_rules": [` + programFiles + `]}`, `command: command must be under C:\Program Files\`},
+		{"string arrays", `{"command": "backup.bat", "cmd_extra_args": ["/V:ON", "/Q"], "validation_rules": [{"field": "cmd_extra_args", "pattern": "^/[A-Z]:?[A-Z]*$"}]}`, ""},
+		{"string array element", `{"command": "backup.bat", "cmd_extra_args": ["/V:ON", "& del"], "validation_rules": [{"field": "cmd_extra_args", "pattern": "^/"}]}`,
+			`cmd_extra_args: does not match "^/"`},
+	}
+	for _, tt := range tests {
+		err := writeConfig(t, tt.config).loadConfig()
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: loadConfig: %v", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: loadConfig error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
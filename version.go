@@ -0,0 +1,20 @@
+//go:build windows
+
+package main
+
+import "fmt"
+
+// 构建信息，发布时通过链接参数注入：
+//
+//	go build -ldflags "-X main.version=0.1.3 -X main.commit=abc1234 -X main.buildDate=2025-01-01T00:00:00Z"
+//
+// 见 Makefile。
+var (
+	version   = "0.1.2"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+func versionString() string {
+	return fmt.Sprintf("WinPSP version %s (commit %s, built %s)", version, commit, buildDate)
+}
@@ -0,0 +1,21 @@
+//go:build windows
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVersionString(t *testing.T) {
+	if got := versionString(); !strings.HasPrefix(got, "WinPSP version "+version) || version == "" {
+		t.Errorf("versionString() = %q", got)
+	}
+
+	// 链接参数注入的值
+	defer func(v, c, d string) { version, commit, buildDate = v, c, d }(version, commit, buildDate)
+	version, commit, buildDate = "0.1.3", "abc1234", "2025-01-01T00:00:00Z"
+	if got, want := versionString(), "WinPSP version 0.1.3 (commit abc1234, built 2025-01-01T00:00:00Z)"; got != want {
+		t.Errorf("versionString() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,287 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	defaultVSSVolume = `C:\`
+	vssEnvVar        = "WINPSP_VSS_SHADOW"
+	vssAsyncTimeout  = 120 * time.Second
+)
+
+// VSS 常量（vss.h / vsbackup.h）
+const (
+	vssCtxBackup          = 0
+	vssBtCopy             = 5 // 复制备份：不影响应用自己的备份历史（如截断 SQL Server 日志）
+	vssObjectSnapshotSet  = 2
+	vssSAsyncPending      = 0x00042309
+	vssSAsyncFinished     = 0x0004230A
+	vssSAsyncCancelled    = 0x0004230B
+	rpcCAuthnLevelPktPriv = 6
+	rpcCImpLevelIdentify  = 2
+	eoacDynamicCloaking   = 0x40
+	rpcETooLate           = 0x80010119
+)
+
+// IVssBackupComponents 虚表索引（vsbackup.h 中的声明顺序）
+const (
+	vssRelease               = 2
+	vssInitializeForBackup   = 5
+	vssSetBackupState        = 6
+	vssGatherWriterMetadata  = 9
+	vssPrepareForBackup      = 14
+	vssBackupComplete        = 27
+	vssSetContext            = 35
+	vssStartSnapshotSet      = 36
+	vssAddToSnapshotSet      = 37
+	vssDoSnapshotSet         = 38
+	vssDeleteSnapshots       = 39
+	vssGetSnapshotProperties = 42
+)
+
+// IVssAsync 虚表索引
+const (
+	vssAsyncRelease     = 2
+	vssAsyncWait        = 4
+	vssAsyncQueryStatus = 5
+)
+
+var (
+	modvssapi                     = windows.NewLazySystemDLL("vssapi.dll")
+	procCreateVssBackupComponents = modvssapi.NewProc("CreateVssBackupComponentsInternal")
+	procVssFreeSnapshotProperties = modvssapi.NewProc("VssFreeSnapshotPropertiesInternal")
+	modole32                      = windows.NewLazySystemDLL("ole32.dll")
+	procCoInitializeSecurity      = modole32.NewProc("CoInitializeSecurity")
+)
+
+// -------------------- VSS 卷影副本 --------------------
+
+// vss_quiesce 为 true 时，在执行命令前为 vss_volume 创建卷影副本：
+// VSS writer（SQL Server、Exchange 等）会先把数据刷到磁盘并冻结写入，
+// 命令通过 %WINPSP_VSS_SHADOW% 读取一致的快照，结束后删除快照。
+
+// shadowCopy 是一个已创建的卷影副本，Close 删除它
+type shadowCopy interface {
+	Path() string // 快照设备路径，如 \\?\GLOBALROOT\Device\HarddiskVolumeShadowCopy3\
+	Close() error
+}
+
+// createShadowCopy 可在调试时替换
+var createShadowCopy = createVSSSnapshot
+
+// comObject 对应 COM 接口指针：第一个字段是指向虚表的指针
+type comObject struct {
+	vtbl *[64]uintptr
+}
+
+func (o *comObject) call(method int, args ...uintptr) uintptr {
+	r, _, _ := syscall.SyscallN(o.vtbl[method], append([]uintptr{uintptr(unsafe.Pointer(o))}, args...)...)
+	return r
+}
+
+func (o *comObject) release() {
+	o.call(vssRelease)
+}
+
+type hresultError struct {
+	op string
+	hr uint32
+}
+
+func (e *hresultError) Error() string {
+	return fmt.Sprintf("VSS %s failed: HRESULT 0x%08X", e.op, e.hr)
+}
+
+func checkHR(op string, r uintptr) error {
+	if int32(r) < 0 {
+		return &hresultError{op: op, hr: uint32(r)}
+	}
+	return nil
+}
+
+// vssSnapshotProp 对应 VSS_SNAPSHOT_PROP
+type vssSnapshotProp struct {
+	SnapshotID           windows.GUID
+	SnapshotSetID        windows.GUID
+	SnapshotsCount       int32
+	SnapshotDeviceObject *uint16
+	OriginalVolumeName   *uint16
+	OriginatingMachine   *uint16
+	ServiceMachine       *uint16
+	ExposedName          *uint16
+	ExposedPath          *uint16
+	ProviderID           windows.GUID
+	SnapshotAttributes   int32
+	CreationTimestamp    int64
+	Status               int32
+}
+
+// guidArgs 按调用约定展开按值传递的 VSS_ID（16 字节结构体）：
+// x64 传指针，ARM64 放在两个寄存器里，x86 压栈四个 DWORD
+func guidArgs(g *windows.GUID) []uintptr {
+	switch runtime.GOARCH {
+	case "amd64":
+		return []uintptr{uintptr(unsafe.Pointer(g))}
+	case "arm64":
+		w := (*[2]uint64)(unsafe.Pointer(g))
+		return []uintptr{uintptr(w[0]), uintptr(w[1])}
+	default:
+		w := (*[4]uint32)(unsafe.Pointer(g))
+		return []uintptr{uintptr(w[0]), uintptr(w[1]), uintptr(w[2]), uintptr(w[3])}
+	}
+}
+
+// waitAsync 等待 IVssAsync 完成并释放它。r 是返回 async 的那次调用的 HRESULT。
+func waitAsync(op string, r uintptr, async *comObject) error {
+	if err := checkHR(op, r); err != nil {
+		return err
+	}
+	defer async.release()
+
+	if err := checkHR(op, async.call(vssAsyncWait, uintptr(vssAsyncTimeout.Milliseconds()))); err != nil {
+		return err
+	}
+
+	var hr int32
+	if err := checkHR(op, async.call(vssAsyncQueryStatus, uintptr(unsafe.Pointer(&hr)), 0)); err != nil {
+		return err
+	}
+	switch uint32(hr) {
+	case vssSAsyncFinished:
+		return nil
+	case vssSAsyncPending:
+		return fmt.Errorf("VSS %s did not finish within %s", op, vssAsyncTimeout)
+	case vssSAsyncCancelled:
+		return fmt.Errorf("VSS %s was cancelled", op)
+	default:
+		return checkHR(op, uintptr(uint32(hr)))
+	}
+}
+
+type vssSnapshot struct {
+	comp  *comObject
+	setID windows.GUID
+	path  string
+}
+
+func (v *vssSnapshot) Path() string { return v.path }
+
+// createVSSSnapshot 为 volume（如 C:\）创建非持久的卷影副本。
+// COM 调用需要固定在同一个系统线程上，直到 Close。
+func createVSSSnapshot(volume string) (_ shadowCopy, err error) {
+	if err := procCreateVssBackupComponents.Find(); err != nil {
+		return nil, err
+	}
+
+	runtime.LockOSThread()
+	// S_FALSE：本线程已初始化过 COM，同样需要配对的 CoUninitialize
+	if err := windows.CoInitializeEx(0, windows.COINIT_MULTITHREADED); err != nil && err != windows.Errno(windows.S_FALSE) {
+		runtime.UnlockOSThread()
+		return nil, fmt.Errorf("CoInitializeEx: %w", err)
+	}
+
+	// 允许 writer 回调本进程；进程里已经初始化过安全设置时会返回 RPC_E_TOO_LATE
+	r, _, _ := procCoInitializeSecurity.Call(0, ^uintptr(0), 0, 0,
+		rpcCAuthnLevelPktPriv, rpcCImpLevelIdentify, 0, eoacDynamicCloaking, 0)
+	if int32(r) < 0 && uint32(r) != rpcETooLate {
+		windows.CoUninitialize()
+		runtime.UnlockOSThread()
+		return nil, checkHR("CoInitializeSecurity", r)
+	}
+
+	v := &vssSnapshot{}
+	r, _, _ = procCreateVssBackupComponents.Call(uintptr(unsafe.Pointer(&v.comp)))
+	if err := checkHR("CreateVssBackupComponents", r); err != nil {
+		windows.CoUninitialize()
+		runtime.UnlockOSThread()
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			v.comp.release()
+			windows.CoUninitialize()
+			runtime.UnlockOSThread()
+		}
+	}()
+
+	if err := checkHR("InitializeForBackup", v.comp.call(vssInitializeForBackup, 0)); err != nil {
+		return nil, err
+	}
+	if err := checkHR("SetContext", v.comp.call(vssSetContext, vssCtxBackup)); err != nil {
+		return nil, err
+	}
+	if err := checkHR("SetBackupState", v.comp.call(vssSetBackupState, 0, 0, vssBtCopy, 0)); err != nil {
+		return nil, err
+	}
+
+	var async *comObject
+	r = v.comp.call(vssGatherWriterMetadata, uintptr(unsafe.Pointer(&async)))
+	if err := waitAsync("GatherWriterMetadata", r, async); err != nil {
+		return nil, err
+	}
+
+	if err := checkHR("StartSnapshotSet", v.comp.call(vssStartSnapshotSet, uintptr(unsafe.Pointer(&v.setID)))); err != nil {
+		return nil, err
+	}
+
+	vol, err := windows.UTF16PtrFromString(volume)
+	if err != nil {
+		return nil, err
+	}
+	var providerID, snapshotID windows.GUID // GUID_NULL：由系统选择提供程序
+	args := append([]uintptr{uintptr(unsafe.Pointer(vol))}, guidArgs(&providerID)...)
+	args = append(args, uintptr(unsafe.Pointer(&snapshotID)))
+	if err := checkHR("AddToSnapshotSet", v.comp.call(vssAddToSnapshotSet, args...)); err != nil {
+		return nil, err
+	}
+	runtime.KeepAlive(vol)
+	runtime.KeepAlive(&providerID)
+
+	r = v.comp.call(vssPrepareForBackup, uintptr(unsafe.Pointer(&async)))
+	if err := waitAsync("PrepareForBackup", r, async); err != nil {
+		return nil, err
+	}
+	r = v.comp.call(vssDoSnapshotSet, uintptr(unsafe.Pointer(&async)))
+	if err := waitAsync("DoSnapshotSet", r, async); err != nil {
+		return nil, err
+	}
+
+	var prop vssSnapshotProp
+	args = append(guidArgs(&snapshotID), uintptr(unsafe.Pointer(&prop)))
+	if err := checkHR("GetSnapshotProperties", v.comp.call(vssGetSnapshotProperties, args...)); err != nil {
+		return nil, err
+	}
+	runtime.KeepAlive(&snapshotID)
+	v.path = windows.UTF16PtrToString(prop.SnapshotDeviceObject) + `\`
+	procVssFreeSnapshotProperties.Call(uintptr(unsafe.Pointer(&prop)))
+
+	return v, nil
+}
+
+// Close 通知 writer 备份结束并删除快照
+func (v *vssSnapshot) Close() error {
+	defer runtime.UnlockOSThread()
+	defer windows.CoUninitialize()
+	defer v.comp.release()
+
+	var async *comObject
+	r := v.comp.call(vssBackupComplete, uintptr(unsafe.Pointer(&async)))
+	_ = waitAsync("BackupComplete", r, async)
+
+	var deleted int32
+	var nondeleted windows.GUID
+	args := append(guidArgs(&v.setID), vssObjectSnapshotSet, 1,
+		uintptr(unsafe.Pointer(&deleted)), uintptr(unsafe.Pointer(&nondeleted)))
+	err := checkHR("DeleteSnapshots", v.comp.call(vssDeleteSnapshots, args...))
+	runtime.KeepAlive(&v.setID)
+	return err
+}
@@ -0,0 +1,200 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestCheckHR(t *testing.T) {
+	tests := []struct {
+		r    uintptr
+		want string // 空表示成功
+	}{
+		{0, ""},
+		{1, ""}, // S_FALSE
+		{vssSAsyncFinished, ""},
+		{0x80042308, "VSS DoSnapshotSet failed: HRESULT 0x80042308"},
+		{0x80070005, "VSS DoSnapshotSet failed: HRESULT 0x80070005"},
+	}
+	for _, tt := range tests {
+		got := ""
+		if err := checkHR("DoSnapshotSet", tt.r); err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("checkHR(0x%X) = %q, want %q", tt.r, got, tt.want)
+		}
+	}
+}
+
+// fakeVSSAsync 是用 Go 回调实现的 IVssAsync，第一个字段是虚表指针
+type fakeVSSAsync struct {
+	comObject
+	waitHR   uint32 // Wait 的返回值
+	status   uint32 // QueryStatus 报告的状态
+	waited   uint32 // Wait 收到的超时（毫秒）
+	released int
+}
+
+var (
+	fakeVSSAsyncVtbl     [64]uintptr
+	fakeVSSAsyncVtblOnce sync.Once
+)
+
+// newFakeVSSAsync 返回 Wait 返回 waitHR、QueryStatus 报告 status 的 IVssAsync。
+// 回调的数量有上限，所有对象共用一张虚表。
+func newFakeVSSAsync(waitHR, status uint32) *fakeVSSAsync {
+	fakeVSSAsyncVtblOnce.Do(func() {
+		fakeVSSAsyncVtbl[vssAsyncRelease] = windows.NewCallback(func(a *fakeVSSAsync) uintptr {
+			a.released++
+			return 0
+		})
+		fakeVSSAsyncVtbl[vssAsyncWait] = windows.NewCallback(func(a *fakeVSSAsync, ms uintptr) uintptr {
+			a.waited = uint32(ms)
+			return uintptr(a.waitHR)
+		})
+		fakeVSSAsyncVtbl[vssAsyncQueryStatus] = windows.NewCallback(func(a *fakeVSSAsync, hr *int32, reserved uintptr) uintptr {
+			*hr = int32(a.status)
+			return 0
+		})
+	})
+	return &fakeVSSAsync{comObject: comObject{vtbl: &fakeVSSAsyncVtbl}, waitHR: waitHR, status: status}
+}
+
+func TestWaitAsync(t *testing.T) {
+	tests := []struct {
+		name         string
+		r            uintptr // 返回 IVssAsync 的那次调用的 HRESULT
+		waitHR       uint32
+		status       uint32
+		want         string // 空表示成功
+		wantReleased int
+	}{
+		{"finished", 0, 0, vssSAsyncFinished, "", 1},
+		{"call failed", 0x80042301, 0, vssSAsyncFinished, "VSS PrepareForBackup failed: HRESULT 0x80042301", 0},
+		{"wait failed", 0, 0x80070005, vssSAsyncFinished, "VSS PrepareForBackup failed: HRESULT 0x80070005", 1},
+		{"still pending", 0, 0, vssSAsyncPending, "VSS PrepareForBackup did not finish within 2m0s", 1},
+		{"cancelled", 0, 0, vssSAsyncCancelled, "VSS PrepareForBackup was cancelled", 1},
+		{"writer failed", 0, 0, 0x800423F2, "VSS PrepareForBackup failed: HRESULT 0x800423F2", 1},
+	}
+	for _, tt := range tests {
+		a := newFakeVSSAsync(tt.waitHR, tt.status)
+		got := ""
+		if err := waitAsync("PrepareForBackup", tt.r, &a.comObject); err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("%s: waitAsync = %q, want %q", tt.name, got, tt.want)
+		}
+		if a.released != tt.wantReleased {
+			t.Errorf("%s: released %d times, want %d", tt.name, a.released, tt.wantReleased)
+		}
+		if tt.r == 0 && a.waited != uint32(vssAsyncTimeout.Milliseconds()) {
+			t.Errorf("%s: Wait called with %d ms", tt.name, a.waited)
+		}
+	}
+}
+
+// fakeShadowCopy 代替真正的卷影副本
+type fakeShadowCopy struct {
+	path   string
+	closed bool
+}
+
+func (f *fakeShadowCopy) Path() string { return f.path }
+
+func (f *fakeShadowCopy) Close() error {
+	f.closed = true
+	return nil
+}
+
+// mockShadowCopy 让 createShadowCopy 返回 shadow（err 非 nil 时失败），返回请求快照的卷
+func mockShadowCopy(t *testing.T, shadow *fakeShadowCopy, err error) *string {
+	t.Helper()
+	var volume string
+	orig := createShadowCopy
+	createShadowCopy = func(v string) (shadowCopy, error) {
+		volume = v
+		if err != nil {
+			return nil, err
+		}
+		return shadow, nil
+	}
+	t.Cleanup(func() { createShadowCopy = orig })
+	return &volume
+}
+
+// shutdownLog 执行一次关机处理，返回写出的日志内容
+func shutdownLog(t *testing.T, s *winpspService) string {
+	t.Helper()
+	if err := s.handleShutdownOnce(); err != nil {
+		t.Fatal(err)
+	}
+	logs, _ := filepath.Glob(filepath.Join(filepath.Dir(s.configPath), logFilePrefix+"*"+logFileExt))
+	if len(logs) != 1 {
+		t.Fatalf("log files: %q, want one", logs)
+	}
+	data, err := os.ReadFile(logs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+const vssConfig = `{
+  "command": "cmd.exe /c echo shadow=%WINPSP_VSS_SHADOW%",
+  "vss_quiesce": true,
+  "vss_volume": "D:"
+}`
+
+func TestShutdownVSS(t *testing.T) {
+	shadow := &fakeShadowCopy{path: `\\?\GLOBALROOT\Device\HarddiskVolumeShadowCopy7\`}
+	volume := mockShadowCopy(t, shadow, nil)
+	s := loadedService(t, vssConfig)
+	s.interactive = true
+
+	log := shutdownLog(t, s)
+	if *volume != `D:\` {
+		t.Errorf("snapshot requested for %q, want D:\\", *volume)
+	}
+	for _, want := range []string{
+		`VSS snapshot of D:\ created: ` + shadow.path,
+		"shadow=" + shadow.path,
+		"VSS snapshot deleted",
+	} {
+		if !strings.Contains(log, want) {
+			t.Errorf("log lacks %q:\n%s", want, log)
+		}
+	}
+	if !shadow.closed {
+		t.Error("snapshot not deleted after the command")
+	}
+}
+
+func TestShutdownVSSFailed(t *testing.T) {
+	mockShadowCopy(t, nil, errors.New("VSS DoSnapshotSet failed: HRESULT 0x80042316"))
+	s := loadedService(t, vssConfig)
+	s.interactive = true
+
+	// 快照失败时命令照常执行，只是没有 %WINPSP_VSS_SHADOW%
+	log := shutdownLog(t, s)
+	for _, want := range []string{
+		`Warning: VSS snapshot of D:\ failed, running without it: VSS DoSnapshotSet failed: HRESULT 0x80042316`,
+		"shadow=%WINPSP_VSS_SHADOW%",
+	} {
+		if !strings.Contains(log, want) {
+			t.Errorf("log lacks %q:\n%s", want, log)
+		}
+	}
+	if strings.Contains(log, "VSS snapshot deleted") {
+		t.Errorf("log reports deleting a snapshot that was never created:\n%s", log)
+	}
+}
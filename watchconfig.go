@@ -0,0 +1,175 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const watchPollInterval = 500 * time.Millisecond
+
+// -------------------- --watch-config --------------------
+
+// watchConfig 打印当前配置，然后每 500 ms 检查一次配置文件，内容变化时
+// 输出逐字段的差异并重新校验，直到收到 Ctrl+C。
+// 用于观察配置管理工具（GPO、Ansible 等）何时、如何改写了配置。
+func watchConfig(configPath string, w io.Writer) error {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	return watchConfigUntil(configPath, w, interrupt)
+}
+
+// watchConfigUntil 同 watchConfig，在 stop 收到值时返回
+func watchConfigUntil(configPath string, w io.Writer, stop <-chan os.Signal) error {
+	data, err := readConfigFile(configPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	fmt.Fprintf(w, "==> %s <==\n", configPath)
+	if data == nil {
+		fmt.Fprintln(w, "(file does not exist)")
+	} else {
+		w.Write(bytes.TrimRight(data, "\r\n"))
+		fmt.Fprintln(w)
+		printConfigValidation(configPath, w)
+	}
+
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+
+		// 文件可能正在被写入，读取失败时下次再试
+		cur, err := readConfigFile(configPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if bytes.Equal(cur, data) {
+			continue
+		}
+
+		fmt.Fprintf(w, "\n[%s] ", time.Now().Format("2006-01-02 15:04:05"))
+		switch {
+		case cur == nil:
+			fmt.Fprintln(w, "Config file removed")
+		case data == nil:
+			fmt.Fprintln(w, "Config file created")
+			printConfigDiff(nil, cur, w)
+			printConfigValidation(configPath, w)
+		default:
+			fmt.Fprintln(w, "Config file changed")
+			printConfigDiff(data, cur, w)
+			printConfigValidation(configPath, w)
+		}
+		data = cur
+	}
+}
+
+func printConfigValidation(configPath string, w io.Writer) {
+	s := &winpspService{configPath: configPath}
+	if err := s.loadConfig(); err != nil {
+		fmt.Fprintf(w, "Config error: %v\n", err)
+		return
+	}
+	fmt.Fprintln(w, "Config valid")
+}
+
+// printConfigDiff 按字段路径列出两份配置的差异：+ 新增，- 删除，~ 修改
+func printConfigDiff(old, cur []byte, w io.Writer) {
+	before, err := flattenConfigJSON(old)
+	if err != nil {
+		before = map[string]string{}
+	}
+	after, err := flattenConfigJSON(cur)
+	if err != nil {
+		fmt.Fprintf(w, "  (not valid JSON: %v)\n", err)
+		return
+	}
+
+	keys := make([]string, 0, len(before)+len(after))
+	for k := range before {
+		keys = append(keys, k)
+	}
+	for k := range after {
+		if _, ok := before[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	changed := false
+	for _, k := range keys {
+		b, inBefore := before[k]
+		a, inAfter := after[k]
+		switch {
+		case !inBefore:
+			fmt.Fprintf(w, "  + %s: %s\n", k, a)
+		case !inAfter:
+			fmt.Fprintf(w, "  - %s: %s\n", k, b)
+		case a != b:
+			fmt.Fprintf(w, "  ~ %s: %s -> %s\n", k, b, a)
+		default:
+			continue
+		}
+		changed = true
+	}
+	if !changed {
+		fmt.Fprintln(w, "  (no field changes; formatting only)")
+	}
+}
+
+// flattenConfigJSON 把 JSON 展开为 "字段路径 → 值" 的形式，如 commands[0].timeout
+func flattenConfigJSON(data []byte) (map[string]string, error) {
+	out := map[string]string{}
+	if data == nil {
+		return out, nil
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	flattenValue("", v, out)
+	return out, nil
+}
+
+func flattenValue(path string, v any, out map[string]string) {
+	switch t := v.(type) {
+	case map[string]any:
+		if len(t) == 0 {
+			out[path] = "{}"
+		}
+		for k, child := range t {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			flattenValue(p, child, out)
+		}
+	case []any:
+		if len(t) == 0 {
+			out[path] = "[]"
+		}
+		for i, child := range t {
+			flattenValue(path+"["+strconv.Itoa(i)+"]", child, out)
+		}
+	default:
+		b, _ := json.Marshal(t)
+		out[path] = string(b)
+	}
+}
@@ -0,0 +1,151 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlattenConfigJSON(t *testing.T) {
+	tests := []struct {
+		data string
+		want map[string]string
+	}{
+		{`{"command": "a.exe", "timeout": 30}`, map[string]string{"command": `"a.exe"`, "timeout": "30"}},
+		{`{"commands": [{"command": "a.exe", "timeout": 5}, {"command": "b.exe"}]}`,
+			map[string]string{"commands[0].command": `"a.exe"`, "commands[0].timeout": "5", "commands[1].command": `"b.exe"`}},
+		{`{"env": {}, "cleanup_patterns": [], "vss_quiesce": true, "statsd_prefix": null}`,
+			map[string]string{"env": "{}", "cleanup_patterns": "[]", "vss_quiesce": "true", "statsd_prefix": "null"}},
+	}
+	for _, tt := range tests {
+		got, err := flattenConfigJSON([]byte(tt.data))
+		if err != nil {
+			t.Errorf("flattenConfigJSON(%s): %v", tt.data, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("flattenConfigJSON(%s) = %v, want %v", tt.data, got, tt.want)
+			continue
+		}
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Errorf("flattenConfigJSON(%s)[%s] = %q, want %q", tt.data, k, got[k], v)
+			}
+		}
+	}
+	if _, err := flattenConfigJSON([]byte(`{"command": `)); err == nil {
+		t.Error("flattenConfigJSON accepted invalid JSON")
+	}
+}
+
+func TestPrintConfigDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, cur string
+		want     string
+	}{
+		{"changed", `{"command": "a.exe", "timeout": 30}`, `{"command": "a.exe", "timeout": 60}`,
+			"  ~ timeout: 30 -> 60\n"},
+		{"added and removed", `{"command": "a.exe", "log_count": 7}`, `{"command": "a.exe", "retry_count": 2}`,
+			"  - log_count: 7\n  + retry_count: 2\n"},
+		{"formatting only", `{"command":"a.exe"}`, "{\r\n  \"command\": \"a.exe\"\r\n}\r\n",
+			"  (no field changes; formatting only)\n"},
+		{"created", "", `{"command": "a.exe"}`, "  + command: \"a.exe\"\n"},
+		{"invalid JSON", `{"command": "a.exe"}`, `{"command": `, "  (not valid JSON: "},
+	}
+	for _, tt := range tests {
+		var old []byte
+		if tt.old != "" {
+			old = []byte(tt.old)
+		}
+		var out strings.Builder
+		printConfigDiff(old, []byte(tt.cur), &out)
+		if !strings.HasPrefix(out.String(), tt.want) {
+			t.Errorf("%s: diff = %q, want %q", tt.name, out.String(), tt.want)
+		}
+	}
+}
+
+// 在 watchConfigUntil 运行时修改真实的配置文件，检查每次修改的输出
+func TestWatchConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "winpsp.json")
+	if err := os.WriteFile(path, []byte(`{"command": "cmd.exe /c echo a", "timeout": 30}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out syncBuffer
+	stop := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() { done <- watchConfigUntil(path, &out, stop) }()
+
+	// waitFor 等待输出中出现 want，返回之后新增的部分
+	seen := 0
+	waitFor := func(step, want string) string {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if s := out.String(); strings.Contains(s[seen:], want) {
+				i := seen + strings.Index(s[seen:], want) + len(want)
+				got := s[seen:i]
+				seen = i
+				return got
+			}
+			time.Sleep(watchPollInterval / 5)
+		}
+		t.Fatalf("%s: %q not printed; output:\n%s", step, want, out.String()[seen:])
+		return ""
+	}
+
+	if got := waitFor("start", "Config valid\n"); !strings.Contains(got, `"timeout": 30`) || !strings.HasPrefix(got, "==> "+path+" <==\n") {
+		t.Errorf("start: printed %q, want the current config", got)
+	}
+
+	steps := []struct {
+		name  string
+		write string // 空表示删除文件
+		want  []string
+	}{
+		{"changed", `{"command": "cmd.exe /c echo a", "timeout": 60}`, []string{"Config file changed\n", "  ~ timeout: 30 -> 60\n", "Config valid\n"}},
+		{"invalid", `{"command": "cmd.exe /c echo a", "timeout": 60, "log_write_mode": "bogus"}`,
+			[]string{"Config file changed\n", "  + log_write_mode: \"bogus\"\n", "Config error: invalid log_write_mode"}},
+		{"removed", "", []string{"Config file removed\n"}},
+		{"created", `{"command": "cmd.exe /c echo b"}`, []string{"Config file created\n", "  + command: \"cmd.exe /c echo b\"\n", "Config valid\n"}},
+	}
+	for _, st := range steps {
+		// 先写临时文件再改名，轮询不会读到写了一半的文件
+		var err error
+		if st.write == "" {
+			err = os.Remove(path)
+		} else if err = os.WriteFile(path+".tmp", []byte(st.write), 0644); err == nil {
+			err = os.Rename(path+".tmp", path)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range st.want {
+			waitFor(st.name, want)
+		}
+	}
+
+	// 内容没有变化（只更新了修改时间）时不输出
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	time.Sleep(3 * watchPollInterval)
+	if extra := out.String()[seen:]; extra != "" {
+		t.Errorf("printed %q although the content did not change", extra)
+	}
+
+	stop <- os.Interrupt
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("watchConfigUntil: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchConfigUntil did not return after the interrupt")
+	}
+}
@@ -0,0 +1,127 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultWebhookTimeoutSecs = 10
+	webhookTestMaxBody        = 64 << 10 // --test-webhook 最多打印的响应内容
+)
+
+// -------------------- Webhook 通知 --------------------
+
+// webhookPayload 是运行结束后 POST 到 webhook_url 的 JSON
+type webhookPayload struct {
+	Host string `json:"host"`
+	runRecord
+}
+
+func webhookHost() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown-host"
+	}
+	return host
+}
+
+// sendWebhook 把本次运行结果 POST 到 webhook_url。
+// 关机期间网络可能已经不可用，超时必须短，失败只记录日志。
+func (s *winpspService) sendWebhook(rec *runRecord) error {
+	resp, err := postWebhook(s.config.Load(), webhookPayload{Host: webhookHost(), runRecord: *rec})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// postWebhook 把 payload 以 JSON POST 到 webhook_url，使用 tls_* 设置和 token。
+// 调用方负责关闭响应。
+func postWebhook(cfg *Config, payload any) (*http.Response, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, cfg.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	token, err := webhookToken(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	timeout := defaultWebhookTimeoutSecs
+	if cfg.WebhookTimeoutSecs != nil {
+		timeout = *cfg.WebhookTimeoutSecs
+	}
+	client, err := newHTTPClient(cfg, time.Duration(timeout)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// -------------------- --test-webhook --------------------
+
+// webhookTestPayload 在普通的运行结果之外加上 "test": true，接收方可以据此忽略
+type webhookTestPayload struct {
+	Test bool `json:"test"`
+	webhookPayload
+}
+
+// testWebhook 实现 --test-webhook：POST 一份虚构的成功结果，打印响应的状态和内容。
+// 返回 true 表示服务器返回了 2xx。
+func testWebhook(configPath string) bool {
+	s := &winpspService{configPath: configPath}
+	if err := s.loadConfig(); err != nil {
+		fmt.Printf("Config error: %v\n", err)
+		return false
+	}
+	cfg := s.config.Load()
+	if cfg.WebhookURL == "" {
+		fmt.Println("webhook_url is not set in the config file.")
+		return false
+	}
+
+	rec := runRecord{
+		LastRun:  time.Now(),
+		Command:  "winpsp --test-webhook",
+		Result:   resultSuccess,
+		Attempts: 1,
+	}
+	fmt.Printf("POST %s\n", cfg.WebhookURL)
+	resp, err := postWebhook(cfg, webhookTestPayload{Test: true, webhookPayload: webhookPayload{Host: webhookHost(), runRecord: rec}})
+	if err != nil {
+		fmt.Printf("Webhook error: %v\n", err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	// 重定向已由 http.Client 跟随，这里是最终的响应
+	fmt.Println(resp.Status)
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, webhookTestMaxBody))
+	if len(body) > 0 {
+		fmt.Println(strings.TrimRight(string(body), "\r\n"))
+	}
+	return resp.StatusCode >= 200 && resp.StatusCode <= 299
+}
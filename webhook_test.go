@@ -0,0 +1,146 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// webhookRequests 记录 webhook 服务器收到的请求
+type webhookRequests struct {
+	mu     sync.Mutex
+	bodies [][]byte
+	auth   []string
+}
+
+func (wr *webhookRequests) record(r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	wr.bodies = append(wr.bodies, body)
+	wr.auth = append(wr.auth, r.Header.Get("Authorization"))
+}
+
+func TestTestWebhook(t *testing.T) {
+	var got webhookRequests
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		got.record(r)
+		w.Write([]byte(`{"received": true}` + "\n"))
+	})
+	mux.HandleFunc("/created", func(w http.ResponseWriter, r *http.Request) {
+		got.record(r)
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusTemporaryRedirect) // 307 保留方法和内容
+	})
+	mux.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		got.record(r)
+		http.Error(w, "payload rejected", http.StatusBadRequest)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		path    string
+		token   string
+		want    bool
+		wantOut []string
+	}{
+		{"ok", "/ok", "", true, []string{"POST " + srv.URL + "/ok", "200 OK", `{"received": true}`}},
+		{"token", "/created", "s3cr3t", true, []string{"201 Created"}},
+		{"redirect followed", "/moved", "", true, []string{"200 OK", `{"received": true}`}},
+		{"rejected", "/fail", "", false, []string{"400 Bad Request", "payload rejected"}},
+		{"not found", "/missing", "", false, []string{"404 Not Found"}},
+	}
+	for _, tt := range tests {
+		got = webhookRequests{}
+		data, _ := json.Marshal(map[string]string{"command": "backup.exe", "webhook_url": srv.URL + tt.path, "webhook_token": tt.token})
+		s := writeConfig(t, string(data))
+
+		var ok bool
+		out := captureStdout(t, func() { ok = testWebhook(s.configPath) })
+		if ok != tt.want {
+			t.Errorf("%s: testWebhook = %v, want %v\n%s", tt.name, ok, tt.want, out)
+		}
+		for _, want := range tt.wantOut {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s: output %q does not contain %q", tt.name, out, want)
+			}
+		}
+		if tt.path == "/missing" {
+			continue
+		}
+
+		if len(got.bodies) != 1 {
+			t.Errorf("%s: server received %d requests, want 1", tt.name, len(got.bodies))
+			continue
+		}
+		var payload map[string]any
+		if err := json.Unmarshal(got.bodies[0], &payload); err != nil {
+			t.Errorf("%s: payload is not JSON: %v", tt.name, err)
+			continue
+		}
+		if payload["test"] != true || payload["exit_code"] != float64(0) || payload["result"] != resultSuccess || payload["host"] != webhookHost() {
+			t.Errorf("%s: payload %v", tt.name, payload)
+		}
+		if want := map[bool]string{true: "Bearer " + tt.token, false: ""}[tt.token != ""]; got.auth[0] != want {
+			t.Errorf("%s: Authorization %q, want %q", tt.name, got.auth[0], want)
+		}
+	}
+}
+
+func TestTestWebhookConfigErrors(t *testing.T) {
+	for _, data := range []string{
+		`{"command": "backup.exe"}`, // 没有 webhook_url
+		`{"command": "backup.exe", "webhook_url": "http://127.0.0.1:1/", "webhook_timeout_secs": "ten"}`,
+		`{"command": "backup.exe", "webhook_url": "http://127.0.0.1:1/"}`, // 无法连接
+	} {
+		s := writeConfig(t, data)
+		var ok bool
+		out := captureStdout(t, func() { ok = testWebhook(s.configPath) })
+		if ok {
+			t.Errorf("%s: testWebhook succeeded:\n%s", data, out)
+		}
+	}
+}
+
+// --test-webhook 使用 tls_* 设置
+func TestTestWebhookTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "WinPSP Test CA")
+	certFile, keyFile := ca.writeClientCert(t, dir)
+	srv, serverCA := mtlsServer(t, ca, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("client " + r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+
+	tests := []struct {
+		name   string
+		fields map[string]string
+		want   bool
+	}{
+		{"client certificate", map[string]string{"tls_cert_file": certFile, "tls_key_file": keyFile, "tls_ca_file": serverCA}, true},
+		{"no client certificate", map[string]string{"tls_ca_file": serverCA}, false},
+		{"server not trusted", map[string]string{"tls_cert_file": certFile, "tls_key_file": keyFile}, false},
+	}
+	for _, tt := range tests {
+		tt.fields["command"] = "backup.exe"
+		tt.fields["webhook_url"] = srv.URL
+		data, _ := json.Marshal(tt.fields)
+		s := writeConfig(t, string(data))
+
+		var ok bool
+		out := captureStdout(t, func() { ok = testWebhook(s.configPath) })
+		if ok != tt.want || (ok && !strings.Contains(out, "client winpsp-client")) {
+			t.Errorf("%s: testWebhook = %v, want %v\n%s", tt.name, ok, tt.want, out)
+		}
+	}
+}
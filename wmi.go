@@ -0,0 +1,403 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const defaultWMINamespace = `root\cimv2`
+
+// -------------------- WMI 方法调用 --------------------
+
+// wmi_class 设置后，主命令不再启动进程，而是调用 WMI 方法（例如 Hyper-V 的
+// Msvm_ComputerSystem.RequestStateChange 让虚拟机保存状态）。
+// wmi_class 是方法所在的对象路径：静态方法写类名，实例方法写带键值的实例路径，
+// 如 Msvm_ComputerSystem.CreationClassName="Msvm_ComputerSystem",Name="<VM GUID>"。
+// 方法的 ReturnValue 作为退出码，参与 success_exit_codes 判断和重试。
+// 直接通过 COM 虚表调用 WbemLocator，不依赖第三方库。
+
+var (
+	clsidWbemLocator = windows.GUID{Data1: 0x4590F811, Data2: 0x1D3A, Data3: 0x11D0, Data4: [8]byte{0x89, 0x1F, 0x00, 0xAA, 0x00, 0x4B, 0x2E, 0x24}}
+	iidIWbemLocator  = windows.GUID{Data1: 0xDC12A687, Data2: 0x737F, Data3: 0x11CF, Data4: [8]byte{0x88, 0x4D, 0x00, 0xAA, 0x00, 0x4B, 0x2E, 0x24}}
+)
+
+// 虚表索引（wbemcli.h 中的声明顺序）
+const (
+	wbemLocatorConnectServer = 3
+
+	wbemServicesGetObject  = 6
+	wbemServicesExecMethod = 24
+
+	wbemObjectGet           = 4
+	wbemObjectPut           = 5
+	wbemObjectGetObjectText = 13
+	wbemObjectSpawnInstance = 15
+	wbemObjectGetMethod     = 19
+
+	wbemCallResultGetResultObject = 3
+)
+
+const (
+	clsctxInprocServer        = 1
+	wbemFlagConnectUseMaxWait = 0x80
+	wbemFlagReturnImmediately = 0x10
+	wbemSTimedOut             = 0x40004
+	wbemInfinite              = 0xFFFFFFFF
+	rpcCAuthnWinNT            = 10
+	rpcCAuthnLevelCall        = 3
+	rpcCImpLevelImpersonate   = 3
+)
+
+// CIM 类型（wbemcli.h CIMTYPE_ENUMERATION）
+const (
+	cimSint16    = 2
+	cimSint32    = 3
+	cimReal32    = 4
+	cimReal64    = 5
+	cimBoolean   = 11
+	cimSint8     = 16
+	cimUint8     = 17
+	cimUint16    = 18
+	cimUint32    = 19
+	cimChar16    = 103
+	cimFlagArray = 0x2000
+)
+
+// VARIANT 类型
+const (
+	vtI2   = 2
+	vtI4   = 3
+	vtR4   = 4
+	vtR8   = 5
+	vtBSTR = 8
+	vtBool = 11
+	vtUI1  = 17
+)
+
+var (
+	procCoCreateInstance  = modole32.NewProc("CoCreateInstance")
+	procCoSetProxyBlanket = modole32.NewProc("CoSetProxyBlanket")
+
+	modoleaut32           = windows.NewLazySystemDLL("oleaut32.dll")
+	procSysAllocString    = modoleaut32.NewProc("SysAllocString")
+	procSysFreeString     = modoleaut32.NewProc("SysFreeString")
+	procVariantClear      = modoleaut32.NewProc("VariantClear")
+	procVariantChangeType = modoleaut32.NewProc("VariantChangeType")
+)
+
+// variant 对应 VARIANT：类型、三个保留字段和 8/16 字节的值
+type variant struct {
+	VT       uint16
+	reserved [3]uint16
+	val      [2]uintptr
+}
+
+func (v *variant) clear() {
+	procVariantClear.Call(uintptr(unsafe.Pointer(v)))
+}
+
+// wmiCall 是一次 WMI 方法调用
+type wmiCall struct {
+	Namespace string
+	Path      string // 对象路径：类名（静态方法）或实例路径
+	Method    string
+	Args      map[string]string
+}
+
+// wmiResult 是方法的返回值和全部输出参数（MOF 文本）
+type wmiResult struct {
+	ReturnValue int
+	Output      string
+}
+
+// execWMIMethod 执行 WMI 方法，可在调试时替换
+var execWMIMethod = wmiExecMethod
+
+// wmiCallFromConfig 返回配置中的 WMI 调用
+func wmiCallFromConfig(cfg *Config) wmiCall {
+	return wmiCall{Namespace: cfg.WMINamespace, Path: cfg.WMIClass, Method: cfg.WMIMethod, Args: cfg.WMIArgs}
+}
+
+// wmiDisplayName 返回 "类名.方法名"，用作命令名
+func wmiDisplayName(cfg *Config) string {
+	class, _, _ := strings.Cut(cfg.WMIClass, ".")
+	return class + "." + cfg.WMIMethod
+}
+
+// runWMI 执行 wmi_class 的方法，返回值与 runCommand 相同：退出码取 ReturnValue
+func (s *winpspService) runWMI(timeout time.Duration, opts execOptions) (int, bool, error) {
+	cfg := s.config.Load()
+	call := wmiCallFromConfig(cfg)
+	if s.simulate {
+		return simulateCommand(cfg, "wmi:"+wmiDisplayName(cfg), timeout, opts)
+	}
+
+	res, timedOut, err := execWMIMethod(call, timeout)
+	if err != nil || timedOut {
+		return 1, timedOut, err
+	}
+	for _, line := range strings.Split(res.Output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			opts.logf("WMI: %s", line)
+		}
+	}
+	return res.ReturnValue, false, nil
+}
+
+func wmiHR(op string, r uintptr) error {
+	if int32(r) < 0 {
+		return fmt.Errorf("WMI %s failed: HRESULT 0x%08X", op, uint32(r))
+	}
+	return nil
+}
+
+func sysAllocString(s string) (uintptr, error) {
+	p, err := windows.UTF16PtrFromString(s)
+	if err != nil {
+		return 0, err
+	}
+	b, _, _ := procSysAllocString.Call(uintptr(unsafe.Pointer(p)))
+	if b == 0 {
+		return 0, errors.New("SysAllocString failed")
+	}
+	return b, nil
+}
+
+func sysFreeString(b uintptr) {
+	procSysFreeString.Call(b)
+}
+
+// wmiExecMethod 连接命名空间并同步执行方法。COM 调用固定在一个系统线程上；
+// 方法以半同步方式调用，timeout 到期后不再等待结果。
+func wmiExecMethod(call wmiCall, timeout time.Duration) (_ wmiResult, timedOut bool, err error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if err := windows.CoInitializeEx(0, windows.COINIT_MULTITHREADED); err != nil && err != windows.Errno(windows.S_FALSE) {
+		return wmiResult{}, false, fmt.Errorf("CoInitializeEx: %w", err)
+	}
+	defer windows.CoUninitialize()
+
+	ns := call.Namespace
+	if ns == "" {
+		ns = defaultWMINamespace
+	}
+
+	var locator *comObject
+	r, _, _ := procCoCreateInstance.Call(uintptr(unsafe.Pointer(&clsidWbemLocator)), 0, clsctxInprocServer,
+		uintptr(unsafe.Pointer(&iidIWbemLocator)), uintptr(unsafe.Pointer(&locator)))
+	if err := wmiHR("CoCreateInstance(WbemLocator)", r); err != nil {
+		return wmiResult{}, false, err
+	}
+	defer locator.release()
+
+	bstrs := map[string]uintptr{}
+	defer func() {
+		for _, b := range bstrs {
+			sysFreeString(b)
+		}
+	}()
+	for _, s := range []string{ns, call.Path, call.Method} {
+		if _, ok := bstrs[s]; ok {
+			continue
+		}
+		b, err := sysAllocString(s)
+		if err != nil {
+			return wmiResult{}, false, err
+		}
+		bstrs[s] = b
+	}
+
+	var services *comObject
+	r = locator.call(wbemLocatorConnectServer, bstrs[ns], 0, 0, 0, wbemFlagConnectUseMaxWait, 0, 0,
+		uintptr(unsafe.Pointer(&services)))
+	if err := wmiHR("ConnectServer "+ns, r); err != nil {
+		return wmiResult{}, false, err
+	}
+	defer services.release()
+
+	// 服务以 LocalSystem 运行；提供程序需要以调用者身份执行（Hyper-V 等要求 IMPERSONATE）
+	r, _, _ = procCoSetProxyBlanket.Call(uintptr(unsafe.Pointer(services)), rpcCAuthnWinNT, 0, 0,
+		rpcCAuthnLevelCall, rpcCImpLevelImpersonate, 0, 0)
+	if err := wmiHR("CoSetProxyBlanket", r); err != nil {
+		return wmiResult{}, false, err
+	}
+
+	in, err := wmiInParams(services, call)
+	if err != nil {
+		return wmiResult{}, false, err
+	}
+	if in != nil {
+		defer in.release()
+	}
+
+	var callResult *comObject
+	r = services.call(wbemServicesExecMethod, bstrs[call.Path], bstrs[call.Method], wbemFlagReturnImmediately, 0,
+		uintptr(unsafe.Pointer(in)), 0, uintptr(unsafe.Pointer(&callResult)))
+	if err := wmiHR("ExecMethod "+call.Method, r); err != nil {
+		return wmiResult{}, false, err
+	}
+	defer callResult.release()
+
+	wait := uintptr(wbemInfinite)
+	if timeout > 0 {
+		wait = uintptr(timeout.Milliseconds())
+	}
+	var out *comObject
+	r = callResult.call(wbemCallResultGetResultObject, wait, uintptr(unsafe.Pointer(&out)))
+	if uint32(r) == wbemSTimedOut {
+		return wmiResult{}, true, nil
+	}
+	if err := wmiHR(call.Method, r); err != nil {
+		return wmiResult{}, false, err
+	}
+	if out == nil {
+		return wmiResult{}, false, nil
+	}
+	defer out.release()
+
+	var res wmiResult
+	if v, ok := wmiGetProperty(out, "ReturnValue"); ok {
+		var i4 variant
+		if r, _, _ := procVariantChangeType.Call(uintptr(unsafe.Pointer(&i4)), uintptr(unsafe.Pointer(&v)),
+			0, vtI4); int32(r) >= 0 {
+			res.ReturnValue = int(int32(i4.val[0]))
+		}
+		v.clear()
+	}
+	var text *uint16
+	if out.call(wbemObjectGetObjectText, 0, uintptr(unsafe.Pointer(&text))) == 0 && text != nil {
+		res.Output = windows.UTF16PtrToString(text)
+		sysFreeString(uintptr(unsafe.Pointer(text)))
+	}
+	return res, false, nil
+}
+
+// wmiInParams 按方法的参数定义创建输入参数对象，把 wmi_args 的字符串转换为参数的类型。
+// 方法没有输入参数时返回 nil。
+func wmiInParams(services *comObject, call wmiCall) (*comObject, error) {
+	class, _, _ := strings.Cut(call.Path, ".")
+	bclass, err := sysAllocString(class)
+	if err != nil {
+		return nil, err
+	}
+	defer sysFreeString(bclass)
+
+	var def *comObject
+	if err := wmiHR("GetObject "+class, services.call(wbemServicesGetObject, bclass, 0, 0,
+		uintptr(unsafe.Pointer(&def)), 0)); err != nil {
+		return nil, err
+	}
+	defer def.release()
+
+	method, err := windows.UTF16PtrFromString(call.Method)
+	if err != nil {
+		return nil, err
+	}
+	var sig *comObject
+	if err := wmiHR("GetMethod "+call.Method, def.call(wbemObjectGetMethod, uintptr(unsafe.Pointer(method)), 0,
+		uintptr(unsafe.Pointer(&sig)), 0)); err != nil {
+		return nil, err
+	}
+	if sig == nil {
+		if len(call.Args) > 0 {
+			return nil, fmt.Errorf("WMI method %s takes no parameters", call.Method)
+		}
+		return nil, nil
+	}
+	defer sig.release()
+
+	var in *comObject
+	if err := wmiHR("SpawnInstance", sig.call(wbemObjectSpawnInstance, 0, uintptr(unsafe.Pointer(&in)))); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(call.Args))
+	for name := range call.Args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := wmiPutArg(in, name, call.Args[name]); err != nil {
+			in.release()
+			return nil, err
+		}
+	}
+	return in, nil
+}
+
+// wmiPutArg 设置一个输入参数：字符串先转换为 CIM 类型对应的 VARIANT 类型
+func wmiPutArg(in *comObject, name, value string) error {
+	p, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	var cur variant
+	var cimType int32
+	if r := in.call(wbemObjectGet, uintptr(unsafe.Pointer(p)), 0, uintptr(unsafe.Pointer(&cur)),
+		uintptr(unsafe.Pointer(&cimType)), 0); int32(r) < 0 {
+		return fmt.Errorf("WMI parameter %s: not a parameter of the method", name)
+	}
+	cur.clear()
+	if cimType&cimFlagArray != 0 {
+		return fmt.Errorf("WMI parameter %s: array parameters are not supported", name)
+	}
+
+	b, err := sysAllocString(value)
+	if err != nil {
+		return err
+	}
+	src := variant{VT: vtBSTR}
+	src.val[0] = b
+	defer src.clear()
+
+	var dst variant
+	if r, _, _ := procVariantChangeType.Call(uintptr(unsafe.Pointer(&dst)), uintptr(unsafe.Pointer(&src)),
+		0, uintptr(cimVariantType(cimType))); int32(r) < 0 {
+		return fmt.Errorf("WMI parameter %s: cannot convert %q: HRESULT 0x%08X", name, value, uint32(r))
+	}
+	defer dst.clear()
+
+	return wmiHR("Put "+name, in.call(wbemObjectPut, uintptr(unsafe.Pointer(p)), 0, uintptr(unsafe.Pointer(&dst)), 0))
+}
+
+// wmiGetProperty 读取属性，不存在时返回 false。调用方负责 clear。
+func wmiGetProperty(obj *comObject, name string) (variant, bool) {
+	var v variant
+	p, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return v, false
+	}
+	if r := obj.call(wbemObjectGet, uintptr(unsafe.Pointer(p)), 0, uintptr(unsafe.Pointer(&v)), 0, 0); int32(r) < 0 {
+		return v, false
+	}
+	return v, true
+}
+
+// cimVariantType 返回 WMI 期望的 VARIANT 类型（与 WMI 脚本 API 的对应关系相同）；
+// 64 位整数、日期时间和引用都以字符串传递
+func cimVariantType(cimType int32) uint16 {
+	switch cimType {
+	case cimSint8, cimSint16, cimChar16:
+		return vtI2
+	case cimUint8:
+		return vtUI1
+	case cimUint16, cimSint32, cimUint32:
+		return vtI4
+	case cimReal32:
+		return vtR4
+	case cimReal64:
+		return vtR8
+	case cimBoolean:
+		return vtBool
+	}
+	return vtBSTR
+}
@@ -0,0 +1,76 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var procSetFileSecurityW = modadvapi32.NewProc("SetFileSecurityW")
+
+// -------------------- working_dir_sddl --------------------
+
+// working_dir_sddl 在命令执行前把 SDDL 描述的安全描述符设置到 working_directory 上，
+// 命令在其中创建的文件和目录继承其中可继承的 ACE，后续的工具就能访问它们。
+// 只修改目录本身（SetFileSecurity），不改动目录中已有的文件。
+// SDDL 中有哪些部分（所有者、组、DACL）就设置哪些，SACL 不设置。
+
+// setDirSecurity 设置目录的安全描述符，可在调试时替换
+var setDirSecurity = func(path string, info windows.SECURITY_INFORMATION, sd *windows.SECURITY_DESCRIPTOR) error {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	r, _, err := procSetFileSecurityW.Call(uintptr(unsafe.Pointer(p)), uintptr(info), uintptr(unsafe.Pointer(sd)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// sddlSecurityInfo 解析 SDDL，返回安全描述符和其中包含的部分
+func sddlSecurityInfo(sddl string) (*windows.SECURITY_DESCRIPTOR, windows.SECURITY_INFORMATION, error) {
+	sd, err := windows.SecurityDescriptorFromString(sddl)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var info windows.SECURITY_INFORMATION
+	if owner, _, err := sd.Owner(); err == nil && owner != nil {
+		info |= windows.OWNER_SECURITY_INFORMATION
+	}
+	if group, _, err := sd.Group(); err == nil && group != nil {
+		info |= windows.GROUP_SECURITY_INFORMATION
+	}
+	if _, _, err := sd.DACL(); err == nil {
+		info |= windows.DACL_SECURITY_INFORMATION
+		if control, _, err := sd.Control(); err == nil && control&windows.SE_DACL_PROTECTED != 0 {
+			info |= windows.PROTECTED_DACL_SECURITY_INFORMATION
+		} else {
+			info |= windows.UNPROTECTED_DACL_SECURITY_INFORMATION
+		}
+	}
+	if info == 0 {
+		return nil, 0, fmt.Errorf("SDDL %q has no owner, group or DACL", sddl)
+	}
+	return sd, info, nil
+}
+
+// applyWorkingDirSDDL 把 working_dir_sddl 设置到 working_directory 上，未配置时什么也不做
+func applyWorkingDirSDDL(e *CommandEntry, logf func(format string, args ...any)) error {
+	if e.WorkingDirSDDL == "" {
+		return nil
+	}
+	sd, info, err := sddlSecurityInfo(e.WorkingDirSDDL)
+	if err != nil {
+		return fmt.Errorf("working_dir_sddl: %w", err)
+	}
+	if err := setDirSecurity(e.WorkingDirectory, info, sd); err != nil {
+		return fmt.Errorf("working_dir_sddl: set security of %s: %w", e.WorkingDirectory, err)
+	}
+	logf("Working directory security set: %s", e.WorkingDirSDDL)
+	return nil
+}
@@ -0,0 +1,82 @@
+//go:build windows
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestSDDLSecurityInfo(t *testing.T) {
+	const (
+		owner       = windows.OWNER_SECURITY_INFORMATION
+		group       = windows.GROUP_SECURITY_INFORMATION
+		dacl        = windows.DACL_SECURITY_INFORMATION
+		protected   = windows.PROTECTED_DACL_SECURITY_INFORMATION
+		unprotected = windows.UNPROTECTED_DACL_SECURITY_INFORMATION
+	)
+	tests := []struct {
+		sddl    string
+		want    windows.SECURITY_INFORMATION
+		wantErr bool
+	}{
+		{"O:BAG:SYD:PAI(A;OICI;FA;;;SY)(A;OICI;FA;;;BA)", owner | group | dacl | protected, false},
+		{"D:(A;OICI;FA;;;SY)(A;OICI;0x1200a9;;;BU)", dacl | unprotected, false},
+		{"D:AI(A;OICI;FA;;;SY)", dacl | unprotected, false},
+		{"D:P", dacl | protected, false},
+		{"O:BA", owner, false},
+		{"G:SY", group, false},
+		{"O:SYD:P(A;OICI;FA;;;SY)", owner | dacl | protected, false},
+		{"", 0, true},
+		{"S:(ML;;NW;;;LW)", 0, true}, // 只有 SACL
+		{"D:(A;;FA;;;NoSuchSID)", 0, true},
+		{"not sddl", 0, true},
+	}
+	for _, tt := range tests {
+		sd, info, err := sddlSecurityInfo(tt.sddl)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("sddlSecurityInfo(%q) error = %v, wantErr %v", tt.sddl, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if sd == nil {
+			t.Errorf("sddlSecurityInfo(%q) returned a nil security descriptor", tt.sddl)
+		}
+		if info != tt.want {
+			t.Errorf("sddlSecurityInfo(%q) = %#x, want %#x", tt.sddl, info, tt.want)
+		}
+	}
+}
+
+func TestApplyWorkingDirSDDL(t *testing.T) {
+	dir := t.TempDir()
+	logf := func(string, ...any) {}
+
+	// 未配置时不修改目录
+	if err := applyWorkingDirSDDL(&CommandEntry{WorkingDirectory: dir}, logf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Everyone 完全控制，测试结束后仍能删除临时目录
+	e := &CommandEntry{WorkingDirectory: dir, WorkingDirSDDL: "D:P(A;OICI;FA;;;WD)"}
+	if err := applyWorkingDirSDDL(e, logf); err != nil {
+		t.Fatalf("applyWorkingDirSDDL: %v", err)
+	}
+	sd, err := windows.GetNamedSecurityInfo(dir, windows.SE_FILE_OBJECT, windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := sd.String()
+	if !strings.HasPrefix(got, "D:P") || !strings.Contains(got, "(A;OICI;FA;;;WD)") {
+		t.Errorf("DACL of %s = %s, want protected (A;OICI;FA;;;WD)", dir, got)
+	}
+
+	e.WorkingDirSDDL = "S:(ML;;NW;;;LW)"
+	if err := applyWorkingDirSDDL(e, logf); err == nil {
+		t.Error("applyWorkingDirSDDL accepted an SDDL without owner, group or DACL")
+	}
+}